@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// grant is account linking returned by the admin API
+type grant struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	ClientID      string     `json:"client_id"`
+	Scope         string     `json:"scope"`
+	IssuedAt      time.Time  `json:"issued_at"`
+	LastRefreshAt *time.Time `json:"last_refresh_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+// session is login session returned by the admin API
+type session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// apiError is error response of the admin API
+type apiError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// admin is client of the admin API
+type admin struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+const usage = `Usage: alisa-admin [flags] <command> [arguments]
+
+Commands:
+  grants [-user <id>] [-client <id>]   list active grants
+  revoke-grant <grant id>              revoke single grant
+  revoke -user <id> [-client <id>]     revoke grants of the user, all user sessions are closed
+                                       when client isn't set
+  revoke -client <id>                  revoke grants of the client
+  sessions [-user <id>]                list login sessions
+  revoke-session <session id>          close single login session
+
+Flags:
+`
+
+func main() {
+	a := &admin{}
+	var timeout time.Duration
+
+	flag.StringVar(&a.baseURL, "url", "http://127.0.0.1:8081",
+		"alisa-service admin listener URL, unix socket is set as unix:<path>")
+	flag.StringVar(&a.token, "token", os.Getenv("ADMIN_TOKEN"), "admin API token")
+	flag.DurationVar(&timeout, "timeout", time.Second*5, "timeout of the request")
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	a.baseURL = strings.TrimRight(a.baseURL, "/")
+	a.client = &http.Client{Timeout: timeout}
+
+	// Requests to the unix socket use any host, it's ignored by the listener
+	if path := strings.TrimPrefix(a.baseURL, "unix:"); path != a.baseURL {
+		a.baseURL = "http://localhost"
+		a.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+
+	if 0 == flag.NArg() {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if e := a.run(flag.Arg(0), flag.Args()[1:]); nil != e {
+		_, _ = fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+}
+
+// run execute the command
+func (a *admin) run(command string, args []string) error {
+	commandFlags := flag.NewFlagSet(command, flag.ExitOnError)
+	userID := commandFlags.String("user", "", "user ID")
+	clientID := commandFlags.String("client", "", "OAuth client ID")
+	_ = commandFlags.Parse(args)
+
+	query := url.Values{}
+	if len(*userID) > 0 {
+		query.Set("user_id", *userID)
+	}
+	if len(*clientID) > 0 {
+		query.Set("client_id", *clientID)
+	}
+
+	switch command {
+	case "grants":
+		return a.grants(query)
+	case "revoke":
+		if 0 == len(query) {
+			return fmt.Errorf("revoke: -user or -client is required")
+		}
+
+		var result struct {
+			Grants   int `json:"grants"`
+			Sessions int `json:"sessions"`
+		}
+		if e := a.request(http.MethodDelete, "/admin/grants?"+query.Encode(), &result); nil != e {
+			return e
+		}
+
+		fmt.Printf("Revoked %d grants and %d sessions\n", result.Grants, result.Sessions)
+		return nil
+	case "revoke-grant":
+		if 1 != commandFlags.NArg() {
+			return fmt.Errorf("revoke-grant: grant ID is required")
+		}
+
+		return a.request(http.MethodDelete, "/admin/grants/"+url.PathEscape(commandFlags.Arg(0)), nil)
+	case "sessions":
+		return a.sessions(query)
+	case "revoke-session":
+		if 1 != commandFlags.NArg() {
+			return fmt.Errorf("revoke-session: session ID is required")
+		}
+
+		return a.request(http.MethodDelete, "/admin/sessions/"+url.PathEscape(commandFlags.Arg(0)), nil)
+	}
+
+	return fmt.Errorf("unknown command %s", command)
+}
+
+// grants print active grants
+func (a *admin) grants(query url.Values) error {
+	var result struct {
+		Grants []grant `json:"grants"`
+	}
+	if e := a.request(http.MethodGet, "/admin/grants?"+query.Encode(), &result); nil != e {
+		return e
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tUSER\tCLIENT\tSCOPE\tISSUED\tLAST REFRESH\tEXPIRES")
+	for _, g := range result.Grants {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			g.ID, g.UserID, g.ClientID, g.Scope,
+			formatTime(&g.IssuedAt), formatTime(g.LastRefreshAt), formatTime(g.ExpiresAt))
+	}
+
+	return w.Flush()
+}
+
+// sessions print login sessions
+func (a *admin) sessions(query url.Values) error {
+	query.Del("client_id")
+
+	var result struct {
+		Sessions []session `json:"sessions"`
+	}
+	if e := a.request(http.MethodGet, "/admin/sessions?"+query.Encode(), &result); nil != e {
+		return e
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tUSER\tEXPIRES")
+	for _, s := range result.Sessions {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", s.ID, s.UserID, formatTime(&s.ExpiresAt))
+	}
+
+	return w.Flush()
+}
+
+// formatTime return local time, "-" is returned when time is unknown
+func formatTime(t *time.Time) string {
+	if nil == t || t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// request send request to the admin API and decode response into result, if it isn't nil
+func (a *admin) request(method string, path string, result interface{}) error {
+	request, e := http.NewRequest(method, a.baseURL+path, nil)
+	if nil != e {
+		return e
+	}
+	request.Header.Set("Authorization", "Bearer "+a.token)
+	request.Header.Set("Accept", "application/json")
+
+	response, e := a.client.Do(request)
+	if nil != e {
+		return e
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	body, e := io.ReadAll(response.Body)
+	if nil != e {
+		return e
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var failure apiError
+		if e = json.Unmarshal(body, &failure); nil == e && len(failure.Error) > 0 {
+			if len(failure.Description) > 0 {
+				return fmt.Errorf("%s: %s (%s)", response.Status, failure.Error, failure.Description)
+			}
+
+			return fmt.Errorf("%s: %s", response.Status, failure.Error)
+		}
+
+		return fmt.Errorf("%s", response.Status)
+	}
+
+	if nil == result {
+		return nil
+	}
+
+	return json.Unmarshal(body, result)
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	stdlog "log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pior/runnable"
 	"github.com/vedga/alisa/internal/pkg/log"
 	"github.com/vedga/alisa/internal/service/alisa"
 	"github.com/vedga/alisa/internal/service/devices"
+	"github.com/vedga/alisa/internal/service/google"
+	"github.com/vedga/alisa/internal/service/homekit"
+	"github.com/vedga/alisa/internal/service/households"
 	"github.com/vedga/alisa/internal/service/httpserver"
+	"github.com/vedga/alisa/internal/service/marusya"
 	"github.com/vedga/alisa/internal/service/mqtt"
 	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/internal/service/sber"
+	"github.com/vedga/alisa/internal/service/states"
 	"github.com/vedga/alisa/internal/service/tasmota"
 	"github.com/vedga/alisa/pkg/eventbus"
 	"go.uber.org/zap"
@@ -18,6 +26,11 @@ import (
 	"go.uber.org/zap/zapio"
 )
 
+const (
+	// shutdownMargin is time to stop services which wait for HTTP server on shutdown
+	shutdownMargin = time.Second * 5
+)
+
 func main() {
 	// Application-wide logger
 	logger, e := log.NewLogger()
@@ -55,12 +68,28 @@ func main() {
 	}
 
 	var devicesService *devices.Service
-	if devicesService, e = devices.NewService(); nil != e {
+	if devicesService, e = devices.NewService(bus); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	var statesService *states.Service
+	if statesService, e = states.NewService(bus); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	var householdsService *households.Service
+	// Device bindings of the user are dropped when user unlink accounts with any assistant
+	if householdsService, e = households.NewService(bus,
+		devicesService,
+		alisa.UserUnlinked,
+		marusya.UserUnlinked,
+		sber.UserUnlinked,
+		google.UserUnlinked); nil != e {
 		stdlog.Fatal(e)
 	}
 
 	var tasmotaService *tasmota.Service
-	if tasmotaService, e = tasmota.NewService(bus, devicesService); nil != e {
+	if tasmotaService, e = tasmota.NewService(bus, devicesService, statesService); nil != e {
 		stdlog.Fatal(e)
 	}
 
@@ -69,26 +98,79 @@ func main() {
 		stdlog.Fatal(e)
 	}
 
+	// Rate limiter must be installed before routes, otherwise it isn't applied to them. Admin listener is local, its
+	// clients share the address, so lockout of the guessing client would lock the administrator too.
+	var rateLimitService *ratelimit.Service
+	if rateLimitService, e = ratelimit.NewService(bus); nil != e {
+		stdlog.Fatal(e)
+	}
+	httpService.Router().Use(rateLimitService.Middleware())
+
 	var oauthService *oauth.Service
-	if oauthService, e = oauth.NewService(httpService.Router()); nil != e {
+	if oauthService, e = oauth.NewService(httpService.Router(), httpService.AdminRouter(), rateLimitService); nil != e {
 		stdlog.Fatal(e)
 	}
 
 	// Create Alisa service and add it to the application manager
 	var alisaService *alisa.Service
-	if alisaService, e = alisa.NewService(httpService.Router(), oauthService); nil != e {
+	if alisaService, e = alisa.NewService(httpService.Router(),
+		bus,
+		oauthService,
+		householdsService,
+		statesService); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	// Create Marusya service, it share devices and accounts with Alisa
+	var marusyaService *marusya.Service
+	if marusyaService, e = marusya.NewService(httpService.Router(),
+		bus,
+		oauthService,
+		householdsService,
+		statesService); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	// Create Sber service, it's active only when enabled by configuration
+	var sberService *sber.Service
+	if sberService, e = sber.NewService(httpService.Router(),
+		bus,
+		oauthService,
+		householdsService,
+		statesService); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	// Create Google Smart Home service, it's active only when enabled by configuration
+	var googleService *google.Service
+	if googleService, e = google.NewService(httpService.Router(),
+		bus,
+		oauthService,
+		householdsService,
+		statesService); nil != e {
+		stdlog.Fatal(e)
+	}
+
+	// Create HomeKit bridge, it control devices in the local network without cloud
+	var homekitService *homekit.Service
+	if homekitService, e = homekit.NewService(bus, devicesService, statesService); nil != e {
 		stdlog.Fatal(e)
 	}
 
-	appManager := runnable.NewManager()
+	// Manager stop dependencies after their users, it must wait until HTTP requests are drained
+	appManager := runnable.NewManager(runnable.ManagerShutdownTimeout(httpserver.ShutdownTimeout + shutdownMargin))
 
-	appManager.Add(devicesService)
+	appManager.Add(devicesService, statesService, householdsService)
 
 	appManager.Add(mqttService, tasmotaService)
 
-	appManager.Add(httpService, oauthService)
+	// OAuth service close token database, so it's stopped after HTTP server and front-ends which use it
+	appManager.Add(httpService, rateLimitService, oauthService)
+	for _, frontend := range []runnable.Runnable{alisaService, marusyaService, sberService, googleService} {
+		appManager.Add(frontend, oauthService)
+	}
 
-	appManager.Add(alisaService)
+	appManager.Add(alisaService, marusyaService, sberService, googleService, homekitService)
 
 	log.Log.Debugf("Application started")
 
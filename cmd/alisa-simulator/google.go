@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	endpointGoogleFulfillment = "/google/fulfillment"
+	// googleDevicePlaceholder is replaced in the recorded intents by the first controllable device ID
+	googleDevicePlaceholder = "{{device}}"
+	googleIntentSync        = "action.devices.SYNC"
+	googleIntentQuery       = "action.devices.QUERY"
+	googleIntentExecute     = "action.devices.EXECUTE"
+	googleIntentDisconnect  = "action.devices.DISCONNECT"
+	googleTraitOnOff        = "action.devices.traits.OnOff"
+)
+
+// googleStatuses is device statuses allowed by the protocol
+var googleStatuses = map[string]bool{
+	"SUCCESS":    true,
+	"PENDING":    true,
+	"OFFLINE":    true,
+	"EXCEPTIONS": true,
+	"ERROR":      true,
+}
+
+// googleRequest is recorded fulfillment request
+type googleRequest struct {
+	RequestID string `json:"requestId"`
+	Inputs    []struct {
+		Intent  string `json:"intent"`
+		Payload struct {
+			Devices []struct {
+				ID string `json:"id"`
+			} `json:"devices"`
+			Commands []struct {
+				Devices []struct {
+					ID string `json:"id"`
+				} `json:"devices"`
+			} `json:"commands"`
+		} `json:"payload"`
+	} `json:"inputs"`
+}
+
+// googleResponse is fulfillment response
+type googleResponse struct {
+	RequestID string `json:"requestId"`
+	Payload   struct {
+		ErrorCode   string `json:"errorCode"`
+		AgentUserID string `json:"agentUserId"`
+		// Devices is list of devices for SYNC and map of states for QUERY
+		Devices  json.RawMessage `json:"devices"`
+		Commands []struct {
+			IDs       []string `json:"ids"`
+			Status    string   `json:"status"`
+			ErrorCode string   `json:"errorCode"`
+		} `json:"commands"`
+	} `json:"payload"`
+}
+
+// googleDevice is device returned by SYNC intent
+type googleDevice struct {
+	ID     string   `json:"id"`
+	Type   string   `json:"type"`
+	Traits []string `json:"traits"`
+	Name   struct {
+		Name string `json:"name"`
+	} `json:"name"`
+}
+
+// googleState is device state returned by QUERY intent
+type googleState struct {
+	Status    string `json:"status"`
+	ErrorCode string `json:"errorCode"`
+}
+
+// runGoogle link accounts and replay recorded Google intents
+func (s *simulator) runGoogle() {
+	if !s.link() {
+		return
+	}
+
+	files, e := filepath.Glob(filepath.Join(s.config.googleIntents, "*.json"))
+	if nil != e || 0 == len(files) {
+		s.report.step("Recorded intents in " + s.config.googleIntents)
+		s.report.violatef("no recorded intents found")
+		return
+	}
+	sort.Strings(files)
+
+	device := ""
+	for _, file := range files {
+		if !s.replayIntent(file, &device) {
+			return
+		}
+	}
+}
+
+// replayIntent send recorded intent and check the response, false returned when following intents can't be sent
+func (s *simulator) replayIntent(file string, device *string) bool {
+	s.report.step("POST " + endpointGoogleFulfillment + " " + filepath.Base(file))
+
+	data, e := os.ReadFile(file)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return false
+	}
+
+	if strings.Contains(string(data), googleDevicePlaceholder) {
+		if 0 == len(*device) {
+			s.report.violatef("no controllable device found by SYNC, intent skipped")
+			return true
+		}
+		data = []byte(strings.ReplaceAll(string(data), googleDevicePlaceholder, *device))
+	}
+
+	var request googleRequest
+	if e = json.Unmarshal(data, &request); nil != e || 1 != len(request.Inputs) {
+		s.report.violatef("invalid recorded intent: %v", e)
+		return false
+	}
+	// Each replay must have unique request ID
+	request.RequestID = uuid.NewString()
+	if data, e = setRequestID(data, request.RequestID); nil != e {
+		s.report.violatef("%v", e)
+		return false
+	}
+
+	response, e := s.do(http.MethodPost, endpointGoogleFulfillment, bytes.NewReader(data), "application/json")
+	if nil != e {
+		s.report.violatef("%v", e)
+		return false
+	}
+
+	var result googleResponse
+	status, e := decodeResponse(response, &result)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return false
+	}
+
+	if http.StatusOK != status {
+		s.report.violatef("status %d, expected %d", status, http.StatusOK)
+		return false
+	}
+
+	input := request.Inputs[0]
+
+	if googleIntentDisconnect == input.Intent {
+		s.checkGoogleRevoked()
+		return false
+	}
+
+	if result.RequestID != request.RequestID {
+		s.report.violatef("requestId %q, expected %q", result.RequestID, request.RequestID)
+	}
+
+	if len(result.Payload.ErrorCode) > 0 {
+		s.report.violatef("intent failed with %s", result.Payload.ErrorCode)
+		return true
+	}
+
+	switch input.Intent {
+	case googleIntentSync:
+		*device = s.checkGoogleSync(&result)
+	case googleIntentQuery:
+		var states map[string]googleState
+		if e = json.Unmarshal(result.Payload.Devices, &states); nil != e {
+			s.report.violatef("payload.devices: %v", e)
+			break
+		}
+		for _, requested := range input.Payload.Devices {
+			state, found := states[requested.ID]
+			if !found {
+				s.report.violatef("state of %s isn't returned", requested.ID)
+				continue
+			}
+			s.checkGoogleStatus(requested.ID, state.Status, state.ErrorCode)
+		}
+	case googleIntentExecute:
+		results := make(map[string]bool)
+		for _, command := range result.Payload.Commands {
+			for _, id := range command.IDs {
+				results[id] = true
+				s.checkGoogleStatus(id, command.Status, command.ErrorCode)
+			}
+		}
+		for _, command := range input.Payload.Commands {
+			for _, requested := range command.Devices {
+				if !results[requested.ID] {
+					s.report.violatef("result of %s isn't returned", requested.ID)
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// checkGoogleSync check SYNC response and return first device with OnOff trait
+func (s *simulator) checkGoogleSync(result *googleResponse) (device string) {
+	if 0 == len(result.Payload.AgentUserID) {
+		s.report.violatef("payload.agentUserId is empty")
+	}
+
+	var devices []googleDevice
+	if e := json.Unmarshal(result.Payload.Devices, &devices); nil != e {
+		s.report.violatef("payload.devices: %v", e)
+		return ""
+	}
+
+	ids := make(map[string]bool)
+	for index, d := range devices {
+		if 0 == len(d.ID) || 0 == len(d.Type) || 0 == len(d.Traits) || 0 == len(d.Name.Name) {
+			s.report.violatef("device #%d: id, type, traits and name are required", index)
+		}
+
+		if ids[d.ID] {
+			s.report.violatef("duplicate device id %s", d.ID)
+		}
+		ids[d.ID] = true
+
+		for _, trait := range d.Traits {
+			if googleTraitOnOff == trait && 0 == len(device) {
+				device = d.ID
+			}
+		}
+	}
+
+	return device
+}
+
+// checkGoogleStatus check device status and error code
+func (s *simulator) checkGoogleStatus(id string, status string, errorCode string) {
+	if !googleStatuses[status] {
+		s.report.violatef("device %s: unknown status %q", id, status)
+	}
+
+	if "SUCCESS" != status && 0 == len(errorCode) {
+		s.report.violatef("device %s: errorCode is required for status %s", id, status)
+	}
+}
+
+// checkGoogleRevoked check tokens aren't accepted after DISCONNECT
+func (s *simulator) checkGoogleRevoked() {
+	s.report.step("Tokens revoked after " + googleIntentDisconnect)
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"requestId": uuid.NewString(),
+		"inputs":    []map[string]string{{"intent": googleIntentSync}},
+	})
+
+	response, e := s.do(http.MethodPost, endpointGoogleFulfillment, bytes.NewReader(data), "application/json")
+	if nil != e {
+		s.report.violatef("%v", e)
+		return
+	}
+	_ = response.Body.Close()
+
+	if http.StatusUnauthorized != response.StatusCode {
+		s.report.violatef("access token accepted after disconnect, status %d", response.StatusCode)
+	}
+}
+
+// setRequestID replace request ID of the recorded intent
+func setRequestID(data []byte, requestID string) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if e := json.Unmarshal(data, &request); nil != e {
+		return nil, e
+	}
+
+	request["requestId"], _ = json.Marshal(requestID)
+
+	return json.Marshal(request)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	config := simulatorConfig{}
+
+	flag.StringVar(&config.baseURL, "url", "https://127.0.0.1:8443",
+		"alisa-service base URL, public listener by default; use http:// when TLS isn't configured")
+	flag.BoolVar(&config.insecure, "insecure", false,
+		"don't verify TLS certificate, e.g. when certificate is issued for the domain name and URL use IP address")
+	flag.StringVar(&config.prefix, "prefix", "/alisa", "path prefix of the skill, e.g. /marusya")
+	flag.StringVar(&config.clientID, "client-id", os.Getenv("YANDEX_CLIENT_ID"), "OAuth client ID")
+	flag.StringVar(&config.clientSecret, "client-secret", os.Getenv("YANDEX_CLIENT_SECRET"), "OAuth client secret")
+	flag.StringVar(&config.redirectURI, "redirect-uri", "https://social.yandex.net/broker/redirect",
+		"OAuth redirect URI registered for the client")
+	flag.StringVar(&config.username, "username", os.Getenv("OAUTH_USERNAME"), "user who link accounts")
+	flag.StringVar(&config.password, "password", os.Getenv("OAUTH_PASSWORD"), "password of the user")
+	flag.StringVar(&config.accessToken, "access-token", os.Getenv("OAUTH_ACCESS_TOKEN"),
+		"access token of the external OAuth provider, accounts aren't linked when it's set")
+	flag.BoolVar(&config.toggle, "toggle", false,
+		"invert on_off state of the devices during action test, otherwise current state is set again")
+	flag.BoolVar(&config.skipUnlink, "skip-unlink", false, "don't unlink accounts at the end")
+	flag.DurationVar(&config.timeout, "timeout", time.Second*5, "timeout of the single request")
+	flag.StringVar(&config.googleIntents, "google-intents", "",
+		"directory with recorded Google Smart Home intents, e.g. cmd/alisa-simulator/testdata/google; "+
+			"when set, the intents are replayed instead of Yandex checks")
+	flag.Parse()
+
+	r := &report{}
+
+	if len(config.googleIntents) > 0 {
+		newSimulator(config, r).runGoogle()
+	} else {
+		newSimulator(config, r).run()
+	}
+
+	r.print(os.Stdout)
+
+	if r.failed() {
+		os.Exit(1)
+	}
+
+	fmt.Println("All checks passed")
+}
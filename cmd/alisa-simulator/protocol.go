@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+const (
+	headerRequestID = "X-Request-Id"
+	endpointProbe   = "/v1.0"
+	endpointUnlink  = "/v1.0/user/unlink"
+	endpointDevices = "/v1.0/user/devices"
+	endpointQuery   = "/v1.0/user/devices/query"
+	endpointAction  = "/v1.0/user/devices/action"
+	endpointOAuth   = "/oauth/authorize"
+	endpointToken   = "/oauth/token"
+	actionDone      = "DONE"
+	actionError     = "ERROR"
+)
+
+// errorCodes is error codes allowed by the protocol
+var errorCodes = map[string]bool{
+	"DOOR_OPEN":                     true,
+	"LID_OPEN":                      true,
+	"REMOTE_CONTROL_DISABLED":       true,
+	"NOT_ENOUGH_WATER":              true,
+	"LOW_CHARGE_LEVEL":              true,
+	"CONTAINER_FULL":                true,
+	"CONTAINER_EMPTY":               true,
+	"DRIP_TRAY_FULL":                true,
+	"DEVICE_STUCK":                  true,
+	"DEVICE_OFF":                    true,
+	"FIRMWARE_OUT_OF_DATE":          true,
+	"NOT_ENOUGH_DETERGENT":          true,
+	"HUMAN_INVOLVEMENT_NEEDED":      true,
+	"DEVICE_UNREACHABLE":            true,
+	"DEVICE_BUSY":                   true,
+	"INTERNAL_ERROR":                true,
+	"INVALID_ACTION":                true,
+	"INVALID_VALUE":                 true,
+	"NOT_SUPPORTED_IN_CURRENT_MODE": true,
+	"ACCOUNT_LINKING_ERROR":         true,
+	"DEVICE_NOT_FOUND":              true,
+}
+
+// tokenResponse is OAuth token endpoint response
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// devicesResponse is response for the devices request. Devices decoded one by one to report all violations.
+type devicesResponse struct {
+	RequestID string `json:"request_id"`
+	Payload   struct {
+		UserID  string            `json:"user_id"`
+		Devices []json.RawMessage `json:"devices"`
+	} `json:"payload"`
+}
+
+// deviceRequest is device reference in the query and action requests
+type deviceRequest struct {
+	ID         string          `json:"id"`
+	CustomData json.RawMessage `json:"custom_data,omitempty"`
+}
+
+// queryRequest is request for the devices query
+type queryRequest struct {
+	Devices []deviceRequest `json:"devices"`
+}
+
+// deviceState is device state in the query response
+type deviceState struct {
+	ID           string            `json:"id"`
+	Capabilities []json.RawMessage `json:"capabilities"`
+	Properties   []json.RawMessage `json:"properties"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorMessage string            `json:"error_message"`
+}
+
+// queryResponse is response for the devices query
+type queryResponse struct {
+	RequestID string `json:"request_id"`
+	Payload   struct {
+		Devices []deviceState `json:"devices"`
+	} `json:"payload"`
+}
+
+// actionCapability is requested capability state
+type actionCapability struct {
+	Type  string `json:"type"`
+	State struct {
+		Instance string      `json:"instance"`
+		Value    interface{} `json:"value"`
+	} `json:"state"`
+}
+
+// actionDevice is device with requested capability states
+type actionDevice struct {
+	deviceRequest
+	Capabilities []actionCapability `json:"capabilities"`
+}
+
+// actionRequest is request for the devices action
+type actionRequest struct {
+	Payload struct {
+		Devices []actionDevice `json:"devices"`
+	} `json:"payload"`
+}
+
+// actionResult is result of the device or capability action
+type actionResult struct {
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// capabilityResult is capability action result
+type capabilityResult struct {
+	Type  string `json:"type"`
+	State struct {
+		Instance     string       `json:"instance"`
+		ActionResult actionResult `json:"action_result"`
+	} `json:"state"`
+}
+
+// deviceResult is device action result
+type deviceResult struct {
+	ID           string             `json:"id"`
+	Capabilities []capabilityResult `json:"capabilities"`
+	ActionResult *actionResult      `json:"action_result"`
+}
+
+// actionResponse is response for the devices action
+type actionResponse struct {
+	RequestID string `json:"request_id"`
+	Payload   struct {
+		Devices []deviceResult `json:"devices"`
+	} `json:"payload"`
+}
+
+// unlinkResponse is response for the unlink request
+type unlinkResponse struct {
+	RequestID string `json:"request_id"`
+}
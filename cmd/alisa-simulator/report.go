@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// violation is protocol violation found during the step
+type violation struct {
+	step    string
+	message string
+}
+
+// report collect results of the simulation steps
+type report struct {
+	steps      []string
+	violations []violation
+}
+
+// step start new simulation step
+func (r *report) step(name string) {
+	r.steps = append(r.steps, name)
+}
+
+// violatef add protocol violation to the current step
+func (r *report) violatef(format string, args ...interface{}) {
+	step := ""
+	if len(r.steps) > 0 {
+		step = r.steps[len(r.steps)-1]
+	}
+
+	r.violations = append(r.violations, violation{
+		step:    step,
+		message: fmt.Sprintf(format, args...),
+	})
+}
+
+// failed return true when at least one violation found
+func (r *report) failed() bool {
+	return len(r.violations) > 0
+}
+
+// print write report to the writer
+func (r *report) print(w io.Writer) {
+	for _, step := range r.steps {
+		status := "ok"
+		for _, v := range r.violations {
+			if v.step == step {
+				status = "FAIL"
+				break
+			}
+		}
+
+		_, _ = fmt.Fprintf(w, "%-8s %s\n", status, step)
+
+		for _, v := range r.violations {
+			if v.step == step {
+				_, _ = fmt.Fprintf(w, "         - %s\n", v.message)
+			}
+		}
+	}
+}
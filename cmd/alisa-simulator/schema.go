@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Protocol tables of the Yandex smart home API. They're written from the protocol documentation and don't use
+// types of the service, so responses are checked against the protocol and not against the service itself.
+
+const (
+	capabilityOnOff        = "devices.capabilities.on_off"
+	capabilityColorSetting = "devices.capabilities.color_setting"
+	capabilityMode         = "devices.capabilities.mode"
+	capabilityRange        = "devices.capabilities.range"
+	capabilityToggle       = "devices.capabilities.toggle"
+	capabilityVideoStream  = "devices.capabilities.video_stream"
+	propertyFloat          = "devices.properties.float"
+	propertyEvent          = "devices.properties.event"
+	unitPercent            = "unit.percent"
+	// Color temperature range allowed by the protocol, in Kelvin
+	minColorTemperature = 1500
+	maxColorTemperature = 9000
+	maxRGB              = 0xFFFFFF
+)
+
+// deviceTypes is device types allowed by the protocol
+var deviceTypes = newSet(
+	"devices.types.light", "devices.types.light.ceiling", "devices.types.light.lamp", "devices.types.light.strip",
+	"devices.types.socket", "devices.types.switch", "devices.types.switch.relay",
+	"devices.types.thermostat", "devices.types.thermostat.ac",
+	"devices.types.media_device", "devices.types.media_device.tv", "devices.types.media_device.tv_box",
+	"devices.types.media_device.receiver",
+	"devices.types.cooking", "devices.types.cooking.coffee_maker", "devices.types.cooking.kettle",
+	"devices.types.cooking.multicooker",
+	"devices.types.openable", "devices.types.openable.curtain", "devices.types.openable.valve",
+	"devices.types.humidifier", "devices.types.purifier", "devices.types.vacuum_cleaner",
+	"devices.types.washing_machine", "devices.types.dishwasher", "devices.types.iron",
+	"devices.types.sensor", "devices.types.sensor.button", "devices.types.sensor.climate", "devices.types.sensor.gas",
+	"devices.types.sensor.illumination", "devices.types.sensor.motion", "devices.types.sensor.open",
+	"devices.types.sensor.smoke", "devices.types.sensor.vibration", "devices.types.sensor.water_leak",
+	"devices.types.smart_meter", "devices.types.smart_meter.cold_water", "devices.types.smart_meter.electricity",
+	"devices.types.smart_meter.gas", "devices.types.smart_meter.heat", "devices.types.smart_meter.hot_water",
+	"devices.types.camera", "devices.types.pet_drinking_fountain", "devices.types.pet_feeder",
+	"devices.types.ventilation", "devices.types.ventilation.fan",
+	"devices.types.other",
+)
+
+// rangeUnits is units of the range capability instances, instance without units don't allow unit
+var rangeUnits = map[string][]string{
+	"brightness":  {unitPercent},
+	"channel":     nil,
+	"humidity":    {unitPercent},
+	"open":        {unitPercent},
+	"temperature": {"unit.temperature.celsius", "unit.temperature.kelvin"},
+	"volume":      nil,
+}
+
+// toggleInstances is instances of the toggle capability
+var toggleInstances = newSet("backlight", "controls_locked", "ionization", "keep_warm", "mute", "oscillation", "pause")
+
+// modeInstances is instances of the mode capability
+var modeInstances = newSet("cleanup_mode", "coffee_mode", "dishwashing", "fan_speed", "heat", "input_source",
+	"program", "swing", "tea_mode", "thermostat", "work_speed")
+
+// modeValues is values of the mode capability
+var modeValues = newSet(
+	"auto", "eco", "smart", "turbo",
+	"cool", "dry", "fan_only", "heat", "preheat",
+	"high", "low", "medium", "max", "min", "fast", "slow", "express", "normal", "quiet",
+	"horizontal", "stationary", "vertical",
+	"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"americano", "cappuccino", "double", "double_espresso", "espresso", "latte",
+	"black_tea", "flower_tea", "green_tea", "herbal_tea", "oolong_tea", "puerh_tea", "red_tea", "white_tea",
+	"glass", "intensive", "pre_rinse",
+	"aspic", "baby_food", "baking", "bread", "boiling", "cereals", "cheesecake", "deep_fryer", "dessert",
+	"fowl", "frying", "macaroni", "milk_porridge", "multicooker", "pasta", "pilaf", "pizza", "sauce",
+	"slow_cook", "soup", "steam", "stewing", "vacuum", "wool", "yogurt",
+)
+
+// colorScenes is scenes of the color_setting capability
+var colorScenes = newSet("alarm", "alice", "candle", "dinner", "fantasy", "garland", "jungle", "movie", "neon",
+	"night", "ocean", "party", "reading", "rest", "romance", "siren", "sunrise", "sunset")
+
+// streamProtocols is protocols of the video_stream capability
+var streamProtocols = newSet("hls", "progressive_mp4")
+
+// floatUnits is units of the float property instances, instance without units don't allow unit
+var floatUnits = map[string][]string{
+	"amperage":            {"unit.ampere"},
+	"battery_level":       {unitPercent},
+	"co2_level":           {"unit.ppm"},
+	"electricity_meter":   {"unit.kilowatt_hour"},
+	"food_level":          {unitPercent},
+	"gas_concentration":   {unitPercent},
+	"gas_meter":           {"unit.cubic_meter"},
+	"heat_meter":          {"unit.gigacalorie"},
+	"humidity":            {unitPercent},
+	"illumination":        {"unit.illumination.lux"},
+	"meter":               nil,
+	"pm1_density":         {"unit.density.mcg_m3"},
+	"pm2.5_density":       {"unit.density.mcg_m3"},
+	"pm10_density":        {"unit.density.mcg_m3"},
+	"power":               {"unit.watt"},
+	"pressure":            {"unit.pressure.atm", "unit.pressure.pascal", "unit.pressure.bar", "unit.pressure.mmhg"},
+	"smoke_concentration": {unitPercent},
+	"temperature":         {"unit.temperature.celsius", "unit.temperature.kelvin"},
+	"tvoc":                {"unit.density.mcg_m3"},
+	"voltage":             {"unit.volt"},
+	"water_level":         {unitPercent},
+	"water_meter":         {"unit.cubic_meter"},
+}
+
+// eventValues is values of the event property instances
+var eventValues = map[string]map[string]bool{
+	"battery_level": newSet("low", "normal"),
+	"button":        newSet("click", "double_click", "long_press"),
+	"food_level":    newSet("empty", "low", "normal"),
+	"gas":           newSet("detected", "not_detected", "high"),
+	"motion":        newSet("detected", "not_detected"),
+	"open":          newSet("opened", "closed"),
+	"smoke":         newSet("detected", "not_detected", "high"),
+	"vibration":     newSet("tilt", "fall", "vibration"),
+	"water_leak":    newSet("dry", "leak"),
+	"water_level":   newSet("empty", "low", "normal"),
+}
+
+// newSet return set of the strings
+func newSet(values ...string) map[string]bool {
+	result := make(map[string]bool, len(values))
+	for _, value := range values {
+		result[value] = true
+	}
+
+	return result
+}
+
+// contains return true when value is in the list
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// device is device description of the devices response
+type device struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Type         string          `json:"type"`
+	CustomData   json.RawMessage `json:"custom_data,omitempty"`
+	Capabilities []capability    `json:"capabilities"`
+	Properties   []property      `json:"properties"`
+}
+
+// capability is capability description, parameters are decoded by the capability type
+type capability struct {
+	Type        string          `json:"type"`
+	Retrievable bool            `json:"retrievable"`
+	Reportable  bool            `json:"reportable"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// capabilityParameters is parameters of all capability types
+type capabilityParameters struct {
+	Instance     string   `json:"instance"`
+	Unit         string   `json:"unit"`
+	ColorModel   string   `json:"color_model"`
+	Protocols    []string `json:"protocols"`
+	TemperatureK *struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	} `json:"temperature_k"`
+	ColorScene *struct {
+		Scenes []struct {
+			ID string `json:"id"`
+		} `json:"scenes"`
+	} `json:"color_scene"`
+	Modes []struct {
+		Value string `json:"value"`
+	} `json:"modes"`
+	Range *struct {
+		Min       float64 `json:"min"`
+		Max       float64 `json:"max"`
+		Precision float64 `json:"precision"`
+	} `json:"range"`
+}
+
+// property is property description, parameters are decoded by the property type
+type property struct {
+	Type        string          `json:"type"`
+	Retrievable bool            `json:"retrievable"`
+	Reportable  bool            `json:"reportable"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// propertyParameters is parameters of all property types
+type propertyParameters struct {
+	Instance string `json:"instance"`
+	Unit     string `json:"unit"`
+	Events   []struct {
+		Value string `json:"value"`
+	} `json:"events"`
+}
+
+// state is capability or property state of the query response
+type state struct {
+	Type  string `json:"type"`
+	State struct {
+		Instance string          `json:"instance"`
+		Value    json.RawMessage `json:"value"`
+	} `json:"state"`
+}
+
+// check return violation of the device description, nil is returned when device is allowed by the protocol
+func (d *device) check() error {
+	if 0 == len(d.ID) {
+		return fmt.Errorf("device without id")
+	}
+
+	if 0 == len(strings.TrimSpace(d.Name)) {
+		return fmt.Errorf("device %s without name", d.ID)
+	}
+
+	if !deviceTypes[d.Type] {
+		return fmt.Errorf("device %s has unknown type %q", d.ID, d.Type)
+	}
+
+	if 0 == len(d.Capabilities) && 0 == len(d.Properties) {
+		return fmt.Errorf("device %s without capabilities and properties", d.ID)
+	}
+
+	declared := make(map[string]bool)
+	for index := range d.Capabilities {
+		c := &d.Capabilities[index]
+		if e := c.check(); nil != e {
+			return fmt.Errorf("device %s: %w", d.ID, e)
+		}
+
+		key := c.Type + "/" + c.instance()
+		if declared[key] {
+			return fmt.Errorf("device %s: duplicate capability %s", d.ID, key)
+		}
+		declared[key] = true
+	}
+
+	for index := range d.Properties {
+		p := &d.Properties[index]
+		if e := p.check(); nil != e {
+			return fmt.Errorf("device %s: %w", d.ID, e)
+		}
+
+		key := p.Type + "/" + p.instance()
+		if declared[key] {
+			return fmt.Errorf("device %s: duplicate property %s", d.ID, key)
+		}
+		declared[key] = true
+	}
+
+	return nil
+}
+
+// parameters return decoded capability parameters, absent parameters are empty
+func (c *capability) parameters() (*capabilityParameters, error) {
+	var result capabilityParameters
+	if 0 == len(c.Parameters) || "null" == string(c.Parameters) {
+		return &result, nil
+	}
+
+	if e := json.Unmarshal(c.Parameters, &result); nil != e {
+		return nil, fmt.Errorf("capability %s parameters: %w", c.Type, e)
+	}
+
+	return &result, nil
+}
+
+// instance return instance of the capability which may be declared several times, or empty string
+func (c *capability) instance() string {
+	switch c.Type {
+	case capabilityMode, capabilityRange, capabilityToggle:
+		if p, e := c.parameters(); nil == e {
+			return p.Instance
+		}
+	}
+
+	return ""
+}
+
+// check return violation of the capability description
+func (c *capability) check() error {
+	p, e := c.parameters()
+	if nil != e {
+		return e
+	}
+
+	switch c.Type {
+	case capabilityOnOff:
+		return nil
+	case capabilityColorSetting:
+		if 0 == len(p.ColorModel) && nil == p.TemperatureK && nil == p.ColorScene {
+			return fmt.Errorf("color_setting without color_model, temperature_k and color_scene")
+		}
+		if len(p.ColorModel) > 0 && "rgb" != p.ColorModel && "hsv" != p.ColorModel {
+			return fmt.Errorf("unknown color_model %q", p.ColorModel)
+		}
+		if t := p.TemperatureK; nil != t &&
+			(t.Min > t.Max || t.Min < minColorTemperature || t.Max > maxColorTemperature) {
+			return fmt.Errorf("temperature_k range %d..%d", t.Min, t.Max)
+		}
+		if nil != p.ColorScene {
+			if 0 == len(p.ColorScene.Scenes) {
+				return fmt.Errorf("color_scene without scenes")
+			}
+			for _, scene := range p.ColorScene.Scenes {
+				if !colorScenes[scene.ID] {
+					return fmt.Errorf("unknown color scene %q", scene.ID)
+				}
+			}
+		}
+	case capabilityMode:
+		if !modeInstances[p.Instance] {
+			return fmt.Errorf("unknown mode instance %q", p.Instance)
+		}
+		if 0 == len(p.Modes) {
+			return fmt.Errorf("mode %s without modes", p.Instance)
+		}
+		for _, mode := range p.Modes {
+			if !modeValues[mode.Value] {
+				return fmt.Errorf("unknown mode %q of %s", mode.Value, p.Instance)
+			}
+		}
+	case capabilityRange:
+		units, known := rangeUnits[p.Instance]
+		if !known {
+			return fmt.Errorf("unknown range instance %q", p.Instance)
+		}
+		if (0 == len(units) && len(p.Unit) > 0) || (len(units) > 0 && !contains(units, p.Unit)) {
+			return fmt.Errorf("unit %q of range %s", p.Unit, p.Instance)
+		}
+		if r := p.Range; nil != r {
+			if r.Min >= r.Max || r.Precision < 0 || (unitPercent == p.Unit && (r.Min < 0 || r.Max > 100)) {
+				return fmt.Errorf("range %s %v..%v precision %v", p.Instance, r.Min, r.Max, r.Precision)
+			}
+		}
+	case capabilityToggle:
+		if !toggleInstances[p.Instance] {
+			return fmt.Errorf("unknown toggle instance %q", p.Instance)
+		}
+	case capabilityVideoStream:
+		if c.Retrievable {
+			return fmt.Errorf("video_stream is retrievable")
+		}
+		if 0 == len(p.Protocols) {
+			return fmt.Errorf("video_stream without protocols")
+		}
+		for _, protocol := range p.Protocols {
+			if !streamProtocols[protocol] {
+				return fmt.Errorf("unknown video_stream protocol %q", protocol)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown capability %q", c.Type)
+	}
+
+	return nil
+}
+
+// checkState return violation of the capability state
+func (c *capability) checkState(instance string, value json.RawMessage) error {
+	p, e := c.parameters()
+	if nil != e {
+		return e
+	}
+
+	switch c.Type {
+	case capabilityOnOff:
+		if "on" != instance {
+			return fmt.Errorf("unknown on_off instance %q", instance)
+		}
+		var on bool
+		return decodeValue(c.Type, value, &on)
+	case capabilityColorSetting:
+		return checkColor(p, instance, value)
+	case capabilityMode:
+		var mode string
+		if e = decodeValue(c.Type, value, &mode); nil != e {
+			return e
+		}
+		for _, m := range p.Modes {
+			if m.Value == mode {
+				return nil
+			}
+		}
+		return fmt.Errorf("mode %s value %q isn't declared", instance, mode)
+	case capabilityRange:
+		var number float64
+		if e = decodeValue(c.Type, value, &number); nil != e {
+			return e
+		}
+		if r := p.Range; nil != r && (number < r.Min || number > r.Max) {
+			return fmt.Errorf("range %s value %v out of %v..%v", instance, number, r.Min, r.Max)
+		}
+	case capabilityToggle:
+		var on bool
+		return decodeValue(c.Type, value, &on)
+	default:
+		return fmt.Errorf("capability %s has no state", c.Type)
+	}
+
+	return nil
+}
+
+// checkColor return violation of the color_setting state
+func checkColor(p *capabilityParameters, instance string, value json.RawMessage) error {
+	switch instance {
+	case "rgb":
+		var rgb int
+		if e := decodeValue(capabilityColorSetting, value, &rgb); nil != e {
+			return e
+		}
+		if "rgb" != p.ColorModel || rgb < 0 || rgb > maxRGB {
+			return fmt.Errorf("rgb color %d, color_model %q", rgb, p.ColorModel)
+		}
+	case "hsv":
+		var hsv struct {
+			H int `json:"h"`
+			S int `json:"s"`
+			V int `json:"v"`
+		}
+		if e := decodeValue(capabilityColorSetting, value, &hsv); nil != e {
+			return e
+		}
+		if "hsv" != p.ColorModel || hsv.H < 0 || hsv.H > 360 || hsv.S < 0 || hsv.S > 100 || hsv.V < 0 || hsv.V > 100 {
+			return fmt.Errorf("hsv color %+v, color_model %q", hsv, p.ColorModel)
+		}
+	case "temperature_k":
+		var temperature int
+		if e := decodeValue(capabilityColorSetting, value, &temperature); nil != e {
+			return e
+		}
+		if nil == p.TemperatureK || temperature < p.TemperatureK.Min || temperature > p.TemperatureK.Max {
+			return fmt.Errorf("color temperature %d isn't declared", temperature)
+		}
+	case "scene":
+		var scene string
+		if e := decodeValue(capabilityColorSetting, value, &scene); nil != e {
+			return e
+		}
+		declared := false
+		if nil != p.ColorScene {
+			for _, s := range p.ColorScene.Scenes {
+				declared = declared || s.ID == scene
+			}
+		}
+		if !declared {
+			return fmt.Errorf("color scene %q isn't declared", scene)
+		}
+	default:
+		return fmt.Errorf("unknown color_setting instance %q", instance)
+	}
+
+	return nil
+}
+
+// parameters return decoded property parameters
+func (p *property) parameters() (*propertyParameters, error) {
+	var result propertyParameters
+	if e := json.Unmarshal(p.Parameters, &result); nil != e {
+		return nil, fmt.Errorf("property %s parameters: %w", p.Type, e)
+	}
+
+	return &result, nil
+}
+
+// instance return instance of the property
+func (p *property) instance() string {
+	if parameters, e := p.parameters(); nil == e {
+		return parameters.Instance
+	}
+
+	return ""
+}
+
+// check return violation of the property description
+func (p *property) check() error {
+	if !p.Retrievable && !p.Reportable {
+		return fmt.Errorf("property %s is neither retrievable nor reportable", p.Type)
+	}
+
+	parameters, e := p.parameters()
+	if nil != e {
+		return e
+	}
+
+	switch p.Type {
+	case propertyFloat:
+		units, known := floatUnits[parameters.Instance]
+		if !known {
+			return fmt.Errorf("unknown float instance %q", parameters.Instance)
+		}
+		if (0 == len(units) && len(parameters.Unit) > 0) || (len(units) > 0 && !contains(units, parameters.Unit)) {
+			return fmt.Errorf("unit %q of float %s", parameters.Unit, parameters.Instance)
+		}
+	case propertyEvent:
+		values, known := eventValues[parameters.Instance]
+		if !known {
+			return fmt.Errorf("unknown event instance %q", parameters.Instance)
+		}
+		if 0 == len(parameters.Events) {
+			return fmt.Errorf("event %s without events", parameters.Instance)
+		}
+		for _, event := range parameters.Events {
+			if !values[event.Value] {
+				return fmt.Errorf("unknown event %q of %s", event.Value, parameters.Instance)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown property %q", p.Type)
+	}
+
+	return nil
+}
+
+// checkState return violation of the property state
+func (p *property) checkState(instance string, value json.RawMessage) error {
+	parameters, e := p.parameters()
+	if nil != e {
+		return e
+	}
+
+	switch p.Type {
+	case propertyFloat:
+		var number float64
+		if e = decodeValue(p.Type, value, &number); nil != e {
+			return e
+		}
+		if unitPercent == parameters.Unit && (number < 0 || number > 100) {
+			return fmt.Errorf("float %s value %v out of 0..100", instance, number)
+		}
+	case propertyEvent:
+		var event string
+		if e = decodeValue(p.Type, value, &event); nil != e {
+			return e
+		}
+		for _, declared := range parameters.Events {
+			if declared.Value == event {
+				return nil
+			}
+		}
+		return fmt.Errorf("event %s value %q isn't declared", instance, event)
+	}
+
+	return nil
+}
+
+// decodeValue decode state value, value must be present and have the type of the result
+func decodeValue(stateType string, value json.RawMessage, result interface{}) error {
+	if 0 == len(value) || "null" == string(value) {
+		return fmt.Errorf("%s state without value", stateType)
+	}
+
+	if e := json.Unmarshal(value, result); nil != e {
+		return fmt.Errorf("%s state value %s: %w", stateType, string(value), e)
+	}
+
+	return nil
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// csrfPattern extract CSRF token from the authorization page
+var csrfPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// simulatorConfig is simulator settings
+type simulatorConfig struct {
+	baseURL      string
+	prefix       string
+	clientID     string
+	clientSecret string
+	redirectURI  string
+	// username and password is credentials of the user who link accounts
+	username string
+	password string
+	// accessToken is token issued by the external OAuth provider, accounts aren't linked when it's set
+	accessToken string
+	toggle      bool
+	skipUnlink  bool
+	// insecure disable verification of the service TLS certificate
+	insecure bool
+	timeout  time.Duration
+	// googleIntents is directory with recorded Google intents
+	googleIntents string
+}
+
+// simulator act as Yandex Smart Home cloud
+type simulator struct {
+	config simulatorConfig
+	report *report
+	client *http.Client
+	token  tokenResponse
+	// codeVerifier is PKCE secret of the authorization request
+	codeVerifier string
+	// devices is declared devices by ID
+	devices map[string]*device
+	// states is on_off states of the devices received by query
+	states map[string]bool
+}
+
+// newSimulator return new simulator
+func newSimulator(config simulatorConfig, r *report) *simulator {
+	// Cookie jar keep session of the authorization page, error is never returned without options
+	jar, _ := cookiejar.New(nil)
+
+	return &simulator{
+		config: config,
+		report: r,
+		client: &http.Client{
+			Timeout: config.timeout,
+			Jar:     jar,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: config.insecure,
+				},
+			},
+			// Authorization code is returned by redirect, which must not be followed
+			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		devices: make(map[string]*device),
+		states:  make(map[string]bool),
+	}
+}
+
+// run perform all simulation steps, later steps skipped when required step failed
+func (s *simulator) run() {
+	if !s.probe() {
+		return
+	}
+
+	if !s.link() {
+		return
+	}
+
+	if !s.enumerateDevices() {
+		return
+	}
+
+	s.queryDevices()
+	s.performActions()
+
+	if s.config.skipUnlink {
+		return
+	}
+
+	if s.unlink() {
+		s.checkRevoked()
+	}
+}
+
+// probe check service is ready
+func (s *simulator) probe() bool {
+	s.report.step("HEAD " + s.config.prefix + endpointProbe)
+
+	response, e := s.do(http.MethodHead, s.config.prefix+endpointProbe, nil, "")
+	if nil != e {
+		s.report.violatef("%v", e)
+		return false
+	}
+	_ = response.Body.Close()
+
+	if http.StatusOK != response.StatusCode {
+		s.report.violatef("status %d, expected %d", response.StatusCode, http.StatusOK)
+		return false
+	}
+
+	return true
+}
+
+// link link accounts, or use access token of the external provider
+func (s *simulator) link() bool {
+	if len(s.config.accessToken) > 0 {
+		s.token = tokenResponse{AccessToken: s.config.accessToken, TokenType: "Bearer"}
+		return true
+	}
+
+	code, ok := s.authorize()
+	if !ok {
+		return false
+	}
+
+	return s.exchangeCode(code)
+}
+
+// authorize log in on the authorization page, allow access and return authorization code
+func (s *simulator) authorize() (string, bool) {
+	s.report.step("GET " + endpointOAuth)
+
+	state := uuid.NewString()
+	s.codeVerifier = uuid.NewString() + uuid.NewString()
+	challenge := sha256.Sum256([]byte(s.codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.config.clientID},
+		"redirect_uri":          {s.config.redirectURI},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	path := endpointOAuth + "?" + query.Encode()
+
+	csrf, ok := s.authorizePage(http.MethodGet, path, nil)
+	if !ok {
+		return "", false
+	}
+
+	s.report.step("POST " + endpointOAuth + " (login)")
+
+	if csrf, ok = s.authorizePage(http.MethodPost, path, url.Values{
+		"csrf_token": {csrf},
+		"action":     {"login"},
+		"username":   {s.config.username},
+		"password":   {s.config.password},
+	}); !ok {
+		return "", false
+	}
+
+	s.report.step("POST " + endpointOAuth + " (allow)")
+
+	response, e := s.do(http.MethodPost, path, strings.NewReader(url.Values{
+		"csrf_token": {csrf},
+		"action":     {"allow"},
+	}.Encode()), "application/x-www-form-urlencoded")
+	if nil != e {
+		s.report.violatef("%v", e)
+		return "", false
+	}
+	_ = response.Body.Close()
+
+	if http.StatusFound != response.StatusCode {
+		s.report.violatef("status %d, expected redirect %d", response.StatusCode, http.StatusFound)
+		return "", false
+	}
+
+	location, e := url.Parse(response.Header.Get("Location"))
+	if nil != e {
+		s.report.violatef("invalid redirect location: %v", e)
+		return "", false
+	}
+
+	if !strings.HasPrefix(location.String(), s.config.redirectURI) {
+		s.report.violatef("redirect to %s, expected %s", location, s.config.redirectURI)
+	}
+
+	if errorCode := location.Query().Get("error"); len(errorCode) > 0 {
+		s.report.violatef("authorization error %s: %s", errorCode, location.Query().Get("error_description"))
+		return "", false
+	}
+
+	if location.Query().Get("state") != state {
+		s.report.violatef("state %q isn't returned", state)
+	}
+
+	code := location.Query().Get("code")
+	if 0 == len(code) {
+		s.report.violatef("authorization code isn't returned")
+		return "", false
+	}
+
+	return code, true
+}
+
+// authorizePage request login or consent page and return CSRF token of the page form
+func (s *simulator) authorizePage(method string, path string, form url.Values) (string, bool) {
+	var body io.Reader
+	contentType := ""
+	if nil != form {
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	}
+
+	response, e := s.do(method, path, body, contentType)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return "", false
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	page, e := io.ReadAll(response.Body)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return "", false
+	}
+
+	if http.StatusOK != response.StatusCode {
+		s.report.violatef("status %d, expected %d", response.StatusCode, http.StatusOK)
+		return "", false
+	}
+
+	match := csrfPattern.FindSubmatch(page)
+	if nil == match {
+		s.report.violatef("CSRF token isn't found on the page")
+		return "", false
+	}
+
+	return html.UnescapeString(string(match[1])), true
+}
+
+// exchangeCode exchange authorization code to the tokens
+func (s *simulator) exchangeCode(code string) bool {
+	s.report.step("POST " + endpointToken + " (authorization_code)")
+
+	var ok bool
+	if s.token, ok = s.requestToken(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.config.redirectURI},
+		"code_verifier": {s.codeVerifier},
+	}); !ok {
+		return false
+	}
+
+	if 0 == len(s.token.AccessToken) {
+		s.report.violatef("access_token isn't returned")
+		return false
+	}
+
+	if !strings.EqualFold("bearer", s.token.TokenType) {
+		s.report.violatef("token_type %q, expected Bearer", s.token.TokenType)
+	}
+
+	if 0 == len(s.token.RefreshToken) {
+		s.report.violatef("refresh_token isn't returned, Yandex require it")
+	}
+
+	return true
+}
+
+// requestToken perform token request
+func (s *simulator) requestToken(form url.Values) (token tokenResponse, ok bool) {
+	response, e := s.postToken(form)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return token, false
+	}
+
+	status, e := decodeResponse(response, &token)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return token, false
+	}
+
+	if http.StatusOK != status {
+		s.report.violatef("status %d, error %q", status, token.Error)
+		return token, false
+	}
+
+	return token, true
+}
+
+// postToken send form to the token endpoint, client is authenticated by HTTP Basic credentials
+func (s *simulator) postToken(form url.Values) (*http.Response, error) {
+	request, e := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.config.baseURL, "/")+endpointToken,
+		strings.NewReader(form.Encode()))
+	if nil != e {
+		return nil, e
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Credentials are form encoded before they're put to the header (RFC 6749 section 2.3.1)
+	request.SetBasicAuth(url.QueryEscape(s.config.clientID), url.QueryEscape(s.config.clientSecret))
+
+	return s.client.Do(request)
+}
+
+// enumerateDevices request user devices and check device descriptions
+func (s *simulator) enumerateDevices() bool {
+	s.report.step("GET " + s.config.prefix + endpointDevices)
+
+	var result devicesResponse
+	requestID, ok := s.call(http.MethodGet, s.config.prefix+endpointDevices, nil, &result)
+	if !ok {
+		return false
+	}
+
+	s.checkRequestID(requestID, result.RequestID)
+
+	if 0 == len(result.Payload.UserID) {
+		s.report.violatef("payload.user_id is empty")
+	}
+
+	for index, raw := range result.Payload.Devices {
+		var d device
+		if e := json.Unmarshal(raw, &d); nil != e {
+			s.report.violatef("device #%d: %v", index, e)
+			continue
+		}
+
+		if e := d.check(); nil != e {
+			s.report.violatef("device #%d: %v", index, e)
+		}
+
+		if _, found := s.devices[d.ID]; found {
+			s.report.violatef("duplicate device id %s", d.ID)
+			continue
+		}
+		s.devices[d.ID] = &d
+	}
+
+	if 0 == len(s.devices) {
+		s.report.violatef("no devices returned, following steps are skipped")
+		return false
+	}
+
+	return true
+}
+
+// queryDevices request states of all devices and check states are allowed by the device descriptions
+func (s *simulator) queryDevices() {
+	s.report.step("POST " + s.config.prefix + endpointQuery)
+
+	var request queryRequest
+	for id, device := range s.devices {
+		request.Devices = append(request.Devices, deviceRequest{
+			ID:         id,
+			CustomData: customData(device),
+		})
+	}
+	// Unknown device must be reported by the device level error
+	request.Devices = append(request.Devices, deviceRequest{ID: "simulator-unknown-device"})
+
+	var result queryResponse
+	requestID, ok := s.call(http.MethodPost, s.config.prefix+endpointQuery, &request, &result)
+	if !ok {
+		return
+	}
+
+	s.checkRequestID(requestID, result.RequestID)
+
+	received := make(map[string]bool)
+	for _, state := range result.Payload.Devices {
+		received[state.ID] = true
+		s.checkDeviceState(state)
+	}
+
+	for _, device := range request.Devices {
+		if !received[device.ID] {
+			s.report.violatef("device %s state isn't returned", device.ID)
+		}
+	}
+}
+
+// checkDeviceState check device state is allowed by the device description
+func (s *simulator) checkDeviceState(deviceState deviceState) {
+	d, declared := s.devices[deviceState.ID]
+
+	if len(deviceState.ErrorCode) > 0 {
+		if !errorCodes[deviceState.ErrorCode] {
+			s.report.violatef("device %s: unknown error_code %s", deviceState.ID, deviceState.ErrorCode)
+		}
+		if len(deviceState.Capabilities) > 0 || len(deviceState.Properties) > 0 {
+			s.report.violatef("device %s: error_code with states", deviceState.ID)
+		}
+		return
+	}
+
+	if !declared {
+		s.report.violatef("device %s: state of the undeclared device", deviceState.ID)
+		return
+	}
+
+	for _, raw := range deviceState.Capabilities {
+		var c state
+		if e := json.Unmarshal(raw, &c); nil != e {
+			s.report.violatef("device %s: %v", deviceState.ID, e)
+			continue
+		}
+
+		capability := findCapability(d, c.Type, c.State.Instance)
+		if nil == capability {
+			s.report.violatef("device %s: state of the undeclared capability %s %s",
+				deviceState.ID, c.Type, c.State.Instance)
+			continue
+		}
+
+		if !capability.Retrievable {
+			s.report.violatef("device %s: state of the not retrievable capability %s", deviceState.ID, c.Type)
+		}
+
+		if e := capability.checkState(c.State.Instance, c.State.Value); nil != e {
+			s.report.violatef("device %s: %v", deviceState.ID, e)
+			continue
+		}
+
+		var on bool
+		if capabilityOnOff == c.Type && nil == json.Unmarshal(c.State.Value, &on) {
+			s.states[deviceState.ID] = on
+		}
+	}
+
+	for _, raw := range deviceState.Properties {
+		var p state
+		if e := json.Unmarshal(raw, &p); nil != e {
+			s.report.violatef("device %s: %v", deviceState.ID, e)
+			continue
+		}
+
+		property := findProperty(d, p.Type, p.State.Instance)
+		if nil == property {
+			s.report.violatef("device %s: state of the undeclared property %s %s",
+				deviceState.ID, p.Type, p.State.Instance)
+			continue
+		}
+
+		if e := property.checkState(p.State.Instance, p.State.Value); nil != e {
+			s.report.violatef("device %s: %v", deviceState.ID, e)
+		}
+	}
+}
+
+// performActions set on_off state of the devices with known state
+func (s *simulator) performActions() {
+	s.report.step("POST " + s.config.prefix + endpointAction)
+
+	var request actionRequest
+	for id, on := range s.states {
+		if s.config.toggle {
+			on = !on
+		}
+
+		request.Payload.Devices = append(request.Payload.Devices, actionDevice{
+			deviceRequest: deviceRequest{
+				ID:         id,
+				CustomData: customData(s.devices[id]),
+			},
+			Capabilities: []actionCapability{onOffAction(on)},
+		})
+	}
+	// Unknown device must be reported by the device level error
+	request.Payload.Devices = append(request.Payload.Devices, actionDevice{
+		deviceRequest: deviceRequest{ID: "simulator-unknown-device"},
+		Capabilities:  []actionCapability{onOffAction(true)},
+	})
+
+	var result actionResponse
+	requestID, ok := s.call(http.MethodPost, s.config.prefix+endpointAction, &request, &result)
+	if !ok {
+		return
+	}
+
+	s.checkRequestID(requestID, result.RequestID)
+
+	received := make(map[string]*deviceResult)
+	for index := range result.Payload.Devices {
+		received[result.Payload.Devices[index].ID] = &result.Payload.Devices[index]
+	}
+
+	for _, device := range request.Payload.Devices {
+		deviceResult, found := received[device.ID]
+		if !found {
+			s.report.violatef("device %s action result isn't returned", device.ID)
+			continue
+		}
+
+		s.checkActionResult(device, deviceResult)
+	}
+}
+
+// checkActionResult check device action result
+func (s *simulator) checkActionResult(request actionDevice, result *deviceResult) {
+	if nil != result.ActionResult {
+		s.checkResult(request.ID, result.ActionResult)
+		if len(result.Capabilities) > 0 {
+			s.report.violatef("device %s: action_result with capability results", request.ID)
+		}
+		return
+	}
+
+	if _, declared := s.devices[request.ID]; !declared {
+		s.report.violatef("device %s: undeclared device without device level error", request.ID)
+	}
+
+	for _, c := range request.Capabilities {
+		found := false
+		for _, r := range result.Capabilities {
+			if r.Type == c.Type && r.State.Instance == c.State.Instance {
+				found = true
+				s.checkResult(request.ID, &r.State.ActionResult)
+			}
+		}
+
+		if !found {
+			s.report.violatef("device %s: result of the capability %s %s isn't returned",
+				request.ID, c.Type, c.State.Instance)
+		}
+	}
+}
+
+// checkResult check action result is allowed by the protocol
+func (s *simulator) checkResult(id string, result *actionResult) {
+	switch result.Status {
+	case actionDone:
+		if len(result.ErrorCode) > 0 {
+			s.report.violatef("device %s: status %s with error_code", id, result.Status)
+		}
+	case actionError:
+		if !errorCodes[result.ErrorCode] {
+			s.report.violatef("device %s: unknown error_code %q", id, result.ErrorCode)
+		}
+	default:
+		s.report.violatef("device %s: unknown status %q", id, result.Status)
+	}
+}
+
+// unlink unlink accounts
+func (s *simulator) unlink() bool {
+	s.report.step("POST " + s.config.prefix + endpointUnlink)
+
+	var result unlinkResponse
+	requestID, ok := s.call(http.MethodPost, s.config.prefix+endpointUnlink, nil, &result)
+	if !ok {
+		return false
+	}
+
+	s.checkRequestID(requestID, result.RequestID)
+
+	return true
+}
+
+// checkRevoked check tokens aren't accepted after unlink
+func (s *simulator) checkRevoked() {
+	s.report.step("Tokens revoked after unlink")
+
+	response, e := s.do(http.MethodGet, s.config.prefix+endpointDevices, nil, "")
+	if nil != e {
+		s.report.violatef("%v", e)
+		return
+	}
+	_ = response.Body.Close()
+
+	if http.StatusUnauthorized != response.StatusCode && http.StatusForbidden != response.StatusCode {
+		s.report.violatef("access token accepted after unlink, status %d", response.StatusCode)
+	}
+
+	if 0 == len(s.token.RefreshToken) {
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.token.RefreshToken},
+	}
+
+	if response, e = s.postToken(form); nil != e {
+		s.report.violatef("%v", e)
+		return
+	}
+	_ = response.Body.Close()
+
+	if http.StatusOK == response.StatusCode {
+		s.report.violatef("refresh token accepted after unlink")
+	}
+}
+
+// checkRequestID check request ID is returned in the response
+func (s *simulator) checkRequestID(sent string, received string) {
+	if sent != received {
+		s.report.violatef("request_id %q, expected %q", received, sent)
+	}
+}
+
+// call perform authorized request to the skill and decode response
+func (s *simulator) call(method string, path string, request interface{}, result interface{}) (string, bool) {
+	var body io.Reader
+	contentType := ""
+	if nil != request {
+		data, e := json.Marshal(request)
+		if nil != e {
+			s.report.violatef("%v", e)
+			return "", false
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	requestID := uuid.NewString()
+
+	response, e := s.doWithRequestID(method, path, body, contentType, requestID)
+	if nil != e {
+		s.report.violatef("%v", e)
+		return requestID, false
+	}
+
+	status, e := decodeResponse(response, result)
+	if http.StatusOK != status {
+		s.report.violatef("status %d, expected %d", status, http.StatusOK)
+		return requestID, false
+	}
+
+	if nil != e {
+		s.report.violatef("%v", e)
+		return requestID, false
+	}
+
+	return requestID, true
+}
+
+// do perform request to the service
+func (s *simulator) do(method string, path string, body io.Reader, contentType string) (*http.Response, error) {
+	return s.doWithRequestID(method, path, body, contentType, uuid.NewString())
+}
+
+// doWithRequestID perform request to the service with the request ID
+func (s *simulator) doWithRequestID(method string,
+	path string,
+	body io.Reader,
+	contentType string,
+	requestID string) (*http.Response, error) {
+	request, e := http.NewRequest(method, strings.TrimSuffix(s.config.baseURL, "/")+path, body)
+	if nil != e {
+		return nil, e
+	}
+
+	request.Header.Set(headerRequestID, requestID)
+	if len(contentType) > 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if len(s.token.AccessToken) > 0 {
+		request.Header.Set("Authorization", "Bearer "+s.token.AccessToken)
+	}
+
+	return s.client.Do(request)
+}
+
+// decodeResponse decode JSON response body
+func decodeResponse(response *http.Response, result interface{}) (int, error) {
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	data, e := io.ReadAll(response.Body)
+	if nil != e {
+		return response.StatusCode, e
+	}
+
+	if e = json.Unmarshal(data, result); nil != e {
+		return response.StatusCode, fmt.Errorf("invalid response %q: %w", string(data), e)
+	}
+
+	return response.StatusCode, nil
+}
+
+// onOffAction return request of the on_off capability state
+func onOffAction(on bool) actionCapability {
+	result := actionCapability{Type: capabilityOnOff}
+	result.State.Instance = "on"
+	result.State.Value = on
+
+	return result
+}
+
+// findCapability return declared device capability
+func findCapability(d *device, capabilityType string, instance string) *capability {
+	for index := range d.Capabilities {
+		c := &d.Capabilities[index]
+		if c.Type != capabilityType {
+			continue
+		}
+
+		if declared := c.instance(); len(declared) > 0 && declared != instance {
+			continue
+		}
+
+		return c
+	}
+
+	return nil
+}
+
+// findProperty return declared device property
+func findProperty(d *device, propertyType string, instance string) *property {
+	for index := range d.Properties {
+		p := &d.Properties[index]
+		if p.Type == propertyType && p.instance() == instance {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// customData return custom data of the device, which must be returned to the service as is
+func customData(d *device) json.RawMessage {
+	if nil == d {
+		return nil
+	}
+
+	return d.CustomData
+}
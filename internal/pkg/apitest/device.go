@@ -0,0 +1,126 @@
+package apitest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// Device is fake device which apply commands to the state cache immediately, or fail with the configured error
+type Device struct {
+	ID         string
+	Name       string
+	Channels   []api.Channel
+	Sensors    []api.Sensor
+	StateCache api.StateCache
+	// Failure is returned by Execute instead of the command execution, when it's set
+	Failure error
+
+	lock     sync.Mutex
+	commands []api.Command
+}
+
+// GetType is implementation of api.Device interface
+func (d *Device) GetType() string {
+	return "tasmota"
+}
+
+// GetName is implementation of api.Device interface
+func (d *Device) GetName() string {
+	return d.Name
+}
+
+// GetFirmwareVersion is implementation of api.Device interface
+func (d *Device) GetFirmwareVersion() string {
+	return "12.1.1"
+}
+
+// GetChannels is implementation of api.Device interface
+func (d *Device) GetChannels() []api.Channel {
+	return d.Channels
+}
+
+// GetSensors is implementation of api.Device interface
+func (d *Device) GetSensors() []api.Sensor {
+	return d.Sensors
+}
+
+// GetRoute is implementation of api.Device interface
+func (d *Device) GetRoute() api.Route {
+	return api.Route{Integration: "tasmota", Topic: d.ID}
+}
+
+// Update is implementation of api.Device interface
+func (d *Device) Update(_ api.Device) error {
+	return nil
+}
+
+// Execute is implementation of api.Device interface
+func (d *Device) Execute(_ context.Context, command api.Command) error {
+	if nil != d.Failure {
+		return d.Failure
+	}
+
+	d.lock.Lock()
+	d.commands = append(d.commands, command)
+	d.lock.Unlock()
+
+	return d.StateCache.UpdateState(d.ID, func(state *api.State) {
+		channel := state.Channels[command.Channel]
+		switch command.Type {
+		case api.CommandOnOff:
+			channel.On = command.On
+		case api.CommandBrightness:
+			channel.Brightness = command.Brightness
+		case api.CommandColor:
+			channel.Color = command.Color
+			channel.ColorTemperature = 0
+		case api.CommandColorTemperature:
+			channel.ColorTemperature = command.ColorTemperature
+		}
+		state.Channels[command.Channel] = channel
+	})
+}
+
+// Executed return commands received by the device
+func (d *Device) Executed() []api.Command {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]api.Command(nil), d.commands...)
+}
+
+// Devices is device manager of the fixed devices by device ID
+type Devices map[string]api.Device
+
+// AddDevice is implementation of api.DeviceManager interface
+func (devices Devices) AddDevice(deviceID string, device api.Device) error {
+	devices[deviceID] = device
+	return nil
+}
+
+// GetDevice is implementation of api.DeviceManager interface
+func (devices Devices) GetDevice(deviceID string) (api.Device, error) {
+	if device, found := devices[deviceID]; found {
+		return device, nil
+	}
+
+	return nil, api.ErrDeviceNotFound
+}
+
+// FindDevice is implementation of api.DeviceManager interface
+func (devices Devices) FindDevice(route api.Route) (string, api.Device, error) {
+	for deviceID, device := range devices {
+		if route == device.GetRoute() {
+			return deviceID, device, nil
+		}
+	}
+
+	return "", nil, api.ErrDeviceNotFound
+}
+
+// EnumDevices is implementation of api.DeviceManager interface
+func (devices Devices) EnumDevices() (map[string]api.Device, error) {
+	return devices, nil
+}
@@ -0,0 +1,63 @@
+package apitest
+
+import (
+	"sync"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// States is state cache in memory, states don't expire
+type States struct {
+	lock   sync.Mutex
+	states map[string]*api.State
+}
+
+// NewStates return empty state cache
+func NewStates() *States {
+	return &States{
+		states: make(map[string]*api.State),
+	}
+}
+
+// UpdateState is implementation of api.StateCache interface
+func (cache *States) UpdateState(deviceID string, update func(state *api.State)) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	state, found := cache.states[deviceID]
+	if !found {
+		state = &api.State{
+			Online:   true,
+			Channels: make(map[int]api.ChannelState),
+			Sensors:  make(map[api.SensorKind]float64),
+		}
+		cache.states[deviceID] = state
+	}
+
+	update(state)
+
+	return nil
+}
+
+// GetState is implementation of api.StateCache interface
+func (cache *States) GetState(deviceID string) (api.State, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	state, found := cache.states[deviceID]
+	if !found || !state.Online {
+		return api.State{}, api.ErrDeviceUnreachable
+	}
+
+	result := *state
+	result.Channels = make(map[int]api.ChannelState, len(state.Channels))
+	for index, channel := range state.Channels {
+		result.Channels[index] = channel
+	}
+	result.Sensors = make(map[api.SensorKind]float64, len(state.Sensors))
+	for kind, value := range state.Sensors {
+		result.Sensors[kind] = value
+	}
+
+	return result, nil
+}
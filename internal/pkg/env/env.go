@@ -0,0 +1,26 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration return positive duration from the environment variable, or default value when it isn't set
+func Duration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue, nil
+	}
+
+	result, e := time.ParseDuration(value)
+	if nil != e {
+		return 0, fmt.Errorf("%s: %w", name, e)
+	}
+
+	if result <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package alisa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/pkg/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// Yandex action statuses
+	actionStatusDone  = "DONE"
+	actionStatusError = "ERROR"
+)
+
+// actionDevice is device with requested capability states
+type actionDevice struct {
+	deviceRequest
+	// Capabilities decoded one by one, so invalid capability don't break whole request
+	Capabilities []json.RawMessage `json:"capabilities"`
+}
+
+// actionRequest is request for "/v1.0/user/devices/action"
+type actionRequest struct {
+	Payload struct {
+		Devices []actionDevice `json:"devices"`
+	} `json:"payload"`
+}
+
+// deviceResult is device action result in the action response
+type deviceResult struct {
+	ID           string             `json:"id"`
+	Capabilities []CapabilityResult `json:"capabilities,omitempty"`
+	ActionResult *ActionResult      `json:"action_result,omitempty"`
+}
+
+type actionPayload struct {
+	Devices []deviceResult `json:"devices"`
+}
+
+// actionResponse is response for "/v1.0/user/devices/action" request
+type actionResponse struct {
+	RequestID string        `json:"request_id,omitempty"`
+	Payload   actionPayload `json:"payload"`
+}
+
+func newActionResponse(ginCtx *gin.Context, devices []deviceResult) *actionResponse {
+	return &actionResponse{
+		RequestID: ginCtx.GetHeader(headerRequestID),
+		Payload: actionPayload{
+			Devices: devices,
+		},
+	}
+}
+
+// newActionResult return action result for the error
+func newActionResult(e error) ActionResult {
+	if nil == e {
+		return ActionResult{
+			Status: actionStatusDone,
+		}
+	}
+
+	return ActionResult{
+		Status:       actionStatusError,
+		ErrorCode:    errorCode(e),
+		ErrorMessage: e.Error(),
+	}
+}
+
+// executeActions perform actions on all requested devices concurrently
+func executeActions(ctx context.Context,
+	logger *zap.SugaredLogger,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	devices []actionDevice) []deviceResult {
+	results := make([]deviceResult, len(devices))
+
+	var wg sync.WaitGroup
+	for index := range devices {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			results[index] = executeDeviceActions(ctx, logger, deviceManager, stateCache, &devices[index])
+		}(index)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// executeDeviceActions perform actions on the device one by one
+func executeDeviceActions(ctx context.Context,
+	logger *zap.SugaredLogger,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	request *actionDevice) deviceResult {
+	result := deviceResult{
+		ID: request.ID,
+	}
+
+	deviceID, device, channel, e := resolveEndpoint(deviceManager, request.ID, request.CustomData)
+	if nil == e && nil == channel {
+		// Sensors don't have any capabilities
+		e = fmt.Errorf("%w: device don't have capabilities", api.ErrInvalidAction)
+	}
+	if nil != e {
+		logger.Warnw("Device action failed", "device_id", request.ID, "error", e)
+		deviceError := newActionResult(e)
+		result.ActionResult = &deviceError
+		return result
+	}
+
+	capabilities := channelCapabilities(*channel, false)
+
+	for _, raw := range request.Capabilities {
+		var c CapabilityState
+		if e = json.Unmarshal(raw, &c); nil != e {
+			logger.Warnw("Invalid capability action", "device_id", request.ID, "error", e)
+			result.Capabilities = append(result.Capabilities, newInvalidCapabilityResult(raw, e))
+			continue
+		}
+
+		command, e := newCommand(stateCache, deviceID, *channel, capabilities, c.State)
+		if nil == e {
+			e = device.Execute(ctx, command)
+		}
+		if nil != e {
+			logger.Warnw("Capability action failed",
+				"device_id", request.ID,
+				"type", c.Type,
+				"instance", c.State.GetInstance(),
+				"error", e)
+		}
+
+		result.Capabilities = append(result.Capabilities, CapabilityResult{
+			Type: c.Type,
+			State: CapabilityResultState{
+				Instance:     c.State.GetInstance(),
+				ActionResult: newActionResult(e),
+			},
+		})
+	}
+
+	return result
+}
+
+// newInvalidCapabilityResult return result for the capability which can't be decoded
+func newInvalidCapabilityResult(raw json.RawMessage, e error) CapabilityResult {
+	var c struct {
+		Type  string `json:"type"`
+		State struct {
+			Instance string `json:"instance"`
+		} `json:"state"`
+	}
+	_ = json.Unmarshal(raw, &c)
+
+	if !errors.Is(e, api.ErrInvalidAction) && !errors.Is(e, api.ErrInvalidValue) {
+		e = fmt.Errorf("%w: %v", api.ErrInvalidValue, e)
+	}
+
+	return CapabilityResult{
+		Type: c.Type,
+		State: CapabilityResultState{
+			Instance:     c.State.Instance,
+			ActionResult: newActionResult(e),
+		},
+	}
+}
+
+// newCommand return device command for the capability action
+func newCommand(stateCache api.StateCache,
+	deviceID string,
+	channel api.Channel,
+	capabilities []Capability,
+	state CapabilityStateValue) (api.Command, error) {
+	command := api.Command{
+		Channel: channel.Index,
+	}
+
+	parameters, declared := findCapability(capabilities, state.CapabilityType(), state.GetInstance())
+	if !declared {
+		return command, fmt.Errorf("%w: %s %s isn't supported", api.ErrInvalidAction,
+			state.CapabilityType(), state.GetInstance())
+	}
+
+	if e := state.Validate(parameters); nil != e {
+		return command, e
+	}
+
+	switch value := state.(type) {
+	case *OnOffState:
+		command.Type = api.CommandOnOff
+		command.On = value.Value
+	case *RangeState:
+		rangeParameters, _ := parameters.(*RangeParameters)
+		if RangeBrightness != value.Instance || nil == rangeParameters {
+			return command, api.ErrInvalidAction
+		}
+
+		brightness := value.Value
+		if value.Relative {
+			// Value is change of the current brightness
+			current, e := stateCache.GetState(deviceID)
+			if nil != e {
+				return command, e
+			}
+			brightness += float64(current.Channels[channel.Index].Brightness)
+
+			if nil != rangeParameters.Range {
+				brightness = math.Max(rangeParameters.Range.Min, math.Min(rangeParameters.Range.Max, brightness))
+			}
+		} else if !rangeParameters.RandomAccess {
+			return command, fmt.Errorf("%w: %s random access isn't supported", api.ErrInvalidAction, value.Instance)
+		}
+
+		command.Type = api.CommandBrightness
+		command.Brightness = int(math.Round(brightness))
+	case *ColorSettingState:
+		switch value.Instance {
+		case InstanceHSV:
+			command.Type = api.CommandColor
+			command.Color = api.ColorHSV{
+				Hue:        value.HSV.H,
+				Saturation: value.HSV.S,
+				Value:      value.HSV.V,
+			}
+		case InstanceTemperatureK:
+			command.Type = api.CommandColorTemperature
+			command.ColorTemperature = value.TemperatureK
+		default:
+			return command, api.ErrInvalidAction
+		}
+	default:
+		return command, api.ErrInvalidAction
+	}
+
+	return command, nil
+}
@@ -0,0 +1,854 @@
+package alisa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// Capability types
+const (
+	CapabilityOnOff        = "devices.capabilities.on_off"
+	CapabilityColorSetting = "devices.capabilities.color_setting"
+	CapabilityMode         = "devices.capabilities.mode"
+	CapabilityRange        = "devices.capabilities.range"
+	CapabilityToggle       = "devices.capabilities.toggle"
+	CapabilityVideoStream  = "devices.capabilities.video_stream"
+)
+
+// Capability instances which don't depend on parameters
+const (
+	InstanceOn           = "on"
+	InstanceHSV          = "hsv"
+	InstanceRGB          = "rgb"
+	InstanceTemperatureK = "temperature_k"
+	InstanceScene        = "scene"
+	InstanceGetStream    = "get_stream"
+)
+
+// Range capability instances
+const (
+	RangeBrightness  = "brightness"
+	RangeChannel     = "channel"
+	RangeHumidity    = "humidity"
+	RangeOpen        = "open"
+	RangeTemperature = "temperature"
+	RangeVolume      = "volume"
+)
+
+// Color models
+const (
+	ColorModelHSV = "hsv"
+	ColorModelRGB = "rgb"
+)
+
+// Units
+const (
+	UnitPercent            = "unit.percent"
+	UnitTemperatureCelsius = "unit.temperature.celsius"
+	UnitTemperatureKelvin  = "unit.temperature.kelvin"
+	UnitAmpere             = "unit.ampere"
+	UnitVolt               = "unit.volt"
+	UnitWatt               = "unit.watt"
+	UnitPPM                = "unit.ppm"
+	UnitIlluminationLux    = "unit.illumination.lux"
+	UnitDensityMcgM3       = "unit.density.mcg_m3"
+	UnitPressureAtm        = "unit.pressure.atm"
+	UnitPressurePascal     = "unit.pressure.pascal"
+	UnitPressureBar        = "unit.pressure.bar"
+	UnitPressureMmHg       = "unit.pressure.mmhg"
+	UnitKilowattHour       = "unit.kilowatt_hour"
+	UnitCubicMeter         = "unit.cubic_meter"
+	UnitGigacalorie        = "unit.gigacalorie"
+)
+
+const (
+	videoStreamHLS          = "hls"
+	videoStreamProgressive  = "progressive_mp4"
+	colorTemperatureMinimum = 1500
+	colorTemperatureMaximum = 9000
+)
+
+// rangeUnits is allowed units for range capability instances, empty list means unit isn't allowed
+var rangeUnits = map[string][]string{
+	RangeBrightness:  {UnitPercent},
+	RangeChannel:     {},
+	RangeHumidity:    {UnitPercent},
+	RangeOpen:        {UnitPercent},
+	RangeTemperature: {UnitTemperatureCelsius, UnitTemperatureKelvin},
+	RangeVolume:      {},
+}
+
+// toggleInstances is allowed toggle capability instances
+var toggleInstances = newSet("backlight", "controls_locked", "ionization", "keep_warm", "mute", "oscillation", "pause")
+
+// modeInstances is allowed mode capability instances
+var modeInstances = newSet("cleanup_mode", "coffee_mode", "dishwashing", "fan_speed", "heat", "input_source",
+	"program", "swing", "tea_mode", "thermostat", "work_speed")
+
+// modeValues is allowed mode capability values
+var modeValues = newSet("auto", "eco", "smart", "turbo", "cool", "dry", "fan_only", "heat", "preheat",
+	"high", "low", "medium", "max", "min", "fast", "slow", "express", "normal", "quiet",
+	"horizontal", "stationary", "vertical",
+	"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"americano", "cappuccino", "double", "double_espresso", "espresso", "latte",
+	"black_tea", "flower_tea", "green_tea", "herbal_tea", "oolong_tea", "puerh_tea", "red_tea", "white_tea",
+	"glass", "intensive", "pre_rinse",
+	"aspic", "baby_food", "baking", "bread", "boiling", "cereals", "cheesecake", "deep_fryer", "dessert",
+	"fowl", "frying", "macaroni", "milk_porridge", "multicooker", "pasta", "pilaf", "pizza", "sauce",
+	"slow_cook", "soup", "steam", "stewing", "vacuum", "wool", "yogurt")
+
+// colorScenes is allowed color scenes
+var colorScenes = newSet("alarm", "alice", "candle", "dinner", "fantasy", "garland", "jungle", "movie",
+	"neon", "night", "ocean", "party", "reading", "rest", "romance", "siren", "sunrise", "sunset")
+
+// videoStreamProtocols is allowed video stream protocols
+var videoStreamProtocols = newSet(videoStreamHLS, videoStreamProgressive)
+
+// newSet return set of the strings
+func newSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
+
+// newValidationError return validation error, which is reported as invalid value
+func newValidationError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", api.ErrInvalidValue, fmt.Sprintf(format, args...))
+}
+
+// CapabilityParameters is capability type specific parameters
+type CapabilityParameters interface {
+	// CapabilityType return type of the capability
+	CapabilityType() string
+	// GetInstance return instance for capabilities which may be declared several times, or empty string
+	GetInstance() string
+	// Validate check parameters are allowed by the protocol
+	Validate() error
+}
+
+// CapabilityStateValue is capability type specific state
+type CapabilityStateValue interface {
+	// CapabilityType return type of the capability
+	CapabilityType() string
+	// GetInstance return state instance
+	GetInstance() string
+	// Validate check state is allowed by the capability parameters
+	Validate(parameters CapabilityParameters) error
+}
+
+// Capability is device capability description
+type Capability struct {
+	Type        string               `json:"type"`
+	Retrievable bool                 `json:"retrievable"`
+	Reportable  bool                 `json:"reportable"`
+	Parameters  CapabilityParameters `json:"parameters,omitempty"`
+}
+
+// NewCapability return capability description for the parameters
+func NewCapability(retrievable bool, reportable bool, parameters CapabilityParameters) Capability {
+	return Capability{
+		Type:        parameters.CapabilityType(),
+		Retrievable: retrievable,
+		Reportable:  reportable,
+		Parameters:  parameters,
+	}
+}
+
+// Validate check capability is allowed by the protocol
+func (c *Capability) Validate() error {
+	if nil == c.Parameters {
+		if CapabilityOnOff == c.Type {
+			// Parameters are optional
+			return nil
+		}
+		return newValidationError("capability %s without parameters", c.Type)
+	}
+
+	if c.Type != c.Parameters.CapabilityType() {
+		return newValidationError("capability %s with %s parameters", c.Type, c.Parameters.CapabilityType())
+	}
+
+	if CapabilityVideoStream == c.Type && c.Retrievable {
+		return newValidationError("capability %s can't be retrievable", c.Type)
+	}
+
+	return c.Parameters.Validate()
+}
+
+// GetInstance return instance for capabilities which may be declared several times
+func (c *Capability) GetInstance() string {
+	if nil == c.Parameters {
+		return ""
+	}
+
+	return c.Parameters.GetInstance()
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface
+func (c *Capability) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type        string          `json:"type"`
+		Retrievable bool            `json:"retrievable"`
+		Reportable  bool            `json:"reportable"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	}
+	if e := json.Unmarshal(data, &raw); nil != e {
+		return e
+	}
+
+	c.Type = raw.Type
+	c.Retrievable = raw.Retrievable
+	c.Reportable = raw.Reportable
+	c.Parameters = nil
+
+	var parameters CapabilityParameters
+	switch raw.Type {
+	case CapabilityOnOff:
+		parameters = &OnOffParameters{}
+	case CapabilityColorSetting:
+		parameters = &ColorSettingParameters{}
+	case CapabilityMode:
+		parameters = &ModeParameters{}
+	case CapabilityRange:
+		parameters = &RangeParameters{}
+	case CapabilityToggle:
+		parameters = &ToggleParameters{}
+	case CapabilityVideoStream:
+		parameters = &VideoStreamParameters{}
+	default:
+		return newValidationError("unknown capability %s", raw.Type)
+	}
+
+	if 0 == len(raw.Parameters) {
+		return nil
+	}
+
+	if e := json.Unmarshal(raw.Parameters, parameters); nil != e {
+		return e
+	}
+	c.Parameters = parameters
+
+	return nil
+}
+
+// CapabilityState is capability with state
+type CapabilityState struct {
+	Type  string               `json:"type"`
+	State CapabilityStateValue `json:"state"`
+}
+
+// NewCapabilityState return capability with state
+func NewCapabilityState(state CapabilityStateValue) CapabilityState {
+	return CapabilityState{
+		Type:  state.CapabilityType(),
+		State: state,
+	}
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface
+func (c *CapabilityState) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type  string          `json:"type"`
+		State json.RawMessage `json:"state"`
+	}
+	if e := json.Unmarshal(data, &raw); nil != e {
+		return e
+	}
+
+	var state CapabilityStateValue
+	switch raw.Type {
+	case CapabilityOnOff:
+		state = &OnOffState{}
+	case CapabilityColorSetting:
+		state = &ColorSettingState{}
+	case CapabilityMode:
+		state = &ModeState{}
+	case CapabilityRange:
+		state = &RangeState{}
+	case CapabilityToggle:
+		state = &ToggleState{}
+	case CapabilityVideoStream:
+		state = &VideoStreamState{}
+	default:
+		return fmt.Errorf("%w: unknown capability %s", api.ErrInvalidAction, raw.Type)
+	}
+
+	if e := json.Unmarshal(raw.State, state); nil != e {
+		return newValidationError("capability %s state: %v", raw.Type, e)
+	}
+
+	c.Type = raw.Type
+	c.State = state
+
+	return nil
+}
+
+// OnOffParameters is parameters of the on_off capability
+type OnOffParameters struct {
+	// Split is true when device can't be turned on and off by the same command
+	Split bool `json:"split,omitempty"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *OnOffParameters) CapabilityType() string {
+	return CapabilityOnOff
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *OnOffParameters) GetInstance() string {
+	return ""
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *OnOffParameters) Validate() error {
+	return nil
+}
+
+// OnOffState is state of the on_off capability
+type OnOffState struct {
+	Instance string `json:"instance"`
+	Value    bool   `json:"value"`
+}
+
+// NewOnOffState return on_off capability state
+func NewOnOffState(value bool) *OnOffState {
+	return &OnOffState{
+		Instance: InstanceOn,
+		Value:    value,
+	}
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *OnOffState) CapabilityType() string {
+	return CapabilityOnOff
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *OnOffState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *OnOffState) Validate(_ CapabilityParameters) error {
+	if InstanceOn != s.Instance {
+		return fmt.Errorf("%w: unknown on_off instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	return nil
+}
+
+// TemperatureRange is color temperature range in Kelvin
+type TemperatureRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// ColorScene is color scene identifier
+type ColorScene struct {
+	ID string `json:"id"`
+}
+
+// ColorScenes is list of supported color scenes
+type ColorScenes struct {
+	Scenes []ColorScene `json:"scenes"`
+}
+
+// ColorSettingParameters is parameters of the color_setting capability
+type ColorSettingParameters struct {
+	ColorModel   string            `json:"color_model,omitempty"`
+	TemperatureK *TemperatureRange `json:"temperature_k,omitempty"`
+	ColorScene   *ColorScenes      `json:"color_scene,omitempty"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *ColorSettingParameters) CapabilityType() string {
+	return CapabilityColorSetting
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *ColorSettingParameters) GetInstance() string {
+	return ""
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *ColorSettingParameters) Validate() error {
+	if 0 == len(p.ColorModel) && nil == p.TemperatureK && nil == p.ColorScene {
+		return newValidationError("color_setting without color model, temperature or scenes")
+	}
+
+	if len(p.ColorModel) > 0 && ColorModelHSV != p.ColorModel && ColorModelRGB != p.ColorModel {
+		return newValidationError("unknown color model %s", p.ColorModel)
+	}
+
+	if nil != p.TemperatureK {
+		if p.TemperatureK.Min > p.TemperatureK.Max ||
+			p.TemperatureK.Min < colorTemperatureMinimum || p.TemperatureK.Max > colorTemperatureMaximum {
+			return newValidationError("invalid temperature range %d..%d", p.TemperatureK.Min, p.TemperatureK.Max)
+		}
+	}
+
+	if nil != p.ColorScene {
+		if 0 == len(p.ColorScene.Scenes) {
+			return newValidationError("empty color scenes list")
+		}
+
+		for _, scene := range p.ColorScene.Scenes {
+			if !colorScenes[scene.ID] {
+				return newValidationError("unknown color scene %s", scene.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ColorHSV is color in the HSV model
+type ColorHSV struct {
+	H int `json:"h"`
+	S int `json:"s"`
+	V int `json:"v"`
+}
+
+// ColorSettingState is state of the color_setting capability, only value for the instance is used
+type ColorSettingState struct {
+	Instance     string
+	HSV          ColorHSV
+	RGB          int
+	TemperatureK int
+	Scene        string
+}
+
+// colorSettingStateJSON is JSON representation of the color_setting capability state
+type colorSettingStateJSON struct {
+	Instance string          `json:"instance"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *ColorSettingState) CapabilityType() string {
+	return CapabilityColorSetting
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *ColorSettingState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *ColorSettingState) Validate(parameters CapabilityParameters) error {
+	var p *ColorSettingParameters
+	if nil != parameters {
+		p, _ = parameters.(*ColorSettingParameters)
+	}
+
+	switch s.Instance {
+	case InstanceHSV:
+		if nil != p && ColorModelHSV != p.ColorModel {
+			return fmt.Errorf("%w: hsv color model isn't supported", api.ErrInvalidAction)
+		}
+
+		if s.HSV.H < 0 || s.HSV.H > 360 || s.HSV.S < 0 || s.HSV.S > 100 || s.HSV.V < 0 || s.HSV.V > 100 {
+			return newValidationError("invalid hsv color %v", s.HSV)
+		}
+	case InstanceRGB:
+		if nil != p && ColorModelRGB != p.ColorModel {
+			return fmt.Errorf("%w: rgb color model isn't supported", api.ErrInvalidAction)
+		}
+
+		if s.RGB < 0 || s.RGB > 0xFFFFFF {
+			return newValidationError("invalid rgb color %d", s.RGB)
+		}
+	case InstanceTemperatureK:
+		if nil != p && nil == p.TemperatureK {
+			return fmt.Errorf("%w: color temperature isn't supported", api.ErrInvalidAction)
+		}
+
+		if nil != p && (s.TemperatureK < p.TemperatureK.Min || s.TemperatureK > p.TemperatureK.Max) {
+			return newValidationError("color temperature %d out of range", s.TemperatureK)
+		}
+	case InstanceScene:
+		if nil != p && nil == p.ColorScene {
+			return fmt.Errorf("%w: color scenes isn't supported", api.ErrInvalidAction)
+		}
+
+		if !colorScenes[s.Scene] {
+			return newValidationError("unknown color scene %s", s.Scene)
+		}
+	default:
+		return fmt.Errorf("%w: unknown color_setting instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	return nil
+}
+
+// MarshalJSON is implementation of json.Marshaler interface
+func (s *ColorSettingState) MarshalJSON() ([]byte, error) {
+	var value interface{}
+	switch s.Instance {
+	case InstanceHSV:
+		value = s.HSV
+	case InstanceRGB:
+		value = s.RGB
+	case InstanceTemperatureK:
+		value = s.TemperatureK
+	case InstanceScene:
+		value = s.Scene
+	}
+
+	raw, e := json.Marshal(value)
+	if nil != e {
+		return nil, e
+	}
+
+	return json.Marshal(&colorSettingStateJSON{
+		Instance: s.Instance,
+		Value:    raw,
+	})
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface
+func (s *ColorSettingState) UnmarshalJSON(data []byte) error {
+	var raw colorSettingStateJSON
+	if e := json.Unmarshal(data, &raw); nil != e {
+		return e
+	}
+
+	s.Instance = raw.Instance
+
+	switch raw.Instance {
+	case InstanceHSV:
+		return json.Unmarshal(raw.Value, &s.HSV)
+	case InstanceRGB:
+		return json.Unmarshal(raw.Value, &s.RGB)
+	case InstanceTemperatureK:
+		return json.Unmarshal(raw.Value, &s.TemperatureK)
+	case InstanceScene:
+		return json.Unmarshal(raw.Value, &s.Scene)
+	}
+
+	// Unknown instance will be reported by validation
+	return nil
+}
+
+// ModeValue is mode capability value
+type ModeValue struct {
+	Value string `json:"value"`
+}
+
+// ModeParameters is parameters of the mode capability
+type ModeParameters struct {
+	Instance string      `json:"instance"`
+	Modes    []ModeValue `json:"modes"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *ModeParameters) CapabilityType() string {
+	return CapabilityMode
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *ModeParameters) GetInstance() string {
+	return p.Instance
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *ModeParameters) Validate() error {
+	if !modeInstances[p.Instance] {
+		return newValidationError("unknown mode instance %s", p.Instance)
+	}
+
+	if 0 == len(p.Modes) {
+		return newValidationError("empty modes list for %s", p.Instance)
+	}
+
+	for _, mode := range p.Modes {
+		if !modeValues[mode.Value] {
+			return newValidationError("unknown mode %s", mode.Value)
+		}
+	}
+
+	return nil
+}
+
+// ModeState is state of the mode capability
+type ModeState struct {
+	Instance string `json:"instance"`
+	Value    string `json:"value"`
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *ModeState) CapabilityType() string {
+	return CapabilityMode
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *ModeState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *ModeState) Validate(parameters CapabilityParameters) error {
+	if !modeInstances[s.Instance] {
+		return fmt.Errorf("%w: unknown mode instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	p, valid := parameters.(*ModeParameters)
+	if !valid {
+		if !modeValues[s.Value] {
+			return newValidationError("unknown mode %s", s.Value)
+		}
+		return nil
+	}
+
+	for _, mode := range p.Modes {
+		if mode.Value == s.Value {
+			return nil
+		}
+	}
+
+	return newValidationError("mode %s isn't supported", s.Value)
+}
+
+// RangeBounds is range capability bounds
+type RangeBounds struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Precision float64 `json:"precision,omitempty"`
+}
+
+// RangeParameters is parameters of the range capability
+type RangeParameters struct {
+	Instance     string       `json:"instance"`
+	Unit         string       `json:"unit,omitempty"`
+	RandomAccess bool         `json:"random_access"`
+	Range        *RangeBounds `json:"range,omitempty"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *RangeParameters) CapabilityType() string {
+	return CapabilityRange
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *RangeParameters) GetInstance() string {
+	return p.Instance
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *RangeParameters) Validate() error {
+	units, known := rangeUnits[p.Instance]
+	if !known {
+		return newValidationError("unknown range instance %s", p.Instance)
+	}
+
+	if 0 == len(units) {
+		if len(p.Unit) > 0 {
+			return newValidationError("unit isn't allowed for range instance %s", p.Instance)
+		}
+	} else {
+		found := false
+		for _, unit := range units {
+			found = found || unit == p.Unit
+		}
+
+		if !found {
+			return newValidationError("unit %s isn't allowed for range instance %s", p.Unit, p.Instance)
+		}
+	}
+
+	if nil == p.Range {
+		return nil
+	}
+
+	if p.Range.Min >= p.Range.Max || p.Range.Precision < 0 {
+		return newValidationError("invalid range %v for %s", *p.Range, p.Instance)
+	}
+
+	if UnitPercent == p.Unit && (p.Range.Min < 0 || p.Range.Max > 100) {
+		return newValidationError("invalid percent range %v for %s", *p.Range, p.Instance)
+	}
+
+	return nil
+}
+
+// RangeState is state of the range capability
+type RangeState struct {
+	Instance string  `json:"instance"`
+	Value    float64 `json:"value"`
+	Relative bool    `json:"relative,omitempty"`
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *RangeState) CapabilityType() string {
+	return CapabilityRange
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *RangeState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *RangeState) Validate(parameters CapabilityParameters) error {
+	if _, known := rangeUnits[s.Instance]; !known {
+		return fmt.Errorf("%w: unknown range instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return newValidationError("invalid %s value", s.Instance)
+	}
+
+	p, valid := parameters.(*RangeParameters)
+	if !valid || nil == p.Range || s.Relative {
+		return nil
+	}
+
+	if s.Value < p.Range.Min || s.Value > p.Range.Max {
+		return newValidationError("%s value %v out of range", s.Instance, s.Value)
+	}
+
+	return nil
+}
+
+// ToggleParameters is parameters of the toggle capability
+type ToggleParameters struct {
+	Instance string `json:"instance"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *ToggleParameters) CapabilityType() string {
+	return CapabilityToggle
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *ToggleParameters) GetInstance() string {
+	return p.Instance
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *ToggleParameters) Validate() error {
+	if !toggleInstances[p.Instance] {
+		return newValidationError("unknown toggle instance %s", p.Instance)
+	}
+
+	return nil
+}
+
+// ToggleState is state of the toggle capability
+type ToggleState struct {
+	Instance string `json:"instance"`
+	Value    bool   `json:"value"`
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *ToggleState) CapabilityType() string {
+	return CapabilityToggle
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *ToggleState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *ToggleState) Validate(_ CapabilityParameters) error {
+	if !toggleInstances[s.Instance] {
+		return fmt.Errorf("%w: unknown toggle instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	return nil
+}
+
+// VideoStreamParameters is parameters of the video_stream capability
+type VideoStreamParameters struct {
+	Protocols []string `json:"protocols"`
+}
+
+// CapabilityType is implementation of CapabilityParameters interface
+func (p *VideoStreamParameters) CapabilityType() string {
+	return CapabilityVideoStream
+}
+
+// GetInstance is implementation of CapabilityParameters interface
+func (p *VideoStreamParameters) GetInstance() string {
+	return ""
+}
+
+// Validate is implementation of CapabilityParameters interface
+func (p *VideoStreamParameters) Validate() error {
+	if 0 == len(p.Protocols) {
+		return newValidationError("empty video stream protocols list")
+	}
+
+	for _, protocol := range p.Protocols {
+		if !videoStreamProtocols[protocol] {
+			return newValidationError("unknown video stream protocol %s", protocol)
+		}
+	}
+
+	return nil
+}
+
+// VideoStreamValue is video_stream action value (protocols) or action result (stream URL and protocol)
+type VideoStreamValue struct {
+	Protocols []string `json:"protocols,omitempty"`
+	StreamURL string   `json:"stream_url,omitempty"`
+	Protocol  string   `json:"protocol,omitempty"`
+}
+
+// VideoStreamState is state of the video_stream capability
+type VideoStreamState struct {
+	Instance string           `json:"instance"`
+	Value    VideoStreamValue `json:"value"`
+}
+
+// CapabilityType is implementation of CapabilityStateValue interface
+func (s *VideoStreamState) CapabilityType() string {
+	return CapabilityVideoStream
+}
+
+// GetInstance is implementation of CapabilityStateValue interface
+func (s *VideoStreamState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of CapabilityStateValue interface
+func (s *VideoStreamState) Validate(_ CapabilityParameters) error {
+	if InstanceGetStream != s.Instance {
+		return fmt.Errorf("%w: unknown video_stream instance %s", api.ErrInvalidAction, s.Instance)
+	}
+
+	for _, protocol := range s.Value.Protocols {
+		if !videoStreamProtocols[protocol] {
+			return newValidationError("unknown video stream protocol %s", protocol)
+		}
+	}
+
+	return nil
+}
+
+// ActionResult is result of the capability or device action
+type ActionResult struct {
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// CapabilityResultState is capability action result. Value is used only by video_stream capability.
+type CapabilityResultState struct {
+	Instance     string            `json:"instance"`
+	Value        *VideoStreamValue `json:"value,omitempty"`
+	ActionResult ActionResult      `json:"action_result"`
+}
+
+// CapabilityResult is capability with action result
+type CapabilityResult struct {
+	Type  string                `json:"type"`
+	State CapabilityResultState `json:"state"`
+}
@@ -0,0 +1,228 @@
+package alisa
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// checkError compare validation result with expected error, nil means value is accepted
+func checkError(t *testing.T, name string, got error, want error) {
+	t.Helper()
+
+	switch {
+	case nil == want && nil != got:
+		t.Errorf("%s: unexpected error %v", name, got)
+	case nil != want && !errors.Is(got, want):
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func TestCapabilityParametersValidate(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		capability Capability
+		want       error
+	}{
+		{"on_off without parameters", Capability{Type: CapabilityOnOff}, nil},
+		{"on_off split", NewCapability(true, true, &OnOffParameters{Split: true}), nil},
+		{"color_setting without parameters", Capability{Type: CapabilityColorSetting}, api.ErrInvalidValue},
+		{"type mismatch", Capability{Type: CapabilityMode, Parameters: &ToggleParameters{Instance: "mute"}}, api.ErrInvalidValue},
+
+		{"color model hsv", NewCapability(true, true, &ColorSettingParameters{ColorModel: ColorModelHSV}), nil},
+		{"color model rgb", NewCapability(true, true, &ColorSettingParameters{ColorModel: ColorModelRGB}), nil},
+		{"color model unknown", NewCapability(true, true, &ColorSettingParameters{ColorModel: "cmyk"}), api.ErrInvalidValue},
+		{"color setting empty", NewCapability(true, true, &ColorSettingParameters{}), api.ErrInvalidValue},
+		{"temperature range", NewCapability(true, true, &ColorSettingParameters{
+			TemperatureK: &TemperatureRange{Min: 2700, Max: 6500}}), nil},
+		{"temperature range bounds", NewCapability(true, true, &ColorSettingParameters{
+			TemperatureK: &TemperatureRange{Min: colorTemperatureMinimum, Max: colorTemperatureMaximum}}), nil},
+		{"temperature range reversed", NewCapability(true, true, &ColorSettingParameters{
+			TemperatureK: &TemperatureRange{Min: 6500, Max: 2700}}), api.ErrInvalidValue},
+		{"temperature range too low", NewCapability(true, true, &ColorSettingParameters{
+			TemperatureK: &TemperatureRange{Min: 1000, Max: 6500}}), api.ErrInvalidValue},
+		{"temperature range too high", NewCapability(true, true, &ColorSettingParameters{
+			TemperatureK: &TemperatureRange{Min: 2700, Max: 10000}}), api.ErrInvalidValue},
+		{"color scenes", NewCapability(true, true, &ColorSettingParameters{
+			ColorScene: &ColorScenes{Scenes: []ColorScene{{ID: "party"}, {ID: "night"}}}}), nil},
+		{"color scenes empty", NewCapability(true, true, &ColorSettingParameters{
+			ColorScene: &ColorScenes{}}), api.ErrInvalidValue},
+		{"color scene unknown", NewCapability(true, true, &ColorSettingParameters{
+			ColorScene: &ColorScenes{Scenes: []ColorScene{{ID: "disco"}}}}), api.ErrInvalidValue},
+
+		{"mode", NewCapability(true, true, &ModeParameters{Instance: "fan_speed",
+			Modes: []ModeValue{{Value: "low"}, {Value: "high"}}}), nil},
+		{"mode unknown instance", NewCapability(true, true, &ModeParameters{Instance: "speed",
+			Modes: []ModeValue{{Value: "low"}}}), api.ErrInvalidValue},
+		{"mode empty", NewCapability(true, true, &ModeParameters{Instance: "fan_speed"}), api.ErrInvalidValue},
+		{"mode unknown value", NewCapability(true, true, &ModeParameters{Instance: "fan_speed",
+			Modes: []ModeValue{{Value: "hurricane"}}}), api.ErrInvalidValue},
+
+		{"range brightness", NewCapability(true, true, &RangeParameters{Instance: RangeBrightness, Unit: UnitPercent,
+			RandomAccess: true, Range: &RangeBounds{Min: 1, Max: 100, Precision: 1}}), nil},
+		{"range without bounds", NewCapability(true, true, &RangeParameters{Instance: RangeBrightness,
+			Unit: UnitPercent}), nil},
+		{"range unknown instance", NewCapability(true, true, &RangeParameters{Instance: "speed"}), api.ErrInvalidValue},
+		{"range missing unit", NewCapability(true, true, &RangeParameters{Instance: RangeBrightness}), api.ErrInvalidValue},
+		{"range wrong unit", NewCapability(true, true, &RangeParameters{Instance: RangeTemperature,
+			Unit: UnitPercent}), api.ErrInvalidValue},
+		{"range kelvin", NewCapability(true, true, &RangeParameters{Instance: RangeTemperature,
+			Unit: UnitTemperatureKelvin}), nil},
+		{"range unit not allowed", NewCapability(true, true, &RangeParameters{Instance: RangeVolume,
+			Unit: UnitPercent}), api.ErrInvalidValue},
+		{"range volume", NewCapability(true, true, &RangeParameters{Instance: RangeVolume,
+			Range: &RangeBounds{Min: 0, Max: 50}}), nil},
+		{"range empty bounds", NewCapability(true, true, &RangeParameters{Instance: RangeChannel,
+			Range: &RangeBounds{Min: 5, Max: 5}}), api.ErrInvalidValue},
+		{"range negative precision", NewCapability(true, true, &RangeParameters{Instance: RangeChannel,
+			Range: &RangeBounds{Min: 0, Max: 5, Precision: -1}}), api.ErrInvalidValue},
+		{"range percent over 100", NewCapability(true, true, &RangeParameters{Instance: RangeOpen,
+			Unit: UnitPercent, Range: &RangeBounds{Min: 0, Max: 200}}), api.ErrInvalidValue},
+
+		{"toggle", NewCapability(true, true, &ToggleParameters{Instance: "mute"}), nil},
+		{"toggle unknown instance", NewCapability(true, true, &ToggleParameters{Instance: "turbo"}), api.ErrInvalidValue},
+
+		{"video stream", NewCapability(false, false, &VideoStreamParameters{
+			Protocols: []string{videoStreamHLS, videoStreamProgressive}}), nil},
+		{"video stream retrievable", NewCapability(true, false, &VideoStreamParameters{
+			Protocols: []string{videoStreamHLS}}), api.ErrInvalidValue},
+		{"video stream empty", NewCapability(false, false, &VideoStreamParameters{}), api.ErrInvalidValue},
+		{"video stream unknown protocol", NewCapability(false, false, &VideoStreamParameters{
+			Protocols: []string{"rtsp"}}), api.ErrInvalidValue},
+	} {
+		checkError(t, test.name, test.capability.Validate(), test.want)
+	}
+}
+
+func TestCapabilityStateValidate(t *testing.T) {
+	hsv := &ColorSettingParameters{ColorModel: ColorModelHSV, TemperatureK: &TemperatureRange{Min: 2700, Max: 6500}}
+	rgb := &ColorSettingParameters{ColorModel: ColorModelRGB}
+	scenes := &ColorSettingParameters{ColorScene: &ColorScenes{Scenes: []ColorScene{{ID: "party"}}}}
+	mode := &ModeParameters{Instance: "fan_speed", Modes: []ModeValue{{Value: "low"}, {Value: "high"}}}
+	brightness := &RangeParameters{Instance: RangeBrightness, Unit: UnitPercent, Range: &RangeBounds{Min: 1, Max: 100}}
+
+	for _, test := range []struct {
+		name       string
+		state      CapabilityStateValue
+		parameters CapabilityParameters
+		want       error
+	}{
+		{"on", NewOnOffState(true), nil, nil},
+		{"on_off unknown instance", &OnOffState{Instance: "power"}, nil, api.ErrInvalidAction},
+
+		{"hsv", &ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 360, S: 100, V: 100}}, hsv, nil},
+		{"hsv without parameters", &ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 0, S: 0, V: 0}}, nil, nil},
+		{"hsv hue out of range", &ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 361, S: 50, V: 50}}, hsv, api.ErrInvalidValue},
+		{"hsv negative saturation", &ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 10, S: -1, V: 50}}, hsv, api.ErrInvalidValue},
+		{"hsv value over 100", &ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 10, S: 50, V: 101}}, hsv, api.ErrInvalidValue},
+		{"hsv on rgb device", &ColorSettingState{Instance: InstanceHSV}, rgb, api.ErrInvalidAction},
+		{"rgb", &ColorSettingState{Instance: InstanceRGB, RGB: 0xFFFFFF}, rgb, nil},
+		{"rgb out of range", &ColorSettingState{Instance: InstanceRGB, RGB: 0x1000000}, rgb, api.ErrInvalidValue},
+		{"rgb negative", &ColorSettingState{Instance: InstanceRGB, RGB: -1}, nil, api.ErrInvalidValue},
+		{"rgb on hsv device", &ColorSettingState{Instance: InstanceRGB, RGB: 1}, hsv, api.ErrInvalidAction},
+		{"temperature", &ColorSettingState{Instance: InstanceTemperatureK, TemperatureK: 4000}, hsv, nil},
+		{"temperature minimum", &ColorSettingState{Instance: InstanceTemperatureK, TemperatureK: 2700}, hsv, nil},
+		{"temperature out of range", &ColorSettingState{Instance: InstanceTemperatureK, TemperatureK: 2000}, hsv, api.ErrInvalidValue},
+		{"temperature not supported", &ColorSettingState{Instance: InstanceTemperatureK, TemperatureK: 4000}, rgb, api.ErrInvalidAction},
+		{"scene", &ColorSettingState{Instance: InstanceScene, Scene: "party"}, scenes, nil},
+		{"scene unknown", &ColorSettingState{Instance: InstanceScene, Scene: "disco"}, scenes, api.ErrInvalidValue},
+		{"scene not supported", &ColorSettingState{Instance: InstanceScene, Scene: "party"}, rgb, api.ErrInvalidAction},
+		{"color unknown instance", &ColorSettingState{Instance: "cmyk"}, hsv, api.ErrInvalidAction},
+
+		{"mode", &ModeState{Instance: "fan_speed", Value: "high"}, mode, nil},
+		{"mode not declared", &ModeState{Instance: "fan_speed", Value: "auto"}, mode, api.ErrInvalidValue},
+		{"mode without parameters", &ModeState{Instance: "fan_speed", Value: "auto"}, nil, nil},
+		{"mode unknown without parameters", &ModeState{Instance: "fan_speed", Value: "hurricane"}, nil, api.ErrInvalidValue},
+		{"mode unknown instance", &ModeState{Instance: "speed", Value: "low"}, mode, api.ErrInvalidAction},
+
+		{"range", &RangeState{Instance: RangeBrightness, Value: 100}, brightness, nil},
+		{"range below minimum", &RangeState{Instance: RangeBrightness, Value: 0}, brightness, api.ErrInvalidValue},
+		{"range above maximum", &RangeState{Instance: RangeBrightness, Value: 101}, brightness, api.ErrInvalidValue},
+		{"range relative", &RangeState{Instance: RangeBrightness, Value: -10, Relative: true}, brightness, nil},
+		{"range without bounds", &RangeState{Instance: RangeVolume, Value: 1000}, nil, nil},
+		{"range NaN", &RangeState{Instance: RangeBrightness, Value: math.NaN()}, brightness, api.ErrInvalidValue},
+		{"range infinity", &RangeState{Instance: RangeVolume, Value: math.Inf(1)}, nil, api.ErrInvalidValue},
+		{"range unknown instance", &RangeState{Instance: "speed", Value: 1}, nil, api.ErrInvalidAction},
+
+		{"toggle", &ToggleState{Instance: "pause", Value: true}, nil, nil},
+		{"toggle unknown instance", &ToggleState{Instance: "turbo"}, nil, api.ErrInvalidAction},
+
+		{"video stream", &VideoStreamState{Instance: InstanceGetStream,
+			Value: VideoStreamValue{Protocols: []string{videoStreamHLS}}}, nil, nil},
+		{"video stream unknown protocol", &VideoStreamState{Instance: InstanceGetStream,
+			Value: VideoStreamValue{Protocols: []string{"rtsp"}}}, nil, api.ErrInvalidValue},
+		{"video stream unknown instance", &VideoStreamState{Instance: "stream"}, nil, api.ErrInvalidAction},
+	} {
+		checkError(t, test.name, test.state.Validate(test.parameters), test.want)
+	}
+}
+
+func TestCapabilityUnmarshal(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		want error
+	}{
+		{"on_off without parameters", `{"type":"devices.capabilities.on_off","retrievable":true}`, nil},
+		{"color_setting", `{"type":"devices.capabilities.color_setting","retrievable":true,
+			"parameters":{"color_model":"hsv","temperature_k":{"min":2700,"max":6500}}}`, nil},
+		{"range", `{"type":"devices.capabilities.range","retrievable":true,
+			"parameters":{"instance":"brightness","unit":"unit.percent","random_access":true,"range":{"min":1,"max":100}}}`, nil},
+		{"range invalid", `{"type":"devices.capabilities.range","parameters":{"instance":"brightness"}}`, api.ErrInvalidValue},
+		{"unknown type", `{"type":"devices.capabilities.teleport"}`, api.ErrInvalidValue},
+	} {
+		var capability Capability
+		e := json.Unmarshal([]byte(test.data), &capability)
+		if nil == e {
+			e = capability.Validate()
+		}
+		checkError(t, test.name, e, test.want)
+	}
+}
+
+func TestCapabilityStateJSON(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  string
+		state CapabilityStateValue
+		want  error
+	}{
+		{"on_off", `{"type":"devices.capabilities.on_off","state":{"instance":"on","value":true}}`,
+			NewOnOffState(true), nil},
+		{"hsv", `{"type":"devices.capabilities.color_setting","state":{"instance":"hsv","value":{"h":120,"s":50,"v":75}}}`,
+			&ColorSettingState{Instance: InstanceHSV, HSV: ColorHSV{H: 120, S: 50, V: 75}}, nil},
+		{"rgb", `{"type":"devices.capabilities.color_setting","state":{"instance":"rgb","value":16711680}}`,
+			&ColorSettingState{Instance: InstanceRGB, RGB: 0xFF0000}, nil},
+		{"temperature", `{"type":"devices.capabilities.color_setting","state":{"instance":"temperature_k","value":4000}}`,
+			&ColorSettingState{Instance: InstanceTemperatureK, TemperatureK: 4000}, nil},
+		{"scene", `{"type":"devices.capabilities.color_setting","state":{"instance":"scene","value":"party"}}`,
+			&ColorSettingState{Instance: InstanceScene, Scene: "party"}, nil},
+		{"range", `{"type":"devices.capabilities.range","state":{"instance":"brightness","value":-10,"relative":true}}`,
+			&RangeState{Instance: RangeBrightness, Value: -10, Relative: true}, nil},
+		{"wrong value type", `{"type":"devices.capabilities.on_off","state":{"instance":"on","value":"yes"}}`,
+			nil, api.ErrInvalidValue},
+		{"unknown type", `{"type":"devices.capabilities.teleport","state":{}}`, nil, api.ErrInvalidAction},
+	} {
+		var state CapabilityState
+		e := json.Unmarshal([]byte(test.data), &state)
+		checkError(t, test.name, e, test.want)
+		if nil != e || nil != test.want {
+			continue
+		}
+
+		// State is sent back to Yandex, so it must be encoded the same way
+		got, e := json.Marshal(NewCapabilityState(state.State))
+		if nil != e {
+			t.Errorf("%s: %v", test.name, e)
+			continue
+		}
+
+		want, _ := json.Marshal(NewCapabilityState(test.state))
+		if string(want) != string(got) {
+			t.Errorf("%s: got %s, want %s", test.name, got, want)
+		}
+	}
+}
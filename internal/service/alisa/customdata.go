@@ -0,0 +1,92 @@
+package alisa
+
+import (
+	"encoding/json"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// customDataVersion is version of the custom data format, data with other version is ignored
+	customDataVersion = 1
+)
+
+// CustomData is routing data which Yandex store with device and return in the query and action requests
+type CustomData struct {
+	Version     int    `json:"v"`
+	Integration string `json:"integration,omitempty"`
+	DeviceID    string `json:"device_id"`
+	Topic       string `json:"topic,omitempty"`
+	Channel     int    `json:"channel,omitempty"`
+}
+
+// newCustomData return routing data for the device channel
+func newCustomData(deviceID string, device api.Device, channel int) *CustomData {
+	route := device.GetRoute()
+
+	return &CustomData{
+		Version:     customDataVersion,
+		Integration: route.Integration,
+		DeviceID:    deviceID,
+		Topic:       route.Topic,
+		Channel:     channel,
+	}
+}
+
+// route return device route stored in the custom data
+func (data *CustomData) route() api.Route {
+	return api.Route{
+		Integration: data.Integration,
+		Topic:       data.Topic,
+	}
+}
+
+// decodeCustomData return routing data from the request, or nil when data is absent or has unknown format
+func decodeCustomData(raw json.RawMessage) *CustomData {
+	if 0 == len(raw) {
+		return nil
+	}
+
+	var data CustomData
+	if e := json.Unmarshal(raw, &data); nil != e || customDataVersion != data.Version || 0 == len(data.DeviceID) {
+		return nil
+	}
+
+	return &data
+}
+
+// resolveEndpoint return device and channel for the Alisa device. Routing data is trusted when it match the
+// registry, otherwise device is searched by the route and then by the Alisa device ID.
+func resolveEndpoint(deviceManager api.DeviceManager,
+	id string,
+	raw json.RawMessage) (string, api.Device, *api.Channel, error) {
+	data := decodeCustomData(raw)
+	if nil == data {
+		return findEndpoint(deviceManager, id)
+	}
+
+	device, e := deviceManager.GetDevice(data.DeviceID)
+	deviceID := data.DeviceID
+	if nil != e || device.GetRoute() != data.route() {
+		// Stale data, device ID in the registry may be changed
+		if deviceID, device, e = deviceManager.FindDevice(data.route()); nil != e {
+			return findEndpoint(deviceManager, id)
+		}
+	}
+
+	if 0 == data.Channel {
+		if 0 == len(device.GetSensors()) {
+			return findEndpoint(deviceManager, id)
+		}
+
+		return deviceID, device, nil, nil
+	}
+
+	for _, channel := range device.GetChannels() {
+		if channel.Index == data.Channel {
+			return deviceID, device, &channel, nil
+		}
+	}
+
+	return findEndpoint(deviceManager, id)
+}
@@ -0,0 +1,162 @@
+package alisa
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/service/households"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+// testHeaterID is relay of other household
+const testHeaterID = "tasmota_665544332211"
+
+// newTestHouseholds return access control where maria see only first channel of the relay
+func newTestHouseholds(t *testing.T) (api.AccessControl, *apitest.States, apitest.Devices) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "households.json")
+	if e := os.WriteFile(path, []byte(`{"households": [
+		{"id": "ivan", "users": ["ivan"], "devices": ["`+testHeaterID+`", "`+testRelayID+`"]},
+		{"id": "maria", "users": ["maria"], "devices": ["`+testRelayID+`:1"]}
+	]}`), 0600); nil != e {
+		t.Fatal(e)
+	}
+	t.Setenv("HOUSEHOLDS_CONFIG", path)
+
+	stateCache := apitest.NewStates()
+	devices := apitest.Devices{
+		testRelayID: &apitest.Device{
+			ID: testRelayID,
+			Channels: []api.Channel{
+				{Index: 1, Type: api.ChannelRelay},
+				{Index: 2, Type: api.ChannelRelay},
+			},
+			Sensors:    []api.Sensor{{Kind: api.SensorTemperature}},
+			StateCache: stateCache,
+		},
+		testHeaterID: &apitest.Device{
+			ID:         testHeaterID,
+			Channels:   []api.Channel{{Index: 1, Type: api.ChannelRelay}},
+			StateCache: stateCache,
+		},
+	}
+	for deviceID := range devices {
+		_ = stateCache.UpdateState(deviceID, func(state *api.State) {})
+	}
+
+	access, e := households.NewService(eventbus.New(), devices)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return access, stateCache, devices
+}
+
+// customData return JSON of the custom data
+func customData(data CustomData) json.RawMessage {
+	data.Version = customDataVersion
+	raw, _ := json.Marshal(&data)
+	return raw
+}
+
+func TestForgedCustomData(t *testing.T) {
+	access, stateCache, devices := newTestHouseholds(t)
+	maria := access.UserDevices("maria")
+
+	tests := []struct {
+		name    string
+		request deviceRequest
+		want    string
+	}{
+		{
+			name: "allowed channel",
+			request: deviceRequest{
+				ID:         testRelayID + ":1",
+				CustomData: customData(CustomData{DeviceID: testRelayID, Integration: "tasmota", Topic: testRelayID, Channel: 1}),
+			},
+		},
+		{
+			name: "device of other household",
+			request: deviceRequest{
+				ID:         testHeaterID,
+				CustomData: customData(CustomData{DeviceID: testHeaterID, Integration: "tasmota", Topic: testHeaterID, Channel: 1}),
+			},
+			want: errorDeviceNotFound,
+		},
+		{
+			name: "route of other household",
+			request: deviceRequest{
+				ID:         testHeaterID,
+				CustomData: customData(CustomData{DeviceID: testRelayID, Integration: "tasmota", Topic: testHeaterID, Channel: 1}),
+			},
+			want: errorDeviceNotFound,
+		},
+		{
+			name: "channel of other household",
+			request: deviceRequest{
+				ID:         testRelayID + ":1",
+				CustomData: customData(CustomData{DeviceID: testRelayID, Integration: "tasmota", Topic: testRelayID, Channel: 2}),
+			},
+		},
+		{
+			name: "channel of other household without fallback",
+			request: deviceRequest{
+				ID:         testRelayID + ":2",
+				CustomData: customData(CustomData{DeviceID: testRelayID, Integration: "tasmota", Topic: testRelayID, Channel: 2}),
+			},
+			want: errorDeviceNotFound,
+		},
+		{
+			name: "sensors of other household",
+			request: deviceRequest{
+				ID:         testRelayID,
+				CustomData: customData(CustomData{DeviceID: testRelayID, Integration: "tasmota", Topic: testRelayID}),
+			},
+			want: errorDeviceNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newDeviceState(zap.NewNop().Sugar(), maria, stateCache, test.request)
+			if test.want != state.ErrorCode {
+				t.Errorf("query: got error %q, want %q", state.ErrorCode, test.want)
+			}
+
+			// Forged data may only fall back to the device of the Alisa ID, which is visible to the user
+			if 0 == len(test.want) && (1 != len(state.Capabilities) || test.request.ID != state.ID) {
+				t.Errorf("query: got state %+v", state)
+			}
+
+			request := actionDevice{
+				deviceRequest: test.request,
+				Capabilities:  []json.RawMessage{json.RawMessage(onOff(true))},
+			}
+			result := executeDeviceActions(context.Background(), zap.NewNop().Sugar(), maria, stateCache, &request)
+
+			got := ""
+			if nil != result.ActionResult {
+				got = result.ActionResult.ErrorCode
+			}
+			if test.want != got {
+				t.Errorf("action: got error %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// Only allowed channel of the shared relay is controlled
+	for deviceID, device := range devices {
+		for _, command := range device.(*apitest.Device).Executed() {
+			if testRelayID != deviceID || 1 != command.Channel {
+				t.Errorf("executed command %+v of %s", command, deviceID)
+			}
+		}
+	}
+}
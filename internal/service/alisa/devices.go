@@ -1,6 +1,53 @@
 package alisa
 
-import "github.com/gin-gonic/gin"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/pkg/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// channelSeparator separate device ID and channel index in the Alisa device ID
+	channelSeparator = ":"
+)
+
+// Device types
+const (
+	DeviceTypeLight              = "devices.types.light"
+	DeviceTypeSocket             = "devices.types.socket"
+	DeviceTypeSwitch             = "devices.types.switch"
+	DeviceTypeSensor             = "devices.types.sensor"
+	DeviceTypeSensorClimate      = "devices.types.sensor.climate"
+	DeviceTypeSensorIllumination = "devices.types.sensor.illumination"
+	DeviceTypeOther              = "devices.types.other"
+)
+
+// deviceTypes is allowed device types
+var deviceTypes = newSet(
+	DeviceTypeLight, "devices.types.light.ceiling", "devices.types.light.lamp", "devices.types.light.strip",
+	DeviceTypeSocket,
+	DeviceTypeSwitch, "devices.types.switch.relay",
+	"devices.types.thermostat", "devices.types.thermostat.ac",
+	"devices.types.media_device", "devices.types.media_device.tv", "devices.types.media_device.tv_box",
+	"devices.types.media_device.receiver",
+	"devices.types.cooking", "devices.types.cooking.coffee_maker", "devices.types.cooking.kettle",
+	"devices.types.cooking.multicooker",
+	"devices.types.openable", "devices.types.openable.curtain", "devices.types.openable.valve",
+	"devices.types.humidifier", "devices.types.purifier", "devices.types.vacuum_cleaner",
+	"devices.types.washing_machine", "devices.types.dishwasher", "devices.types.iron",
+	DeviceTypeSensor, "devices.types.sensor.button", DeviceTypeSensorClimate, "devices.types.sensor.gas",
+	DeviceTypeSensorIllumination, "devices.types.sensor.motion", "devices.types.sensor.open",
+	"devices.types.sensor.smoke", "devices.types.sensor.vibration", "devices.types.sensor.water_leak",
+	"devices.types.smart_meter", "devices.types.smart_meter.cold_water", "devices.types.smart_meter.electricity",
+	"devices.types.smart_meter.gas", "devices.types.smart_meter.heat", "devices.types.smart_meter.hot_water",
+	"devices.types.camera", "devices.types.pet_drinking_fountain", "devices.types.pet_feeder",
+	DeviceTypeOther,
+)
 
 // DeviceInfo represent device information
 type DeviceInfo struct {
@@ -12,15 +59,81 @@ type DeviceInfo struct {
 
 // Device represent device
 type Device struct {
-	ID           string        `json:"id,omitempty"`
-	Name         string        `json:"name,omitempty"`
-	Description  string        `json:"description,omitempty"`
-	Room         string        `json:"room,omitempty"`
-	Type         string        `json:"type,omitempty"`
-	CustomData   string        `json:"custom_data,omitempty"`
-	Capabilities []interface{} `json:"capabilities,omitempty"`
-	Properties   []interface{} `json:"properties,omitempty"`
-	DeviceInfo   DeviceInfo    `json:"device_info,omitempty"`
+	ID           string       `json:"id,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	Room         string       `json:"room,omitempty"`
+	Type         string       `json:"type,omitempty"`
+	CustomData   *CustomData  `json:"custom_data,omitempty"`
+	Capabilities []Capability `json:"capabilities,omitempty"`
+	Properties   []Property   `json:"properties,omitempty"`
+	DeviceInfo   DeviceInfo   `json:"device_info,omitempty"`
+}
+
+// Validate check device description is allowed by the protocol
+func (d *Device) Validate() error {
+	if 0 == len(d.ID) {
+		return newValidationError("device without id")
+	}
+
+	if 0 == len(strings.TrimSpace(d.Name)) {
+		return newValidationError("device %s without name", d.ID)
+	}
+
+	if !deviceTypes[d.Type] {
+		return newValidationError("device %s has unknown type %s", d.ID, d.Type)
+	}
+
+	if 0 == len(d.Capabilities) && 0 == len(d.Properties) {
+		return newValidationError("device %s without capabilities and properties", d.ID)
+	}
+
+	declared := make(map[string]bool)
+
+	for index := range d.Capabilities {
+		c := &d.Capabilities[index]
+		if e := c.Validate(); nil != e {
+			return fmt.Errorf("device %s: %w", d.ID, e)
+		}
+
+		key := c.Type + "/" + c.GetInstance()
+		if declared[key] {
+			return newValidationError("device %s has duplicate capability %s", d.ID, key)
+		}
+		declared[key] = true
+	}
+
+	for index := range d.Properties {
+		p := &d.Properties[index]
+		if e := p.Validate(); nil != e {
+			return fmt.Errorf("device %s: %w", d.ID, e)
+		}
+
+		key := p.Type + "/" + p.GetInstance()
+		if declared[key] {
+			return newValidationError("device %s has duplicate property %s", d.ID, key)
+		}
+		declared[key] = true
+	}
+
+	return nil
+}
+
+// findCapability return declared capability parameters for the capability type and instance
+func findCapability(capabilities []Capability, capabilityType string, instance string) (CapabilityParameters, bool) {
+	for _, c := range capabilities {
+		if c.Type != capabilityType {
+			continue
+		}
+
+		if parameterInstance := c.GetInstance(); len(parameterInstance) > 0 && parameterInstance != instance {
+			continue
+		}
+
+		return c.Parameters, true
+	}
+
+	return nil, false
 }
 
 type devicesPayload struct {
@@ -34,11 +147,263 @@ type devicesResponse struct {
 	Payload   devicesPayload `json:"payload,omitempty"`
 }
 
-func newDevicesResponse(ginCtx *gin.Context) *devicesResponse {
+func newDevicesResponse(ginCtx *gin.Context, devices []Device) *devicesResponse {
 	return &devicesResponse{
 		RequestID: ginCtx.GetHeader(headerRequestID),
 		Payload: devicesPayload{
-			UserID: ginCtx.GetString(contextUserID),
+			UserID:  ginCtx.GetString(contextUserID),
+			Devices: devices,
+		},
+	}
+}
+
+// endpointID return Alisa device ID for the device channel. Channel 0 is device itself.
+func endpointID(deviceID string, channel int) string {
+	if 0 == channel {
+		return deviceID
+	}
+
+	return deviceID + channelSeparator + strconv.Itoa(channel)
+}
+
+// parseEndpointID return device ID and channel index for the Alisa device ID
+func parseEndpointID(id string) (deviceID string, channel int) {
+	index := strings.LastIndex(id, channelSeparator)
+	if index < 0 {
+		return id, 0
+	}
+
+	var e error
+	if channel, e = strconv.Atoi(id[index+1:]); nil != e {
+		return id, 0
+	}
+
+	return id[:index], channel
+}
+
+// findEndpoint return device and channel for the Alisa device ID. Channel is nil for the sensors endpoint.
+func findEndpoint(deviceManager api.DeviceManager, id string) (string, api.Device, *api.Channel, error) {
+	deviceID, channelIndex := parseEndpointID(id)
+
+	device, e := deviceManager.GetDevice(deviceID)
+	if nil != e {
+		return deviceID, nil, nil, e
+	}
+
+	if 0 == channelIndex {
+		if 0 == len(device.GetSensors()) {
+			return deviceID, nil, nil, api.ErrDeviceNotFound
+		}
+
+		return deviceID, device, nil, nil
+	}
+
+	for _, channel := range device.GetChannels() {
+		if channel.Index == channelIndex {
+			return deviceID, device, &channel, nil
+		}
+	}
+
+	return deviceID, nil, nil, api.ErrDeviceNotFound
+}
+
+// newDevices return all Alisa devices provided by the device manager
+func newDevices(logger *zap.SugaredLogger,
+	deviceManager api.DeviceManager,
+	names deviceNames,
+	reportable bool) ([]Device, error) {
+	devices, e := deviceManager.EnumDevices()
+	if nil != e {
+		return nil, e
+	}
+
+	// Keep devices order stable between requests
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	result := make([]Device, 0, len(devices))
+	for _, deviceID := range deviceIDs {
+		for _, endpoint := range newDeviceEndpoints(deviceID, devices[deviceID], names, reportable) {
+			if e := endpoint.Validate(); nil != e {
+				logger.Errorw("Invalid device description", "device_id", endpoint.ID, "error", e)
+				continue
+			}
+
+			result = append(result, endpoint)
+		}
+	}
+
+	return result, nil
+}
+
+// newDeviceEndpoints return Alisa devices for each device channel and for the device sensors
+func newDeviceEndpoints(deviceID string, device api.Device, names deviceNames, reportable bool) (result []Device) {
+	info := DeviceInfo{
+		Model:           device.GetType(),
+		SoftwareVersion: device.GetFirmwareVersion(),
+	}
+
+	channels := device.GetChannels()
+	for _, channel := range channels {
+		endpoint := Device{
+			ID:           endpointID(deviceID, channel.Index),
+			Name:         channelName(deviceID, device, channel, len(channels)),
+			Type:         DeviceTypeSwitch,
+			CustomData:   newCustomData(deviceID, device, channel.Index),
+			Capabilities: channelCapabilities(channel, reportable),
+			DeviceInfo:   info,
+		}
+
+		if api.ChannelLight == channel.Type {
+			endpoint.Type = DeviceTypeLight
+		}
+
+		names.apply(&endpoint, deviceID, channel.Index, len(channels))
+
+		result = append(result, endpoint)
+	}
+
+	if sensors := device.GetSensors(); len(sensors) > 0 {
+		endpoint := Device{
+			ID:         endpointID(deviceID, 0),
+			Name:       device.GetName(),
+			Type:       sensorsType(sensors),
+			CustomData: newCustomData(deviceID, device, 0),
+			Properties: sensorProperties(sensors, reportable),
+			DeviceInfo: info,
+		}
+
+		if 0 == len(endpoint.Name) {
+			endpoint.Name = deviceID
+		}
+
+		names.apply(&endpoint, deviceID, 0, len(channels))
+
+		result = append(result, endpoint)
+	}
+
+	return result
+}
+
+// channelName return name for the device channel
+func channelName(deviceID string, device api.Device, channel api.Channel, channels int) string {
+	if name := strings.TrimSpace(channel.Name); len(name) > 0 {
+		return name
+	}
+
+	name := strings.TrimSpace(device.GetName())
+	if 0 == len(name) {
+		name = deviceID
+	}
+
+	if channels > 1 {
+		// Names must be different for each channel
+		name += " " + strconv.Itoa(channel.Index)
+	}
+
+	return name
+}
+
+const (
+	// Tasmota-compatible white light temperature range
+	lightTemperatureMin = 2000
+	lightTemperatureMax = 6500
+)
+
+// channelCapabilities return capabilities of the device channel
+func channelCapabilities(channel api.Channel, reportable bool) []Capability {
+	capabilities := []Capability{
+		NewCapability(true, reportable, &OnOffParameters{}),
+	}
+
+	if api.ChannelLight != channel.Type || api.LightNone == channel.Light {
+		return capabilities
+	}
+
+	capabilities = append(capabilities, NewCapability(true, reportable, &RangeParameters{
+		Instance:     RangeBrightness,
+		Unit:         UnitPercent,
+		RandomAccess: true,
+		Range: &RangeBounds{
+			Min:       1,
+			Max:       100,
+			Precision: 1,
 		},
+	}))
+
+	colorParameters := &ColorSettingParameters{}
+	switch channel.Light {
+	case api.LightRGB, api.LightRGBW:
+		colorParameters.ColorModel = ColorModelHSV
+	case api.LightRGBCW:
+		colorParameters.ColorModel = ColorModelHSV
+		colorParameters.TemperatureK = &TemperatureRange{Min: lightTemperatureMin, Max: lightTemperatureMax}
+	case api.LightCW:
+		colorParameters.TemperatureK = &TemperatureRange{Min: lightTemperatureMin, Max: lightTemperatureMax}
+	default:
+		return capabilities
+	}
+
+	return append(capabilities, NewCapability(true, reportable, colorParameters))
+}
+
+// sensorParameters is Alisa float property parameters for known sensor kinds
+var sensorParameters = map[api.SensorKind]FloatParameters{
+	api.SensorTemperature: {Instance: FloatTemperature, Unit: UnitTemperatureCelsius},
+	api.SensorHumidity:    {Instance: FloatHumidity, Unit: UnitPercent},
+	api.SensorPressure:    {Instance: FloatPressure, Unit: UnitPressureMmHg},
+	api.SensorIlluminance: {Instance: FloatIllumination, Unit: UnitIlluminationLux},
+	api.SensorCO2:         {Instance: FloatCO2, Unit: UnitPPM},
+	api.SensorVoltage:     {Instance: FloatVoltage, Unit: UnitVolt},
+	api.SensorCurrent:     {Instance: FloatAmperage, Unit: UnitAmpere},
+	api.SensorPower:       {Instance: FloatPower, Unit: UnitWatt},
+}
+
+// climateSensors is sensor kinds of the climate sensor device
+var climateSensors = map[api.SensorKind]bool{
+	api.SensorTemperature: true,
+	api.SensorHumidity:    true,
+	api.SensorPressure:    true,
+	api.SensorCO2:         true,
+}
+
+// sensorsType return Alisa device type for the device sensors
+func sensorsType(sensors []api.Sensor) string {
+	climate, illumination := 0, 0
+	for _, sensor := range sensors {
+		switch {
+		case climateSensors[sensor.Kind]:
+			climate++
+		case api.SensorIlluminance == sensor.Kind:
+			illumination++
+		}
+	}
+
+	switch len(sensors) {
+	case climate:
+		return DeviceTypeSensorClimate
+	case illumination:
+		return DeviceTypeSensorIllumination
+	default:
+		return DeviceTypeSensor
 	}
 }
+
+// sensorProperties return properties for the device sensors
+func sensorProperties(sensors []api.Sensor, reportable bool) []Property {
+	properties := make([]Property, 0, len(sensors))
+
+	for _, sensor := range sensors {
+		parameters, known := sensorParameters[sensor.Kind]
+		if !known {
+			continue
+		}
+
+		properties = append(properties, NewProperty(true, reportable, &parameters))
+	}
+
+	return properties
+}
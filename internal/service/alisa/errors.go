@@ -0,0 +1,59 @@
+package alisa
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// Yandex error codes
+	errorDeviceNotFound    = "DEVICE_NOT_FOUND"
+	errorDeviceUnreachable = "DEVICE_UNREACHABLE"
+	errorInternal          = "INTERNAL_ERROR"
+	errorInvalidAction     = "INVALID_ACTION"
+	errorInvalidValue      = "INVALID_VALUE"
+	errorAccountLinking    = "ACCOUNT_LINKING_ERROR"
+)
+
+// errorResponse is response for the request which can't be processed at all
+type errorResponse struct {
+	RequestID    string `json:"request_id,omitempty"`
+	ErrorCode    string `json:"error_code"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// errorCode return Yandex error code for the error
+func errorCode(e error) string {
+	switch {
+	case errors.Is(e, api.ErrDeviceNotFound):
+		return errorDeviceNotFound
+	case errors.Is(e, api.ErrDeviceUnreachable), errors.Is(e, context.DeadlineExceeded):
+		return errorDeviceUnreachable
+	case errors.Is(e, api.ErrInvalidAction):
+		return errorInvalidAction
+	case errors.Is(e, api.ErrInvalidValue):
+		return errorInvalidValue
+	default:
+		return errorInternal
+	}
+}
+
+// abortWithError log the error and abort request with the error response
+func abortWithError(ginCtx *gin.Context, status int, code string, e error) {
+	logger := requestLogger(ginCtx)
+	if status >= http.StatusInternalServerError {
+		logger.Errorw("Request failed", "error_code", code, "error", e)
+	} else {
+		logger.Warnw("Request rejected", "error_code", code, "error", e)
+	}
+
+	ginCtx.AbortWithStatusJSON(status, &errorResponse{
+		RequestID:    ginCtx.GetHeader(headerRequestID),
+		ErrorCode:    code,
+		ErrorMessage: e.Error(),
+	})
+}
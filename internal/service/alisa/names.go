@@ -0,0 +1,152 @@
+package alisa
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+const (
+	// envDevicesConfig is path to the JSON file with names, rooms and types of the Alisa devices
+	envDevicesConfig = "ALISA_DEVICES_CONFIG"
+)
+
+// deviceName is user defined description of the device or device channel
+type deviceName struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Room        string `json:"room,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+var (
+	// configKeys is known keys of the devices config
+	configKeys = map[string]bool{"devices": true}
+	// deviceNameKeys is known keys of the device description
+	deviceNameKeys = map[string]bool{"name": true, "description": true, "room": true, "type": true}
+)
+
+// deviceNames is user defined descriptions keyed by device ID, or device ID with channel index separated by colon
+type deviceNames map[string]deviceName
+
+// loadDeviceNames return device descriptions from the configuration file, if path isn't empty
+func loadDeviceNames(path string) (deviceNames, error) {
+	if 0 == len(path) {
+		return deviceNames{}, nil
+	}
+
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return nil, e
+	}
+
+	var config struct {
+		Devices deviceNames `json:"devices"`
+	}
+	if e = json.Unmarshal(data, &config); nil != e {
+		return nil, fmt.Errorf("devices config %s: %w", path, e)
+	}
+
+	// Unknown keys are usually typos, which are ignored by the decoder
+	for _, key := range unknownKeys(data) {
+		log.Log.Warnw("Unknown key of the devices config is ignored", "path", path, "key", key)
+	}
+
+	for id, name := range config.Devices {
+		if len(name.Type) > 0 && !deviceTypes[name.Type] {
+			return nil, fmt.Errorf("devices config %s: device %s has unknown type %s", path, id, name.Type)
+		}
+	}
+
+	if nil == config.Devices {
+		return deviceNames{}, nil
+	}
+
+	return config.Devices, nil
+}
+
+// unknownKeys return sorted paths of the config keys which aren't known, including device keys with invalid
+// channel index
+func unknownKeys(data []byte) []string {
+	var config map[string]json.RawMessage
+	if e := json.Unmarshal(data, &config); nil != e {
+		return nil
+	}
+
+	var unknown []string
+	for key := range config {
+		if !configKeys[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	var devices map[string]map[string]json.RawMessage
+	if e := json.Unmarshal(config["devices"], &devices); nil != e {
+		devices = nil
+	}
+
+	for id, fields := range devices {
+		if index := strings.LastIndex(id, channelSeparator); index >= 0 {
+			if channel, e := strconv.Atoi(id[index+1:]); nil != e || channel < 0 {
+				unknown = append(unknown, "devices."+id)
+			}
+		}
+
+		for key := range fields {
+			if !deviceNameKeys[key] {
+				unknown = append(unknown, "devices."+id+"."+key)
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// apply set user defined description of the Alisa device. Device level description is used as default for
+// the channels, except type which is applied to the channels only. Sensors use key with channel 0.
+func (names deviceNames) apply(endpoint *Device, deviceID string, channel int, channels int) {
+	if deviceLevel, found := names[deviceID]; found {
+		if len(deviceLevel.Name) > 0 {
+			endpoint.Name = deviceLevel.Name
+			if channel > 0 && channels > 1 {
+				// Names must be different for each channel
+				endpoint.Name += " " + strconv.Itoa(channel)
+			}
+		}
+
+		if 0 == channel {
+			deviceLevel.Type = ""
+		}
+		endpoint.merge(deviceLevel)
+	}
+
+	if channelLevel, found := names[deviceID+channelSeparator+strconv.Itoa(channel)]; found {
+		if len(channelLevel.Name) > 0 {
+			endpoint.Name = channelLevel.Name
+		}
+		endpoint.merge(channelLevel)
+	}
+
+	endpoint.Name = strings.TrimSpace(endpoint.Name)
+}
+
+// merge set description, room and type which are defined by user
+func (d *Device) merge(name deviceName) {
+	if len(name.Description) > 0 {
+		d.Description = name.Description
+	}
+
+	if len(name.Room) > 0 {
+		d.Room = name.Room
+	}
+
+	if len(name.Type) > 0 {
+		d.Type = name.Type
+	}
+}
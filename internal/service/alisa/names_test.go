@@ -0,0 +1,27 @@
+package alisa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"known", `{"devices": {"tasmota_AABBCCDDEEFF": {"name": "Свет", "description": "Люстра", "room": "Кухня",
+			"type": "devices.types.light"}, "tasmota_AABBCCDDEEFF:2": {"name": "Вытяжка"}}}`, nil},
+		{"top level", `{"device": {}, "devices": {}}`, []string{"device"}},
+		{"device field", `{"devices": {"tasmota_AABBCCDDEEFF": {"nmae": "Свет", "rooms": "Кухня"}}}`,
+			[]string{"devices.tasmota_AABBCCDDEEFF.nmae", "devices.tasmota_AABBCCDDEEFF.rooms"}},
+		{"channel", `{"devices": {"tasmota_AABBCCDDEEFF:light": {"name": "Свет"}}}`,
+			[]string{"devices.tasmota_AABBCCDDEEFF:light"}},
+		{"invalid", `{"devices": []}`, nil},
+	} {
+		if got := unknownKeys([]byte(test.data)); !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package alisa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/devices"
+	"github.com/vedga/alisa/internal/service/states"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// envYandexSkillID is skill identifier from the Yandex Dialogs developer console
+	envYandexSkillID = "YANDEX_SKILL_ID"
+	// envYandexSkillToken is OAuth token for the Yandex Dialogs API
+	envYandexSkillToken = "YANDEX_SKILL_OAUTH_TOKEN"
+	// envYandexCallbackURL is base URL of the Yandex Dialogs skills API
+	envYandexCallbackURL = "YANDEX_CALLBACK_URL"
+	defaultCallbackURL   = "https://dialogs.yandex.net/api/v1/skills"
+	callbackState        = "/callback/state"
+	callbackDiscovery    = "/callback/discovery"
+	// notifyInterval is minimal interval between notifications, changes are batched during this interval
+	notifyInterval = time.Second
+	// notifyRetries is number of attempts to send notification
+	notifyRetries = 3
+	// notifyRetryDelay is delay before first retry, doubled with each attempt
+	notifyRetryDelay = time.Millisecond * 500
+	// notifyRequestTimeout is timeout of the single notification request
+	notifyRequestTimeout = time.Second * 5
+)
+
+// callbackPayload is payload of the Yandex callback request
+type callbackPayload struct {
+	UserID  string        `json:"user_id"`
+	Devices []deviceState `json:"devices,omitempty"`
+}
+
+// callbackRequest is Yandex callback request
+type callbackRequest struct {
+	TS      float64         `json:"ts"`
+	Payload callbackPayload `json:"payload"`
+}
+
+// callbackResponse is Yandex callback response
+type callbackResponse struct {
+	RequestID    string `json:"request_id"`
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// errTransient is error which may disappear on retry
+var errTransient = errors.New("transient error")
+
+// notifier push state and discovery notifications to the Yandex callback API
+type notifier struct {
+	client     *http.Client
+	baseURL    string
+	token      string
+	access     api.AccessControl
+	stateCache api.StateCache
+	lock       sync.Mutex
+	// users is subscribed users with last reported capability and property states
+	users map[string]map[string]string
+	// changed is IDs of the devices with changed state
+	changed map[string]struct{}
+	// discovered is IDs of the added devices and devices with changed description
+	discovered map[string]struct{}
+}
+
+// newNotifier return notifier, or nil when notifications isn't configured
+func newNotifier(access api.AccessControl, stateCache api.StateCache) *notifier {
+	skillID, found := os.LookupEnv(envYandexSkillID)
+	if !found {
+		return nil
+	}
+
+	token, found := os.LookupEnv(envYandexSkillToken)
+	if !found {
+		log.Log.Warn("Notifications disabled, skill OAuth token isn't configured")
+		return nil
+	}
+
+	baseURL := defaultCallbackURL
+	if value, found := os.LookupEnv(envYandexCallbackURL); found {
+		baseURL = value
+	}
+
+	return &notifier{
+		client: &http.Client{
+			Timeout: notifyRequestTimeout,
+		},
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/" + skillID,
+		token:      token,
+		access:     access,
+		stateCache: stateCache,
+		users:      make(map[string]map[string]string),
+		changed:    make(map[string]struct{}),
+		discovered: make(map[string]struct{}),
+	}
+}
+
+// Subscribe start sending notifications for the user
+func (n *notifier) Subscribe(userID string) {
+	if 0 == len(userID) {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, found := n.users[userID]; !found {
+		n.users[userID] = make(map[string]string)
+	}
+}
+
+// Unsubscribe stop sending notifications for the user
+func (n *notifier) Unsubscribe(userID string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.users, userID)
+}
+
+// run send batched notifications until context done, users are unsubscribed by events of the unlink topic
+func (n *notifier) run(ctx context.Context, bus eventbus.Bus, unlinkTopic string) error {
+	if e := bus.Subscribe(unlinkTopic, n.Unsubscribe); nil != e {
+		return e
+	}
+	defer func() {
+		_ = bus.Unsubscribe(unlinkTopic, n.Unsubscribe)
+	}()
+
+	if e := bus.Subscribe(states.StateChanged, n.onStateChanged); nil != e {
+		return e
+	}
+	defer func() {
+		_ = bus.Unsubscribe(states.StateChanged, n.onStateChanged)
+	}()
+
+	if e := bus.Subscribe(devices.DeviceChanged, n.onDeviceChanged); nil != e {
+		return e
+	}
+	defer func() {
+		_ = bus.Unsubscribe(devices.DeviceChanged, n.onDeviceChanged)
+	}()
+
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.flush(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// onStateChanged called when device state changed
+func (n *notifier) onStateChanged(deviceID string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.changed[deviceID] = struct{}{}
+}
+
+// onDeviceChanged called when device added or device description changed
+func (n *notifier) onDeviceChanged(deviceID string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.discovered[deviceID] = struct{}{}
+}
+
+// flush send notifications about changes collected since previous call
+func (n *notifier) flush(ctx context.Context) {
+	n.lock.Lock()
+	changed := n.changed
+	n.changed = make(map[string]struct{})
+	discovered := n.discovered
+	n.discovered = make(map[string]struct{})
+	userIDs := make([]string, 0, len(n.users))
+	for userID := range n.users {
+		userIDs = append(userIDs, userID)
+	}
+	n.lock.Unlock()
+
+	// Discovery is requested only by users who see at least one of the changed devices
+	for _, userID := range userIDs {
+		if !hasDevice(n.access.UserDevices(userID), discovered) {
+			continue
+		}
+
+		if e := n.send(ctx, callbackDiscovery, callbackPayload{UserID: userID}); nil != e {
+			log.Log.Errorw("Unable to send discovery notification", "user_id", userID, "error", e)
+		}
+	}
+
+	if 0 == len(changed) {
+		return
+	}
+
+	for _, userID := range userIDs {
+		// User may see only part of the changed devices
+		n.notifyUser(ctx, userID, n.changedEndpoints(n.access.UserDevices(userID), changed))
+	}
+}
+
+// hasDevice return true when at least one of the devices is available from the device manager
+func hasDevice(deviceManager api.DeviceManager, deviceIDs map[string]struct{}) bool {
+	for deviceID := range deviceIDs {
+		if _, e := deviceManager.GetDevice(deviceID); nil == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changedEndpoints return current states of all Alisa devices of the changed devices
+func (n *notifier) changedEndpoints(deviceManager api.DeviceManager, changed map[string]struct{}) []deviceState {
+	deviceIDs := make([]string, 0, len(changed))
+	for deviceID := range changed {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	var endpoints []deviceState
+	for _, deviceID := range deviceIDs {
+		device, e := deviceManager.GetDevice(deviceID)
+		if nil != e {
+			continue
+		}
+
+		ids := make([]string, 0)
+		for _, channel := range device.GetChannels() {
+			ids = append(ids, endpointID(deviceID, channel.Index))
+		}
+		if len(device.GetSensors()) > 0 {
+			ids = append(ids, endpointID(deviceID, 0))
+		}
+
+		for _, id := range ids {
+			if state := newDeviceState(log.Log, deviceManager, n.stateCache, deviceRequest{ID: id}); 0 == len(state.ErrorCode) {
+				endpoints = append(endpoints, state)
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// notifyUser send states which wasn't reported to the user yet
+func (n *notifier) notifyUser(ctx context.Context, userID string, endpoints []deviceState) {
+	reported := make(map[string]string)
+
+	var changes []deviceState
+	for _, endpoint := range endpoints {
+		change := deviceState{
+			ID: endpoint.ID,
+		}
+
+		for _, c := range endpoint.Capabilities {
+			if key, value, changed := n.isChanged(userID, endpoint.ID+"/"+c.Type+"/"+c.State.GetInstance(), c); changed {
+				reported[key] = value
+				change.Capabilities = append(change.Capabilities, c)
+			}
+		}
+
+		for _, p := range endpoint.Properties {
+			if key, value, changed := n.isChanged(userID, endpoint.ID+"/"+p.Type+"/"+p.State.GetInstance(), p); changed {
+				reported[key] = value
+				change.Properties = append(change.Properties, p)
+			}
+		}
+
+		if len(change.Capabilities) > 0 || len(change.Properties) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	if 0 == len(changes) {
+		return
+	}
+
+	if e := n.send(ctx, callbackState, callbackPayload{UserID: userID, Devices: changes}); nil != e {
+		log.Log.Errorw("Unable to send state notification", "user_id", userID, "error", e)
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if userReported, found := n.users[userID]; found {
+		for key, value := range reported {
+			userReported[key] = value
+		}
+	}
+}
+
+// isChanged check is state differ from the last reported to the user
+func (n *notifier) isChanged(userID string, key string, state interface{}) (string, string, bool) {
+	data, e := json.Marshal(state)
+	if nil != e {
+		return key, "", false
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	userReported, found := n.users[userID]
+	if !found {
+		// User unsubscribed
+		return key, "", false
+	}
+
+	return key, string(data), userReported[key] != string(data)
+}
+
+// send notification with retries on transient errors
+func (n *notifier) send(ctx context.Context, path string, payload callbackPayload) (e error) {
+	delay := notifyRetryDelay
+
+	for attempt := 0; attempt < notifyRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+				delay *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if e = n.post(ctx, path, payload); nil == e || !errors.Is(e, errTransient) {
+			return e
+		}
+
+		log.Log.Debugw("Notification failed, retry", "path", path, "error", e)
+	}
+
+	return e
+}
+
+// post send single notification request
+func (n *notifier) post(ctx context.Context, path string, payload callbackPayload) error {
+	body, e := json.Marshal(&callbackRequest{
+		TS:      float64(time.Now().UnixNano()) / float64(time.Second),
+		Payload: payload,
+	})
+	if nil != e {
+		return e
+	}
+
+	request, e := http.NewRequestWithContext(ctx, http.MethodPost, n.baseURL+path, bytes.NewReader(body))
+	if nil != e {
+		return e
+	}
+	request.Header.Set("Authorization", "OAuth "+n.token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, e := n.client.Do(request)
+	if nil != e {
+		return fmt.Errorf("%w: %v", errTransient, e)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	data, _ := io.ReadAll(response.Body)
+
+	switch {
+	case http.StatusAccepted == response.StatusCode || http.StatusOK == response.StatusCode:
+		return nil
+	case http.StatusTooManyRequests == response.StatusCode || response.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: status %d", errTransient, response.StatusCode)
+	}
+
+	var result callbackResponse
+	if e = json.Unmarshal(data, &result); nil == e && len(result.ErrorCode) > 0 {
+		return fmt.Errorf("status %d: %s %s", response.StatusCode, result.ErrorCode, result.ErrorMessage)
+	}
+
+	return fmt.Errorf("status %d", response.StatusCode)
+}
@@ -0,0 +1,344 @@
+package alisa
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/devices"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+const (
+	// testSkillID is skill of the fake callback API
+	testSkillID = "skill"
+	// testRelayID is two channels relay with temperature sensor
+	testRelayID = "tasmota_112233445566"
+)
+
+// testCallback is fake Yandex callback API which record notifications, queued statuses are answered first
+type testCallback struct {
+	*httptest.Server
+	lock     sync.Mutex
+	statuses []int
+	requests []testNotification
+}
+
+// testNotification is notification received by the fake callback API
+type testNotification struct {
+	Path    string
+	Payload string
+}
+
+// newTestCallback return started fake callback API
+func newTestCallback(t *testing.T) *testCallback {
+	t.Helper()
+
+	callback := &testCallback{}
+	callback.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodPost != r.Method || "OAuth skill-token" != r.Header.Get("Authorization") {
+			t.Errorf("unexpected notification request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		var request struct {
+			TS      float64         `json:"ts"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if e := json.Unmarshal(body, &request); nil != e || 0 == request.TS {
+			t.Errorf("invalid notification %s: %v", body, e)
+		}
+
+		callback.lock.Lock()
+		defer callback.lock.Unlock()
+
+		callback.requests = append(callback.requests, testNotification{
+			Path:    r.URL.Path,
+			Payload: string(request.Payload),
+		})
+
+		if len(callback.statuses) > 0 {
+			status := callback.statuses[0]
+			callback.statuses = callback.statuses[1:]
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"request_id":"1","status":"ERROR","error_code":"UNKNOWN_USER"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"request_id":"1","status":"ok"}`))
+	}))
+	t.Cleanup(callback.Close)
+
+	return callback
+}
+
+// answer queue statuses of the next requests
+func (callback *testCallback) answer(statuses ...int) {
+	callback.lock.Lock()
+	defer callback.lock.Unlock()
+
+	callback.statuses = append(callback.statuses, statuses...)
+}
+
+// received return notifications received since previous call
+func (callback *testCallback) received() []testNotification {
+	callback.lock.Lock()
+	defer callback.lock.Unlock()
+
+	requests := callback.requests
+	callback.requests = nil
+
+	return requests
+}
+
+// newTestNotifier return notifier of the fake callback API, user ivan see the relay and maria don't see anything
+func newTestNotifier(t *testing.T, callback *testCallback) (*notifier, *apitest.States) {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+
+	t.Setenv(envYandexSkillID, testSkillID)
+	t.Setenv(envYandexSkillToken, "skill-token")
+	t.Setenv(envYandexCallbackURL, callback.URL+"/")
+
+	stateCache := apitest.NewStates()
+	relay := &apitest.Device{
+		ID:   testRelayID,
+		Name: "Реле",
+		Channels: []api.Channel{
+			{Index: 1, Type: api.ChannelRelay},
+			{Index: 2, Type: api.ChannelRelay},
+		},
+		Sensors:    []api.Sensor{{Kind: api.SensorTemperature}},
+		StateCache: stateCache,
+	}
+
+	n := newNotifier(testAccess{"ivan": apitest.Devices{testRelayID: relay}}, stateCache)
+	if nil == n {
+		t.Fatal("notifier isn't configured")
+	}
+
+	return n, stateCache
+}
+
+// testAccess give each user own devices, unknown user has no devices
+type testAccess map[string]api.DeviceManager
+
+func (access testAccess) UserDevices(userID string) api.DeviceManager {
+	if deviceManager, found := access[userID]; found {
+		return deviceManager
+	}
+
+	return apitest.Devices{}
+}
+
+// onOff return JSON of the on_off capability state
+func onOff(on bool) string {
+	data, _ := json.Marshal(NewCapabilityState(NewOnOffState(on)))
+	return string(data)
+}
+
+func TestNotifierBatching(t *testing.T) {
+	callback := newTestCallback(t)
+	n, stateCache := newTestNotifier(t, callback)
+
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[1] = api.ChannelState{On: true}
+		state.Sensors[api.SensorTemperature] = 21.5
+	})
+
+	// Changes aren't sent to nobody
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+	if requests := callback.received(); 0 != len(requests) {
+		t.Fatalf("got notifications without subscribers %+v", requests)
+	}
+
+	n.Subscribe("ivan")
+	n.Subscribe("maria")
+
+	// Several changes of the device are sent by single request, user without devices isn't notified
+	n.onStateChanged(testRelayID)
+	n.onStateChanged(testRelayID)
+	n.onStateChanged("tasmota_000000000000")
+	n.flush(context.Background())
+
+	want := []testNotification{{
+		Path: "/" + testSkillID + callbackState,
+		Payload: `{"user_id":"ivan","devices":[` +
+			`{"id":"` + testRelayID + `:1","capabilities":[` + onOff(true) + `]},` +
+			`{"id":"` + testRelayID + `:2","capabilities":[` + onOff(false) + `]},` +
+			`{"id":"` + testRelayID + `","properties":[` +
+			`{"type":"devices.properties.float","state":{"instance":"temperature","value":21.5}}]}]}`,
+	}}
+	if got := callback.received(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("got notifications\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Nothing is sent until next change, and only changed capability is sent then
+	n.flush(context.Background())
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+	if requests := callback.received(); 0 != len(requests) {
+		t.Fatalf("got notifications without changes %+v", requests)
+	}
+
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[2] = api.ChannelState{On: true}
+	})
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+
+	want = []testNotification{{
+		Path: "/" + testSkillID + callbackState,
+		Payload: `{"user_id":"ivan","devices":[` +
+			`{"id":"` + testRelayID + `:2","capabilities":[` + onOff(true) + `]}]}`,
+	}}
+	if got := callback.received(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("got notifications\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Unsubscribed user isn't notified
+	n.Unsubscribe("ivan")
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[2] = api.ChannelState{}
+	})
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+	if requests := callback.received(); 0 != len(requests) {
+		t.Fatalf("got notifications of unsubscribed user %+v", requests)
+	}
+}
+
+func TestNotifierRetry(t *testing.T) {
+	callback := newTestCallback(t)
+	n, stateCache := newTestNotifier(t, callback)
+	n.Subscribe("ivan")
+
+	// Transient failures are retried until notification is accepted
+	callback.answer(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[1] = api.ChannelState{On: true}
+	})
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+
+	requests := callback.received()
+	if 3 != len(requests) || requests[0] != requests[2] {
+		t.Fatalf("got notifications %+v", requests)
+	}
+
+	// Notification which isn't accepted after all retries is sent with the next change
+	callback.answer(http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[1] = api.ChannelState{}
+	})
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+
+	if requests = callback.received(); notifyRetries != len(requests) {
+		t.Fatalf("got %d notifications, want %d", len(requests), notifyRetries)
+	}
+
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+	if requests = callback.received(); 1 != len(requests) {
+		t.Fatalf("failed notification isn't sent again, got %+v", requests)
+	}
+
+	// Rejected notification isn't retried
+	callback.answer(http.StatusBadRequest)
+
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Channels[1] = api.ChannelState{On: true}
+	})
+	n.onStateChanged(testRelayID)
+	n.flush(context.Background())
+
+	if requests = callback.received(); 1 != len(requests) {
+		t.Fatalf("rejected notification is retried, got %+v", requests)
+	}
+}
+
+func TestNotifierDiscovery(t *testing.T) {
+	callback := newTestCallback(t)
+	n, _ := newTestNotifier(t, callback)
+	n.Subscribe("ivan")
+	n.Subscribe("maria")
+
+	// Device which nobody see isn't discovered
+	n.onDeviceChanged("tasmota_000000000000")
+	n.flush(context.Background())
+	if requests := callback.received(); 0 != len(requests) {
+		t.Fatalf("discovery of the unavailable device is sent %+v", requests)
+	}
+
+	// Discovery is requested once by the user who see the device, whatever number of devices changed
+	n.onDeviceChanged(testRelayID)
+	n.onDeviceChanged("tasmota_000000000000")
+	n.flush(context.Background())
+
+	requests := callback.received()
+	if 1 != len(requests) || "/"+testSkillID+callbackDiscovery != requests[0].Path ||
+		`{"user_id":"ivan"}` != requests[0].Payload {
+		t.Fatalf("got notifications %+v", requests)
+	}
+
+	n.flush(context.Background())
+	if requests = callback.received(); 0 != len(requests) {
+		t.Fatalf("discovery is sent again %+v", requests)
+	}
+}
+
+func TestNotifierUnlink(t *testing.T) {
+	callback := newTestCallback(t)
+	n, _ := newTestNotifier(t, callback)
+	n.Subscribe("ivan")
+	n.Subscribe("maria")
+
+	bus := eventbus.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- n.run(ctx, bus, UserUnlinked)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Notifier subscribe to state changes after unlink events
+	for deadline := time.Now().Add(time.Second); !bus.HasCallback(devices.DeviceChanged); {
+		if time.Now().After(deadline) {
+			t.Fatal("notifier isn't started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bus.Publish(UserUnlinked, "ivan")
+
+	n.lock.Lock()
+	_, ivan := n.users["ivan"]
+	_, maria := n.users["maria"]
+	n.lock.Unlock()
+
+	if ivan || !maria {
+		t.Errorf("got subscriptions ivan %v, maria %v", ivan, maria)
+	}
+}
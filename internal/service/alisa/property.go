@@ -0,0 +1,390 @@
+package alisa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// Property types
+const (
+	PropertyFloat = "devices.properties.float"
+	PropertyEvent = "devices.properties.event"
+)
+
+// Float property instances
+const (
+	FloatAmperage     = "amperage"
+	FloatBattery      = "battery_level"
+	FloatCO2          = "co2_level"
+	FloatHumidity     = "humidity"
+	FloatIllumination = "illumination"
+	FloatPM1          = "pm1_density"
+	FloatPM25         = "pm2.5_density"
+	FloatPM10         = "pm10_density"
+	FloatPower        = "power"
+	FloatPressure     = "pressure"
+	FloatTemperature  = "temperature"
+	FloatTVOC         = "tvoc"
+	FloatVoltage      = "voltage"
+	FloatWaterLevel   = "water_level"
+	FloatFoodLevel    = "food_level"
+	FloatGas          = "gas_concentration"
+	FloatSmoke        = "smoke_concentration"
+	FloatElectricity  = "electricity_meter"
+	FloatGasMeter     = "gas_meter"
+	FloatHeatMeter    = "heat_meter"
+	FloatWaterMeter   = "water_meter"
+	FloatMeter        = "meter"
+)
+
+// floatUnits is allowed units for float property instances, empty list means unit isn't allowed
+var floatUnits = map[string][]string{
+	FloatAmperage:     {UnitAmpere},
+	FloatBattery:      {UnitPercent},
+	FloatCO2:          {UnitPPM},
+	FloatHumidity:     {UnitPercent},
+	FloatIllumination: {UnitIlluminationLux},
+	FloatPM1:          {UnitDensityMcgM3},
+	FloatPM25:         {UnitDensityMcgM3},
+	FloatPM10:         {UnitDensityMcgM3},
+	FloatPower:        {UnitWatt},
+	FloatPressure:     {UnitPressureAtm, UnitPressurePascal, UnitPressureBar, UnitPressureMmHg},
+	FloatTemperature:  {UnitTemperatureCelsius, UnitTemperatureKelvin},
+	FloatTVOC:         {UnitDensityMcgM3},
+	FloatVoltage:      {UnitVolt},
+	FloatWaterLevel:   {UnitPercent},
+	FloatFoodLevel:    {UnitPercent},
+	FloatGas:          {UnitPercent},
+	FloatSmoke:        {UnitPercent},
+	FloatElectricity:  {UnitKilowattHour},
+	FloatGasMeter:     {UnitCubicMeter},
+	FloatHeatMeter:    {UnitGigacalorie},
+	FloatWaterMeter:   {UnitCubicMeter},
+	FloatMeter:        {},
+}
+
+// eventValues is allowed events for event property instances
+var eventValues = map[string]map[string]bool{
+	"vibration":     newSet("tilt", "fall", "vibration"),
+	"open":          newSet("opened", "closed"),
+	"button":        newSet("click", "double_click", "long_press"),
+	"motion":        newSet("detected", "not_detected"),
+	"smoke":         newSet("detected", "not_detected", "high"),
+	"gas":           newSet("detected", "not_detected", "high"),
+	"battery_level": newSet("low", "normal"),
+	"food_level":    newSet("empty", "low", "normal"),
+	"water_level":   newSet("empty", "low", "normal"),
+	"water_leak":    newSet("dry", "leak"),
+}
+
+// PropertyParameters is property type specific parameters
+type PropertyParameters interface {
+	// PropertyType return type of the property
+	PropertyType() string
+	// GetInstance return property instance
+	GetInstance() string
+	// Validate check parameters are allowed by the protocol
+	Validate() error
+}
+
+// PropertyStateValue is property type specific state
+type PropertyStateValue interface {
+	// PropertyType return type of the property
+	PropertyType() string
+	// GetInstance return state instance
+	GetInstance() string
+	// Validate check state is allowed by the property parameters
+	Validate(parameters PropertyParameters) error
+}
+
+// Property is device property description
+type Property struct {
+	Type        string             `json:"type"`
+	Retrievable bool               `json:"retrievable"`
+	Reportable  bool               `json:"reportable"`
+	Parameters  PropertyParameters `json:"parameters"`
+}
+
+// NewProperty return property description for the parameters
+func NewProperty(retrievable bool, reportable bool, parameters PropertyParameters) Property {
+	return Property{
+		Type:        parameters.PropertyType(),
+		Retrievable: retrievable,
+		Reportable:  reportable,
+		Parameters:  parameters,
+	}
+}
+
+// Validate check property is allowed by the protocol
+func (p *Property) Validate() error {
+	if nil == p.Parameters {
+		return newValidationError("property %s without parameters", p.Type)
+	}
+
+	if p.Type != p.Parameters.PropertyType() {
+		return newValidationError("property %s with %s parameters", p.Type, p.Parameters.PropertyType())
+	}
+
+	if !p.Retrievable && !p.Reportable {
+		return newValidationError("property %s is neither retrievable nor reportable", p.Type)
+	}
+
+	return p.Parameters.Validate()
+}
+
+// GetInstance return property instance
+func (p *Property) GetInstance() string {
+	if nil == p.Parameters {
+		return ""
+	}
+
+	return p.Parameters.GetInstance()
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface
+func (p *Property) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type        string          `json:"type"`
+		Retrievable bool            `json:"retrievable"`
+		Reportable  bool            `json:"reportable"`
+		Parameters  json.RawMessage `json:"parameters"`
+	}
+	if e := json.Unmarshal(data, &raw); nil != e {
+		return e
+	}
+
+	var parameters PropertyParameters
+	switch raw.Type {
+	case PropertyFloat:
+		parameters = &FloatParameters{}
+	case PropertyEvent:
+		parameters = &EventParameters{}
+	default:
+		return newValidationError("unknown property %s", raw.Type)
+	}
+
+	if e := json.Unmarshal(raw.Parameters, parameters); nil != e {
+		return e
+	}
+
+	p.Type = raw.Type
+	p.Retrievable = raw.Retrievable
+	p.Reportable = raw.Reportable
+	p.Parameters = parameters
+
+	return nil
+}
+
+// PropertyState is property with state
+type PropertyState struct {
+	Type  string             `json:"type"`
+	State PropertyStateValue `json:"state"`
+}
+
+// NewPropertyState return property with state
+func NewPropertyState(state PropertyStateValue) PropertyState {
+	return PropertyState{
+		Type:  state.PropertyType(),
+		State: state,
+	}
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface
+func (p *PropertyState) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type  string          `json:"type"`
+		State json.RawMessage `json:"state"`
+	}
+	if e := json.Unmarshal(data, &raw); nil != e {
+		return e
+	}
+
+	var state PropertyStateValue
+	switch raw.Type {
+	case PropertyFloat:
+		state = &FloatState{}
+	case PropertyEvent:
+		state = &EventState{}
+	default:
+		return newValidationError("unknown property %s", raw.Type)
+	}
+
+	if e := json.Unmarshal(raw.State, state); nil != e {
+		return e
+	}
+
+	p.Type = raw.Type
+	p.State = state
+
+	return nil
+}
+
+// FloatParameters is parameters of the float property
+type FloatParameters struct {
+	Instance string `json:"instance"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// PropertyType is implementation of PropertyParameters interface
+func (p *FloatParameters) PropertyType() string {
+	return PropertyFloat
+}
+
+// GetInstance is implementation of PropertyParameters interface
+func (p *FloatParameters) GetInstance() string {
+	return p.Instance
+}
+
+// Validate is implementation of PropertyParameters interface
+func (p *FloatParameters) Validate() error {
+	units, known := floatUnits[p.Instance]
+	if !known {
+		return newValidationError("unknown float instance %s", p.Instance)
+	}
+
+	if 0 == len(units) {
+		if len(p.Unit) > 0 {
+			return newValidationError("unit isn't allowed for float instance %s", p.Instance)
+		}
+		return nil
+	}
+
+	for _, unit := range units {
+		if unit == p.Unit {
+			return nil
+		}
+	}
+
+	return newValidationError("unit %s isn't allowed for float instance %s", p.Unit, p.Instance)
+}
+
+// FloatState is state of the float property
+type FloatState struct {
+	Instance string  `json:"instance"`
+	Value    float64 `json:"value"`
+}
+
+// PropertyType is implementation of PropertyStateValue interface
+func (s *FloatState) PropertyType() string {
+	return PropertyFloat
+}
+
+// GetInstance is implementation of PropertyStateValue interface
+func (s *FloatState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of PropertyStateValue interface
+func (s *FloatState) Validate(parameters PropertyParameters) error {
+	units, known := floatUnits[s.Instance]
+	if !known {
+		return newValidationError("unknown float instance %s", s.Instance)
+	}
+
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return newValidationError("invalid %s value", s.Instance)
+	}
+
+	unit := ""
+	if p, valid := parameters.(*FloatParameters); valid {
+		unit = p.Unit
+	} else if len(units) > 0 {
+		unit = units[0]
+	}
+
+	switch {
+	case UnitPercent == unit && (s.Value < 0 || s.Value > 100):
+		return newValidationError("%s value %v out of range", s.Instance, s.Value)
+	case UnitTemperatureKelvin == unit && s.Value < 0:
+		return newValidationError("%s value %v out of range", s.Instance, s.Value)
+	case UnitTemperatureCelsius != unit && UnitTemperatureKelvin != unit && s.Value < 0:
+		// Only temperature may be negative
+		return newValidationError("%s value %v out of range", s.Instance, s.Value)
+	}
+
+	return nil
+}
+
+// EventValue is event property value
+type EventValue struct {
+	Value string `json:"value"`
+}
+
+// EventParameters is parameters of the event property
+type EventParameters struct {
+	Instance string       `json:"instance"`
+	Events   []EventValue `json:"events"`
+}
+
+// PropertyType is implementation of PropertyParameters interface
+func (p *EventParameters) PropertyType() string {
+	return PropertyEvent
+}
+
+// GetInstance is implementation of PropertyParameters interface
+func (p *EventParameters) GetInstance() string {
+	return p.Instance
+}
+
+// Validate is implementation of PropertyParameters interface
+func (p *EventParameters) Validate() error {
+	values, known := eventValues[p.Instance]
+	if !known {
+		return newValidationError("unknown event instance %s", p.Instance)
+	}
+
+	if 0 == len(p.Events) {
+		return newValidationError("empty events list for %s", p.Instance)
+	}
+
+	for _, event := range p.Events {
+		if !values[event.Value] {
+			return newValidationError("unknown %s event %s", p.Instance, event.Value)
+		}
+	}
+
+	return nil
+}
+
+// EventState is state of the event property
+type EventState struct {
+	Instance string `json:"instance"`
+	Value    string `json:"value"`
+}
+
+// PropertyType is implementation of PropertyStateValue interface
+func (s *EventState) PropertyType() string {
+	return PropertyEvent
+}
+
+// GetInstance is implementation of PropertyStateValue interface
+func (s *EventState) GetInstance() string {
+	return s.Instance
+}
+
+// Validate is implementation of PropertyStateValue interface
+func (s *EventState) Validate(parameters PropertyParameters) error {
+	values, known := eventValues[s.Instance]
+	if !known {
+		return newValidationError("unknown event instance %s", s.Instance)
+	}
+
+	p, valid := parameters.(*EventParameters)
+	if !valid {
+		if !values[s.Value] {
+			return newValidationError("unknown %s event %s", s.Instance, s.Value)
+		}
+		return nil
+	}
+
+	for _, event := range p.Events {
+		if event.Value == s.Value {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s event %s isn't declared", api.ErrInvalidValue, s.Instance, s.Value)
+}
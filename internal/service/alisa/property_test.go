@@ -0,0 +1,119 @@
+package alisa
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+func TestPropertyParametersValidate(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		property Property
+		want     error
+	}{
+		{"temperature celsius", NewProperty(true, true, &FloatParameters{Instance: FloatTemperature,
+			Unit: UnitTemperatureCelsius}), nil},
+		{"temperature kelvin", NewProperty(true, false, &FloatParameters{Instance: FloatTemperature,
+			Unit: UnitTemperatureKelvin}), nil},
+		{"temperature without unit", NewProperty(true, true, &FloatParameters{Instance: FloatTemperature}),
+			api.ErrInvalidValue},
+		{"humidity wrong unit", NewProperty(true, true, &FloatParameters{Instance: FloatHumidity,
+			Unit: UnitPPM}), api.ErrInvalidValue},
+		{"pressure mmhg", NewProperty(true, true, &FloatParameters{Instance: FloatPressure,
+			Unit: UnitPressureMmHg}), nil},
+		{"pm2.5", NewProperty(true, true, &FloatParameters{Instance: FloatPM25, Unit: UnitDensityMcgM3}), nil},
+		{"meter without unit", NewProperty(true, true, &FloatParameters{Instance: FloatMeter}), nil},
+		{"meter with unit", NewProperty(true, true, &FloatParameters{Instance: FloatMeter,
+			Unit: UnitCubicMeter}), api.ErrInvalidValue},
+		{"unknown float instance", NewProperty(true, true, &FloatParameters{Instance: "radiation"}),
+			api.ErrInvalidValue},
+		{"neither retrievable nor reportable", NewProperty(false, false, &FloatParameters{
+			Instance: FloatVoltage, Unit: UnitVolt}), api.ErrInvalidValue},
+		{"without parameters", Property{Type: PropertyFloat, Retrievable: true}, api.ErrInvalidValue},
+		{"type mismatch", Property{Type: PropertyEvent, Retrievable: true,
+			Parameters: &FloatParameters{Instance: FloatPower, Unit: UnitWatt}}, api.ErrInvalidValue},
+
+		{"motion", NewProperty(false, true, &EventParameters{Instance: "motion",
+			Events: []EventValue{{Value: "detected"}, {Value: "not_detected"}}}), nil},
+		{"button", NewProperty(false, true, &EventParameters{Instance: "button",
+			Events: []EventValue{{Value: "click"}, {Value: "long_press"}}}), nil},
+		{"event empty", NewProperty(false, true, &EventParameters{Instance: "motion"}), api.ErrInvalidValue},
+		{"event unknown value", NewProperty(false, true, &EventParameters{Instance: "open",
+			Events: []EventValue{{Value: "detected"}}}), api.ErrInvalidValue},
+		{"event unknown instance", NewProperty(false, true, &EventParameters{Instance: "earthquake",
+			Events: []EventValue{{Value: "detected"}}}), api.ErrInvalidValue},
+	} {
+		checkError(t, test.name, test.property.Validate(), test.want)
+	}
+}
+
+func TestPropertyStateValidate(t *testing.T) {
+	celsius := &FloatParameters{Instance: FloatTemperature, Unit: UnitTemperatureCelsius}
+	kelvin := &FloatParameters{Instance: FloatTemperature, Unit: UnitTemperatureKelvin}
+	motion := &EventParameters{Instance: "motion", Events: []EventValue{{Value: "detected"}}}
+
+	for _, test := range []struct {
+		name       string
+		state      PropertyStateValue
+		parameters PropertyParameters
+		want       error
+	}{
+		{"negative celsius", &FloatState{Instance: FloatTemperature, Value: -30}, celsius, nil},
+		{"negative kelvin", &FloatState{Instance: FloatTemperature, Value: -1}, kelvin, api.ErrInvalidValue},
+		{"default unit of temperature", &FloatState{Instance: FloatTemperature, Value: -5}, nil, nil},
+		{"humidity", &FloatState{Instance: FloatHumidity, Value: 100}, nil, nil},
+		{"humidity over 100", &FloatState{Instance: FloatHumidity, Value: 100.5}, nil, api.ErrInvalidValue},
+		{"battery negative", &FloatState{Instance: FloatBattery, Value: -1}, nil, api.ErrInvalidValue},
+		{"power", &FloatState{Instance: FloatPower, Value: 2300}, nil, nil},
+		{"negative voltage", &FloatState{Instance: FloatVoltage, Value: -220}, nil, api.ErrInvalidValue},
+		{"meter", &FloatState{Instance: FloatMeter, Value: 12345.6}, nil, nil},
+		{"NaN", &FloatState{Instance: FloatCO2, Value: math.NaN()}, nil, api.ErrInvalidValue},
+		{"infinity", &FloatState{Instance: FloatPower, Value: math.Inf(1)}, nil, api.ErrInvalidValue},
+		{"unknown float instance", &FloatState{Instance: "radiation", Value: 1}, nil, api.ErrInvalidValue},
+
+		{"event", &EventState{Instance: "motion", Value: "detected"}, motion, nil},
+		{"event not declared", &EventState{Instance: "motion", Value: "not_detected"}, motion, api.ErrInvalidValue},
+		{"event without parameters", &EventState{Instance: "water_leak", Value: "leak"}, nil, nil},
+		{"event unknown value", &EventState{Instance: "water_leak", Value: "flood"}, nil, api.ErrInvalidValue},
+		{"event unknown instance", &EventState{Instance: "earthquake", Value: "detected"}, nil, api.ErrInvalidValue},
+	} {
+		checkError(t, test.name, test.state.Validate(test.parameters), test.want)
+	}
+}
+
+func TestPropertyJSON(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		want error
+	}{
+		{"float", `{"type":"devices.properties.float","retrievable":true,"reportable":true,
+			"parameters":{"instance":"temperature","unit":"unit.temperature.celsius"}}`, nil},
+		{"event", `{"type":"devices.properties.event","reportable":true,
+			"parameters":{"instance":"open","events":[{"value":"opened"},{"value":"closed"}]}}`, nil},
+		{"float invalid unit", `{"type":"devices.properties.float","retrievable":true,
+			"parameters":{"instance":"co2_level","unit":"unit.percent"}}`, api.ErrInvalidValue},
+		{"unknown type", `{"type":"devices.properties.color","parameters":{}}`, api.ErrInvalidValue},
+	} {
+		var property Property
+		e := json.Unmarshal([]byte(test.data), &property)
+		if nil == e {
+			e = property.Validate()
+		}
+		checkError(t, test.name, e, test.want)
+	}
+
+	var state PropertyState
+	if e := json.Unmarshal([]byte(`{"type":"devices.properties.float","state":{"instance":"humidity","value":45.5}}`),
+		&state); nil != e {
+		t.Fatal(e)
+	}
+
+	float, valid := state.State.(*FloatState)
+	if !valid || FloatHumidity != float.Instance || 45.5 != float.Value {
+		t.Fatalf("unexpected float state %#v", state.State)
+	}
+}
@@ -0,0 +1,194 @@
+package alisa
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/pkg/api"
+	"go.uber.org/zap"
+)
+
+// deviceRequest is device reference in the query and action requests
+type deviceRequest struct {
+	ID         string          `json:"id"`
+	CustomData json.RawMessage `json:"custom_data,omitempty"`
+}
+
+// queryRequest is request for "/v1.0/user/devices/query"
+type queryRequest struct {
+	Devices []deviceRequest `json:"devices"`
+}
+
+// deviceState is device state in the query response
+type deviceState struct {
+	ID           string            `json:"id"`
+	Capabilities []CapabilityState `json:"capabilities,omitempty"`
+	Properties   []PropertyState   `json:"properties,omitempty"`
+	ErrorCode    string            `json:"error_code,omitempty"`
+	ErrorMessage string            `json:"error_message,omitempty"`
+}
+
+type queryPayload struct {
+	Devices []deviceState `json:"devices"`
+}
+
+// queryResponse is response for "/v1.0/user/devices/query" request
+type queryResponse struct {
+	RequestID string       `json:"request_id,omitempty"`
+	Payload   queryPayload `json:"payload"`
+}
+
+func newQueryResponse(ginCtx *gin.Context, devices []deviceState) *queryResponse {
+	return &queryResponse{
+		RequestID: ginCtx.GetHeader(headerRequestID),
+		Payload: queryPayload{
+			Devices: devices,
+		},
+	}
+}
+
+// newDeviceState return current state of the Alisa device
+func newDeviceState(logger *zap.SugaredLogger,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	request deviceRequest) deviceState {
+	result := deviceState{
+		ID: request.ID,
+	}
+
+	deviceID, device, channel, e := resolveEndpoint(deviceManager, request.ID, request.CustomData)
+	if nil != e {
+		return result.withError(e)
+	}
+
+	state, e := stateCache.GetState(deviceID)
+	if nil != e {
+		return result.withError(e)
+	}
+
+	if nil == channel {
+		result.Properties = sensorStates(logger, device.GetSensors(), state)
+	} else {
+		result.Capabilities = channelStates(logger, *channel, state.Channels[channel.Index])
+	}
+
+	return result
+}
+
+// withError set device level error
+func (state deviceState) withError(e error) deviceState {
+	state.ErrorCode = errorCode(e)
+	state.ErrorMessage = e.Error()
+
+	return state
+}
+
+// channelStates return capability states of the device channel
+func channelStates(logger *zap.SugaredLogger, channel api.Channel, state api.ChannelState) []CapabilityState {
+	capabilities := channelCapabilities(channel, false)
+	states := make([]CapabilityState, 0, len(capabilities))
+
+	for _, c := range capabilities {
+		var value CapabilityStateValue
+
+		switch parameters := c.Parameters.(type) {
+		case *OnOffParameters:
+			value = NewOnOffState(state.On)
+		case *RangeParameters:
+			brightness := float64(state.Brightness)
+			if nil != parameters.Range {
+				// Device may report value which isn't allowed, e.g. zero brightness when light is off
+				brightness = math.Max(parameters.Range.Min, math.Min(parameters.Range.Max, brightness))
+			}
+
+			value = &RangeState{
+				Instance: parameters.Instance,
+				Value:    brightness,
+			}
+		case *ColorSettingParameters:
+			if nil != parameters.TemperatureK && (0 == len(parameters.ColorModel) || state.ColorTemperature > 0) {
+				temperature := state.ColorTemperature
+				if temperature < parameters.TemperatureK.Min {
+					temperature = parameters.TemperatureK.Min
+				} else if temperature > parameters.TemperatureK.Max {
+					temperature = parameters.TemperatureK.Max
+				}
+
+				value = &ColorSettingState{
+					Instance:     InstanceTemperatureK,
+					TemperatureK: temperature,
+				}
+			} else {
+				value = &ColorSettingState{
+					Instance: InstanceHSV,
+					HSV: ColorHSV{
+						H: state.Color.Hue,
+						S: state.Color.Saturation,
+						V: state.Color.Value,
+					},
+				}
+			}
+		default:
+			continue
+		}
+
+		if e := value.Validate(c.Parameters); nil != e {
+			logger.Errorw("Invalid capability state", "instance", value.GetInstance(), "error", e)
+			continue
+		}
+
+		states = append(states, NewCapabilityState(value))
+	}
+
+	return states
+}
+
+// sensorStates return property states for the device sensors
+func sensorStates(logger *zap.SugaredLogger, sensors []api.Sensor, state api.State) []PropertyState {
+	properties := make([]PropertyState, 0, len(sensors))
+
+	for _, sensor := range sensors {
+		parameters, known := sensorParameters[sensor.Kind]
+		if !known {
+			continue
+		}
+
+		value, found := state.Sensors[sensor.Kind]
+		if !found {
+			// Value not reported yet
+			continue
+		}
+
+		property := &FloatState{
+			Instance: parameters.Instance,
+			Value:    sensorValue(sensor, value),
+		}
+
+		if e := property.Validate(&parameters); nil != e {
+			logger.Errorw("Invalid property state", "instance", property.Instance, "error", e)
+			continue
+		}
+
+		properties = append(properties, NewPropertyState(property))
+	}
+
+	return properties
+}
+
+// sensorValue convert sensor value to the units used in the Alisa property
+func sensorValue(sensor api.Sensor, value float64) float64 {
+	switch sensor.Kind {
+	case api.SensorTemperature:
+		if "F" == sensor.Unit {
+			value = (value - 32) * 5 / 9
+		}
+	case api.SensorPressure:
+		if "hPa" == sensor.Unit {
+			value *= 0.750062
+		}
+	}
+
+	// Alisa don't need more precision
+	return math.Round(value*100) / 100
+}
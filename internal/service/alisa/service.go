@@ -3,79 +3,203 @@ package alisa
 import (
 	"context"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/pior/runnable"
 	"github.com/vedga/alisa/internal/pkg/log"
 	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// UserUnlinked is events topic where service put user ID when user unlinked accounts
+	UserUnlinked = "alisa:unlinked"
 )
 
 const (
 	alisaEndpointPrefix        = "/alisa"
-	alisaEndpointProbe         = alisaEndpointPrefix + "/v1.0"
+	alisaEndpointProbe         = "/v1.0"
 	alisaEndpointUserPrefix    = alisaEndpointProbe + "/user/"
 	alisaEndpointUnlink        = "unlink"
 	alisaEndpointDevices       = "devices"
-	alisaEndpointDevicesQuery  = "query"
-	alisaEndpointDevicesAction = "action"
+	alisaEndpointDevicesQuery  = alisaEndpointDevices + "/query"
+	alisaEndpointDevicesAction = alisaEndpointDevices + "/action"
 	headerRequestID            = "X-Request-Id"
 	contextUserID              = "X-User-ID"
+	contextClientID            = "X-Client-ID"
+	contextTokenInfo           = "X-Token-Info"
+	// actionTimeout is time to wait devices confirmation, Yandex wait response about 3 seconds
+	actionTimeout = time.Millisecond * 2500
+	// envClientIDs is comma separated OAuth clients allowed to use Alisa front-end, Yandex client by default
+	envClientIDs = "ALISA_CLIENT_IDS"
+	// envYandexClientID is OAuth client of the Yandex skill
+	envYandexClientID = "YANDEX_CLIENT_ID"
 )
 
+// Config is settings of the smart home protocol front-end
+type Config struct {
+	// Prefix is path of the front-end routes, e.g. "/alisa"
+	Prefix string
+	// DevicesConfig is path to the JSON file with device names, rooms and types, may be empty
+	DevicesConfig string
+	// UnlinkTopic is events topic where front-end put user ID when user unlinked accounts
+	UnlinkTopic string
+	// Notifications enable state and discovery notifications to the Yandex callback API
+	Notifications bool
+	// ClientIDs is OAuth clients allowed to use the front-end, tokens of other clients are rejected. Tokens of all
+	// clients are accepted when it's empty.
+	ClientIDs []string
+}
+
 // Service is Alisa service implementation
 type Service struct {
 	runnable.Runnable
+	bus          eventbus.Bus
+	oauthService *oauth.Service
+	access       api.AccessControl
+	stateCache   api.StateCache
+	unlinkTopic  string
+	clientIDs    []string
+	names        deviceNames
+	notifier     *notifier
 }
 
 // NewService return new service implementation
-func NewService(router gin.IRouter, oauthService *oauth.Service) (service *Service, e error) {
-	service = &Service{}
+func NewService(router gin.IRouter,
+	bus eventbus.Bus,
+	oauthService *oauth.Service,
+	access api.AccessControl,
+	stateCache api.StateCache) (service *Service, e error) {
+	return NewFrontend(router, bus, oauthService, access, stateCache, Config{
+		Prefix:        alisaEndpointPrefix,
+		DevicesConfig: os.Getenv(envDevicesConfig),
+		UnlinkTopic:   UserUnlinked,
+		Notifications: true,
+		ClientIDs:     oauth.ClientIDs(envClientIDs, envYandexClientID),
+	})
+}
 
-	// Following group required only authorized access
-	authorized := router.Group(alisaEndpointUserPrefix)
+// NewFrontend return service which serve protocol compatible with Yandex smart home with the config
+func NewFrontend(router gin.IRouter,
+	bus eventbus.Bus,
+	oauthService *oauth.Service,
+	access api.AccessControl,
+	stateCache api.StateCache,
+	config Config) (service *Service, e error) {
+	service = &Service{
+		bus:          bus,
+		oauthService: oauthService,
+		access:       access,
+		stateCache:   stateCache,
+		unlinkTopic:  config.UnlinkTopic,
+		clientIDs:    config.ClientIDs,
+	}
 
-	// Set bearer token checker
-	authorized.Use(func(ginCtx *gin.Context) {
-		tokenInfo, e := oauthService.ValidationBearerToken(ginCtx)
-		if nil != e {
-			switch e {
-			case errors.ErrInvalidAccessToken:
-				ginCtx.Status(http.StatusForbidden)
-			default:
-				ginCtx.Status(http.StatusUnauthorized)
-			}
-
-			ginCtx.Abort()
-			return
-		}
+	if 0 == len(config.ClientIDs) {
+		log.Log.Warnw("OAuth client of the front-end isn't configured, tokens of all clients are accepted",
+			"prefix", config.Prefix)
+	}
+	// Grants revoked by administrator are handled as unlinked accounts
+	oauthService.PublishUnlinks(bus, config.UnlinkTopic, config.ClientIDs)
 
-		// Add User ID to the context
-		ginCtx.Set(contextUserID, tokenInfo.GetUserID())
+	if config.Notifications {
+		service.notifier = newNotifier(access, stateCache)
+	}
 
-		log.Log.Debugf("Token %v", tokenInfo)
+	if service.names, e = loadDeviceNames(config.DevicesConfig); nil != e {
+		return nil, e
+	}
 
-		// Call next handler
-		ginCtx.Next()
-	})
+	// Following group required only authorized access
+	authorized := router.Group(config.Prefix+alisaEndpointUserPrefix, traceRequest, service.authorize)
 
-	router.HEAD(alisaEndpointProbe, service.onProbe)
-	authorized.POST(alisaEndpointUnlink, service.onUnlink)
-	authorized.GET(alisaEndpointDevices, service.onDevices)
-	authorized.POST(alisaEndpointDevicesQuery, service.onDevicesQuery)
-	authorized.POST(alisaEndpointDevicesAction, service.onDevicesAction)
+	router.HEAD(config.Prefix+alisaEndpointProbe, traceRequest, service.onProbe)
+	authorized.POST(alisaEndpointUnlink, service.requireScope(oauth.ScopeDevicesRead), service.onUnlink)
+	authorized.GET(alisaEndpointDevices, service.requireScope(oauth.ScopeDevicesRead), service.onDevices)
+	authorized.POST(alisaEndpointDevicesQuery, service.requireScope(oauth.ScopeDevicesRead), service.onDevicesQuery)
+	authorized.POST(alisaEndpointDevicesAction, service.requireScope(oauth.ScopeDevicesControl), service.onDevicesAction)
 
 	return service, nil
 }
 
 // Run is implementation of runnable.Runnable interface
 func (service *Service) Run(ctx context.Context) error {
+	if nil != service.notifier {
+		// Subscriptions aren't stored, so users linked before restart are restored from their grants
+		userIDs, e := service.oauthService.LinkedUsers(service.clientIDs)
+		if nil != e {
+			log.Log.Warnw("Linked users aren't subscribed to notifications", "error", e)
+		}
+
+		for _, userID := range userIDs {
+			service.notifier.Subscribe(userID)
+		}
+
+		return service.notifier.run(ctx, service.bus, service.unlinkTopic)
+	}
+
 	// Wait until operation complete
 	<-ctx.Done()
 
 	return ctx.Err()
 }
 
+// authorize is bearer token checker
+func (service *Service) authorize(ginCtx *gin.Context) {
+	tokenInfo, e := service.oauthService.ValidationClientToken(ginCtx, service.clientIDs)
+	if nil != e {
+		switch e {
+		case errors.ErrInvalidAccessToken:
+			abortWithError(ginCtx, http.StatusForbidden, errorAccountLinking, e)
+		case oauth.ErrTooManyRequests:
+			abortWithError(ginCtx, http.StatusTooManyRequests, errorInternal, e)
+		default:
+			abortWithError(ginCtx, http.StatusUnauthorized, errorAccountLinking, e)
+		}
+
+		return
+	}
+
+	// Add User ID to the context
+	ginCtx.Set(contextUserID, tokenInfo.GetUserID())
+	ginCtx.Set(contextClientID, tokenInfo.GetClientID())
+	ginCtx.Set(contextTokenInfo, tokenInfo)
+	traceUser(ginCtx, tokenInfo.GetUserID())
+
+	if nil != service.notifier {
+		// User linked accounts, so should receive notifications
+		service.notifier.Subscribe(tokenInfo.GetUserID())
+	}
+
+	requestLogger(ginCtx).Debugf("Token %v", tokenInfo)
+
+	// Call next handler
+	ginCtx.Next()
+}
+
+// requireScope return handler which reject requests when access token don't grant the scope
+func (service *Service) requireScope(scope string) gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		tokenInfo, _ := ginCtx.Value(contextTokenInfo).(oauth2.TokenInfo)
+		if nil == tokenInfo || !service.oauthService.HasScope(tokenInfo, scope) {
+			abortWithError(ginCtx, http.StatusForbidden, errorAccountLinking, oauth.ErrInsufficientScope)
+			return
+		}
+
+		ginCtx.Next()
+	}
+}
+
+// userDevices return devices allowed to the authorized user
+func (service *Service) userDevices(ginCtx *gin.Context) api.DeviceManager {
+	return service.access.UserDevices(ginCtx.GetString(contextUserID))
+}
+
 // onProbe called by Yandex to check this service ready status
 // Possible code responses:
 // http.StatusOK - service ready
@@ -83,13 +207,26 @@ func (service *Service) Run(ctx context.Context) error {
 // http.StatusNotFound - URL not found
 // StatusInternalServerError - internal service error
 func (service *Service) onProbe(ginCtx *gin.Context) {
-	log.Log.Debug("Service probed")
+	requestLogger(ginCtx).Debug("Service probed")
 	ginCtx.Status(http.StatusOK)
 }
 
 // onUnlink called by Yandex when accounts unlinked
 func (service *Service) onUnlink(ginCtx *gin.Context) {
-	log.Log.Debug("Accounts unlinked")
+	userID := ginCtx.GetString(contextUserID)
+
+	requestLogger(ginCtx).Debug("Accounts unlinked")
+
+	// User may link accounts with other assistants, which use other clients
+	clientID := ginCtx.GetString(contextClientID)
+
+	if e := service.oauthService.RevokeUser(ginCtx.Request.Context(), userID, clientID); nil != e {
+		abortWithError(ginCtx, http.StatusInternalServerError, errorInternal, e)
+		return
+	}
+
+	// Notifier and other services may hold per-user data which should be removed
+	service.bus.Publish(service.unlinkTopic, userID)
 
 	msg := newUnlinkResponse(ginCtx)
 
@@ -98,21 +235,61 @@ func (service *Service) onUnlink(ginCtx *gin.Context) {
 
 // onDevices called by Yandex to enumerate devices
 func (service *Service) onDevices(ginCtx *gin.Context) {
-	log.Log.Debug("Enumerate devices")
+	logger := requestLogger(ginCtx)
+	logger.Debug("Enumerate devices")
+
+	devices, e := newDevices(logger, service.userDevices(ginCtx), service.names, nil != service.notifier)
+	if nil != e {
+		abortWithError(ginCtx, http.StatusInternalServerError, errorInternal, e)
+		return
+	}
 
-	msg := newDevicesResponse(ginCtx)
+	msg := newDevicesResponse(ginCtx, devices)
 
 	ginCtx.JSON(http.StatusOK, msg)
 }
 
 // onDevicesQuery called by Yandex to query device states
 func (service *Service) onDevicesQuery(ginCtx *gin.Context) {
-	log.Log.Debug("Query devices")
-	ginCtx.Status(http.StatusOK)
+	logger := requestLogger(ginCtx)
+	logger.Debug("Query devices")
+
+	var request queryRequest
+	if e := ginCtx.ShouldBindJSON(&request); nil != e {
+		abortWithError(ginCtx, http.StatusBadRequest, errorInvalidValue, e)
+		return
+	}
+
+	deviceManager := service.userDevices(ginCtx)
+
+	devices := make([]deviceState, 0, len(request.Devices))
+	for _, device := range request.Devices {
+		devices = append(devices, newDeviceState(logger, deviceManager, service.stateCache, device))
+	}
+
+	msg := newQueryResponse(ginCtx, devices)
+
+	ginCtx.JSON(http.StatusOK, msg)
 }
 
 // onDevicesAction called by Yandex to perform action on the device
 func (service *Service) onDevicesAction(ginCtx *gin.Context) {
-	log.Log.Debug("Devices action")
-	ginCtx.Status(http.StatusOK)
+	logger := requestLogger(ginCtx)
+	logger.Debug("Devices action")
+
+	var request actionRequest
+	if e := ginCtx.ShouldBindJSON(&request); nil != e {
+		abortWithError(ginCtx, http.StatusBadRequest, errorInvalidValue, e)
+		return
+	}
+
+	// All devices share the same deadline
+	ctx, cancel := context.WithTimeout(ginCtx.Request.Context(), actionTimeout)
+	defer cancel()
+
+	devices := executeActions(ctx, logger, service.userDevices(ginCtx), service.stateCache, request.Payload.Devices)
+
+	msg := newActionResponse(ginCtx, devices)
+
+	ginCtx.JSON(http.StatusOK, msg)
 }
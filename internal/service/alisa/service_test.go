@@ -0,0 +1,190 @@
+package alisa
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+// testClientID is OAuth client of the Yandex skill
+const testClientID = "yandex"
+
+// testFrontend is Alisa front-end with the test relay, it accept JWT access tokens signed by the test key
+type testFrontend struct {
+	router *gin.Engine
+	relay  *apitest.Device
+	key    *ecdsa.PrivateKey
+	keyID  string
+}
+
+// newTestFrontend return Alisa front-end which validate tokens offline, user ivan see the relay
+func newTestFrontend(t *testing.T) *testFrontend {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+	gin.SetMode(gin.TestMode)
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != e {
+		t.Fatal(e)
+	}
+	data, e := x509.MarshalECPrivateKey(key)
+	if nil != e {
+		t.Fatal(e)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if e = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: data}), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	t.Setenv("OAUTH_STORAGE", ":memory:")
+	t.Setenv("OAUTH_JWT_KEYS", path)
+	t.Setenv(envYandexClientID, testClientID)
+	t.Setenv("YANDEX_CLIENT_SECRET", "secret")
+
+	bus := eventbus.New()
+	limiter, e := ratelimit.NewService(bus)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	router := gin.New()
+	oauthService, e := oauth.NewService(router, router, limiter)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	stateCache := apitest.NewStates()
+	result := &testFrontend{
+		router: router,
+		relay: &apitest.Device{
+			ID:         testRelayID,
+			Channels:   []api.Channel{{Index: 1, Type: api.ChannelRelay}},
+			StateCache: stateCache,
+		},
+		key: key,
+	}
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {})
+
+	if _, e = NewService(router, bus, oauthService, testAccess{"ivan": apitest.Devices{testRelayID: result.relay}},
+		stateCache); nil != e {
+		t.Fatal(e)
+	}
+
+	// Key ID is published by JWKS endpoint
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/oauth/jwks", nil))
+	if e = json.Unmarshal(recorder.Body.Bytes(), &jwks); nil != e || 1 != len(jwks.Keys) {
+		t.Fatalf("JWKS %s: %v", recorder.Body, e)
+	}
+	result.keyID = jwks.Keys[0].KeyID
+
+	return result
+}
+
+// token return JWT access token of the user ivan with the scope
+func (frontend *testFrontend) token(t *testing.T, scope string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"sub":       "ivan",
+		"client_id": testClientID,
+		"scope":     scope,
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = frontend.keyID
+
+	access, e := token.SignedString(frontend.key)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return access
+}
+
+// serve send request with the access token and return the response
+func (frontend *testFrontend) serve(method string, path string, access string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	request.Header.Set("Authorization", "Bearer "+access)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(headerRequestID, "request")
+
+	recorder := httptest.NewRecorder()
+	frontend.router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestScopes(t *testing.T) {
+	frontend := newTestFrontend(t)
+
+	const (
+		devices = alisaEndpointPrefix + alisaEndpointUserPrefix + alisaEndpointDevices
+		query   = alisaEndpointPrefix + alisaEndpointUserPrefix + alisaEndpointDevicesQuery
+		action  = alisaEndpointPrefix + alisaEndpointUserPrefix + alisaEndpointDevicesAction
+	)
+	id := endpointID(testRelayID, 1)
+	queryBody := `{"devices":[{"id":"` + id + `"}]}`
+	actionBody := `{"payload":{"devices":[{"id":"` + id + `","capabilities":[` + onOff(true) + `]}]}}`
+
+	read := frontend.token(t, oauth.ScopeDevicesRead)
+	control := frontend.token(t, oauth.ScopeDevicesRead+" "+oauth.ScopeDevicesControl)
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		access string
+		body   string
+		want   int
+	}{
+		{"read token list devices", http.MethodGet, devices, read, "", http.StatusOK},
+		{"read token query devices", http.MethodPost, query, read, queryBody, http.StatusOK},
+		{"read token can't control devices", http.MethodPost, action, read, actionBody, http.StatusForbidden},
+		{"control token control devices", http.MethodPost, action, control, actionBody, http.StatusOK},
+	} {
+		response := frontend.serve(test.method, test.path, test.access, test.body)
+		if test.want != response.Code {
+			t.Errorf("%s: status %d, want %d", test.name, response.Code, test.want)
+			continue
+		}
+
+		if http.StatusForbidden == response.Code {
+			var result errorResponse
+			if e := json.Unmarshal(response.Body.Bytes(), &result); nil != e || errorAccountLinking != result.ErrorCode {
+				t.Errorf("%s: got error %s", test.name, response.Body)
+			}
+		}
+	}
+
+	// Only action of the control token is executed
+	if commands := frontend.relay.Executed(); 1 != len(commands) {
+		t.Errorf("got commands %+v", commands)
+	}
+}
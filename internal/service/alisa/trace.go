@@ -0,0 +1,36 @@
+package alisa
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// contextLogger is context key of the request logger
+	contextLogger = "alisa-logger"
+)
+
+// traceRequest add logger which mark each line by the request ID
+func traceRequest(ginCtx *gin.Context) {
+	ginCtx.Set(contextLogger, log.Log.With("request_id", ginCtx.GetHeader(headerRequestID)))
+
+	// Call next handler
+	ginCtx.Next()
+}
+
+// traceUser add user ID to the request logger
+func traceUser(ginCtx *gin.Context, userID string) {
+	ginCtx.Set(contextLogger, requestLogger(ginCtx).With("user_id", userID))
+}
+
+// requestLogger return logger of the request
+func requestLogger(ginCtx *gin.Context) *zap.SugaredLogger {
+	if value, found := ginCtx.Get(contextLogger); found {
+		if logger, valid := value.(*zap.SugaredLogger); valid {
+			return logger
+		}
+	}
+
+	return log.Log
+}
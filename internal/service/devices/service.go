@@ -3,47 +3,131 @@ package devices
 import (
 	"context"
 	"errors"
+	"reflect"
 	"sync"
 
 	"github.com/pior/runnable"
 	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// DeviceChanged is events topic where service put device ID when new device added or device description changed
+	DeviceChanged = "devices:changed"
 )
 
 // Service is device manager service implementation
 type Service struct {
 	runnable.Runnable
+	events  *eventbus.Dispatcher
 	devices sync.Map
 }
 
 // NewService return new service implementation
-func NewService() (service *Service, e error) {
-	return &Service{}, nil
+func NewService(bus eventbus.Bus) (service *Service, e error) {
+	return &Service{
+		events: eventbus.NewDispatcher(bus),
+	}, nil
+}
+
+// deviceSnapshot is device description used to detect changes
+type deviceSnapshot struct {
+	Type            string
+	Name            string
+	FirmwareVersion string
+	Channels        []api.Channel
+	Sensors         []api.Sensor
+	Route           api.Route
+}
+
+// newDeviceSnapshot return device description snapshot
+func newDeviceSnapshot(device api.Device) deviceSnapshot {
+	return deviceSnapshot{
+		Type:            device.GetType(),
+		Name:            device.GetName(),
+		FirmwareVersion: device.GetFirmwareVersion(),
+		Channels:        device.GetChannels(),
+		Sensors:         device.GetSensors(),
+		Route:           device.GetRoute(),
+	}
 }
 
 // Run is implementation of runnable.Runnable interface
 func (service *Service) Run(ctx context.Context) error {
-	// Wait until operation complete
-	<-ctx.Done()
-
-	return ctx.Err()
+	// Publish events until operation complete
+	return service.events.Run(ctx)
 }
 
 // AddDevice is implementation of api.DeviceManager interface
 func (service *Service) AddDevice(deviceID string, device api.Device) error {
 	// Store device in the internal storage
 	if prev, found := service.devices.LoadOrStore(deviceID, device); found {
+		previous := newDeviceSnapshot(prev.(api.Device))
+
 		// Update existing device
 		if e := prev.(api.Device).Update(device); nil != e {
 			// Unable to update device
 			return e
 		}
+
+		if reflect.DeepEqual(previous, newDeviceSnapshot(prev.(api.Device))) {
+			// Nothing changed
+			return nil
+		}
 	}
 
+	// Devices usually added from the event bus handlers, while bus is locked, so event is published by the dispatcher
+	service.events.Publish(DeviceChanged, deviceID)
+
 	return nil
 }
 
+// GetDevice is implementation of api.DeviceManager interface
+func (service *Service) GetDevice(deviceID string) (api.Device, error) {
+	value, found := service.devices.Load(deviceID)
+	if !found {
+		return nil, api.ErrDeviceNotFound
+	}
+
+	device, valid := value.(api.Device)
+	if !valid {
+		return nil, errors.New("invalid device")
+	}
+
+	return device, nil
+}
+
+// FindDevice is implementation of api.DeviceManager interface
+func (service *Service) FindDevice(route api.Route) (deviceID string, device api.Device, e error) {
+	if 0 == len(route.Integration) || 0 == len(route.Topic) {
+		return "", nil, api.ErrDeviceNotFound
+	}
+
+	service.devices.Range(func(key, value any) bool {
+		candidate, valid := value.(api.Device)
+		if !valid || candidate.GetRoute() != route {
+			return true
+		}
+
+		if deviceID, valid = key.(string); valid {
+			device = candidate
+			return false
+		}
+
+		return true
+	})
+
+	if nil == device {
+		return "", nil, api.ErrDeviceNotFound
+	}
+
+	return deviceID, device, nil
+}
+
 // EnumDevices is implementation of api.DeviceManager interface
 func (service *Service) EnumDevices() (devices map[string]api.Device, e error) {
+	devices = make(map[string]api.Device)
+
 	service.devices.Range(func(key, value any) bool {
 		var (
 			keyValue    string
@@ -0,0 +1,197 @@
+package google
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// channelSeparator separate device ID and channel index in the Google device ID
+	channelSeparator = ":"
+	// Tasmota-compatible white light temperature range
+	lightTemperatureMin = 2000
+	lightTemperatureMax = 6500
+)
+
+// endpointID return Google device ID for the device channel. Channel 0 is device sensors.
+func endpointID(deviceID string, channel int) string {
+	if 0 == channel {
+		return deviceID
+	}
+
+	return deviceID + channelSeparator + strconv.Itoa(channel)
+}
+
+// findEndpoint return device and channel for the Google device ID. Channel is nil for the sensors endpoint.
+func findEndpoint(deviceManager api.DeviceManager, id string) (string, api.Device, *api.Channel, error) {
+	deviceID, channelIndex := id, 0
+	if index := strings.LastIndex(id, channelSeparator); index >= 0 {
+		if channel, e := strconv.Atoi(id[index+1:]); nil == e {
+			deviceID, channelIndex = id[:index], channel
+		}
+	}
+
+	device, e := deviceManager.GetDevice(deviceID)
+	if nil != e {
+		return deviceID, nil, nil, e
+	}
+
+	if 0 == channelIndex {
+		if !hasClimateSensors(device.GetSensors()) {
+			return deviceID, nil, nil, api.ErrDeviceNotFound
+		}
+
+		return deviceID, device, nil, nil
+	}
+
+	for _, channel := range device.GetChannels() {
+		if channel.Index == channelIndex {
+			return deviceID, device, &channel, nil
+		}
+	}
+
+	return deviceID, nil, nil, api.ErrDeviceNotFound
+}
+
+// newDevices return all Google devices provided by the device manager
+func newDevices(deviceManager api.DeviceManager) ([]Device, error) {
+	devices, e := deviceManager.EnumDevices()
+	if nil != e {
+		return nil, e
+	}
+
+	// Keep devices order stable between requests
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	result := make([]Device, 0, len(devices))
+	for _, deviceID := range deviceIDs {
+		result = append(result, newDeviceEndpoints(deviceID, devices[deviceID])...)
+	}
+
+	return result, nil
+}
+
+// newDeviceEndpoints return Google devices for each device channel and for the device sensors
+func newDeviceEndpoints(deviceID string, device api.Device) (result []Device) {
+	name := strings.TrimSpace(device.GetName())
+	if 0 == len(name) {
+		name = deviceID
+	}
+
+	info := DeviceInfo{
+		Manufacturer:    manufacturer(device),
+		Model:           device.GetType(),
+		HardwareVersion: device.GetType(),
+		SoftwareVersion: device.GetFirmwareVersion(),
+	}
+
+	channels := device.GetChannels()
+	for _, channel := range channels {
+		endpoint := Device{
+			ID:         endpointID(deviceID, channel.Index),
+			Name:       DeviceName{Name: strings.TrimSpace(channel.Name)},
+			DeviceInfo: info,
+		}
+
+		if 0 == len(endpoint.Name.Name) {
+			endpoint.Name.Name = name
+			if len(channels) > 1 {
+				// Names must be different for each channel
+				endpoint.Name.Name += " " + strconv.Itoa(channel.Index)
+			}
+		}
+		endpoint.Name.DefaultNames = []string{endpoint.Name.Name}
+
+		endpoint.Type, endpoint.Traits, endpoint.Attributes = channelTraits(channel)
+
+		result = append(result, endpoint)
+	}
+
+	if hasClimateSensors(device.GetSensors()) {
+		result = append(result, Device{
+			ID:     endpointID(deviceID, 0),
+			Type:   deviceTypeSensor,
+			Traits: []string{traitTemperatureSetting},
+			Name: DeviceName{
+				DefaultNames: []string{name},
+				Name:         name,
+			},
+			Attributes: map[string]interface{}{
+				"availableThermostatModes":    []string{},
+				"thermostatTemperatureUnit":   "C",
+				"queryOnlyTemperatureSetting": true,
+			},
+			DeviceInfo: info,
+		})
+	}
+
+	return result
+}
+
+// manufacturer return device manufacturer name
+func manufacturer(device api.Device) string {
+	if route := device.GetRoute(); len(route.Integration) > 0 {
+		return route.Integration
+	}
+
+	return "alisa"
+}
+
+// channelTraits return Google device type, traits and traits attributes for the device channel
+func channelTraits(channel api.Channel) (string, []string, map[string]interface{}) {
+	if api.ChannelLight != channel.Type || api.LightNone == channel.Light {
+		return deviceTypeSwitch, []string{traitOnOff}, nil
+	}
+
+	traits := []string{traitOnOff, traitBrightness}
+
+	color, temperature := lightAbilities(channel.Light)
+	if !color && !temperature {
+		return deviceTypeLight, traits, nil
+	}
+
+	attributes := make(map[string]interface{})
+	if color {
+		attributes["colorModel"] = "hsv"
+	}
+	if temperature {
+		attributes["colorTemperatureRange"] = map[string]int{
+			"temperatureMinK": lightTemperatureMin,
+			"temperatureMaxK": lightTemperatureMax,
+		}
+	}
+
+	return deviceTypeLight, append(traits, traitColorSetting), attributes
+}
+
+// lightAbilities return is light support color and white temperature
+func lightAbilities(light api.LightType) (color bool, temperature bool) {
+	switch light {
+	case api.LightRGB, api.LightRGBW:
+		return true, false
+	case api.LightRGBCW:
+		return true, true
+	case api.LightCW:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// hasClimateSensors return true when the device has sensors reported by the TemperatureSetting trait
+func hasClimateSensors(sensors []api.Sensor) bool {
+	for _, sensor := range sensors {
+		if api.SensorTemperature == sensor.Kind {
+			return true
+		}
+	}
+
+	return false
+}
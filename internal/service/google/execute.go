@@ -0,0 +1,244 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// onOffParams is parameters of the OnOff command
+type onOffParams struct {
+	On *bool `json:"on"`
+}
+
+// brightnessParams is parameters of the BrightnessAbsolute command
+type brightnessParams struct {
+	Brightness *int `json:"brightness"`
+}
+
+// spectrumHSV is color in the HSV model, saturation and value are 0..1
+type spectrumHSV struct {
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+	Value      float64 `json:"value"`
+}
+
+// colorParams is parameters of the ColorAbsolute command
+type colorParams struct {
+	Color struct {
+		Temperature  int          `json:"temperature,omitempty"`
+		TemperatureK int          `json:"temperatureK,omitempty"`
+		SpectrumRGB  *int         `json:"spectrumRGB,omitempty"`
+		SpectrumHSV  *spectrumHSV `json:"spectrumHSV,omitempty"`
+	} `json:"color"`
+}
+
+// executeCommands perform all commands of the EXECUTE intent, result is returned for each device
+func executeCommands(ctx context.Context,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	request executeRequest) executePayload {
+	type job struct {
+		id         string
+		executions []Execution
+	}
+
+	var jobs []job
+	for _, command := range request.Commands {
+		for _, device := range command.Devices {
+			jobs = append(jobs, job{id: device.ID, executions: command.Execution})
+		}
+	}
+
+	results := make([]commandResult, len(jobs))
+
+	// Devices are independent, so commands are sent in parallel
+	var wg sync.WaitGroup
+	for index, j := range jobs {
+		wg.Add(1)
+		go func(index int, id string, executions []Execution) {
+			defer wg.Done()
+
+			results[index] = executeDevice(ctx, deviceManager, stateCache, id, executions)
+		}(index, j.id, j.executions)
+	}
+	wg.Wait()
+
+	return executePayload{
+		Commands: results,
+	}
+}
+
+// executeDevice perform commands on the single Google device
+func executeDevice(ctx context.Context,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	id string,
+	executions []Execution) commandResult {
+	result := commandResult{
+		IDs: []string{id},
+	}
+
+	deviceID, device, channel, e := findEndpoint(deviceManager, id)
+	if nil == e && nil == channel {
+		// Sensors can't be controlled
+		e = api.ErrInvalidAction
+	}
+
+	var commands []api.Command
+	if nil == e {
+		commands, e = newCommands(*channel, executions)
+	}
+
+	for index := 0; nil == e && index < len(commands); index++ {
+		e = device.Execute(ctx, commands[index])
+	}
+
+	if nil != e {
+		log.Log.Warnw("Google command failed", "device_id", id, "error", e)
+
+		result.ErrorCode = errorCode(e)
+		result.Status = statusError
+		if errorDeviceOffline == result.ErrorCode {
+			result.Status = statusOffline
+		}
+
+		return result
+	}
+
+	result.Status = statusSuccess
+
+	if state, e := stateCache.GetState(deviceID); nil == e {
+		result.States = map[string]interface{}{
+			"online": true,
+		}
+		channelStates(result.States, *channel, state.Channels[channel.Index])
+	}
+
+	return result
+}
+
+// newCommands return device commands for the Google executions
+func newCommands(channel api.Channel, executions []Execution) ([]api.Command, error) {
+	commands := make([]api.Command, 0, len(executions))
+	light := api.ChannelLight == channel.Type && api.LightNone != channel.Light
+	color, temperature := lightAbilities(channel.Light)
+
+	for _, execution := range executions {
+		command := api.Command{
+			Channel: channel.Index,
+		}
+
+		switch {
+		case commandOnOff == execution.Command:
+			var params onOffParams
+			if e := json.Unmarshal(execution.Params, &params); nil != e || nil == params.On {
+				return nil, fmt.Errorf("%w: on", api.ErrInvalidValue)
+			}
+			command.Type = api.CommandOnOff
+			command.On = *params.On
+		case commandBrightness == execution.Command && light:
+			var params brightnessParams
+			if e := json.Unmarshal(execution.Params, &params); nil != e || nil == params.Brightness ||
+				*params.Brightness < 0 || *params.Brightness > 100 {
+				return nil, fmt.Errorf("%w: brightness", api.ErrInvalidValue)
+			}
+			command.Type = api.CommandBrightness
+			command.Brightness = *params.Brightness
+		case commandColorAbsolute == execution.Command && (color || temperature):
+			var params colorParams
+			if e := json.Unmarshal(execution.Params, &params); nil != e {
+				return nil, fmt.Errorf("%w: color", api.ErrInvalidValue)
+			}
+			if e := colorCommand(&command, params, color, temperature); nil != e {
+				return nil, e
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s isn't supported", api.ErrInvalidAction, execution.Command)
+		}
+
+		commands = append(commands, command)
+	}
+
+	return commands, nil
+}
+
+// colorCommand fill the command by the ColorAbsolute parameters
+func colorCommand(command *api.Command, params colorParams, color bool, temperature bool) error {
+	kelvin := params.Color.TemperatureK
+	if 0 == kelvin {
+		kelvin = params.Color.Temperature
+	}
+
+	switch {
+	case kelvin > 0 && temperature:
+		if kelvin < lightTemperatureMin || kelvin > lightTemperatureMax {
+			return fmt.Errorf("%w: temperature %d", api.ErrInvalidValue, kelvin)
+		}
+		command.Type = api.CommandColorTemperature
+		command.ColorTemperature = kelvin
+	case nil != params.Color.SpectrumHSV && color:
+		hsv := params.Color.SpectrumHSV
+		if hsv.Hue < 0 || hsv.Hue > 360 || hsv.Saturation < 0 || hsv.Saturation > 1 || hsv.Value < 0 || hsv.Value > 1 {
+			return fmt.Errorf("%w: spectrumHSV", api.ErrInvalidValue)
+		}
+		command.Type = api.CommandColor
+		command.Color = api.ColorHSV{
+			Hue:        int(math.Round(hsv.Hue)) % 360,
+			Saturation: int(math.Round(hsv.Saturation * 100)),
+			Value:      int(math.Round(hsv.Value * 100)),
+		}
+	case nil != params.Color.SpectrumRGB && color:
+		rgb := *params.Color.SpectrumRGB
+		if rgb < 0 || rgb > 0xFFFFFF {
+			return fmt.Errorf("%w: spectrumRGB %d", api.ErrInvalidValue, rgb)
+		}
+		command.Type = api.CommandColor
+		command.Color = rgbToHSV(rgb)
+	default:
+		return fmt.Errorf("%w: color isn't supported", api.ErrInvalidAction)
+	}
+
+	return nil
+}
+
+// rgbToHSV convert 0xRRGGBB color to the HSV model
+func rgbToHSV(rgb int) api.ColorHSV {
+	r := float64((rgb>>16)&0xFF) / 255
+	g := float64((rgb>>8)&0xFF) / 255
+	b := float64(rgb&0xFF) / 255
+
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	hue := 0.0
+	switch {
+	case 0 == delta:
+	case max == r:
+		hue = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		hue = 60 * ((b-r)/delta + 2)
+	default:
+		hue = 60 * ((r-g)/delta + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+
+	saturation := 0.0
+	if max > 0 {
+		saturation = delta / max
+	}
+
+	return api.ColorHSV{
+		Hue:        int(math.Round(hue)) % 360,
+		Saturation: int(math.Round(saturation * 100)),
+		Value:      int(math.Round(max * 100)),
+	}
+}
@@ -0,0 +1,177 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// Intents
+const (
+	intentSync       = "action.devices.SYNC"
+	intentQuery      = "action.devices.QUERY"
+	intentExecute    = "action.devices.EXECUTE"
+	intentDisconnect = "action.devices.DISCONNECT"
+)
+
+// Device types
+const (
+	deviceTypeLight  = "action.devices.types.LIGHT"
+	deviceTypeSwitch = "action.devices.types.SWITCH"
+	deviceTypeSensor = "action.devices.types.SENSOR"
+)
+
+// Traits
+const (
+	traitOnOff              = "action.devices.traits.OnOff"
+	traitBrightness         = "action.devices.traits.Brightness"
+	traitColorSetting       = "action.devices.traits.ColorSetting"
+	traitTemperatureSetting = "action.devices.traits.TemperatureSetting"
+)
+
+// Commands
+const (
+	commandOnOff         = "action.devices.commands.OnOff"
+	commandBrightness    = "action.devices.commands.BrightnessAbsolute"
+	commandColorAbsolute = "action.devices.commands.ColorAbsolute"
+)
+
+// Statuses of the device in the QUERY and EXECUTE responses
+const (
+	statusSuccess = "SUCCESS"
+	statusOffline = "OFFLINE"
+	statusError   = "ERROR"
+)
+
+// Google error codes
+const (
+	errorAuthFailure          = "authFailure"
+	errorDeviceNotFound       = "deviceNotFound"
+	errorDeviceOffline        = "deviceOffline"
+	errorFunctionNotSupported = "functionNotSupported"
+	errorHardError            = "hardError"
+	errorNotSupported         = "notSupported"
+	errorProtocolError        = "protocolError"
+	errorTransient            = "transientError"
+	errorValueOutOfRange      = "valueOutOfRange"
+)
+
+// errorCode return Google error code for the error
+func errorCode(e error) string {
+	switch {
+	case errors.Is(e, api.ErrDeviceNotFound):
+		return errorDeviceNotFound
+	case errors.Is(e, api.ErrDeviceUnreachable), errors.Is(e, context.DeadlineExceeded):
+		return errorDeviceOffline
+	case errors.Is(e, api.ErrInvalidAction):
+		return errorFunctionNotSupported
+	case errors.Is(e, api.ErrInvalidValue):
+		return errorValueOutOfRange
+	default:
+		return errorHardError
+	}
+}
+
+// Request is fulfillment request
+type Request struct {
+	RequestID string  `json:"requestId"`
+	Inputs    []Input `json:"inputs"`
+}
+
+// Input is intent with the intent specific payload
+type Input struct {
+	Intent  string          `json:"intent"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Response is fulfillment response
+type Response struct {
+	RequestID string      `json:"requestId"`
+	Payload   interface{} `json:"payload"`
+}
+
+// errorPayload is payload of the request which can't be processed at all
+type errorPayload struct {
+	ErrorCode   string `json:"errorCode"`
+	DebugString string `json:"debugString,omitempty"`
+}
+
+// DeviceName is names of the device
+type DeviceName struct {
+	DefaultNames []string `json:"defaultNames,omitempty"`
+	Name         string   `json:"name"`
+	Nicknames    []string `json:"nicknames,omitempty"`
+}
+
+// DeviceInfo is device hardware information
+type DeviceInfo struct {
+	Manufacturer    string `json:"manufacturer,omitempty"`
+	Model           string `json:"model,omitempty"`
+	HardwareVersion string `json:"hwVersion,omitempty"`
+	SoftwareVersion string `json:"swVersion,omitempty"`
+}
+
+// Device is device description returned by SYNC intent
+type Device struct {
+	ID              string                 `json:"id"`
+	Type            string                 `json:"type"`
+	Traits          []string               `json:"traits"`
+	Name            DeviceName             `json:"name"`
+	WillReportState bool                   `json:"willReportState"`
+	Attributes      map[string]interface{} `json:"attributes,omitempty"`
+	DeviceInfo      DeviceInfo             `json:"deviceInfo,omitempty"`
+}
+
+// syncPayload is payload of the SYNC response
+type syncPayload struct {
+	AgentUserID string   `json:"agentUserId"`
+	Devices     []Device `json:"devices"`
+}
+
+// deviceRequest is device requested by QUERY or EXECUTE intent
+type deviceRequest struct {
+	ID         string          `json:"id"`
+	CustomData json.RawMessage `json:"customData,omitempty"`
+}
+
+// queryRequest is payload of the QUERY intent
+type queryRequest struct {
+	Devices []deviceRequest `json:"devices"`
+}
+
+// queryPayload is payload of the QUERY response, device states by device ID
+type queryPayload struct {
+	Devices map[string]map[string]interface{} `json:"devices"`
+}
+
+// Execution is single command of the EXECUTE intent
+type Execution struct {
+	Command string          `json:"command"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// executeCommand is commands for the group of devices
+type executeCommand struct {
+	Devices   []deviceRequest `json:"devices"`
+	Execution []Execution     `json:"execution"`
+}
+
+// executeRequest is payload of the EXECUTE intent
+type executeRequest struct {
+	Commands []executeCommand `json:"commands"`
+}
+
+// commandResult is result of the commands for the devices
+type commandResult struct {
+	IDs       []string               `json:"ids"`
+	Status    string                 `json:"status"`
+	States    map[string]interface{} `json:"states,omitempty"`
+	ErrorCode string                 `json:"errorCode,omitempty"`
+}
+
+// executePayload is payload of the EXECUTE response
+type executePayload struct {
+	Commands []commandResult `json:"commands"`
+}
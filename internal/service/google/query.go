@@ -0,0 +1,98 @@
+package google
+
+import (
+	"math"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// newDeviceState return QUERY state of the Google device
+func newDeviceState(deviceManager api.DeviceManager, stateCache api.StateCache, id string) map[string]interface{} {
+	deviceID, device, channel, e := findEndpoint(deviceManager, id)
+	if nil != e {
+		return withError(e)
+	}
+
+	state, e := stateCache.GetState(deviceID)
+	if nil != e {
+		return withError(e)
+	}
+
+	result := map[string]interface{}{
+		"online": true,
+		"status": statusSuccess,
+	}
+
+	if nil == channel {
+		sensorStates(result, device.GetSensors(), state)
+	} else {
+		channelStates(result, *channel, state.Channels[channel.Index])
+	}
+
+	return result
+}
+
+// withError return state of the device which can't be queried
+func withError(e error) map[string]interface{} {
+	code := errorCode(e)
+	if errorDeviceOffline == code {
+		return map[string]interface{}{
+			"online":    false,
+			"status":    statusOffline,
+			"errorCode": code,
+		}
+	}
+
+	return map[string]interface{}{
+		"status":    statusError,
+		"errorCode": code,
+	}
+}
+
+// channelStates add states of the device channel
+func channelStates(result map[string]interface{}, channel api.Channel, state api.ChannelState) {
+	result["on"] = state.On
+
+	if api.ChannelLight != channel.Type || api.LightNone == channel.Light {
+		return
+	}
+
+	result["brightness"] = state.Brightness
+
+	color, temperature := lightAbilities(channel.Light)
+	switch {
+	case temperature && (!color || state.ColorTemperature > 0):
+		result["color"] = map[string]interface{}{
+			"temperatureK": state.ColorTemperature,
+		}
+	case color:
+		result["color"] = map[string]interface{}{
+			"spectrumHsv": map[string]interface{}{
+				"hue":        float64(state.Color.Hue),
+				"saturation": float64(state.Color.Saturation) / 100,
+				"value":      float64(state.Color.Value) / 100,
+			},
+		}
+	}
+}
+
+// sensorStates add ambient values of the device sensors
+func sensorStates(result map[string]interface{}, sensors []api.Sensor, state api.State) {
+	for _, sensor := range sensors {
+		value, found := state.Sensors[sensor.Kind]
+		if !found {
+			// Value not reported yet
+			continue
+		}
+
+		switch sensor.Kind {
+		case api.SensorTemperature:
+			if "F" == sensor.Unit {
+				value = (value - 32) * 5 / 9
+			}
+			result["thermostatTemperatureAmbient"] = math.Round(value*10) / 10
+		case api.SensorHumidity:
+			result["thermostatHumidityAmbient"] = math.Round(value)
+		}
+	}
+}
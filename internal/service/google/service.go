@@ -0,0 +1,237 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// UserUnlinked is events topic where service put user ID when user unlinked accounts
+	UserUnlinked = "google:unlinked"
+)
+
+const (
+	// envGoogleEnabled enable Google Smart Home fulfillment, e.g. "true"
+	envGoogleEnabled = "GOOGLE_ENABLED"
+	// envClientIDs is comma separated OAuth clients allowed to use Google fulfillment, Google client by default
+	envClientIDs = "GOOGLE_CLIENT_IDS"
+	// envGoogleClientID is OAuth client of the Google Home account linking
+	envGoogleClientID         = "GOOGLE_CLIENT_ID"
+	googleEndpointFulfillment = "/google/fulfillment"
+	contextUserID             = "X-User-ID"
+	contextClientID           = "X-Client-ID"
+	contextTokenInfo          = "X-Token-Info"
+	executeTimeout            = time.Second * 5
+)
+
+// Service is Google Smart Home fulfillment implementation
+type Service struct {
+	runnable.Runnable
+	bus          eventbus.Bus
+	oauthService *oauth.Service
+	access       api.AccessControl
+	stateCache   api.StateCache
+	clientIDs    []string
+}
+
+// NewService return new service implementation. Fulfillment route is registered only when it's enabled.
+func NewService(router gin.IRouter,
+	bus eventbus.Bus,
+	oauthService *oauth.Service,
+	access api.AccessControl,
+	stateCache api.StateCache) (service *Service, e error) {
+	service = &Service{
+		bus:          bus,
+		oauthService: oauthService,
+		access:       access,
+		stateCache:   stateCache,
+		clientIDs:    oauth.ClientIDs(envClientIDs, envGoogleClientID),
+	}
+
+	enabled := false
+	if value, found := os.LookupEnv(envGoogleEnabled); found {
+		if enabled, e = strconv.ParseBool(value); nil != e {
+			return nil, e
+		}
+	}
+
+	if enabled {
+		if 0 == len(service.clientIDs) {
+			log.Log.Warn("Google OAuth client isn't configured, tokens of all clients are accepted")
+		}
+		oauthService.PublishUnlinks(bus, UserUnlinked, service.clientIDs)
+
+		router.POST(googleEndpointFulfillment, service.authorize, service.onFulfillment)
+	}
+
+	return service, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	// Wait until operation complete
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// abortWithError log the error and abort request with the error payload
+func abortWithError(ginCtx *gin.Context, status int, requestID string, code string, e error) {
+	log.Log.Warnw("Google request failed",
+		"user_id", ginCtx.GetString(contextUserID),
+		"error_code", code,
+		"error", e)
+
+	ginCtx.AbortWithStatusJSON(status, &Response{
+		RequestID: requestID,
+		Payload: &errorPayload{
+			ErrorCode:   code,
+			DebugString: e.Error(),
+		},
+	})
+}
+
+// authorize is bearer token checker
+func (service *Service) authorize(ginCtx *gin.Context) {
+	tokenInfo, e := service.oauthService.ValidationClientToken(ginCtx, service.clientIDs)
+	if oauth.ErrTooManyRequests == e {
+		abortWithError(ginCtx, http.StatusTooManyRequests, "", errorTransient, e)
+		return
+	}
+	if nil != e {
+		abortWithError(ginCtx, http.StatusUnauthorized, "", errorAuthFailure, e)
+		return
+	}
+
+	ginCtx.Set(contextUserID, tokenInfo.GetUserID())
+	ginCtx.Set(contextClientID, tokenInfo.GetClientID())
+	ginCtx.Set(contextTokenInfo, tokenInfo)
+
+	// Call next handler
+	ginCtx.Next()
+}
+
+// onFulfillment called by Google with the smart home intent
+func (service *Service) onFulfillment(ginCtx *gin.Context) {
+	var request Request
+	if e := ginCtx.ShouldBindJSON(&request); nil != e {
+		abortWithError(ginCtx, http.StatusBadRequest, "", errorProtocolError, e)
+		return
+	}
+
+	// Google always send single input
+	if 1 != len(request.Inputs) {
+		abortWithError(ginCtx, http.StatusBadRequest, request.RequestID, errorProtocolError,
+			fmt.Errorf("%d inputs, expected 1", len(request.Inputs)))
+		return
+	}
+
+	userID := ginCtx.GetString(contextUserID)
+	input := request.Inputs[0]
+
+	// Only EXECUTE change device states, other intents require read access
+	scope := oauth.ScopeDevicesRead
+	if intentExecute == input.Intent {
+		scope = oauth.ScopeDevicesControl
+	}
+	tokenInfo, _ := ginCtx.Value(contextTokenInfo).(oauth2.TokenInfo)
+	if nil == tokenInfo || !service.oauthService.HasScope(tokenInfo, scope) {
+		abortWithError(ginCtx, http.StatusForbidden, request.RequestID, errorAuthFailure, oauth.ErrInsufficientScope)
+		return
+	}
+
+	if intentDisconnect == input.Intent {
+		service.disconnect(ginCtx, request.RequestID)
+		return
+	}
+
+	payload, e := fulfill(ginCtx.Request.Context(), service.access.UserDevices(userID), service.stateCache, userID, input)
+	if nil != e {
+		abortWithError(ginCtx, http.StatusBadRequest, request.RequestID, errorProtocolError, e)
+		return
+	}
+
+	ginCtx.JSON(http.StatusOK, &Response{
+		RequestID: request.RequestID,
+		Payload:   payload,
+	})
+}
+
+// fulfill return response payload for the SYNC, QUERY or EXECUTE intent. It don't depend on HTTP,
+// so recorded intents can be replayed against any device manager.
+func fulfill(ctx context.Context,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache,
+	userID string,
+	input Input) (interface{}, error) {
+	switch input.Intent {
+	case intentSync:
+		devices, e := newDevices(deviceManager)
+		if nil != e {
+			return &errorPayload{ErrorCode: errorHardError, DebugString: e.Error()}, nil
+		}
+
+		return &syncPayload{
+			AgentUserID: userID,
+			Devices:     devices,
+		}, nil
+	case intentQuery:
+		var request queryRequest
+		if e := json.Unmarshal(input.Payload, &request); nil != e {
+			return nil, e
+		}
+
+		payload := queryPayload{
+			Devices: make(map[string]map[string]interface{}, len(request.Devices)),
+		}
+		for _, device := range request.Devices {
+			payload.Devices[device.ID] = newDeviceState(deviceManager, stateCache, device.ID)
+		}
+
+		return &payload, nil
+	case intentExecute:
+		var request executeRequest
+		if e := json.Unmarshal(input.Payload, &request); nil != e {
+			return nil, e
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, executeTimeout)
+		defer cancel()
+
+		payload := executeCommands(ctx, deviceManager, stateCache, request)
+
+		return &payload, nil
+	default:
+		return &errorPayload{ErrorCode: errorNotSupported, DebugString: input.Intent}, nil
+	}
+}
+
+// disconnect revoke tokens of the user when accounts unlinked
+func (service *Service) disconnect(ginCtx *gin.Context, requestID string) {
+	userID := ginCtx.GetString(contextUserID)
+
+	if e := service.oauthService.RevokeUser(ginCtx.Request.Context(), userID,
+		ginCtx.GetString(contextClientID)); nil != e {
+		abortWithError(ginCtx, http.StatusInternalServerError, requestID, errorHardError, e)
+		return
+	}
+
+	// Other services may hold per-user data which should be removed
+	service.bus.Publish(UserUnlinked, userID)
+
+	// DISCONNECT response is empty
+	ginCtx.JSON(http.StatusOK, gin.H{})
+}
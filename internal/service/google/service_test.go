@@ -0,0 +1,223 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/pkg/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// testIntents is directory of the intents recorded for the simulator
+	testIntents = "../../../cmd/alisa-simulator/testdata/google"
+	// testDevicePlaceholder is replaced in the recorded intents by the controllable device ID
+	testDevicePlaceholder = "{{device}}"
+	// testLightID is color light with white temperature
+	testLightID = "tasmota_AABBCCDDEEFF"
+	// testRelayID is relay with climate sensors
+	testRelayID = "tasmota_112233445566"
+)
+
+// newTestDevices return light and relay with known states
+func newTestDevices(t *testing.T) (apitest.Devices, *apitest.States, *apitest.Device, *apitest.Device) {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+
+	stateCache := apitest.NewStates()
+	light := &apitest.Device{
+		ID:         testLightID,
+		Name:       "Лампа",
+		Channels:   []api.Channel{{Index: 1, Type: api.ChannelLight, Light: api.LightRGBCW}},
+		StateCache: stateCache,
+	}
+	relay := &apitest.Device{
+		ID:         testRelayID,
+		Name:       "Реле",
+		Channels:   []api.Channel{{Index: 1, Type: api.ChannelRelay}},
+		Sensors:    []api.Sensor{{Kind: api.SensorTemperature, Unit: "F"}, {Kind: api.SensorHumidity}},
+		StateCache: stateCache,
+	}
+
+	_ = stateCache.UpdateState(testLightID, func(state *api.State) {
+		state.Channels[1] = api.ChannelState{Brightness: 40, ColorTemperature: 4000}
+	})
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {
+		state.Sensors[api.SensorTemperature] = 71.5
+		state.Sensors[api.SensorHumidity] = 40.4
+	})
+
+	return apitest.Devices{testLightID: light, testRelayID: relay}, stateCache, light, relay
+}
+
+// checkPayload compare JSON of the payload with the expected one
+func checkPayload(t *testing.T, name string, payload interface{}, want string) {
+	t.Helper()
+
+	data, e := json.Marshal(payload)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var got, expected interface{}
+	if e = json.Unmarshal(data, &got); nil != e {
+		t.Fatal(e)
+	}
+	if e = json.Unmarshal([]byte(want), &expected); nil != e {
+		t.Fatalf("%s: invalid expected payload: %v", name, e)
+	}
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("%s:\n got %s\nwant %s", name, data, want)
+	}
+}
+
+func TestFulfillRecordedIntents(t *testing.T) {
+	deviceManager, stateCache, light, _ := newTestDevices(t)
+
+	const deviceInfo = `"deviceInfo":{"manufacturer":"tasmota","model":"tasmota","hwVersion":"tasmota","swVersion":"12.1.1"}`
+
+	tests := map[string]struct {
+		want     string
+		commands []api.Command
+	}{
+		"01-sync.json": {want: `{"agentUserId":"ivan","devices":[
+			{"id":"tasmota_112233445566:1","type":"action.devices.types.SWITCH",
+				"traits":["action.devices.traits.OnOff"],
+				"name":{"defaultNames":["Реле"],"name":"Реле"},"willReportState":false,` + deviceInfo + `},
+			{"id":"tasmota_112233445566","type":"action.devices.types.SENSOR",
+				"traits":["action.devices.traits.TemperatureSetting"],
+				"name":{"defaultNames":["Реле"],"name":"Реле"},"willReportState":false,
+				"attributes":{"availableThermostatModes":[],"thermostatTemperatureUnit":"C",
+					"queryOnlyTemperatureSetting":true},` + deviceInfo + `},
+			{"id":"tasmota_AABBCCDDEEFF:1","type":"action.devices.types.LIGHT",
+				"traits":["action.devices.traits.OnOff","action.devices.traits.Brightness",
+					"action.devices.traits.ColorSetting"],
+				"name":{"defaultNames":["Лампа"],"name":"Лампа"},"willReportState":false,
+				"attributes":{"colorModel":"hsv","colorTemperatureRange":{"temperatureMinK":2000,"temperatureMaxK":6500}},` +
+			deviceInfo + `}]}`},
+		"02-query.json": {want: `{"devices":{
+			"tasmota_AABBCCDDEEFF:1":{"online":true,"status":"SUCCESS","on":false,"brightness":40,
+				"color":{"temperatureK":4000}},
+			"unknown-device":{"status":"ERROR","errorCode":"deviceNotFound"}}}`},
+		"03-execute-onoff.json": {
+			want: `{"commands":[{"ids":["tasmota_AABBCCDDEEFF:1"],"status":"SUCCESS",
+				"states":{"online":true,"on":true,"brightness":40,"color":{"temperatureK":4000}}}]}`,
+			commands: []api.Command{{Channel: 1, Type: api.CommandOnOff, On: true}},
+		},
+		"04-execute-color.json": {
+			want: `{"commands":[{"ids":["tasmota_AABBCCDDEEFF:1"],"status":"SUCCESS",
+				"states":{"online":true,"on":true,"brightness":40,
+					"color":{"spectrumHsv":{"hue":300,"saturation":1,"value":1}}}}]}`,
+			commands: []api.Command{{Channel: 1, Type: api.CommandOnOff, On: true},
+				{Channel: 1, Type: api.CommandColor, Color: api.ColorHSV{Hue: 300, Saturation: 100, Value: 100}}},
+		},
+		// Disconnect is handled by the HTTP handler, because it revoke tokens
+		"05-disconnect.json": {want: `{"errorCode":"notSupported","debugString":"action.devices.DISCONNECT"}`},
+	}
+
+	files, e := filepath.Glob(filepath.Join(testIntents, "*.json"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	if len(tests) != len(files) {
+		t.Fatalf("got %d recorded intents, %d are tested", len(files), len(tests))
+	}
+
+	// Intents are replayed in order, as the simulator does
+	for _, file := range files {
+		name := filepath.Base(file)
+		test, found := tests[name]
+		if !found {
+			t.Fatalf("%s isn't tested", name)
+		}
+
+		data, e := os.ReadFile(file)
+		if nil != e {
+			t.Fatal(e)
+		}
+		data = []byte(strings.ReplaceAll(string(data), testDevicePlaceholder, testLightID+":1"))
+
+		var request Request
+		if e = json.Unmarshal(data, &request); nil != e || 1 != len(request.Inputs) {
+			t.Fatalf("%s: invalid intent: %v", name, e)
+		}
+
+		payload, e := fulfill(context.Background(), deviceManager, stateCache, "ivan", request.Inputs[0])
+		if nil != e {
+			t.Fatalf("%s: %v", name, e)
+		}
+		checkPayload(t, name, payload, test.want)
+
+		if nil != test.commands {
+			if got := light.Executed(); !reflect.DeepEqual(test.commands, got) {
+				t.Errorf("%s: got commands %+v, want %+v", name, got, test.commands)
+			}
+		}
+	}
+}
+
+func TestFulfillErrors(t *testing.T) {
+	deviceManager, stateCache, light, relay := newTestDevices(t)
+	relay.Failure = api.ErrDeviceUnreachable
+
+	for _, test := range []struct {
+		name    string
+		intent  string
+		payload string
+		want    string
+	}{
+		{"sensors", intentQuery, `{"devices":[{"id":"tasmota_112233445566"}]}`,
+			`{"devices":{"tasmota_112233445566":{"online":true,"status":"SUCCESS",
+				"thermostatTemperatureAmbient":21.9,"thermostatHumidityAmbient":40}}}`},
+		{"channel without reported state", intentQuery, `{"devices":[{"id":"tasmota_112233445566:1"}]}`,
+			`{"devices":{"tasmota_112233445566:1":{"online":true,"status":"SUCCESS","on":false}}}`},
+		{"unknown channel", intentQuery, `{"devices":[{"id":"tasmota_AABBCCDDEEFF:2"}]}`,
+			`{"devices":{"tasmota_AABBCCDDEEFF:2":{"status":"ERROR","errorCode":"deviceNotFound"}}}`},
+		{"unreachable device", intentExecute, `{"commands":[{"devices":[{"id":"tasmota_112233445566:1"}],
+			"execution":[{"command":"action.devices.commands.OnOff","params":{"on":true}}]}]}`,
+			`{"commands":[{"ids":["tasmota_112233445566:1"],"status":"OFFLINE","errorCode":"deviceOffline"}]}`},
+		{"sensors can't be controlled", intentExecute, `{"commands":[{"devices":[{"id":"tasmota_112233445566"}],
+			"execution":[{"command":"action.devices.commands.OnOff","params":{"on":true}}]}]}`,
+			`{"commands":[{"ids":["tasmota_112233445566"],"status":"ERROR","errorCode":"functionNotSupported"}]}`},
+		{"brightness of relay", intentExecute, `{"commands":[{"devices":[{"id":"tasmota_112233445566:1"}],
+			"execution":[{"command":"action.devices.commands.BrightnessAbsolute","params":{"brightness":50}}]}]}`,
+			`{"commands":[{"ids":["tasmota_112233445566:1"],"status":"ERROR","errorCode":"functionNotSupported"}]}`},
+		{"temperature out of range", intentExecute, `{"commands":[{"devices":[{"id":"tasmota_AABBCCDDEEFF:1"}],
+			"execution":[{"command":"action.devices.commands.ColorAbsolute","params":{"color":{"temperatureK":9000}}}]}]}`,
+			`{"commands":[{"ids":["tasmota_AABBCCDDEEFF:1"],"status":"ERROR","errorCode":"valueOutOfRange"}]}`},
+		{"several devices", intentExecute, `{"commands":[{"devices":[{"id":"tasmota_AABBCCDDEEFF:1"},
+			{"id":"unknown-device"}],"execution":[{"command":"action.devices.commands.BrightnessAbsolute",
+			"params":{"brightness":75}}]}]}`,
+			`{"commands":[{"ids":["tasmota_AABBCCDDEEFF:1"],"status":"SUCCESS","states":{"online":true,"on":false,
+				"brightness":75,"color":{"temperatureK":4000}}},
+				{"ids":["unknown-device"],"status":"ERROR","errorCode":"deviceNotFound"}]}`},
+		{"unknown intent", "action.devices.REPORT", "",
+			`{"errorCode":"notSupported","debugString":"action.devices.REPORT"}`},
+	} {
+		payload, e := fulfill(context.Background(), deviceManager, stateCache, "ivan",
+			Input{Intent: test.intent, Payload: json.RawMessage(test.payload)})
+		if nil != e {
+			t.Fatalf("%s: %v", test.name, e)
+		}
+		checkPayload(t, test.name, payload, test.want)
+	}
+
+	want := []api.Command{{Channel: 1, Type: api.CommandBrightness, Brightness: 75}}
+	if got := light.Executed(); !reflect.DeepEqual(want, got) {
+		t.Errorf("got commands %+v, want %+v", got, want)
+	}
+
+	if _, e := fulfill(context.Background(), deviceManager, stateCache, "ivan",
+		Input{Intent: intentQuery, Payload: json.RawMessage(`{"devices":{}}`)}); nil == e {
+		t.Error("invalid payload is accepted")
+	}
+}
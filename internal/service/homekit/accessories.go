@@ -0,0 +1,814 @@
+package homekit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// Service and characteristic types in the short form
+const (
+	typeAccessoryInformation = "3E"
+	typeLightbulb            = "43"
+	typeSwitch               = "49"
+	typeTemperatureSensor    = "8A"
+	typeHumiditySensor       = "82"
+	typeIdentify             = "14"
+	typeManufacturer         = "20"
+	typeModel                = "21"
+	typeName                 = "23"
+	typeSerialNumber         = "30"
+	typeFirmwareRevision     = "52"
+	typeOn                   = "25"
+	typeBrightness           = "8"
+	typeHue                  = "13"
+	typeSaturation           = "2F"
+	typeColorTemperature     = "CE"
+	typeCurrentTemperature   = "11"
+	typeCurrentHumidity      = "10"
+)
+
+// Instance IDs of the characteristics, they are the same for all accessories
+const (
+	iidInformation        = 1
+	iidIdentify           = 2
+	iidManufacturer       = 3
+	iidModel              = 4
+	iidName               = 5
+	iidSerialNumber       = 6
+	iidFirmwareRevision   = 7
+	iidPrimary            = 8
+	iidOn                 = 9
+	iidBrightness         = 10
+	iidHue                = 11
+	iidSaturation         = 12
+	iidColorTemperature   = 13
+	iidHumidityService    = 14
+	iidCurrentHumidity    = 15
+	iidCurrentTemperature = iidOn
+	// bridgeAccessoryID is accessory ID of the bridge itself
+	bridgeAccessoryID = 1
+)
+
+// HAP status codes
+const (
+	statusSuccess              = 0
+	statusInsufficientRights   = -70401
+	statusCommunicationFailure = -70402
+	statusReadOnly             = -70404
+	statusWriteOnly            = -70405
+	statusNotFound             = -70409
+	statusInvalidValue         = -70410
+)
+
+// Characteristic permissions
+const (
+	permRead   = "pr"
+	permWrite  = "pw"
+	permEvents = "ev"
+)
+
+const (
+	// Tasmota-compatible white light temperature range in mireds
+	miredsMin = 153
+	miredsMax = 500
+	// channelSeparator separate device ID and channel index in the endpoint ID
+	channelSeparator = ":"
+)
+
+var (
+	// errNotFound returned when accessory or characteristic isn't found
+	errNotFound = errors.New("characteristic not found")
+)
+
+// characteristic is characteristic description in the accessories database
+type characteristic struct {
+	AID      uint64      `json:"aid,omitempty"`
+	IID      uint64      `json:"iid"`
+	Type     string      `json:"type,omitempty"`
+	Perms    []string    `json:"perms,omitempty"`
+	Format   string      `json:"format,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	Unit     string      `json:"unit,omitempty"`
+	MinValue *float64    `json:"minValue,omitempty"`
+	MaxValue *float64    `json:"maxValue,omitempty"`
+	MinStep  *float64    `json:"minStep,omitempty"`
+	Status   *int        `json:"status,omitempty"`
+}
+
+// service is service description in the accessories database
+type service struct {
+	IID             uint64           `json:"iid"`
+	Type            string           `json:"type"`
+	Primary         bool             `json:"primary,omitempty"`
+	Characteristics []characteristic `json:"characteristics"`
+}
+
+// accessory is accessory description in the accessories database
+type accessory struct {
+	AID      uint64    `json:"aid"`
+	Services []service `json:"services"`
+}
+
+// endpoint is device channel or device sensors published as accessory
+type endpoint struct {
+	id       string
+	deviceID string
+	device   api.Device
+	// channel is nil for the sensors accessory
+	channel *api.Channel
+	name    string
+}
+
+// accessories is accessories database built from the device manager
+type accessories struct {
+	storage       *storage
+	deviceManager api.DeviceManager
+	stateCache    api.StateCache
+	name          string
+	lock          sync.RWMutex
+	endpoints     map[uint64]*endpoint
+	configNumber  uint32
+}
+
+// newAccessories return accessories database
+func newAccessories(storage *storage, deviceManager api.DeviceManager, stateCache api.StateCache, name string) *accessories {
+	return &accessories{
+		storage:       storage,
+		deviceManager: deviceManager,
+		stateCache:    stateCache,
+		name:          name,
+		endpoints:     make(map[uint64]*endpoint),
+	}
+}
+
+// rangeValue return pointer to the constant for the characteristic range
+func rangeValue(value float64) *float64 {
+	return &value
+}
+
+// Refresh rebuild accessories from the device manager, it return true when configuration number changed
+func (a *accessories) Refresh() (bool, error) {
+	devices, e := a.deviceManager.EnumDevices()
+	if nil != e {
+		return false, e
+	}
+
+	endpoints := make(map[uint64]*endpoint)
+	for deviceID, device := range devices {
+		for _, ep := range newEndpoints(deviceID, device) {
+			aid, e := a.storage.AccessoryID(ep.id)
+			if nil != e {
+				return false, e
+			}
+			endpoints[aid] = ep
+		}
+	}
+
+	// Configuration number depend on the accessories layout only
+	aids := make([]uint64, 0, len(endpoints))
+	for aid := range endpoints {
+		aids = append(aids, aid)
+	}
+	sort.Slice(aids, func(i, j int) bool { return aids[i] < aids[j] })
+
+	hash := sha256.New()
+	for _, aid := range aids {
+		layout, _ := json.Marshal(a.describe(aid, endpoints[aid], nil))
+		_, _ = fmt.Fprintf(hash, "%d:%s\n", aid, layout)
+	}
+
+	configNumber, e := a.storage.ConfigNumber(hex.EncodeToString(hash.Sum(nil)))
+	if nil != e {
+		return false, e
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.endpoints = endpoints
+	changed := configNumber != a.configNumber
+	a.configNumber = configNumber
+
+	return changed, nil
+}
+
+// ConfigNumber return current configuration number
+func (a *accessories) ConfigNumber() uint32 {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	return a.configNumber
+}
+
+// endpoint return endpoint of the accessory
+func (a *accessories) endpoint(aid uint64) (*endpoint, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	ep, found := a.endpoints[aid]
+
+	return ep, found
+}
+
+// DeviceAccessories return accessory IDs of the device
+func (a *accessories) DeviceAccessories(deviceID string) (result []uint64) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	for aid, ep := range a.endpoints {
+		if ep.deviceID == deviceID {
+			result = append(result, aid)
+		}
+	}
+
+	return result
+}
+
+// Database return all accessories with current values
+func (a *accessories) Database() []accessory {
+	a.lock.RLock()
+	endpoints := make(map[uint64]*endpoint, len(a.endpoints))
+	for aid, ep := range a.endpoints {
+		endpoints[aid] = ep
+	}
+	a.lock.RUnlock()
+
+	result := []accessory{a.describeBridge()}
+
+	aids := make([]uint64, 0, len(endpoints))
+	for aid := range endpoints {
+		aids = append(aids, aid)
+	}
+	sort.Slice(aids, func(i, j int) bool { return aids[i] < aids[j] })
+
+	for _, aid := range aids {
+		ep := endpoints[aid]
+
+		state, e := a.stateCache.GetState(ep.deviceID)
+		if nil != e {
+			// Values are omitted for unreachable devices
+			result = append(result, a.describe(aid, ep, nil))
+			continue
+		}
+
+		result = append(result, a.describe(aid, ep, &state))
+	}
+
+	return result
+}
+
+// newEndpoints return accessories for each device channel and for the device sensors
+func newEndpoints(deviceID string, device api.Device) (result []*endpoint) {
+	name := strings.TrimSpace(device.GetName())
+	if 0 == len(name) {
+		name = deviceID
+	}
+
+	channels := device.GetChannels()
+	for index := range channels {
+		channel := channels[index]
+		ep := &endpoint{
+			id:       deviceID + channelSeparator + strconv.Itoa(channel.Index),
+			deviceID: deviceID,
+			device:   device,
+			channel:  &channel,
+			name:     strings.TrimSpace(channel.Name),
+		}
+
+		if 0 == len(ep.name) {
+			ep.name = name
+			if len(channels) > 1 {
+				// Names must be different for each channel
+				ep.name += " " + strconv.Itoa(channel.Index)
+			}
+		}
+
+		result = append(result, ep)
+	}
+
+	if hasSensor(device.GetSensors(), api.SensorTemperature) || hasSensor(device.GetSensors(), api.SensorHumidity) {
+		result = append(result, &endpoint{
+			id:       deviceID,
+			deviceID: deviceID,
+			device:   device,
+			name:     name,
+		})
+	}
+
+	return result
+}
+
+// hasSensor return true when the device has sensor of the kind
+func hasSensor(sensors []api.Sensor, kind api.SensorKind) bool {
+	for _, sensor := range sensors {
+		if kind == sensor.Kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// informationService return accessory information service
+func informationService(name string, manufacturer string, model string, serial string, firmware string) service {
+	info := func(iid uint64, charType string, value string) characteristic {
+		if 0 == len(value) {
+			value = "-"
+		}
+
+		return characteristic{
+			IID:    iid,
+			Type:   charType,
+			Perms:  []string{permRead},
+			Format: "string",
+			Value:  value,
+		}
+	}
+
+	return service{
+		IID:  iidInformation,
+		Type: typeAccessoryInformation,
+		Characteristics: []characteristic{
+			{IID: iidIdentify, Type: typeIdentify, Perms: []string{permWrite}, Format: "bool"},
+			info(iidManufacturer, typeManufacturer, manufacturer),
+			info(iidModel, typeModel, model),
+			info(iidName, typeName, name),
+			info(iidSerialNumber, typeSerialNumber, serial),
+			info(iidFirmwareRevision, typeFirmwareRevision, firmware),
+		},
+	}
+}
+
+// describeBridge return bridge accessory
+func (a *accessories) describeBridge() accessory {
+	return accessory{
+		AID: bridgeAccessoryID,
+		Services: []service{
+			informationService(a.name, "alisa", "Bridge", a.storage.PairingID(), "1.0"),
+		},
+	}
+}
+
+// describe return accessory of the endpoint, values are omitted when state is nil
+func (a *accessories) describe(aid uint64, ep *endpoint, state *api.State) accessory {
+	manufacturer := ep.device.GetRoute().Integration
+	result := accessory{
+		AID: aid,
+		Services: []service{
+			informationService(ep.name, manufacturer, ep.device.GetType(), ep.id, ep.device.GetFirmwareVersion()),
+		},
+	}
+
+	iids := ep.characteristics()
+	characteristics := make(map[uint64]characteristic, len(iids))
+	for _, iid := range iids {
+		c := ep.describe(iid)
+		if nil != state {
+			c.Value, _ = ep.value(iid, *state)
+		}
+		characteristics[iid] = c
+	}
+
+	primary := service{
+		IID:     iidPrimary,
+		Primary: true,
+	}
+
+	switch {
+	case nil == ep.channel && hasSensor(ep.device.GetSensors(), api.SensorTemperature):
+		primary.Type = typeTemperatureSensor
+		primary.Characteristics = []characteristic{characteristics[iidCurrentTemperature]}
+	case nil == ep.channel:
+		primary.Type = typeHumiditySensor
+		primary.Characteristics = []characteristic{characteristics[iidCurrentHumidity]}
+	case isLight(*ep.channel):
+		primary.Type = typeLightbulb
+	default:
+		primary.Type = typeSwitch
+	}
+
+	if nil != ep.channel {
+		for _, iid := range iids {
+			primary.Characteristics = append(primary.Characteristics, characteristics[iid])
+		}
+	}
+
+	result.Services = append(result.Services, primary)
+
+	if nil == ep.channel && primary.Type != typeHumiditySensor && hasSensor(ep.device.GetSensors(), api.SensorHumidity) {
+		result.Services = append(result.Services, service{
+			IID:             iidHumidityService,
+			Type:            typeHumiditySensor,
+			Characteristics: []characteristic{characteristics[iidCurrentHumidity]},
+		})
+	}
+
+	return result
+}
+
+// isLight return true when channel is dimmable or colored light
+func isLight(channel api.Channel) bool {
+	return api.ChannelLight == channel.Type && api.LightNone != channel.Light
+}
+
+// lightAbilities return is light support color and white temperature
+func lightAbilities(light api.LightType) (color bool, temperature bool) {
+	switch light {
+	case api.LightRGB, api.LightRGBW:
+		return true, false
+	case api.LightRGBCW:
+		return true, true
+	case api.LightCW:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// characteristics return instance IDs of the endpoint characteristics, except information service
+func (ep *endpoint) characteristics() []uint64 {
+	if nil == ep.channel {
+		var result []uint64
+		if hasSensor(ep.device.GetSensors(), api.SensorTemperature) {
+			result = append(result, iidCurrentTemperature)
+		}
+		if hasSensor(ep.device.GetSensors(), api.SensorHumidity) {
+			result = append(result, iidCurrentHumidity)
+		}
+		return result
+	}
+
+	result := []uint64{iidOn}
+	if !isLight(*ep.channel) {
+		return result
+	}
+
+	result = append(result, iidBrightness)
+
+	color, temperature := lightAbilities(ep.channel.Light)
+	if color {
+		result = append(result, iidHue, iidSaturation)
+	}
+	if temperature {
+		result = append(result, iidColorTemperature)
+	}
+
+	return result
+}
+
+// describe return characteristic description without value
+func (ep *endpoint) describe(iid uint64) characteristic {
+	readWrite := []string{permRead, permWrite, permEvents}
+	readOnly := []string{permRead, permEvents}
+
+	switch {
+	case nil == ep.channel && iidCurrentTemperature == iid:
+		return characteristic{IID: iid, Type: typeCurrentTemperature, Perms: readOnly, Format: "float",
+			Unit: "celsius", MinValue: rangeValue(-100), MaxValue: rangeValue(100), MinStep: rangeValue(0.1)}
+	case nil == ep.channel && iidCurrentHumidity == iid:
+		return characteristic{IID: iid, Type: typeCurrentHumidity, Perms: readOnly, Format: "float",
+			Unit: "percentage", MinValue: rangeValue(0), MaxValue: rangeValue(100), MinStep: rangeValue(1)}
+	case iidOn == iid:
+		return characteristic{IID: iid, Type: typeOn, Perms: readWrite, Format: "bool"}
+	case iidBrightness == iid:
+		return characteristic{IID: iid, Type: typeBrightness, Perms: readWrite, Format: "int",
+			Unit: "percentage", MinValue: rangeValue(0), MaxValue: rangeValue(100), MinStep: rangeValue(1)}
+	case iidHue == iid:
+		return characteristic{IID: iid, Type: typeHue, Perms: readWrite, Format: "float",
+			Unit: "arcdegrees", MinValue: rangeValue(0), MaxValue: rangeValue(360), MinStep: rangeValue(1)}
+	case iidSaturation == iid:
+		return characteristic{IID: iid, Type: typeSaturation, Perms: readWrite, Format: "float",
+			Unit: "percentage", MinValue: rangeValue(0), MaxValue: rangeValue(100), MinStep: rangeValue(1)}
+	default:
+		return characteristic{IID: iid, Type: typeColorTemperature, Perms: readWrite, Format: "uint32",
+			MinValue: rangeValue(miredsMin), MaxValue: rangeValue(miredsMax), MinStep: rangeValue(1)}
+	}
+}
+
+// has return true when the endpoint has the characteristic
+func (ep *endpoint) has(iid uint64) bool {
+	for _, known := range ep.characteristics() {
+		if known == iid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// value return characteristic value from the device state
+func (ep *endpoint) value(iid uint64, state api.State) (interface{}, error) {
+	if nil == ep.channel {
+		var kind api.SensorKind
+		switch iid {
+		case iidCurrentTemperature:
+			kind = api.SensorTemperature
+		case iidCurrentHumidity:
+			kind = api.SensorHumidity
+		default:
+			return nil, errNotFound
+		}
+
+		value, found := state.Sensors[kind]
+		if !found {
+			return nil, api.ErrDeviceUnreachable
+		}
+
+		if api.SensorTemperature == kind {
+			for _, sensor := range ep.device.GetSensors() {
+				if sensor.Kind == kind && "F" == sensor.Unit {
+					value = (value - 32) * 5 / 9
+				}
+			}
+			return math.Round(value*10) / 10, nil
+		}
+
+		return math.Round(value), nil
+	}
+
+	channelState := state.Channels[ep.channel.Index]
+
+	switch iid {
+	case iidOn:
+		return channelState.On, nil
+	case iidBrightness:
+		return channelState.Brightness, nil
+	case iidHue:
+		return channelState.Color.Hue, nil
+	case iidSaturation:
+		return channelState.Color.Saturation, nil
+	case iidColorTemperature:
+		return toMireds(channelState.ColorTemperature), nil
+	default:
+		return nil, errNotFound
+	}
+}
+
+// toMireds convert color temperature from Kelvin to mireds
+func toMireds(kelvin int) int {
+	if kelvin <= 0 {
+		return miredsMax
+	}
+
+	mireds := int(math.Round(1000000 / float64(kelvin)))
+	if mireds < miredsMin {
+		return miredsMin
+	}
+	if mireds > miredsMax {
+		return miredsMax
+	}
+
+	return mireds
+}
+
+// Read return characteristic value
+func (a *accessories) Read(aid uint64, iid uint64) (interface{}, int) {
+	if bridgeAccessoryID == aid {
+		for _, c := range a.describeBridge().Services[0].Characteristics {
+			if c.IID == iid && nil != c.Value {
+				return c.Value, statusSuccess
+			}
+		}
+
+		return nil, statusNotFound
+	}
+
+	ep, found := a.endpoint(aid)
+	if !found {
+		return nil, statusNotFound
+	}
+
+	if iid >= iidManufacturer && iid <= iidFirmwareRevision {
+		info := a.describe(aid, ep, nil).Services[0]
+		for _, c := range info.Characteristics {
+			if c.IID == iid {
+				return c.Value, statusSuccess
+			}
+		}
+	}
+
+	if iidIdentify == iid {
+		return nil, statusWriteOnly
+	}
+
+	if !ep.has(iid) {
+		return nil, statusNotFound
+	}
+
+	state, e := a.stateCache.GetState(ep.deviceID)
+	if nil != e {
+		return nil, statusCommunicationFailure
+	}
+
+	value, e := ep.value(iid, state)
+	if nil != e {
+		return nil, statusCommunicationFailure
+	}
+
+	return value, statusSuccess
+}
+
+// write is characteristic write request
+type write struct {
+	AID   uint64          `json:"aid"`
+	IID   uint64          `json:"iid"`
+	Value json.RawMessage `json:"value,omitempty"`
+	// Events enable or disable notifications
+	Events *bool `json:"ev,omitempty"`
+}
+
+// Write convert characteristic writes to the device commands and execute them, status returned for each write
+func (a *accessories) Write(ctx context.Context, writes []write) []int {
+	statuses := make([]int, len(writes))
+
+	// Hue and saturation are written separately, but device accept whole color only
+	type color struct {
+		hue        *float64
+		saturation *float64
+		indexes    []int
+	}
+	colors := make(map[uint64]*color)
+
+	type job struct {
+		ep       *endpoint
+		commands []api.Command
+		indexes  []int
+	}
+	jobs := make(map[uint64]*job)
+
+	for index, w := range writes {
+		if nil == w.Value {
+			// Notifications only
+			continue
+		}
+
+		ep, found := a.endpoint(w.AID)
+		if !found {
+			statuses[index] = statusNotFound
+			continue
+		}
+
+		if iidIdentify == w.IID {
+			// Device has no way to identify itself
+			continue
+		}
+
+		if !ep.has(w.IID) {
+			statuses[index] = statusNotFound
+			continue
+		}
+
+		if nil == ep.channel {
+			statuses[index] = statusReadOnly
+			continue
+		}
+
+		command := api.Command{
+			Channel: ep.channel.Index,
+		}
+
+		var number float64
+		switch w.IID {
+		case iidOn:
+			var on interface{}
+			if e := json.Unmarshal(w.Value, &on); nil != e {
+				statuses[index] = statusInvalidValue
+				continue
+			}
+			command.Type = api.CommandOnOff
+			switch value := on.(type) {
+			case bool:
+				command.On = value
+			case float64:
+				command.On = 0 != value
+			default:
+				statuses[index] = statusInvalidValue
+				continue
+			}
+		case iidBrightness, iidColorTemperature, iidHue, iidSaturation:
+			if e := json.Unmarshal(w.Value, &number); nil != e {
+				statuses[index] = statusInvalidValue
+				continue
+			}
+		}
+
+		switch w.IID {
+		case iidBrightness:
+			if number < 0 || number > 100 {
+				statuses[index] = statusInvalidValue
+				continue
+			}
+			command.Type = api.CommandBrightness
+			command.Brightness = int(math.Round(number))
+		case iidColorTemperature:
+			if number < miredsMin || number > miredsMax {
+				statuses[index] = statusInvalidValue
+				continue
+			}
+			command.Type = api.CommandColorTemperature
+			command.ColorTemperature = int(math.Round(1000000 / number))
+		case iidHue, iidSaturation:
+			c, found := colors[w.AID]
+			if !found {
+				c = &color{}
+				colors[w.AID] = c
+			}
+			value := number
+			if iidHue == w.IID {
+				c.hue = &value
+			} else {
+				c.saturation = &value
+			}
+			c.indexes = append(c.indexes, index)
+			continue
+		}
+
+		j, found := jobs[w.AID]
+		if !found {
+			j = &job{ep: ep}
+			jobs[w.AID] = j
+		}
+		j.commands = append(j.commands, command)
+		j.indexes = append(j.indexes, index)
+	}
+
+	for aid, c := range colors {
+		ep, _ := a.endpoint(aid)
+
+		current := api.ColorHSV{Value: 100}
+		if state, e := a.stateCache.GetState(ep.deviceID); nil == e {
+			channelState := state.Channels[ep.channel.Index]
+			current = channelState.Color
+			if 0 == current.Value {
+				current.Value = 100
+			}
+		}
+
+		if nil != c.hue {
+			current.Hue = int(math.Round(*c.hue)) % 360
+		}
+		if nil != c.saturation {
+			current.Saturation = int(math.Round(*c.saturation))
+		}
+
+		if current.Hue < 0 || current.Saturation < 0 || current.Saturation > 100 {
+			for _, index := range c.indexes {
+				statuses[index] = statusInvalidValue
+			}
+			continue
+		}
+
+		j, found := jobs[aid]
+		if !found {
+			j = &job{ep: ep}
+			jobs[aid] = j
+		}
+		j.commands = append(j.commands, api.Command{
+			Channel: ep.channel.Index,
+			Type:    api.CommandColor,
+			Color:   current,
+		})
+		j.indexes = append(j.indexes, c.indexes...)
+	}
+
+	// Accessories are independent, so commands are sent in parallel
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+
+			status := statusSuccess
+			for _, command := range j.commands {
+				if e := j.ep.device.Execute(ctx, command); nil != e {
+					status = statusCommunicationFailure
+					if errors.Is(e, api.ErrInvalidValue) || errors.Is(e, api.ErrInvalidAction) {
+						status = statusInvalidValue
+					}
+					break
+				}
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for _, index := range j.indexes {
+				statuses[index] = status
+			}
+		}(j)
+	}
+	wg.Wait()
+
+	return statuses
+}
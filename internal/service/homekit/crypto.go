@@ -0,0 +1,56 @@
+package homekit
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// hkdfSHA512 derive 32 bytes key as defined by HAP
+func hkdfSHA512(secret []byte, salt string, info string) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, e := io.ReadFull(hkdf.New(sha512.New, secret, []byte(salt), []byte(info)), key); nil != e {
+		return nil, e
+	}
+
+	return key, nil
+}
+
+// namedNonce return nonce for the pairing messages, e.g. "PS-Msg05"
+func namedNonce(name string) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	copy(nonce[chacha20poly1305.NonceSize-len(name):], name)
+
+	return nonce
+}
+
+// counterNonce return nonce for the session frames
+func counterNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+
+	return nonce
+}
+
+// seal encrypt pairing message
+func seal(key []byte, nonce string, plaintext []byte) ([]byte, error) {
+	aead, e := chacha20poly1305.New(key)
+	if nil != e {
+		return nil, e
+	}
+
+	return aead.Seal(nil, namedNonce(nonce), plaintext, nil), nil
+}
+
+// open decrypt pairing message
+func open(key []byte, nonce string, ciphertext []byte) ([]byte, error) {
+	aead, e := chacha20poly1305.New(key)
+	if nil != e {
+		return nil, e
+	}
+
+	return aead.Open(nil, namedNonce(nonce), ciphertext, nil)
+}
@@ -0,0 +1,273 @@
+package homekit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mdnsAddress        = "224.0.0.251:5353"
+	mdnsServiceType    = "_hap._tcp.local."
+	mdnsServicesLookup = "_services._dns-sd._udp.local."
+	// mdnsTTL is TTL of the advertised records
+	mdnsTTL = 120
+	// mdnsAnnounceInterval is interval between repeated announcements
+	mdnsAnnounceInterval = time.Second
+	// mdnsAnnounceCount is number of announcements sent on start and on change
+	mdnsAnnounceCount = 2
+	// mdnsMaxMessage is maximal size of the mDNS message
+	mdnsMaxMessage = 9000
+)
+
+// advertiser publish the HAP service with multicast DNS
+type advertiser struct {
+	instance string
+	host     string
+	port     uint16
+	// txt return current TXT record, it changes when pairing or configuration changed
+	txt   func() []string
+	group *net.UDPAddr
+	lock  sync.Mutex
+	conn  *net.UDPConn
+	// announce is signaled when records changed
+	announce chan struct{}
+}
+
+// mdnsLabel return name usable as single DNS label
+func mdnsLabel(name string) string {
+	return strings.NewReplacer(".", "-", "\\", "-").Replace(strings.TrimSpace(name))
+}
+
+// newAdvertiser return advertiser of the HAP service
+func newAdvertiser(name string, pairingID string, port uint16, txt func() []string) (*advertiser, error) {
+	group, e := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if nil != e {
+		return nil, e
+	}
+
+	return &advertiser{
+		instance: mdnsLabel(name) + "." + mdnsServiceType,
+		// Unique host name don't conflict with the names announced by other responders on the host
+		host:     "alisa-" + strings.ReplaceAll(pairingID, ":", "") + ".local.",
+		port:     port,
+		txt:      txt,
+		group:    group,
+		announce: make(chan struct{}, 1),
+	}, nil
+}
+
+// Update announce changed records
+func (a *advertiser) Update() {
+	select {
+	case a.announce <- struct{}{}:
+	default:
+	}
+}
+
+// run answer queries and announce the service until context done
+func (a *advertiser) run(ctx context.Context) error {
+	conn, e := net.ListenMulticastUDP("udp4", nil, a.group)
+	if nil != e {
+		return e
+	}
+
+	a.lock.Lock()
+	a.conn = conn
+	a.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		// Goodbye records remove the service from the controllers cache
+		a.send(0)
+		_ = conn.Close()
+	}()
+
+	go a.announceLoop(ctx)
+
+	buffer := make([]byte, mdnsMaxMessage)
+	for {
+		n, _, e := conn.ReadFromUDP(buffer)
+		if nil != e {
+			if nil != ctx.Err() || errors.Is(e, net.ErrClosed) {
+				return ctx.Err()
+			}
+
+			log.Log.Debugw("mDNS read failed", "error", e)
+			continue
+		}
+
+		if a.isQuery(buffer[:n]) {
+			a.send(mdnsTTL)
+		}
+	}
+}
+
+// announceLoop send unsolicited announcements on start and when records changed
+func (a *advertiser) announceLoop(ctx context.Context) {
+	a.Update()
+
+	for {
+		select {
+		case <-a.announce:
+			for count := 0; count < mdnsAnnounceCount; count++ {
+				a.send(mdnsTTL)
+
+				select {
+				case <-time.After(mdnsAnnounceInterval):
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isQuery return true when message is query about the service
+func (a *advertiser) isQuery(data []byte) bool {
+	var parser dnsmessage.Parser
+
+	header, e := parser.Start(data)
+	if nil != e || header.Response {
+		return false
+	}
+
+	questions, e := parser.AllQuestions()
+	if nil != e {
+		return false
+	}
+
+	for _, question := range questions {
+		name := question.Name.String()
+		for _, known := range []string{mdnsServiceType, mdnsServicesLookup, a.instance, a.host} {
+			if strings.EqualFold(name, known) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// send multicast response with all records
+func (a *advertiser) send(ttl uint32) {
+	data, e := a.response(ttl)
+	if nil != e {
+		log.Log.Warnw("mDNS response isn't built", "error", e)
+		return
+	}
+
+	a.lock.Lock()
+	conn := a.conn
+	a.lock.Unlock()
+
+	if nil == conn {
+		return
+	}
+
+	if _, e = conn.WriteToUDP(data, a.group); nil != e {
+		log.Log.Debugw("mDNS send failed", "error", e)
+	}
+}
+
+// response build response message with the service records
+func (a *advertiser) response(ttl uint32) ([]byte, error) {
+	serviceType, e := dnsmessage.NewName(mdnsServiceType)
+	if nil != e {
+		return nil, e
+	}
+	servicesLookup, e := dnsmessage.NewName(mdnsServicesLookup)
+	if nil != e {
+		return nil, e
+	}
+	instance, e := dnsmessage.NewName(a.instance)
+	if nil != e {
+		return nil, e
+	}
+	host, e := dnsmessage.NewName(a.host)
+	if nil != e {
+		return nil, e
+	}
+
+	header := func(name dnsmessage.Name, recordType dnsmessage.Type, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique {
+			// Cache flush bit, records are owned by this responder only
+			class |= 1 << 15
+		}
+
+		return dnsmessage.ResourceHeader{Name: name, Type: recordType, Class: class, TTL: ttl}
+	}
+
+	message := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{
+				Header: header(serviceType, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: instance},
+			},
+			{
+				Header: header(servicesLookup, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: serviceType},
+			},
+			{
+				Header: header(instance, dnsmessage.TypeSRV, true),
+				Body:   &dnsmessage.SRVResource{Port: a.port, Target: host},
+			},
+			{
+				Header: header(instance, dnsmessage.TypeTXT, true),
+				Body:   &dnsmessage.TXTResource{TXT: a.txt()},
+			},
+		},
+	}
+
+	for _, ip := range localAddresses() {
+		var address [4]byte
+		copy(address[:], ip)
+
+		message.Answers = append(message.Answers, dnsmessage.Resource{
+			Header: header(host, dnsmessage.TypeA, true),
+			Body:   &dnsmessage.AResource{A: address},
+		})
+	}
+
+	return message.Pack()
+}
+
+// localAddresses return IPv4 addresses of the multicast capable interfaces
+func localAddresses() (result []net.IP) {
+	interfaces, e := net.Interfaces()
+	if nil != e {
+		return nil
+	}
+
+	for _, i := range interfaces {
+		if 0 == i.Flags&net.FlagUp || 0 == i.Flags&net.FlagMulticast || 0 != i.Flags&net.FlagLoopback {
+			continue
+		}
+
+		addresses, e := i.Addrs()
+		if nil != e {
+			continue
+		}
+
+		for _, address := range addresses {
+			if network, ok := address.(*net.IPNet); ok {
+				if ip := network.IP.To4(); nil != ip {
+					result = append(result, ip)
+				}
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,460 @@
+package homekit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	contentTypePairing = "application/pairing+tlv8"
+	// maxSetupAttempts is number of failed pair-setup attempts before pairing is blocked until restart
+	maxSetupAttempts = 100
+	// maxPairings is maximal number of paired controllers
+	maxPairings = 16
+	// permissionAdmin is permissions of the admin controller
+	permissionAdmin = 0x01
+)
+
+var (
+	// errPairingState returned when pairing message received in the wrong state
+	errPairingState = errors.New("unexpected pairing state")
+)
+
+// verifyState is pair-verify in progress
+type verifyState struct {
+	public           []byte
+	controllerPublic []byte
+	shared           []byte
+	key              []byte
+}
+
+// readTLV read request body as TLV8
+func readTLV(request *http.Request) (tlv, error) {
+	data, e := io.ReadAll(request.Body)
+	if nil != e {
+		return nil, e
+	}
+
+	return decodeTLV(data)
+}
+
+// writeTLV send TLV8 response. Content length is set, so the whole response is sent by flush.
+func writeTLV(w http.ResponseWriter, response tlv) {
+	data := response.Encode()
+
+	w.Header().Set("Content-Type", contentTypePairing)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// writeTLVError send pairing error response
+func writeTLVError(w http.ResponseWriter, state byte, code byte) {
+	writeTLV(w, tlv{}.AddByte(tlvState, state).AddByte(tlvError, code))
+}
+
+// onPairSetup process pair-setup messages
+func (b *bridge) onPairSetup(w http.ResponseWriter, request *http.Request) {
+	s := requestSession(request)
+
+	message, e := readTLV(request)
+	if nil != e {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state := message.Byte(tlvState)
+
+	switch state {
+	case 1:
+		b.pairSetupStart(w, s)
+	case 3:
+		b.pairSetupVerify(w, s, message)
+	case 5:
+		b.pairSetupExchange(w, s, message)
+	default:
+		log.Log.Warnw("HomeKit pair-setup failed", "state", state, "error", errPairingState)
+		writeTLVError(w, state+1, tlvErrorUnknown)
+	}
+}
+
+// pairSetupStart process M1 and return SRP salt and public key
+func (b *bridge) pairSetupStart(w http.ResponseWriter, s *session) {
+	if b.storage.Paired() {
+		writeTLVError(w, 2, tlvErrorUnavailable)
+		return
+	}
+
+	b.lock.Lock()
+	failures := b.failures
+	b.lock.Unlock()
+
+	if failures >= maxSetupAttempts {
+		writeTLVError(w, 2, tlvErrorMaxTries)
+		return
+	}
+
+	setup, e := newSRPServer(b.setupCode)
+	if nil != e {
+		log.Log.Errorw("HomeKit pair-setup failed", "error", e)
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+	s.setup = setup
+
+	writeTLV(w, tlv{}.
+		AddByte(tlvState, 2).
+		Add(tlvPublicKey, setup.PublicKey()).
+		Add(tlvSalt, setup.Salt()))
+}
+
+// pairSetupVerify process M3 and return server proof when setup code is valid
+func (b *bridge) pairSetupVerify(w http.ResponseWriter, s *session, message tlv) {
+	if nil == s.setup {
+		writeTLVError(w, 4, tlvErrorUnknown)
+		return
+	}
+
+	proof, e := s.setup.Verify(message.Get(tlvPublicKey), message.Get(tlvProof))
+	if nil != e {
+		b.lock.Lock()
+		b.failures++
+		b.lock.Unlock()
+
+		log.Log.Warnw("HomeKit pair-setup failed, wrong setup code", "error", e)
+		s.setup = nil
+		writeTLVError(w, 4, tlvErrorAuthentication)
+		return
+	}
+
+	writeTLV(w, tlv{}.AddByte(tlvState, 4).Add(tlvProof, proof))
+}
+
+// pairSetupExchange process M5, save controller long-term key and return accessory long-term key
+func (b *bridge) pairSetupExchange(w http.ResponseWriter, s *session, message tlv) {
+	if nil == s.setup || nil == s.setup.Key() {
+		writeTLVError(w, 6, tlvErrorUnknown)
+		return
+	}
+	sharedKey := s.setup.Key()
+	s.setup = nil
+
+	controllerID, controllerKey, e := b.verifyController(sharedKey, message.Get(tlvEncryptedData))
+	if nil != e {
+		log.Log.Warnw("HomeKit pair-setup failed", "error", e)
+		writeTLVError(w, 6, tlvErrorAuthentication)
+		return
+	}
+
+	if e = b.storage.AddPairing(controllerID, pairing{PublicKey: controllerKey, Admin: true}); nil != e {
+		log.Log.Errorw("HomeKit pair-setup failed", "error", e)
+		writeTLVError(w, 6, tlvErrorUnknown)
+		return
+	}
+
+	accessoryX, e := hkdfSHA512(sharedKey, "Pair-Setup-Accessory-Sign-Salt", "Pair-Setup-Accessory-Sign-Info")
+	if nil != e {
+		writeTLVError(w, 6, tlvErrorUnknown)
+		return
+	}
+
+	pairingID := []byte(b.storage.PairingID())
+	privateKey := b.storage.PrivateKey()
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	info := bytes.Join([][]byte{accessoryX, pairingID, publicKey}, nil)
+	subTLV := tlv{}.
+		Add(tlvIdentifier, pairingID).
+		Add(tlvPublicKey, publicKey).
+		Add(tlvSignature, ed25519.Sign(privateKey, info))
+
+	encryptionKey, _ := hkdfSHA512(sharedKey, "Pair-Setup-Encrypt-Salt", "Pair-Setup-Encrypt-Info")
+	encrypted, e := seal(encryptionKey, "PS-Msg06", subTLV.Encode())
+	if nil != e {
+		writeTLVError(w, 6, tlvErrorUnknown)
+		return
+	}
+
+	log.Log.Infow("HomeKit controller paired", "controller_id", controllerID)
+
+	writeTLV(w, tlv{}.AddByte(tlvState, 6).Add(tlvEncryptedData, encrypted))
+
+	b.pairingsChanged()
+}
+
+// verifyController decrypt M5 and verify controller signature
+func (b *bridge) verifyController(sharedKey []byte, encrypted []byte) (string, []byte, error) {
+	encryptionKey, e := hkdfSHA512(sharedKey, "Pair-Setup-Encrypt-Salt", "Pair-Setup-Encrypt-Info")
+	if nil != e {
+		return "", nil, e
+	}
+
+	data, e := open(encryptionKey, "PS-Msg05", encrypted)
+	if nil != e {
+		return "", nil, e
+	}
+
+	subTLV, e := decodeTLV(data)
+	if nil != e {
+		return "", nil, e
+	}
+
+	controllerID := subTLV.Get(tlvIdentifier)
+	controllerKey := subTLV.Get(tlvPublicKey)
+	if 0 == len(controllerID) || ed25519.PublicKeySize != len(controllerKey) {
+		return "", nil, errInvalidTLV
+	}
+
+	controllerX, e := hkdfSHA512(sharedKey, "Pair-Setup-Controller-Sign-Salt", "Pair-Setup-Controller-Sign-Info")
+	if nil != e {
+		return "", nil, e
+	}
+
+	info := bytes.Join([][]byte{controllerX, controllerID, controllerKey}, nil)
+	if !ed25519.Verify(controllerKey, info, subTLV.Get(tlvSignature)) {
+		return "", nil, errSRPInvalidProof
+	}
+
+	return string(controllerID), controllerKey, nil
+}
+
+// onPairVerify process pair-verify messages, session is encrypted when verify complete
+func (b *bridge) onPairVerify(w http.ResponseWriter, request *http.Request) {
+	s := requestSession(request)
+
+	message, e := readTLV(request)
+	if nil != e {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state := message.Byte(tlvState)
+
+	switch state {
+	case 1:
+		b.pairVerifyStart(w, s, message)
+	case 3:
+		b.pairVerifyFinish(w, s, message)
+	default:
+		log.Log.Warnw("HomeKit pair-verify failed", "state", state, "error", errPairingState)
+		writeTLVError(w, state+1, tlvErrorUnknown)
+	}
+}
+
+// pairVerifyStart process M1, generate session key pair and prove accessory identity
+func (b *bridge) pairVerifyStart(w http.ResponseWriter, s *session, message tlv) {
+	controllerPublic := message.Get(tlvPublicKey)
+	if curve25519.PointSize != len(controllerPublic) {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, e := rand.Read(secret); nil != e {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	public, e := curve25519.X25519(secret, curve25519.Basepoint)
+	if nil != e {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	shared, e := curve25519.X25519(secret, controllerPublic)
+	if nil != e {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	key, e := hkdfSHA512(shared, "Pair-Verify-Encrypt-Salt", "Pair-Verify-Encrypt-Info")
+	if nil != e {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	pairingID := []byte(b.storage.PairingID())
+	info := bytes.Join([][]byte{public, pairingID, controllerPublic}, nil)
+	subTLV := tlv{}.
+		Add(tlvIdentifier, pairingID).
+		Add(tlvSignature, ed25519.Sign(b.storage.PrivateKey(), info))
+
+	encrypted, e := seal(key, "PV-Msg02", subTLV.Encode())
+	if nil != e {
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	s.verify = &verifyState{
+		public:           public,
+		controllerPublic: controllerPublic,
+		shared:           shared,
+		key:              key,
+	}
+
+	writeTLV(w, tlv{}.
+		AddByte(tlvState, 2).
+		Add(tlvPublicKey, public).
+		Add(tlvEncryptedData, encrypted))
+}
+
+// pairVerifyFinish process M3, verify controller identity and enable session encryption
+func (b *bridge) pairVerifyFinish(w http.ResponseWriter, s *session, message tlv) {
+	verify := s.verify
+	s.verify = nil
+	if nil == verify {
+		writeTLVError(w, 4, tlvErrorUnknown)
+		return
+	}
+
+	data, e := open(verify.key, "PV-Msg03", message.Get(tlvEncryptedData))
+	if nil != e {
+		writeTLVError(w, 4, tlvErrorAuthentication)
+		return
+	}
+
+	subTLV, e := decodeTLV(data)
+	if nil != e {
+		writeTLVError(w, 4, tlvErrorAuthentication)
+		return
+	}
+
+	controllerID := subTLV.Get(tlvIdentifier)
+	p, found := b.storage.Pairing(string(controllerID))
+	if !found {
+		log.Log.Warnw("HomeKit pair-verify failed, controller isn't paired", "controller_id", string(controllerID))
+		writeTLVError(w, 4, tlvErrorAuthentication)
+		return
+	}
+
+	info := bytes.Join([][]byte{verify.controllerPublic, controllerID, verify.public}, nil)
+	if !ed25519.Verify(p.PublicKey, info, subTLV.Get(tlvSignature)) {
+		log.Log.Warnw("HomeKit pair-verify failed, invalid signature", "controller_id", string(controllerID))
+		writeTLVError(w, 4, tlvErrorAuthentication)
+		return
+	}
+
+	readKey, e := hkdfSHA512(verify.shared, "Control-Salt", "Control-Write-Encryption-Key")
+	if nil != e {
+		writeTLVError(w, 4, tlvErrorUnknown)
+		return
+	}
+
+	writeKey, e := hkdfSHA512(verify.shared, "Control-Salt", "Control-Read-Encryption-Key")
+	if nil != e {
+		writeTLVError(w, 4, tlvErrorUnknown)
+		return
+	}
+
+	// Controller send encrypted requests after this response only, so decryption may be enabled now
+	s.EnableReadEncryption(string(controllerID), readKey)
+
+	writeTLV(w, tlv{}.AddByte(tlvState, 4))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	// Response is sent in plain, following data is encrypted
+	s.EnableWriteEncryption(writeKey)
+}
+
+// onPairings process add, remove and list pairings requests of the admin controller
+func (b *bridge) onPairings(w http.ResponseWriter, request *http.Request) {
+	s := requestSession(request)
+
+	message, e := readTLV(request)
+	if nil != e {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if p, found := b.storage.Pairing(s.ControllerID()); !found || !p.Admin {
+		writeTLVError(w, 2, tlvErrorAuthentication)
+		return
+	}
+
+	controllerID := string(message.Get(tlvIdentifier))
+
+	switch message.Byte(tlvMethod) {
+	case methodAddPairing:
+		publicKey := message.Get(tlvPublicKey)
+		if existing, found := b.storage.Pairing(controllerID); found && !bytes.Equal(existing.PublicKey, publicKey) {
+			writeTLVError(w, 2, tlvErrorUnknown)
+			return
+		} else if !found && len(b.storage.Pairings()) >= maxPairings {
+			writeTLVError(w, 2, tlvErrorMaxPeers)
+			return
+		}
+
+		if e = b.storage.AddPairing(controllerID, pairing{
+			PublicKey: publicKey,
+			Admin:     0 != message.Byte(tlvPermissions)&permissionAdmin,
+		}); nil != e {
+			writeTLVError(w, 2, tlvErrorUnknown)
+			return
+		}
+
+		log.Log.Infow("HomeKit controller added", "controller_id", controllerID)
+	case methodRemovePairing:
+		if e = b.storage.RemovePairing(controllerID); nil != e {
+			writeTLVError(w, 2, tlvErrorUnknown)
+			return
+		}
+
+		log.Log.Infow("HomeKit controller removed", "controller_id", controllerID)
+
+		// Sessions of the removed controllers must be closed after response sent
+		defer b.closeUnpaired()
+	case methodListPairings:
+		response := tlv{}.AddByte(tlvState, 2)
+		first := true
+		for id, p := range b.storage.Pairings() {
+			if !first {
+				response = response.Add(tlvSeparator, nil)
+			}
+			first = false
+
+			permissions := byte(0)
+			if p.Admin {
+				permissions = permissionAdmin
+			}
+
+			response = response.
+				Add(tlvIdentifier, []byte(id)).
+				Add(tlvPublicKey, p.PublicKey).
+				AddByte(tlvPermissions, permissions)
+		}
+
+		writeTLV(w, response)
+		return
+	default:
+		writeTLVError(w, 2, tlvErrorUnknown)
+		return
+	}
+
+	writeTLV(w, tlv{}.AddByte(tlvState, 2))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	b.pairingsChanged()
+}
+
+// closeUnpaired close sessions of the controllers which aren't paired anymore
+func (b *bridge) closeUnpaired() {
+	for _, s := range b.listener.Sessions() {
+		if controllerID := s.ControllerID(); len(controllerID) > 0 {
+			if _, found := b.storage.Pairing(controllerID); !found {
+				_ = s.Close()
+			}
+		}
+	}
+}
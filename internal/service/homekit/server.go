@@ -0,0 +1,306 @@
+package homekit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+const (
+	contentTypeJSON = "application/hap+json"
+	// statusConnectionAuthorizationRequired is HTTP status for the requests of the unverified session
+	statusConnectionAuthorizationRequired = 470
+	// writeTimeout is timeout of the characteristic write, i.e. device command
+	writeTimeout = time.Second * 5
+)
+
+// contextKey is type of the request context keys
+type contextKey int
+
+const (
+	// contextSession is request context key of the session
+	contextSession contextKey = iota
+)
+
+// bridge is HAP server of the bridge accessory
+type bridge struct {
+	storage     *storage
+	accessories *accessories
+	listener    *sessionListener
+	setupCode   string
+	lock        sync.Mutex
+	// failures is number of pair-setup attempts with wrong setup code
+	failures int
+	// onPairingsChanged called when controller paired or removed
+	onPairingsChanged func()
+}
+
+// requestSession return session of the request
+func requestSession(request *http.Request) *session {
+	return request.Context().Value(contextSession).(*session)
+}
+
+// newHandler return HAP request handler
+func (b *bridge) newHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/pair-setup", b.post(b.onPairSetup))
+	mux.HandleFunc("/pair-verify", b.post(b.onPairVerify))
+	mux.HandleFunc("/identify", b.post(b.onIdentify))
+	mux.HandleFunc("/pairings", b.post(b.verified(b.onPairings)))
+	mux.HandleFunc("/accessories", b.verified(b.onAccessories))
+	mux.HandleFunc("/characteristics", b.verified(b.onCharacteristics))
+
+	return mux
+}
+
+// newServer return HTTP server for the sessions
+func (b *bridge) newServer() *http.Server {
+	return &http.Server{
+		Handler: b.newHandler(),
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, contextSession, conn)
+		},
+		ConnState: b.listener.ConnState,
+	}
+}
+
+// post allow POST requests only
+func (b *bridge) post(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		if http.MethodPost != request.Method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, request)
+	}
+}
+
+// verified allow requests of the verified sessions only
+func (b *bridge) verified(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		if 0 == len(requestSession(request).ControllerID()) {
+			writeJSON(w, statusConnectionAuthorizationRequired, map[string]int{"status": statusInsufficientRights})
+			return
+		}
+
+		handler(w, request)
+	}
+}
+
+// writeJSON send JSON response
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	data, e := json.Marshal(response)
+	if nil != e {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// pairingsChanged notify about paired controllers change
+func (b *bridge) pairingsChanged() {
+	if nil != b.onPairingsChanged {
+		b.onPairingsChanged()
+	}
+}
+
+// onIdentify is identify request of the unpaired accessory
+func (b *bridge) onIdentify(w http.ResponseWriter, _ *http.Request) {
+	if b.storage.Paired() {
+		writeJSON(w, http.StatusBadRequest, map[string]int{"status": statusInsufficientRights})
+		return
+	}
+
+	log.Log.Info("HomeKit identify requested")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// onAccessories return accessories database
+func (b *bridge) onAccessories(w http.ResponseWriter, request *http.Request) {
+	if http.MethodGet != request.Method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"accessories": b.accessories.Database(),
+	})
+}
+
+// onCharacteristics read or write characteristics
+func (b *bridge) onCharacteristics(w http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		b.readCharacteristics(w, request)
+	case http.MethodPut:
+		b.writeCharacteristics(w, request)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// parseCharacteristicID parse characteristic ID in the "aid.iid" form
+func parseCharacteristicID(value string) (characteristicID, bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if 2 != len(parts) {
+		return characteristicID{}, false
+	}
+
+	aid, e := strconv.ParseUint(parts[0], 10, 64)
+	if nil != e {
+		return characteristicID{}, false
+	}
+
+	iid, e := strconv.ParseUint(parts[1], 10, 64)
+	if nil != e {
+		return characteristicID{}, false
+	}
+
+	return characteristicID{aid: aid, iid: iid}, true
+}
+
+// readCharacteristics return values of the requested characteristics
+func (b *bridge) readCharacteristics(w http.ResponseWriter, request *http.Request) {
+	var result []characteristic
+	failed := false
+
+	for _, value := range strings.Split(request.URL.Query().Get("id"), ",") {
+		id, ok := parseCharacteristicID(value)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]int{"status": statusInvalidValue})
+			return
+		}
+
+		c := characteristic{
+			AID: id.aid,
+			IID: id.iid,
+		}
+
+		var status int
+		if c.Value, status = b.accessories.Read(id.aid, id.iid); statusSuccess != status {
+			failed = true
+		}
+		c.Status = &status
+
+		result = append(result, c)
+	}
+
+	if !failed {
+		// Statuses are included only when some read failed
+		for index := range result {
+			result[index].Status = nil
+		}
+	}
+
+	status := http.StatusOK
+	if failed {
+		status = http.StatusMultiStatus
+	}
+
+	writeJSON(w, status, map[string]interface{}{
+		"characteristics": result,
+	})
+}
+
+// writeCharacteristics write characteristic values and enable or disable events
+func (b *bridge) writeCharacteristics(w http.ResponseWriter, request *http.Request) {
+	s := requestSession(request)
+
+	var body struct {
+		Characteristics []write `json:"characteristics"`
+	}
+	if e := json.NewDecoder(request.Body).Decode(&body); nil != e {
+		writeJSON(w, http.StatusBadRequest, map[string]int{"status": statusInvalidValue})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), writeTimeout)
+	defer cancel()
+
+	statuses := b.accessories.Write(ctx, body.Characteristics)
+
+	failed := false
+	result := make([]characteristic, len(body.Characteristics))
+	for index, c := range body.Characteristics {
+		if nil != c.Events && statusSuccess == statuses[index] {
+			if _, found := b.accessories.endpoint(c.AID); found || bridgeAccessoryID == c.AID {
+				s.Subscribe(characteristicID{aid: c.AID, iid: c.IID}, *c.Events)
+			} else {
+				statuses[index] = statusNotFound
+			}
+		}
+
+		status := statuses[index]
+		result[index] = characteristic{
+			AID:    c.AID,
+			IID:    c.IID,
+			Status: &status,
+		}
+		failed = failed || statusSuccess != status
+	}
+
+	if !failed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusMultiStatus, map[string]interface{}{
+		"characteristics": result,
+	})
+}
+
+// notify send events about characteristics of the changed device to the subscribed sessions
+func (b *bridge) notify(deviceID string) {
+	aids := b.accessories.DeviceAccessories(deviceID)
+	if 0 == len(aids) {
+		return
+	}
+
+	for _, s := range b.listener.Sessions() {
+		var changed []characteristic
+
+		for _, aid := range aids {
+			ep, found := b.accessories.endpoint(aid)
+			if !found {
+				continue
+			}
+
+			for _, iid := range ep.characteristics() {
+				if !s.Subscribed(characteristicID{aid: aid, iid: iid}) {
+					continue
+				}
+
+				if value, status := b.accessories.Read(aid, iid); statusSuccess == status {
+					changed = append(changed, characteristic{AID: aid, IID: iid, Value: value})
+				}
+			}
+		}
+
+		if 0 == len(changed) {
+			continue
+		}
+
+		body, e := json.Marshal(map[string]interface{}{
+			"characteristics": changed,
+		})
+		if nil != e {
+			continue
+		}
+
+		s.SendEvent(body)
+	}
+}
@@ -0,0 +1,271 @@
+package homekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/devices"
+	"github.com/vedga/alisa/internal/service/states"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// envHomeKitEnabled enable HomeKit bridge, e.g. "true"
+	envHomeKitEnabled = "HOMEKIT_ENABLED"
+	// envHomeKitSetupCode is setup code entered in the Home app, e.g. "031-45-154"
+	envHomeKitSetupCode = "HOMEKIT_SETUP_CODE"
+	// envHomeKitPort is TCP port of the bridge
+	envHomeKitPort = "HOMEKIT_PORT"
+	// envHomeKitName is bridge name shown in the Home app
+	envHomeKitName = "HOMEKIT_NAME"
+	// envHomeKitStorage is path of the file with pairings and accessory IDs
+	envHomeKitStorage = "HOMEKIT_STORAGE"
+	defaultPort       = 51826
+	defaultName       = "Alisa Bridge"
+	defaultStorage    = "homekit.json"
+	// categoryBridge is accessory category of the bridge
+	categoryBridge = 2
+	// shutdownTimeout is timeout to close open sessions
+	shutdownTimeout = time.Second * 5
+)
+
+var (
+	// setupCodePattern is allowed setup code format
+	setupCodePattern = regexp.MustCompile(`^\d{3}-\d{2}-\d{3}$`)
+	// trivialSetupCodes is setup codes forbidden by HAP
+	trivialSetupCodes = map[string]bool{
+		"000-00-000": true, "111-11-111": true, "222-22-222": true, "333-33-333": true, "444-44-444": true,
+		"555-55-555": true, "666-66-666": true, "777-77-777": true, "888-88-888": true, "999-99-999": true,
+		"123-45-678": true, "876-54-321": true,
+	}
+)
+
+// Service is HomeKit bridge, it publish all devices as bridged accessories
+type Service struct {
+	runnable.Runnable
+	bus         eventbus.Bus
+	enabled     bool
+	name        string
+	port        int
+	storage     *storage
+	accessories *accessories
+	bridge      *bridge
+	lock        sync.Mutex
+	// changed is IDs of the devices with changed state
+	changed map[string]struct{}
+	// refresh is true when devices added or changed
+	refresh bool
+	signal  chan struct{}
+}
+
+// NewService return new service implementation. Bridge is started only when it's enabled.
+func NewService(bus eventbus.Bus, deviceManager api.DeviceManager, stateCache api.StateCache) (service *Service, e error) {
+	service = &Service{
+		bus:     bus,
+		name:    defaultName,
+		port:    defaultPort,
+		changed: make(map[string]struct{}),
+		signal:  make(chan struct{}, 1),
+	}
+
+	if value, found := os.LookupEnv(envHomeKitEnabled); found {
+		if service.enabled, e = strconv.ParseBool(value); nil != e {
+			return nil, e
+		}
+	}
+
+	if !service.enabled {
+		return service, nil
+	}
+
+	setupCode := os.Getenv(envHomeKitSetupCode)
+	if !setupCodePattern.MatchString(setupCode) || trivialSetupCodes[setupCode] {
+		return nil, fmt.Errorf("%s must be non-trivial code in the XXX-XX-XXX form", envHomeKitSetupCode)
+	}
+
+	if value, found := os.LookupEnv(envHomeKitPort); found {
+		if service.port, e = strconv.Atoi(value); nil != e {
+			return nil, e
+		}
+	}
+
+	if value, found := os.LookupEnv(envHomeKitName); found {
+		service.name = value
+	}
+
+	path := defaultStorage
+	if value, found := os.LookupEnv(envHomeKitStorage); found {
+		path = value
+	}
+
+	if service.storage, e = newStorage(path); nil != e {
+		return nil, e
+	}
+
+	service.accessories = newAccessories(service.storage, deviceManager, stateCache, service.name)
+	service.bridge = &bridge{
+		storage:     service.storage,
+		accessories: service.accessories,
+		setupCode:   setupCode,
+	}
+
+	return service, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	if !service.enabled {
+		// Wait until operation complete
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	if _, e := service.accessories.Refresh(); nil != e {
+		return e
+	}
+
+	listener, e := net.Listen("tcp", ":"+strconv.Itoa(service.port))
+	if nil != e {
+		return e
+	}
+	service.bridge.listener = newSessionListener(listener)
+
+	advertiser, e := newAdvertiser(service.name, service.storage.PairingID(), uint16(service.port), service.txt)
+	if nil != e {
+		_ = listener.Close()
+		return e
+	}
+	service.bridge.onPairingsChanged = advertiser.Update
+
+	if e = service.bus.Subscribe(states.StateChanged, service.onStateChanged); nil != e {
+		_ = listener.Close()
+		return e
+	}
+	defer func() {
+		_ = service.bus.Unsubscribe(states.StateChanged, service.onStateChanged)
+	}()
+
+	if e = service.bus.Subscribe(devices.DeviceChanged, service.onDeviceChanged); nil != e {
+		_ = listener.Close()
+		return e
+	}
+	defer func() {
+		_ = service.bus.Unsubscribe(devices.DeviceChanged, service.onDeviceChanged)
+	}()
+
+	server := service.bridge.newServer()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- server.Serve(service.bridge.listener)
+	}()
+
+	go func() {
+		if e := advertiser.run(ctx); nil != e && !errors.Is(e, context.Canceled) {
+			log.Log.Errorw("HomeKit advertisement failed", "error", e)
+		}
+	}()
+
+	log.Log.Infow("HomeKit bridge started",
+		"port", service.port,
+		"pairing_id", service.storage.PairingID(),
+		"paired", service.storage.Paired())
+
+	for {
+		select {
+		case <-service.signal:
+			if service.process() {
+				advertiser.Update()
+			}
+		case e = <-serverDone:
+			return e
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+
+			_ = server.Shutdown(shutdownCtx)
+			// Idle sessions are kept open by controllers, they're closed forcibly
+			for _, s := range service.bridge.listener.Sessions() {
+				_ = s.Close()
+			}
+
+			return ctx.Err()
+		}
+	}
+}
+
+// txt return TXT record of the HAP service
+func (service *Service) txt() []string {
+	statusFlags := 0
+	if !service.storage.Paired() {
+		statusFlags = 1
+	}
+
+	return []string{
+		"c#=" + strconv.FormatUint(uint64(service.accessories.ConfigNumber()), 10),
+		"ff=0",
+		"id=" + service.storage.PairingID(),
+		"md=" + service.name,
+		"pv=1.1",
+		"s#=1",
+		"sf=" + strconv.Itoa(statusFlags),
+		"ci=" + strconv.Itoa(categoryBridge),
+	}
+}
+
+// onStateChanged called when device state changed
+func (service *Service) onStateChanged(deviceID string) {
+	service.lock.Lock()
+	service.changed[deviceID] = struct{}{}
+	service.lock.Unlock()
+
+	service.wakeup()
+}
+
+// onDeviceChanged called when device added or device description changed
+func (service *Service) onDeviceChanged(_ string) {
+	service.lock.Lock()
+	service.refresh = true
+	service.lock.Unlock()
+
+	service.wakeup()
+}
+
+// wakeup signal changes are available, it never block event bus
+func (service *Service) wakeup() {
+	select {
+	case service.signal <- struct{}{}:
+	default:
+	}
+}
+
+// process rebuild accessories and send events, it return true when configuration number changed
+func (service *Service) process() (configChanged bool) {
+	service.lock.Lock()
+	changed, refresh := service.changed, service.refresh
+	service.changed, service.refresh = make(map[string]struct{}), false
+	service.lock.Unlock()
+
+	if refresh {
+		var e error
+		if configChanged, e = service.accessories.Refresh(); nil != e {
+			log.Log.Errorw("HomeKit accessories refresh failed", "error", e)
+		}
+	}
+
+	for deviceID := range changed {
+		service.bridge.notify(deviceID)
+	}
+
+	return configChanged
+}
@@ -0,0 +1,358 @@
+package homekit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// frameMaxLength is maximal plaintext length of the encrypted frame
+	frameMaxLength = 1024
+	// frameLengthSize is size of the frame length prefix, it's also additional authenticated data
+	frameLengthSize = 2
+)
+
+var (
+	// errFrameTooLong returned when received frame is longer than allowed
+	errFrameTooLong = errors.New("encrypted frame too long")
+)
+
+// session is controller connection. It's plain until pair-verify complete, then all data is encrypted.
+type session struct {
+	net.Conn
+	readLock  sync.Mutex
+	writeLock sync.Mutex
+	// stateLock protect keys, subscriptions and events, it's acquired after write lock
+	stateLock sync.Mutex
+	// Encryption keys, nil until pair-verify complete
+	readKey      []byte
+	writeKey     []byte
+	readCounter  uint64
+	writeCounter uint64
+	// ciphertext is received data which isn't decrypted yet
+	ciphertext []byte
+	// plaintext is decrypted data which isn't read yet
+	plaintext []byte
+	// controllerID is identifier of the verified controller
+	controllerID string
+	// active is true while HTTP request processed, events are delayed until response sent
+	active bool
+	// pending is events delayed until response sent
+	pending [][]byte
+	// events is subscribed characteristics
+	events map[characteristicID]bool
+	// setup is SRP exchange of the pair-setup in progress
+	setup *srpServer
+	// verify is pair-verify in progress
+	verify *verifyState
+}
+
+// characteristicID is accessory and instance IDs of the characteristic
+type characteristicID struct {
+	aid uint64
+	iid uint64
+}
+
+// newSession return session for the accepted connection
+func newSession(conn net.Conn) *session {
+	return &session{
+		Conn:   conn,
+		events: make(map[characteristicID]bool),
+	}
+}
+
+// Read is implementation of io.Reader, data is decrypted when session is verified
+func (s *session) Read(p []byte) (int, error) {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	for {
+		if len(s.plaintext) > 0 {
+			n := copy(p, s.plaintext)
+			s.plaintext = s.plaintext[n:]
+			return n, nil
+		}
+
+		buffer := make([]byte, frameMaxLength+frameLengthSize+chacha20poly1305.Overhead)
+		n, e := s.Conn.Read(buffer)
+		if n > 0 {
+			// Key may be set while read is blocked, so it's checked after data received
+			if nil == s.key(true) {
+				s.plaintext = append(s.plaintext, buffer[:n]...)
+				continue
+			}
+
+			s.ciphertext = append(s.ciphertext, buffer[:n]...)
+			if e := s.decrypt(); nil != e {
+				return 0, e
+			}
+			continue
+		}
+
+		if nil != e {
+			return 0, e
+		}
+	}
+}
+
+// decrypt decrypt all complete frames received
+func (s *session) decrypt() error {
+	key := s.key(true)
+
+	aead, e := chacha20poly1305.New(key)
+	if nil != e {
+		return e
+	}
+
+	for len(s.ciphertext) >= frameLengthSize {
+		length := int(binary.LittleEndian.Uint16(s.ciphertext))
+		if length > frameMaxLength {
+			return errFrameTooLong
+		}
+
+		total := frameLengthSize + length + chacha20poly1305.Overhead
+		if len(s.ciphertext) < total {
+			// Incomplete frame
+			return nil
+		}
+
+		plaintext, e := aead.Open(nil, counterNonce(s.readCounter), s.ciphertext[frameLengthSize:total],
+			s.ciphertext[:frameLengthSize])
+		if nil != e {
+			return e
+		}
+		s.readCounter++
+
+		s.plaintext = append(s.plaintext, plaintext...)
+		s.ciphertext = s.ciphertext[total:]
+	}
+
+	return nil
+}
+
+// Write is implementation of io.Writer, data is encrypted when session is verified
+func (s *session) Write(p []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.write(p)
+}
+
+// write send data, caller must hold write lock
+func (s *session) write(p []byte) (int, error) {
+	key := s.key(false)
+	if nil == key {
+		return s.Conn.Write(p)
+	}
+
+	aead, e := chacha20poly1305.New(key)
+	if nil != e {
+		return 0, e
+	}
+
+	var frames bytes.Buffer
+	for data := p; len(data) > 0; {
+		size := len(data)
+		if size > frameMaxLength {
+			size = frameMaxLength
+		}
+
+		length := make([]byte, frameLengthSize)
+		binary.LittleEndian.PutUint16(length, uint16(size))
+
+		frames.Write(length)
+		frames.Write(aead.Seal(nil, counterNonce(s.writeCounter), data[:size], length))
+		s.writeCounter++
+
+		data = data[size:]
+	}
+
+	if _, e = s.Conn.Write(frames.Bytes()); nil != e {
+		return 0, e
+	}
+
+	return len(p), nil
+}
+
+// key return read or write key
+func (s *session) key(read bool) []byte {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if read {
+		return s.readKey
+	}
+
+	return s.writeKey
+}
+
+// EnableReadEncryption start decryption of the received data, it must be called before pair-verify response sent
+func (s *session) EnableReadEncryption(controllerID string, key []byte) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.controllerID = controllerID
+	s.readKey = key
+}
+
+// EnableWriteEncryption start encryption of the sent data, it must be called after pair-verify response sent
+func (s *session) EnableWriteEncryption(key []byte) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.writeKey = key
+}
+
+// ControllerID return verified controller identifier, empty for the unverified session
+func (s *session) ControllerID() string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if nil == s.readKey {
+		return ""
+	}
+
+	return s.controllerID
+}
+
+// SetActive mark session is processing the request. Delayed events are sent when request complete.
+func (s *session) SetActive(active bool) {
+	if active {
+		s.stateLock.Lock()
+		s.active = true
+		s.stateLock.Unlock()
+		return
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.stateLock.Lock()
+	s.active = false
+	pending := s.pending
+	s.pending = nil
+	s.stateLock.Unlock()
+
+	for _, event := range pending {
+		if _, e := s.write(event); nil != e {
+			break
+		}
+	}
+}
+
+// Subscribe enable or disable events of the characteristic
+func (s *session) Subscribe(id characteristicID, enable bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if enable {
+		s.events[id] = true
+	} else {
+		delete(s.events, id)
+	}
+}
+
+// Subscribed return true when events of the characteristic are enabled
+func (s *session) Subscribed(id characteristicID) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.events[id]
+}
+
+// SendEvent send characteristics notification, it's delayed while request processed
+func (s *session) SendEvent(body []byte) {
+	var event bytes.Buffer
+	_, _ = fmt.Fprintf(&event, "EVENT/1.0 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n",
+		contentTypeJSON, len(body))
+	event.Write(body)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.stateLock.Lock()
+	if nil == s.writeKey {
+		// Events are allowed for the verified sessions only
+		s.stateLock.Unlock()
+		return
+	}
+
+	if s.active {
+		s.pending = append(s.pending, event.Bytes())
+		s.stateLock.Unlock()
+		return
+	}
+	s.stateLock.Unlock()
+
+	_, _ = s.write(event.Bytes())
+}
+
+// sessionListener wrap accepted connections to the sessions
+type sessionListener struct {
+	net.Listener
+	lock     sync.Mutex
+	sessions map[net.Conn]*session
+}
+
+// newSessionListener return listener which produce sessions
+func newSessionListener(listener net.Listener) *sessionListener {
+	return &sessionListener{
+		Listener: listener,
+		sessions: make(map[net.Conn]*session),
+	}
+}
+
+// Accept is implementation of net.Listener interface
+func (l *sessionListener) Accept() (net.Conn, error) {
+	conn, e := l.Listener.Accept()
+	if nil != e {
+		return nil, e
+	}
+
+	s := newSession(conn)
+
+	l.lock.Lock()
+	l.sessions[s] = s
+	l.lock.Unlock()
+
+	return s, nil
+}
+
+// ConnState track session state, it's used as http.Server.ConnState
+func (l *sessionListener) ConnState(conn net.Conn, state http.ConnState) {
+	s, ok := conn.(*session)
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateActive:
+		s.SetActive(true)
+	case http.StateIdle:
+		s.SetActive(false)
+	case http.StateClosed, http.StateHijacked:
+		l.lock.Lock()
+		delete(l.sessions, conn)
+		l.lock.Unlock()
+	}
+}
+
+// Sessions return all open sessions
+func (l *sessionListener) Sessions() []*session {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	result := make([]*session, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		result = append(result, s)
+	}
+
+	return result
+}
@@ -0,0 +1,229 @@
+package homekit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sealFrames return HAP frames of the data, first frame is encrypted with the counter
+func sealFrames(t *testing.T, key []byte, counter uint64, data []byte) []byte {
+	t.Helper()
+
+	aead, e := chacha20poly1305.New(key)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var result []byte
+	for len(data) > 0 {
+		size := len(data)
+		if size > frameMaxLength {
+			size = frameMaxLength
+		}
+
+		length := []byte{byte(size), byte(size >> 8)}
+		result = append(result, length...)
+		result = aead.Seal(result, counterNonce(counter), data[:size], length)
+
+		counter++
+		data = data[size:]
+	}
+
+	return result
+}
+
+// newTestSession return verified session and the controller end of the connection
+func newTestSession(t *testing.T) (*session, net.Conn, []byte, []byte) {
+	t.Helper()
+
+	accessory, controller := net.Pipe()
+	t.Cleanup(func() {
+		_ = accessory.Close()
+		_ = controller.Close()
+	})
+
+	readKey := bytes.Repeat([]byte{0x01}, chacha20poly1305.KeySize)
+	writeKey := bytes.Repeat([]byte{0x02}, chacha20poly1305.KeySize)
+
+	s := newSession(accessory)
+	s.EnableReadEncryption("controller", readKey)
+	s.EnableWriteEncryption(writeKey)
+
+	return s, controller, readKey, writeKey
+}
+
+// testPayload return data which take several frames
+func testPayload() []byte {
+	payload := make([]byte, frameMaxLength*2+452)
+	for index := range payload {
+		payload[index] = byte(index)
+	}
+
+	return payload
+}
+
+func TestSessionPlain(t *testing.T) {
+	accessory, controller := net.Pipe()
+	defer func() {
+		_ = accessory.Close()
+		_ = controller.Close()
+	}()
+
+	s := newSession(accessory)
+	if 0 != len(s.ControllerID()) {
+		t.Fatal("unverified session has controller ID")
+	}
+
+	go func() {
+		_, _ = controller.Write([]byte("POST /pair-setup HTTP/1.1\r\n"))
+	}()
+
+	buffer := make([]byte, 27)
+	if _, e := io.ReadFull(s, buffer); nil != e || "POST /pair-setup HTTP/1.1\r\n" != string(buffer) {
+		t.Fatalf("got %q, %v", buffer, e)
+	}
+
+	go func() {
+		_, _ = s.Write([]byte("HTTP/1.1 200 OK\r\n"))
+	}()
+
+	buffer = make([]byte, 17)
+	if _, e := io.ReadFull(controller, buffer); nil != e || "HTTP/1.1 200 OK\r\n" != string(buffer) {
+		t.Fatalf("got %q, %v", buffer, e)
+	}
+}
+
+func TestSessionWriteFrames(t *testing.T) {
+	s, controller, _, writeKey := newTestSession(t)
+	if "controller" != s.ControllerID() {
+		t.Fatalf("got controller ID %q", s.ControllerID())
+	}
+
+	payload := testPayload()
+	written := make(chan error, 1)
+	go func() {
+		_, e := s.Write(payload)
+		if nil == e {
+			_, e = s.Write([]byte("next"))
+		}
+		written <- e
+	}()
+
+	aead, e := chacha20poly1305.New(writeKey)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// Frames are decrypted as the controller does
+	var received []byte
+	for counter := uint64(0); counter < 4; counter++ {
+		length := make([]byte, frameLengthSize)
+		if _, e = io.ReadFull(controller, length); nil != e {
+			t.Fatal(e)
+		}
+
+		size := int(binary.LittleEndian.Uint16(length))
+		if size > frameMaxLength {
+			t.Fatalf("frame %d is %d bytes", counter, size)
+		}
+
+		ciphertext := make([]byte, size+chacha20poly1305.Overhead)
+		if _, e = io.ReadFull(controller, ciphertext); nil != e {
+			t.Fatal(e)
+		}
+
+		plaintext, e := aead.Open(nil, counterNonce(counter), ciphertext, length)
+		if nil != e {
+			t.Fatalf("frame %d: %v", counter, e)
+		}
+		received = append(received, plaintext...)
+	}
+
+	if e = <-written; nil != e {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(append(payload, "next"...), received) {
+		t.Fatal("received data differ")
+	}
+}
+
+func TestSessionReadFrames(t *testing.T) {
+	s, controller, readKey, _ := newTestSession(t)
+
+	payload := testPayload()
+	frames := append(sealFrames(t, readKey, 0, payload), sealFrames(t, readKey, 3, []byte("next"))...)
+
+	// Frames are split between reads at any position
+	go func() {
+		for data := frames; len(data) > 0; {
+			size := 7
+			if size > len(data) {
+				size = len(data)
+			}
+			if _, e := controller.Write(data[:size]); nil != e {
+				return
+			}
+			data = data[size:]
+		}
+	}()
+
+	received := make([]byte, len(payload)+4)
+	if _, e := io.ReadFull(s, received); nil != e {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(append(payload, "next"...), received) {
+		t.Fatal("received data differ")
+	}
+}
+
+func TestSessionReadInvalidFrames(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		frames func(key []byte) []byte
+		want   error
+	}{
+		{"tampered frame", func(key []byte) []byte {
+			frames := sealFrames(t, key, 0, []byte("data"))
+			frames[frameLengthSize] ^= 0x01
+			return frames
+		}, nil},
+		{"tampered length", func(key []byte) []byte {
+			frames := sealFrames(t, key, 0, []byte("data"))
+			frames = append(frames, 0)
+			frames[0]++
+			return frames
+		}, nil},
+		{"replayed frame", func(key []byte) []byte {
+			frame := sealFrames(t, key, 0, []byte("data"))
+			return append(frame, frame...)
+		}, nil},
+		{"wrong key", func(key []byte) []byte {
+			return sealFrames(t, bytes.Repeat([]byte{0x03}, chacha20poly1305.KeySize), 0, []byte("data"))
+		}, nil},
+		{"too long frame", func(key []byte) []byte {
+			length := make([]byte, frameLengthSize)
+			binary.LittleEndian.PutUint16(length, frameMaxLength+1)
+			return length
+		}, errFrameTooLong},
+	} {
+		s, controller, readKey, _ := newTestSession(t)
+
+		go func(data []byte) {
+			_, _ = controller.Write(data)
+		}(test.frames(readKey))
+
+		buffer := make([]byte, 8)
+		_, e := io.ReadFull(s, buffer)
+		if nil == e || (nil != test.want && !errors.Is(e, test.want)) {
+			t.Errorf("%s: got %v", test.name, e)
+		}
+
+		_ = controller.Close()
+	}
+}
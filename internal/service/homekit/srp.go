@@ -0,0 +1,178 @@
+package homekit
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"math/big"
+)
+
+const (
+	// srpUsername is SRP user name defined by HAP
+	srpUsername = "Pair-Setup"
+	// srpSaltLength is length of the SRP salt
+	srpSaltLength = 16
+	// srpSecretLength is length of the server private key
+	srpSecretLength = 32
+)
+
+// srpN is prime of the 3072-bit SRP group from RFC 5054
+var srpN, _ = new(big.Int).SetString(""+
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+	"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+	"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05"+
+	"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB"+
+	"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718"+
+	"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33"+
+	"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"+
+	"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864"+
+	"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2"+
+	"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF", 16)
+
+// srpGroup is SRP group with the hash function
+type srpGroup struct {
+	n    *big.Int
+	g    *big.Int
+	hash func() hash.Hash
+}
+
+// srpHAP is 3072-bit group with SHA-512 used by HAP
+var srpHAP = &srpGroup{
+	n:    srpN,
+	g:    big.NewInt(5),
+	hash: sha512.New,
+}
+
+var (
+	// errSRPInvalidKey returned when client public key is invalid
+	errSRPInvalidKey = errors.New("invalid SRP public key")
+	// errSRPInvalidProof returned when client proof don't match, i.e. setup code is wrong
+	errSRPInvalidProof = errors.New("invalid SRP proof")
+)
+
+// srpServer is server side of the SRP-6a exchange with SHA-512 as defined by HAP
+type srpServer struct {
+	group    *srpGroup
+	username string
+	salt     []byte
+	verifier *big.Int
+	secret   *big.Int
+	public   *big.Int
+	// key is shared session key, available after client proof verified
+	key []byte
+}
+
+// Hash return hash of the concatenated values
+func (group *srpGroup) Hash(values ...[]byte) []byte {
+	h := group.hash()
+	for _, value := range values {
+		h.Write(value)
+	}
+
+	return h.Sum(nil)
+}
+
+// Pad return value padded to the group size
+func (group *srpGroup) Pad(value *big.Int) []byte {
+	result := make([]byte, (group.n.BitLen()+7)/8)
+
+	return value.FillBytes(result)
+}
+
+// newSRPServer return SRP server for the setup code
+func newSRPServer(password string) (*srpServer, error) {
+	salt := make([]byte, srpSaltLength)
+	if _, e := rand.Read(salt); nil != e {
+		return nil, e
+	}
+
+	secret := make([]byte, srpSecretLength)
+	if _, e := rand.Read(secret); nil != e {
+		return nil, e
+	}
+
+	return newSRPServerWith(srpHAP, srpUsername, password, salt, secret), nil
+}
+
+// newSRPServerWith return SRP server of the group with known salt and private key
+func newSRPServerWith(group *srpGroup, username string, password string, salt []byte, secret []byte) *srpServer {
+	x := new(big.Int).SetBytes(group.Hash(salt, group.Hash([]byte(username+":"+password))))
+	verifier := new(big.Int).Exp(group.g, x, group.n)
+
+	// B = k*v + g^b
+	k := new(big.Int).SetBytes(group.Hash(group.n.Bytes(), group.Pad(group.g)))
+	b := new(big.Int).SetBytes(secret)
+	public := new(big.Int).Mul(k, verifier)
+	public.Add(public, new(big.Int).Exp(group.g, b, group.n))
+	public.Mod(public, group.n)
+
+	return &srpServer{
+		group:    group,
+		username: username,
+		salt:     salt,
+		verifier: verifier,
+		secret:   b,
+		public:   public,
+	}
+}
+
+// Salt return SRP salt
+func (s *srpServer) Salt() []byte {
+	return s.salt
+}
+
+// PublicKey return server public key B
+func (s *srpServer) PublicKey() []byte {
+	return s.group.Pad(s.public)
+}
+
+// Verify check client proof and return server proof
+func (s *srpServer) Verify(clientPublic []byte, clientProof []byte) ([]byte, error) {
+	group := s.group
+
+	a := new(big.Int).SetBytes(clientPublic)
+	if 0 == new(big.Int).Mod(a, group.n).Sign() {
+		return nil, errSRPInvalidKey
+	}
+
+	key := group.Hash(group.Pad(s.premasterSecret(a)))
+
+	// M1 = H(H(N) xor H(g) | H(I) | s | A | B | K)
+	hashN, hashG := group.Hash(group.n.Bytes()), group.Hash(group.g.Bytes())
+	for index := range hashN {
+		hashN[index] ^= hashG[index]
+	}
+	proof := group.Hash(hashN, group.Hash([]byte(s.username)), s.salt, clientPublic, group.Pad(s.public), key)
+
+	if 1 != subtle.ConstantTimeCompare(proof, clientProof) {
+		return nil, errSRPInvalidProof
+	}
+
+	s.key = key
+
+	// M2 = H(A | M1 | K)
+	return group.Hash(clientPublic, proof, key), nil
+}
+
+// premasterSecret return shared secret S for the client public key
+func (s *srpServer) premasterSecret(a *big.Int) *big.Int {
+	group := s.group
+
+	// u = H(PAD(A) | PAD(B))
+	u := new(big.Int).SetBytes(group.Hash(group.Pad(a), group.Pad(s.public)))
+
+	// S = (A * v^u) ^ b
+	shared := new(big.Int).Exp(s.verifier, u, group.n)
+	shared.Mul(shared, a)
+
+	return shared.Exp(shared, s.secret, group.n)
+}
+
+// Key return shared session key
+func (s *srpServer) Key() []byte {
+	return s.key
+}
@@ -0,0 +1,192 @@
+package homekit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// fromHex return bytes of the hex string, spaces are ignored
+func fromHex(t *testing.T, value string) []byte {
+	t.Helper()
+
+	result, e := hex.DecodeString(strings.Join(strings.Fields(value), ""))
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return result
+}
+
+// fromHexInt return integer of the hex string, spaces are ignored
+func fromHexInt(t *testing.T, value string) *big.Int {
+	t.Helper()
+
+	return new(big.Int).SetBytes(fromHex(t, value))
+}
+
+// srpClient is client side of the SRP-6a exchange
+type srpClient struct {
+	group    *srpGroup
+	username string
+	password string
+	secret   *big.Int
+	public   *big.Int
+}
+
+// newSRPClient return SRP client with known private key
+func newSRPClient(group *srpGroup, username string, password string, secret []byte) *srpClient {
+	a := new(big.Int).SetBytes(secret)
+
+	return &srpClient{
+		group:    group,
+		username: username,
+		password: password,
+		secret:   a,
+		public:   new(big.Int).Exp(group.g, a, group.n),
+	}
+}
+
+// Proof return client proof M1 and session key for the server salt and public key
+func (c *srpClient) Proof(salt []byte, serverPublic []byte) ([]byte, []byte) {
+	group := c.group
+	b := new(big.Int).SetBytes(serverPublic)
+
+	x := new(big.Int).SetBytes(group.Hash(salt, group.Hash([]byte(c.username+":"+c.password))))
+	k := new(big.Int).SetBytes(group.Hash(group.n.Bytes(), group.Pad(group.g)))
+	u := new(big.Int).SetBytes(group.Hash(group.Pad(c.public), group.Pad(b)))
+
+	// S = (B - k*g^x) ^ (a + u*x)
+	base := new(big.Int).Exp(group.g, x, group.n)
+	base.Mul(base, k)
+	base.Sub(b, base)
+	base.Mod(base, group.n)
+	exponent := new(big.Int).Mul(u, x)
+	exponent.Add(exponent, c.secret)
+	key := group.Hash(group.Pad(new(big.Int).Exp(base, exponent, group.n)))
+
+	hashN, hashG := group.Hash(group.n.Bytes()), group.Hash(group.g.Bytes())
+	for index := range hashN {
+		hashN[index] ^= hashG[index]
+	}
+
+	return group.Hash(hashN, group.Hash([]byte(c.username)), salt, group.Pad(c.public), group.Pad(b), key), key
+}
+
+// TestSRPVectors check values of the RFC 5054 appendix B, which use 1024-bit group and SHA-1
+func TestSRPVectors(t *testing.T) {
+	group := &srpGroup{
+		n: fromHexInt(t, `
+			EEAF0AB9 ADB38DD6 9C33F80A FA8FC5E8 60726187 75FF3C0B 9EA2314C
+			9C256576 D674DF74 96EA81D3 383B4813 D692C6E0 E0D5D8E2 50B98BE4
+			8E495C1D 6089DAD1 5DC7D7B4 6154D6B6 CE8EF4AD 69B15D49 82559B29
+			7BCF1885 C529F566 660E57EC 68EDBC3C 05726CC0 2FD4CBF4 976EAA9A
+			FD5138FE 8376435B 9FC61D2F C0EB06E3`),
+		g:    big.NewInt(2),
+		hash: sha1.New,
+	}
+
+	salt := fromHex(t, "BEB25379 D1A8581E B5A72767 3A2441EE")
+	server := newSRPServerWith(group, "alice", "password123", salt,
+		fromHex(t, "E487CB59 D31AC550 471E81F0 0F6928E0 1DDA08E9 74A004F4 9E61F5D1 05284D20"))
+	client := newSRPClient(group, "alice", "password123",
+		fromHex(t, "60975527 035CF2AD 1989806F 0407210B C81EDC04 E2762A56 AFD529DD DA2D4393"))
+
+	k := new(big.Int).SetBytes(group.Hash(group.n.Bytes(), group.Pad(group.g)))
+	u := new(big.Int).SetBytes(group.Hash(group.Pad(client.public), server.PublicKey()))
+
+	for _, test := range []struct {
+		name string
+		got  *big.Int
+		want string
+	}{
+		{"k", k, "7556AA04 5AEF2CDD 07ABAF0F 665C3E81 8913186F"},
+		{"v", server.verifier, `
+			7E273DE8 696FFC4F 4E337D05 B4B375BE B0DDE156 9E8FA00A 9886D812
+			9BADA1F1 822223CA 1A605B53 0E379BA4 729FDC59 F105B478 7E5186F5
+			C671085A 1447B52A 48CF1970 B4FB6F84 00BBF4CE BFBB1681 52E08AB5
+			EA53D15C 1AFF87B2 B9DA6E04 E058AD51 CC72BFC9 033B564E 26480D78
+			E955A5E2 9E7AB245 DB2BE315 E2099AFB`},
+		{"A", client.public, `
+			61D5E490 F6F1B795 47B0704C 436F523D D0E560F0 C64115BB 72557EC4
+			4352E890 3211C046 92272D8B 2D1A5358 A2CF1B6E 0BFCF99F 921530EC
+			8E393561 79EAE45E 42BA92AE ACED8251 71E1E8B9 AF6D9C03 E1327F44
+			BE087EF0 6530E69F 66615261 EEF54073 CA11CF58 58F0EDFD FE15EFEA
+			B349EF5D 76988A36 72FAC47B 0769447B`},
+		{"B", new(big.Int).SetBytes(server.PublicKey()), `
+			BD0C6151 2C692C0C B6D041FA 01BB152D 4916A1E7 7AF46AE1 05393011
+			BAF38964 DC46A067 0DD125B9 5A981652 236F99D9 B681CBF8 7837EC99
+			6C6DA044 53728610 D0C6DDB5 8B318885 D7D82C7F 8DEB75CE 7BD4FBAA
+			37089E6F 9C6059F3 88838E7A 00030B33 1EB76840 910440B1 B27AAEAE
+			EB4012B7 D7665238 A8E3FB00 4B117B58`},
+		{"u", u, "CE38B959 3487DA98 554ED47D 70A7AE5F 462EF019"},
+		{"S", server.premasterSecret(client.public), `
+			B0DC82BA BCF30674 AE450C02 87745E79 90A3381F 63B387AA F271A10D
+			233861E3 59B48220 F7C4693C 9AE12B0A 6F67809F 0876E2D0 13800D6C
+			41BB59B6 D5979B5C 00A172B4 A2A5903A 0BDCAF8A 709585EB 2AFAFA8F
+			3499B200 210DCC1F 10EB3394 3CD67FC8 8A2F39A4 BE5BEC4E C0A3212D
+			C346D7E4 74B29EDE 8A469FFE CA686E5A`},
+	} {
+		if want := fromHexInt(t, test.want); 0 != want.Cmp(test.got) {
+			t.Errorf("%s: got %X, want %X", test.name, test.got, want)
+		}
+	}
+
+	// Proofs aren't part of the RFC vectors, so they're checked against the client
+	proof, key := client.Proof(server.Salt(), server.PublicKey())
+	serverProof, e := server.Verify(group.Pad(client.public), proof)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(key, server.Key()) {
+		t.Error("session keys differ")
+	}
+	if want := group.Hash(group.Pad(client.public), proof, key); !bytes.Equal(want, serverProof) {
+		t.Error("invalid server proof")
+	}
+}
+
+func TestSRPExchange(t *testing.T) {
+	server, e := newSRPServer("031-45-154")
+	if nil != e {
+		t.Fatal(e)
+	}
+	if srpSaltLength != len(server.Salt()) || 384 != len(server.PublicKey()) {
+		t.Fatalf("got salt %d bytes and public key %d bytes", len(server.Salt()), len(server.PublicKey()))
+	}
+
+	// Wrong setup code
+	client := newSRPClient(srpHAP, srpUsername, "031-45-155", bytes.Repeat([]byte{0x5A}, srpSecretLength))
+	proof, _ := client.Proof(server.Salt(), server.PublicKey())
+	if _, e = server.Verify(srpHAP.Pad(client.public), proof); !errors.Is(e, errSRPInvalidProof) {
+		t.Fatalf("wrong setup code: got %v, want %v", e, errSRPInvalidProof)
+	}
+	if nil != server.Key() {
+		t.Fatal("session key is available after failed verification")
+	}
+
+	// Public key which is zero modulo N force shared secret to zero, so it's rejected
+	for _, public := range []*big.Int{big.NewInt(0), srpN, new(big.Int).Lsh(srpN, 1)} {
+		if _, e = server.Verify(public.Bytes(), proof); !errors.Is(e, errSRPInvalidKey) {
+			t.Errorf("public key %X: got %v, want %v", public, e, errSRPInvalidKey)
+		}
+	}
+
+	client = newSRPClient(srpHAP, srpUsername, "031-45-154", bytes.Repeat([]byte{0x5A}, srpSecretLength))
+	proof, key := client.Proof(server.Salt(), server.PublicKey())
+	serverProof, e := server.Verify(srpHAP.Pad(client.public), proof)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(key, server.Key()) || sha512.Size != len(key) {
+		t.Fatal("session keys differ")
+	}
+	if want := srpHAP.Hash(srpHAP.Pad(client.public), proof, key); !bytes.Equal(want, serverProof) {
+		t.Fatal("invalid server proof")
+	}
+}
@@ -0,0 +1,229 @@
+package homekit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// firstAccessoryID is first ID of the bridged accessory, ID 1 is the bridge itself
+	firstAccessoryID = 2
+)
+
+// pairing is paired controller
+type pairing struct {
+	PublicKey []byte `json:"public_key"`
+	Admin     bool   `json:"admin"`
+}
+
+// storageData is persistent bridge state
+type storageData struct {
+	// PairingID is accessory pairing identifier in the MAC address form
+	PairingID  string             `json:"pairing_id"`
+	PrivateKey ed25519.PrivateKey `json:"private_key"`
+	Pairings   map[string]pairing `json:"pairings"`
+	// Accessories is accessory IDs by endpoint ID, IDs must be stable between restarts
+	Accessories map[string]uint64 `json:"accessories"`
+	NextID      uint64            `json:"next_id"`
+	// ConfigNumber is incremented when accessories changed, controllers reload accessories on change
+	ConfigNumber uint32 `json:"config_number"`
+	ConfigHash   string `json:"config_hash"`
+}
+
+// storage keep bridge state in the JSON file
+type storage struct {
+	path string
+	lock sync.RWMutex
+	data storageData
+}
+
+// newStorage load bridge state or create new one
+func newStorage(path string) (*storage, error) {
+	s := &storage{
+		path: path,
+	}
+
+	data, e := os.ReadFile(path)
+	switch {
+	case nil == e:
+		if e = json.Unmarshal(data, &s.data); nil != e {
+			return nil, fmt.Errorf("%s: %w", path, e)
+		}
+	case errors.Is(e, os.ErrNotExist):
+	default:
+		return nil, e
+	}
+
+	if 0 == len(s.data.PairingID) || ed25519.PrivateKeySize != len(s.data.PrivateKey) {
+		if e = s.data.generate(); nil != e {
+			return nil, e
+		}
+	}
+
+	if nil == s.data.Pairings {
+		s.data.Pairings = make(map[string]pairing)
+	}
+	if nil == s.data.Accessories {
+		s.data.Accessories = make(map[string]uint64)
+	}
+	if s.data.NextID < firstAccessoryID {
+		s.data.NextID = firstAccessoryID
+	}
+	if 0 == s.data.ConfigNumber {
+		s.data.ConfigNumber = 1
+	}
+
+	return s, s.save()
+}
+
+// generate create new accessory identity
+func (d *storageData) generate() error {
+	id := make([]byte, 6)
+	if _, e := rand.Read(id); nil != e {
+		return e
+	}
+	d.PairingID = fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", id[0], id[1], id[2], id[3], id[4], id[5])
+
+	_, privateKey, e := ed25519.GenerateKey(rand.Reader)
+	if nil != e {
+		return e
+	}
+	d.PrivateKey = privateKey
+
+	// Controllers paired with previous identity are invalid
+	d.Pairings = make(map[string]pairing)
+
+	return nil
+}
+
+// save write state to the file, caller must hold the lock or be the only user
+func (s *storage) save() error {
+	data, e := json.MarshalIndent(&s.data, "", "  ")
+	if nil != e {
+		return e
+	}
+
+	// Write to the temporary file first, so state isn't lost if write interrupted
+	temporary := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if e = os.WriteFile(temporary, data, 0600); nil != e {
+		return e
+	}
+
+	return os.Rename(temporary, s.path)
+}
+
+// PairingID return accessory pairing identifier
+func (s *storage) PairingID() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.data.PairingID
+}
+
+// PrivateKey return accessory long-term private key
+func (s *storage) PrivateKey() ed25519.PrivateKey {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.data.PrivateKey
+}
+
+// Paired return true when at least one controller paired
+func (s *storage) Paired() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.data.Pairings) > 0
+}
+
+// Pairing return paired controller
+func (s *storage) Pairing(controllerID string) (pairing, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p, found := s.data.Pairings[controllerID]
+
+	return p, found
+}
+
+// Pairings return all paired controllers
+func (s *storage) Pairings() map[string]pairing {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make(map[string]pairing, len(s.data.Pairings))
+	for id, p := range s.data.Pairings {
+		result[id] = p
+	}
+
+	return result
+}
+
+// AddPairing add or update paired controller
+func (s *storage) AddPairing(controllerID string, p pairing) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Pairings[controllerID] = p
+
+	return s.save()
+}
+
+// RemovePairing remove paired controller. All pairings are removed with the last admin controller.
+func (s *storage) RemovePairing(controllerID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.data.Pairings, controllerID)
+
+	admin := false
+	for _, p := range s.data.Pairings {
+		admin = admin || p.Admin
+	}
+	if !admin {
+		s.data.Pairings = make(map[string]pairing)
+	}
+
+	return s.save()
+}
+
+// AccessoryID return stable accessory ID for the endpoint
+func (s *storage) AccessoryID(endpointID string) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id, found := s.data.Accessories[endpointID]; found {
+		return id, nil
+	}
+
+	id := s.data.NextID
+	s.data.NextID++
+	s.data.Accessories[endpointID] = id
+
+	return id, s.save()
+}
+
+// ConfigNumber return configuration number, it's incremented when configuration hash changed
+func (s *storage) ConfigNumber(hash string) (uint32, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if hash == s.data.ConfigHash {
+		return s.data.ConfigNumber, nil
+	}
+
+	s.data.ConfigHash = hash
+	s.data.ConfigNumber++
+	// Configuration number is 1..65535
+	if s.data.ConfigNumber > 65535 {
+		s.data.ConfigNumber = 1
+	}
+
+	return s.data.ConfigNumber, s.save()
+}
@@ -0,0 +1,135 @@
+package homekit
+
+import (
+	"errors"
+)
+
+// TLV8 item types used by pairing
+const (
+	tlvMethod        = 0x00
+	tlvIdentifier    = 0x01
+	tlvSalt          = 0x02
+	tlvPublicKey     = 0x03
+	tlvProof         = 0x04
+	tlvEncryptedData = 0x05
+	tlvState         = 0x06
+	tlvError         = 0x07
+	tlvSignature     = 0x0A
+	tlvPermissions   = 0x0B
+	tlvSeparator     = 0xFF
+)
+
+// Pairing errors
+const (
+	tlvErrorUnknown        = 0x01
+	tlvErrorAuthentication = 0x02
+	tlvErrorMaxPeers       = 0x04
+	tlvErrorMaxTries       = 0x05
+	tlvErrorUnavailable    = 0x06
+)
+
+// Pairing methods
+const (
+	methodPairSetup      = 0x00
+	methodAddPairing     = 0x03
+	methodRemovePairing  = 0x04
+	methodListPairings   = 0x05
+	tlvMaxFragmentLength = 255
+)
+
+var (
+	// errInvalidTLV returned when TLV8 data is truncated
+	errInvalidTLV = errors.New("invalid TLV8 data")
+)
+
+// tlvItem is single TLV8 item
+type tlvItem struct {
+	Type  byte
+	Value []byte
+}
+
+// tlv is ordered TLV8 container, values longer than 255 bytes are fragmented on encoding
+type tlv []tlvItem
+
+// decodeTLV decode TLV8 data, fragments of the same type are merged
+func decodeTLV(data []byte) (tlv, error) {
+	var result tlv
+
+	// fragmented is true when previous item has maximal length, so next item of the same type continue it
+	fragmented := false
+
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, errInvalidTLV
+		}
+
+		itemType, value := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+
+		if last := len(result) - 1; fragmented && result[last].Type == itemType {
+			result[last].Value = append(result[last].Value, value...)
+		} else {
+			result = append(result, tlvItem{Type: itemType, Value: append([]byte(nil), value...)})
+		}
+
+		fragmented = tlvMaxFragmentLength == len(value)
+	}
+
+	return result, nil
+}
+
+// Get return value of the first item with the type
+func (t tlv) Get(itemType byte) []byte {
+	for _, item := range t {
+		if item.Type == itemType {
+			return item.Value
+		}
+	}
+
+	return nil
+}
+
+// Byte return single byte value of the item, 0 if not found
+func (t tlv) Byte(itemType byte) byte {
+	if value := t.Get(itemType); len(value) > 0 {
+		return value[0]
+	}
+
+	return 0
+}
+
+// Add append item to the container
+func (t tlv) Add(itemType byte, value []byte) tlv {
+	return append(t, tlvItem{Type: itemType, Value: value})
+}
+
+// AddByte append single byte item to the container
+func (t tlv) AddByte(itemType byte, value byte) tlv {
+	return t.Add(itemType, []byte{value})
+}
+
+// Encode return TLV8 data
+func (t tlv) Encode() []byte {
+	var result []byte
+
+	for _, item := range t {
+		value := item.Value
+		if 0 == len(value) {
+			result = append(result, item.Type, 0)
+			continue
+		}
+
+		for len(value) > 0 {
+			size := len(value)
+			if size > tlvMaxFragmentLength {
+				size = tlvMaxFragmentLength
+			}
+
+			result = append(result, item.Type, byte(size))
+			result = append(result, value[:size]...)
+			value = value[size:]
+		}
+	}
+
+	return result
+}
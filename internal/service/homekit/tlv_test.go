@@ -0,0 +1,86 @@
+package homekit
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTLVEncode(t *testing.T) {
+	long := bytes.Repeat([]byte{'a'}, 300)
+
+	for _, test := range []struct {
+		name  string
+		items tlv
+		want  []byte
+	}{
+		{"state and identifier", tlv{}.AddByte(tlvState, 3).Add(tlvIdentifier, []byte("hello")),
+			[]byte{0x06, 0x01, 0x03, 0x01, 0x05, 'h', 'e', 'l', 'l', 'o'}},
+		{"empty value", tlv{}.Add(tlvSeparator, nil), []byte{0xFF, 0x00}},
+		{"fragmented value", tlv{}.Add(tlvPublicKey, long).AddByte(tlvState, 2),
+			append(append(append([]byte{0x03, 0xFF}, long[:255]...), 0x03, 45), append(long[255:], 0x06, 0x01, 0x02)...)},
+		{"value of maximal length", tlv{}.Add(tlvProof, long[:255]),
+			append([]byte{0x04, 0xFF}, long[:255]...)},
+	} {
+		got := test.items.Encode()
+		if !bytes.Equal(test.want, got) {
+			t.Errorf("%s: got % X, want % X", test.name, got, test.want)
+			continue
+		}
+
+		decoded, e := decodeTLV(got)
+		if nil != e {
+			t.Fatalf("%s: %v", test.name, e)
+		}
+
+		if !reflect.DeepEqual(test.items, decoded) {
+			t.Errorf("%s: round trip got %+v, want %+v", test.name, decoded, test.items)
+		}
+	}
+}
+
+func TestTLVDecode(t *testing.T) {
+	// Pairings list is separated, so the items of the same type aren't merged
+	items, e := decodeTLV([]byte{
+		0x06, 0x01, 0x02,
+		0x01, 0x01, 'a', 0x0B, 0x01, 0x01,
+		0xFF, 0x00,
+		0x01, 0x01, 'b', 0x0B, 0x01, 0x00,
+	})
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 6 != len(items) || 2 != items.Byte(tlvState) || "a" != string(items.Get(tlvIdentifier)) ||
+		"b" != string(items[4].Value) || 0 != items[5].Value[0] {
+		t.Fatalf("got %+v", items)
+	}
+	if nil != items.Get(tlvError) || 0 != items.Byte(tlvError) {
+		t.Fatal("absent item is found")
+	}
+
+	// Short item of the same type is the next item, not a fragment
+	if items, e = decodeTLV([]byte{0x01, 0x01, 'a', 0x01, 0x01, 'b'}); nil != e || 2 != len(items) {
+		t.Fatalf("got %+v, %v", items, e)
+	}
+
+	// Decoded values don't share memory with the data
+	data := []byte{0x01, 0x01, 'a'}
+	if items, e = decodeTLV(data); nil != e {
+		t.Fatal(e)
+	}
+	data[2] = 'b'
+	if "a" != string(items.Get(tlvIdentifier)) {
+		t.Fatal("decoded value is changed with the data")
+	}
+
+	for _, data := range [][]byte{
+		{0x06},
+		{0x06, 0x02, 0x01},
+		{0x03, 0xFF, 0x00},
+	} {
+		if _, e = decodeTLV(data); !errors.Is(e, errInvalidTLV) {
+			t.Errorf("% X: got %v, want %v", data, e, errInvalidTLV)
+		}
+	}
+}
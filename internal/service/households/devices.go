@@ -0,0 +1,121 @@
+package households
+
+import (
+	"context"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// userDevices is device manager which expose only devices allowed to the user
+type userDevices struct {
+	deviceManager api.DeviceManager
+	allowed       permissions
+}
+
+// AddDevice is implementation of api.DeviceManager interface
+func (manager *userDevices) AddDevice(deviceID string, device api.Device) error {
+	return manager.deviceManager.AddDevice(deviceID, device)
+}
+
+// GetDevice is implementation of api.DeviceManager interface
+func (manager *userDevices) GetDevice(deviceID string) (api.Device, error) {
+	channels, found := manager.allowed[deviceID]
+	if !found {
+		return nil, api.ErrDeviceNotFound
+	}
+
+	device, e := manager.deviceManager.GetDevice(deviceID)
+	if nil != e {
+		return nil, e
+	}
+
+	if nil == channels {
+		return device, nil
+	}
+
+	return newUserDevice(device, channels), nil
+}
+
+// FindDevice is implementation of api.DeviceManager interface
+func (manager *userDevices) FindDevice(route api.Route) (string, api.Device, error) {
+	deviceID, _, e := manager.deviceManager.FindDevice(route)
+	if nil != e {
+		return "", nil, e
+	}
+
+	device, e := manager.GetDevice(deviceID)
+	if nil != e {
+		return "", nil, e
+	}
+
+	return deviceID, device, nil
+}
+
+// EnumDevices is implementation of api.DeviceManager interface
+func (manager *userDevices) EnumDevices() (map[string]api.Device, error) {
+	devices, e := manager.deviceManager.EnumDevices()
+	if nil != e {
+		return nil, e
+	}
+
+	result := make(map[string]api.Device)
+	for deviceID, device := range devices {
+		channels, found := manager.allowed[deviceID]
+		if !found {
+			continue
+		}
+
+		if nil != channels {
+			device = newUserDevice(device, channels)
+		}
+
+		result[deviceID] = device
+	}
+
+	return result, nil
+}
+
+// userDevice is device with only allowed channels
+type userDevice struct {
+	api.Device
+	channels map[int]bool
+}
+
+// newUserDevice return device with only allowed channels
+func newUserDevice(device api.Device, channels map[int]bool) *userDevice {
+	return &userDevice{
+		Device:   device,
+		channels: channels,
+	}
+}
+
+// GetChannels is implementation of api.Device interface
+func (d *userDevice) GetChannels() []api.Channel {
+	var result []api.Channel
+	for _, channel := range d.Device.GetChannels() {
+		if d.channels[channel.Index] {
+			result = append(result, channel)
+		}
+	}
+
+	return result
+}
+
+// GetSensors is implementation of api.Device interface
+func (d *userDevice) GetSensors() []api.Sensor {
+	// Channel 0 is device sensors
+	if !d.channels[0] {
+		return nil
+	}
+
+	return d.Device.GetSensors()
+}
+
+// Execute is implementation of api.Device interface
+func (d *userDevice) Execute(ctx context.Context, command api.Command) error {
+	if !d.channels[command.Channel] {
+		return api.ErrDeviceNotFound
+	}
+
+	return d.Device.Execute(ctx, command)
+}
@@ -0,0 +1,192 @@
+package households
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// envHouseholdsConfig is path to the JSON file with households description
+	envHouseholdsConfig = "HOUSEHOLDS_CONFIG"
+	// allDevices is device reference which allow access to all devices
+	allDevices = "*"
+	// channelSeparator separate device ID and channel index in the device reference
+	channelSeparator = ":"
+)
+
+// Household is group of devices shared by the group of users
+type Household struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name,omitempty"`
+	Users []string `json:"users"`
+	// Devices is device IDs, or device IDs with channel index separated by colon. Channel 0 is device sensors.
+	Devices []string `json:"devices"`
+}
+
+// config is households configuration file content
+type config struct {
+	Households []Household `json:"households"`
+}
+
+// permissions is device channels allowed to the user. Nil channels means all channels allowed.
+type permissions map[string]map[int]bool
+
+// Service is households service implementation
+type Service struct {
+	runnable.Runnable
+	bus           eventbus.Bus
+	deviceManager api.DeviceManager
+	// unlinkTopics is events topics where front-ends put user ID when user unlinked accounts
+	unlinkTopics []string
+	// users is permissions of each user, nil when access isn't restricted
+	users map[string]permissions
+	lock  sync.Mutex
+	// bindings is device managers bound to the users on first access, they're dropped when user unlink accounts
+	bindings map[string]api.DeviceManager
+}
+
+// NewService return new service implementation, bindings of the user are dropped on events of the unlink topics
+func NewService(bus eventbus.Bus, deviceManager api.DeviceManager, unlinkTopics ...string) (service *Service, e error) {
+	service = &Service{
+		bus:           bus,
+		deviceManager: deviceManager,
+		unlinkTopics:  unlinkTopics,
+		bindings:      make(map[string]api.DeviceManager),
+	}
+
+	if path, found := os.LookupEnv(envHouseholdsConfig); found {
+		if service.users, e = loadConfig(path); nil != e {
+			return nil, e
+		}
+	}
+
+	return service, nil
+}
+
+// loadConfig return users permissions from the configuration file
+func loadConfig(path string) (map[string]permissions, error) {
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return nil, e
+	}
+
+	var c config
+	if e = json.Unmarshal(data, &c); nil != e {
+		return nil, fmt.Errorf("households config %s: %w", path, e)
+	}
+
+	users := make(map[string]permissions)
+	for _, household := range c.Households {
+		for _, userID := range household.Users {
+			allowed, found := users[userID]
+			if !found {
+				allowed = make(permissions)
+				users[userID] = allowed
+			}
+
+			for _, reference := range household.Devices {
+				if e = allowed.add(reference); nil != e {
+					return nil, fmt.Errorf("household %s: %w", household.ID, e)
+				}
+			}
+		}
+	}
+
+	return users, nil
+}
+
+// add allow access to the device or device channel
+func (allowed permissions) add(reference string) error {
+	reference = strings.TrimSpace(reference)
+	if 0 == len(reference) {
+		return fmt.Errorf("empty device reference")
+	}
+
+	index := strings.LastIndex(reference, channelSeparator)
+	if index < 0 {
+		// Whole device allowed
+		allowed[reference] = nil
+		return nil
+	}
+
+	deviceID := reference[:index]
+	channel, e := strconv.Atoi(reference[index+1:])
+	if nil != e {
+		return fmt.Errorf("invalid device reference %s: %w", reference, e)
+	}
+
+	channels, found := allowed[deviceID]
+	if found && nil == channels {
+		// Whole device already allowed
+		return nil
+	}
+
+	if !found {
+		channels = make(map[int]bool)
+		allowed[deviceID] = channels
+	}
+	channels[channel] = true
+
+	return nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	for _, topic := range service.unlinkTopics {
+		if e := service.bus.Subscribe(topic, service.onUnlink); nil != e {
+			return e
+		}
+		defer func(topic string) {
+			_ = service.bus.Unsubscribe(topic, service.onUnlink)
+		}(topic)
+	}
+
+	// Wait until operation complete
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// UserDevices is implementation of api.AccessControl interface
+func (service *Service) UserDevices(userID string) api.DeviceManager {
+	if nil == service.users {
+		// Access isn't restricted
+		return service.deviceManager
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if deviceManager, found := service.bindings[userID]; found {
+		return deviceManager
+	}
+
+	var deviceManager api.DeviceManager = service.deviceManager
+	allowed := service.users[userID]
+	if _, found := allowed[allDevices]; !found {
+		deviceManager = &userDevices{
+			deviceManager: service.deviceManager,
+			allowed:       allowed,
+		}
+	}
+	service.bindings[userID] = deviceManager
+
+	return deviceManager
+}
+
+// onUnlink called when user unlinked accounts
+func (service *Service) onUnlink(userID string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	delete(service.bindings, userID)
+}
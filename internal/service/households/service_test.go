@@ -0,0 +1,243 @@
+package households
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// testUnlinked is unlink topic of the test front-end
+	testUnlinked = "test:unlinked"
+	// testLightID is light of the ivan household
+	testLightID = "tasmota_AABBCCDDEEFF"
+	// testRelayID is two channels relay with temperature sensor, its first channel is shared with maria
+	testRelayID = "tasmota_112233445566"
+	// testHeaterID is relay of the maria household
+	testHeaterID = "tasmota_665544332211"
+)
+
+// testConfig is households of the test users, admin see all devices
+const testConfig = `{"households": [
+	{"id": "ivan", "users": ["ivan"], "devices": ["` + testLightID + `", "` + testRelayID + `"]},
+	{"id": "maria", "users": ["maria"], "devices": ["` + testHeaterID + `", "` + testRelayID + `:1"]},
+	{"id": "all", "users": ["admin"], "devices": ["*"]}
+]}`
+
+// newTestService return households service of the test devices
+func newTestService(t *testing.T, bus eventbus.Bus) (*Service, apitest.Devices) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "households.json")
+	if e := os.WriteFile(path, []byte(testConfig), 0600); nil != e {
+		t.Fatal(e)
+	}
+	t.Setenv(envHouseholdsConfig, path)
+
+	stateCache := apitest.NewStates()
+	devices := apitest.Devices{
+		testLightID: &apitest.Device{
+			ID:         testLightID,
+			Channels:   []api.Channel{{Index: 1, Type: api.ChannelLight, Light: api.LightRGBCW}},
+			StateCache: stateCache,
+		},
+		testRelayID: &apitest.Device{
+			ID: testRelayID,
+			Channels: []api.Channel{
+				{Index: 1, Type: api.ChannelRelay},
+				{Index: 2, Type: api.ChannelRelay},
+			},
+			Sensors:    []api.Sensor{{Kind: api.SensorTemperature}},
+			StateCache: stateCache,
+		},
+		testHeaterID: &apitest.Device{
+			ID:         testHeaterID,
+			Channels:   []api.Channel{{Index: 1, Type: api.ChannelRelay}},
+			StateCache: stateCache,
+		},
+	}
+
+	service, e := NewService(bus, devices, testUnlinked)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return service, devices
+}
+
+// bound return true when device manager is bound to the user
+func (service *Service) bound(userID string) bool {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	_, found := service.bindings[userID]
+	return found
+}
+
+func TestUnlink(t *testing.T) {
+	bus := eventbus.New()
+	service, _ := newTestService(t, bus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for deadline := time.Now().Add(time.Second); !bus.HasCallback(testUnlinked); {
+		if time.Now().After(deadline) {
+			t.Fatal("service isn't started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ivan := service.UserDevices("ivan")
+	maria := service.UserDevices("maria")
+	if !service.bound("ivan") || !service.bound("maria") {
+		t.Fatal("device managers aren't bound to the users")
+	}
+	if ivan != service.UserDevices("ivan") {
+		t.Error("device manager of the user isn't reused")
+	}
+
+	bus.Publish(testUnlinked, "ivan")
+
+	if service.bound("ivan") {
+		t.Error("binding of the unlinked user isn't dropped")
+	}
+	if !service.bound("maria") || maria != service.UserDevices("maria") {
+		t.Error("binding of the other user is dropped")
+	}
+
+	// Household of the user isn't changed by unlink, so user see the same devices after link
+	devices, e := service.UserDevices("ivan").EnumDevices()
+	if nil != e || 2 != len(devices) {
+		t.Errorf("got devices %v: %v", devices, e)
+	}
+}
+
+// channelIndexes return indexes of the device channels, sensors are channel 0
+func channelIndexes(device api.Device) []int {
+	var indexes []int
+	if len(device.GetSensors()) > 0 {
+		indexes = append(indexes, 0)
+	}
+	for _, channel := range device.GetChannels() {
+		indexes = append(indexes, channel.Index)
+	}
+
+	return indexes
+}
+
+func TestUserDevices(t *testing.T) {
+	tests := []struct {
+		userID string
+		want   map[string][]int
+	}{
+		{
+			userID: "ivan",
+			want:   map[string][]int{testLightID: {1}, testRelayID: {0, 1, 2}},
+		},
+		{
+			userID: "maria",
+			want:   map[string][]int{testHeaterID: {1}, testRelayID: {1}},
+		},
+		{
+			userID: "admin",
+			want:   map[string][]int{testLightID: {1}, testRelayID: {0, 1, 2}, testHeaterID: {1}},
+		},
+		{
+			userID: "unknown",
+			want:   map[string][]int{},
+		},
+	}
+
+	service, _ := newTestService(t, eventbus.New())
+
+	for _, test := range tests {
+		t.Run(test.userID, func(t *testing.T) {
+			devices, e := service.UserDevices(test.userID).EnumDevices()
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			got := make(map[string][]int, len(devices))
+			for deviceID, device := range devices {
+				got[deviceID] = channelIndexes(device)
+			}
+
+			if !reflect.DeepEqual(test.want, got) {
+				t.Errorf("got devices %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserDevicesAccess(t *testing.T) {
+	service, devices := newTestService(t, eventbus.New())
+	maria := service.UserDevices("maria")
+
+	// Device of other household isn't found by ID and by route
+	if _, e := maria.GetDevice(testLightID); !errors.Is(e, api.ErrDeviceNotFound) {
+		t.Errorf("got device of other household: %v", e)
+	}
+	if _, _, e := maria.FindDevice(devices[testLightID].GetRoute()); !errors.Is(e, api.ErrDeviceNotFound) {
+		t.Errorf("found device of other household: %v", e)
+	}
+
+	// Shared device expose only allowed channel
+	relay, e := maria.GetDevice(testRelayID)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if got := channelIndexes(relay); !reflect.DeepEqual([]int{1}, got) {
+		t.Errorf("got relay channels %v", got)
+	}
+
+	deviceID, found, e := maria.FindDevice(devices[testRelayID].GetRoute())
+	if nil != e || testRelayID != deviceID || !reflect.DeepEqual([]int{1}, channelIndexes(found)) {
+		t.Errorf("found relay %s %v: %v", deviceID, found, e)
+	}
+
+	// Channel of other household can't be controlled, even if command refer it directly
+	command := api.Command{Type: api.CommandOnOff, Channel: 2, On: true}
+	if e = relay.Execute(context.Background(), command); !errors.Is(e, api.ErrDeviceNotFound) {
+		t.Errorf("executed command of other household: %v", e)
+	}
+
+	command.Channel = 1
+	if e = relay.Execute(context.Background(), command); nil != e {
+		t.Errorf("command of the allowed channel failed: %v", e)
+	}
+
+	want := []api.Command{command}
+	if got := devices[testRelayID].(*apitest.Device).Executed(); !reflect.DeepEqual(want, got) {
+		t.Errorf("got commands %v, want %v", got, want)
+	}
+}
+
+func TestUnrestricted(t *testing.T) {
+	devices := apitest.Devices{testLightID: &apitest.Device{ID: testLightID}}
+
+	service, e := NewService(eventbus.New(), devices)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// Without households config every user see all devices
+	if got, _ := service.UserDevices("unknown").EnumDevices(); 1 != len(got) {
+		t.Errorf("got devices %v", got)
+	}
+}
@@ -0,0 +1,23 @@
+package httpserver
+
+import (
+	"expvar"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerDebug add runtime metrics and profiling endpoints, they must be reachable only by the administrators
+func registerDebug(router gin.IRouter) {
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
+	profiles := router.Group("/debug/pprof")
+	profiles.GET("/", gin.WrapF(pprof.Index))
+	profiles.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	profiles.GET("/profile", gin.WrapF(pprof.Profile))
+	profiles.GET("/symbol", gin.WrapF(pprof.Symbol))
+	profiles.POST("/symbol", gin.WrapF(pprof.Symbol))
+	profiles.GET("/trace", gin.WrapF(pprof.Trace))
+	// Index serve named profiles, e.g. heap and goroutine
+	profiles.GET("/:name", gin.WrapF(pprof.Index))
+}
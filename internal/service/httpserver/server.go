@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pior/runnable"
+)
+
+const (
+	// unixPrefix is prefix of the listen address which is unix socket path, e.g. "unix:/run/alisa/admin.sock"
+	unixPrefix = "unix:"
+	// unixSocketMode is permissions of the unix socket, only owner and group may connect
+	unixSocketMode = 0660
+	// ShutdownTimeout is time to drain requests of the listener on shutdown
+	ShutdownTimeout = time.Second * 30
+)
+
+type httpServer struct {
+	server          *http.Server
+	network         string
+	address         string
+	shutdownTimeout time.Duration
+}
+
+// newServer returns a runnable that runs a *http.Server on the TCP address or unix socket. TLS is used when server
+// has TLS configuration.
+func newServer(server *http.Server, listen string) runnable.Runnable {
+	result := &httpServer{server: server, network: "tcp", address: listen, shutdownTimeout: ShutdownTimeout}
+
+	if strings.HasPrefix(listen, unixPrefix) {
+		result.network, result.address = "unix", strings.TrimPrefix(listen, unixPrefix)
+	}
+
+	return result
+}
+
+// listen return listener of the server address
+func (r *httpServer) listen() (net.Listener, error) {
+	if "unix" != r.network {
+		return net.Listen(r.network, r.address)
+	}
+
+	// Socket left by the crashed process prevent listening, other files are never removed
+	if info, e := os.Lstat(r.address); nil == e && 0 != info.Mode()&os.ModeSocket {
+		if e = os.Remove(r.address); nil != e {
+			return nil, e
+		}
+	}
+
+	listener, e := net.Listen(r.network, r.address)
+	if nil != e {
+		return nil, e
+	}
+
+	if e = os.Chmod(r.address, unixSocketMode); nil != e {
+		_ = listener.Close()
+		return nil, e
+	}
+
+	return listener, nil
+}
+
+// Run is implementation of Runnable interface
+func (r *httpServer) Run(ctx context.Context) error {
+	listener, e := r.listen()
+	if nil != e {
+		return fmt.Errorf("http_server: %w", e)
+	}
+
+	errChan := make(chan error)
+
+	go func() {
+		log.Printf("http_server: listening on %s", listener.Addr())
+		if nil == r.server.TLSConfig {
+			errChan <- r.server.Serve(listener)
+		} else {
+			errChan <- r.server.ServeTLS(listener, "", "")
+		}
+	}()
+
+	var err error
+	var shutdownErr error
+
+	select {
+	case <-ctx.Done():
+		log.Printf("http_server: shutdown %s", listener.Addr())
+		shutdownErr = r.shutdown()
+		err = <-errChan
+	case err = <-errChan:
+		log.Printf("http_server: shutdown %s (err: %s)", listener.Addr(), err)
+		shutdownErr = r.shutdown()
+	}
+
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	if err == nil && shutdownErr != nil {
+		err = fmt.Errorf("server shutdown: %w", shutdownErr)
+	}
+
+	return err
+}
+
+func (r *httpServer) shutdown() error {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, r.shutdownTimeout)
+	defer cancel()
+
+	return r.server.Shutdown(ctx)
+}
@@ -1,9 +1,12 @@
 package httpserver
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pior/runnable"
@@ -12,12 +15,24 @@ import (
 const (
 	envCertificateChain = "CERTIFICATE_CHAIN"
 	envPrivateKey       = "PRIVATE_KEY"
+	// envTrustedProxies is comma separated addresses or networks of the reverse proxies, client address is taken
+	// from the X-Forwarded-For header only when request came from them
+	envTrustedProxies = "TRUSTED_PROXIES"
+	// envListen is address of the public listener, e.g. "0.0.0.0:8443" or "unix:/run/alisa/http.sock"
+	envListen     = "HTTP_LISTEN"
+	defaultListen = "0.0.0.0:8443"
+	// envAdminListen is address of the plain HTTP listener of the admin, metrics and debug endpoints, e.g.
+	// "127.0.0.1:8081" or "unix:/run/alisa/admin.sock". Empty value disable the listener.
+	envAdminListen     = "ADMIN_LISTEN"
+	defaultAdminListen = "127.0.0.1:8081"
 )
 
 // Service is HTTP(S) service implementation
 type Service struct {
 	runnable.Runnable
-	engine *gin.Engine
+	engine      *gin.Engine
+	adminEngine *gin.Engine
+	servers     []runnable.Runnable
 }
 
 // NewService return new service implementation
@@ -53,22 +68,84 @@ func NewService() (service *Service, e error) {
 		engine: gin.New(),
 	}
 
-	server := &http.Server{
-		Addr:      "0.0.0.0:8443",
+	// Forwarded address of the direct request is spoofed easily, so it's never trusted by default
+	var trustedProxies []string
+	if value, found := os.LookupEnv(envTrustedProxies); found {
+		for _, proxy := range strings.Split(value, ",") {
+			if proxy = strings.TrimSpace(proxy); len(proxy) > 0 {
+				trustedProxies = append(trustedProxies, proxy)
+			}
+		}
+	}
+	if e = service.engine.SetTrustedProxies(trustedProxies); nil != e {
+		return nil, e
+	}
+
+	listen := defaultListen
+	if value, found := os.LookupEnv(envListen); found {
+		if 0 == len(value) {
+			return nil, fmt.Errorf("%s must not be empty", envListen)
+		}
+		listen = value
+	}
+
+	service.servers = append(service.servers, newServer(&http.Server{
 		TLSConfig: tlsConfig,
 		Handler:   service.engine,
+	}, listen))
+
+	// Admin listener is reached directly, so forwarded addresses are never trusted
+	service.adminEngine = gin.New()
+	if e = service.adminEngine.SetTrustedProxies(nil); nil != e {
+		return nil, e
+	}
+	registerDebug(service.adminEngine)
+
+	adminListen := defaultAdminListen
+	if value, found := os.LookupEnv(envAdminListen); found {
+		adminListen = value
 	}
 
-	if nil == tlsConfig {
-		service.Runnable = runnable.HTTPServer(server)
-	} else {
-		service.Runnable = serverTLS(server)
+	if len(adminListen) > 0 {
+		service.servers = append(service.servers, newServer(&http.Server{
+			Handler: service.adminEngine,
+		}, adminListen))
 	}
 
 	return service, nil
 }
 
-// Router return routes controller
+// Run is implementation of runnable.Runnable interface. Listeners are stopped together, so failure of one of them
+// stop the service.
+func (service *Service) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errChan := make(chan error, len(service.servers))
+	for _, server := range service.servers {
+		go func(server runnable.Runnable) {
+			e := server.Run(ctx)
+			cancel()
+			errChan <- e
+		}(server)
+	}
+
+	var result error
+	for range service.servers {
+		if e := <-errChan; nil != e && nil == result {
+			result = e
+		}
+	}
+
+	return result
+}
+
+// Router return routes controller of the public listener
 func (service *Service) Router() gin.IRouter {
 	return service.engine
 }
+
+// AdminRouter return routes controller of the admin listener, its endpoints aren't reachable from the public one
+func (service *Service) AdminRouter() gin.IRouter {
+	return service.adminEngine
+}
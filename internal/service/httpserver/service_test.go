@@ -0,0 +1,186 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runService start the service, function returned stop it and return result of the run
+func runService(t *testing.T, service *Service) func() error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+
+	return func() error {
+		cancel()
+
+		select {
+		case e := <-done:
+			return e
+		case <-time.After(time.Second * 5):
+			t.Fatal("service isn't stopped")
+			return nil
+		}
+	}
+}
+
+// unixClient return HTTP client connected to the unix socket
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: time.Second,
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// Socket left by the crashed process is replaced
+	stale, e := net.Listen("unix", path)
+	if nil != e {
+		t.Fatal(e)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	t.Setenv(envListen, "127.0.0.1:0")
+	t.Setenv(envAdminListen, unixPrefix+path)
+
+	service, e := NewService()
+	if nil != e {
+		t.Fatal(e)
+	}
+	service.AdminRouter().GET("/ping", func(ginCtx *gin.Context) {
+		ginCtx.String(http.StatusOK, "pong")
+	})
+	stop := runService(t, service)
+
+	client := unixClient(path)
+	for deadline := time.Now().Add(time.Second * 5); ; {
+		response, e := client.Get("http://admin/ping")
+		if nil == e {
+			_ = response.Body.Close()
+			if http.StatusOK != response.StatusCode {
+				t.Fatalf("status %d", response.StatusCode)
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("admin socket isn't served: %v", e)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if info, e := os.Stat(path); nil != e || unixSocketMode != info.Mode().Perm() {
+		t.Errorf("socket mode %v: %v", info.Mode(), e)
+	}
+
+	if e = stop(); nil != e {
+		t.Fatalf("service stopped with error %v", e)
+	}
+	client.CloseIdleConnections()
+}
+
+func TestUnixSocketFileNotRemoved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// File which isn't socket may be put by mistake, it's never removed
+	if e := os.WriteFile(path, []byte("data"), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	server := newServer(&http.Server{Handler: gin.New()}, unixPrefix+path)
+	if e := server.Run(context.Background()); nil == e {
+		t.Fatal("server listen on the regular file")
+	}
+
+	if data, e := os.ReadFile(path); nil != e || "data" != string(data) {
+		t.Fatalf("file is changed: %v", e)
+	}
+}
+
+func TestAdminListen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range []struct {
+		name    string
+		value   string
+		servers int
+	}{
+		{"address", "127.0.0.1:0", 2},
+		{"unix socket", unixPrefix + filepath.Join(t.TempDir(), "admin.sock"), 2},
+		{"disabled", "", 1},
+	} {
+		t.Setenv(envAdminListen, test.value)
+
+		service, e := NewService()
+		if nil != e {
+			t.Fatal(e)
+		}
+
+		if test.servers != len(service.servers) {
+			t.Errorf("%s: got %d servers, want %d", test.name, len(service.servers), test.servers)
+		}
+	}
+
+	// Public listener can't be disabled
+	t.Setenv(envListen, "")
+	if _, e := NewService(); nil == e {
+		t.Fatal("empty public listener is accepted")
+	}
+}
+
+func TestListenerFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Public address is busy, so admin listener is stopped too
+	busy, e := net.Listen("tcp", "127.0.0.1:0")
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer func() {
+		_ = busy.Close()
+	}()
+
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	t.Setenv(envListen, busy.Addr().String())
+	t.Setenv(envAdminListen, unixPrefix+path)
+
+	service, e := NewService()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Run(context.Background())
+	}()
+
+	select {
+	case e = <-done:
+		if nil == e {
+			t.Fatal("failure of the public listener isn't returned")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("admin listener isn't stopped after failure of the public one")
+	}
+}
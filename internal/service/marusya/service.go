@@ -0,0 +1,89 @@
+package marusya
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/service/alisa"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// UserUnlinked is events topic where service put user ID when user unlinked accounts
+	UserUnlinked = "marusya:unlinked"
+)
+
+const (
+	// envMarusyaEnabled enable Marusya front-end, e.g. "true"
+	envMarusyaEnabled     = "MARUSYA_ENABLED"
+	marusyaEndpointPrefix = "/marusya"
+	// envDevicesConfig is path to the JSON file with names, rooms and types of the Marusya devices
+	envDevicesConfig = "MARUSYA_DEVICES_CONFIG"
+	// envAlisaDevicesConfig is used when Marusya devices aren't configured separately
+	envAlisaDevicesConfig = "ALISA_DEVICES_CONFIG"
+	// envClientIDs is comma separated OAuth clients allowed to use Marusya front-end, Marusya client by default
+	envClientIDs = "MARUSYA_CLIENT_IDS"
+	// envMarusyaClientID is OAuth client of the Marusya skill
+	envMarusyaClientID = "MARUSYA_CLIENT_ID"
+)
+
+// Service is Marusya service implementation. Marusya smart home protocol is the same as Yandex one.
+type Service struct {
+	runnable.Runnable
+	// frontend is nil when Marusya front-end isn't enabled
+	frontend *alisa.Service
+}
+
+// NewService return new service implementation. Routes are registered only when front-end enabled.
+func NewService(router gin.IRouter,
+	bus eventbus.Bus,
+	oauthService *oauth.Service,
+	access api.AccessControl,
+	stateCache api.StateCache) (service *Service, e error) {
+	service = &Service{}
+
+	enabled := false
+	if value, found := os.LookupEnv(envMarusyaEnabled); found {
+		if enabled, e = strconv.ParseBool(value); nil != e {
+			return nil, e
+		}
+	}
+
+	if !enabled {
+		return service, nil
+	}
+
+	devicesConfig, found := os.LookupEnv(envDevicesConfig)
+	if !found {
+		devicesConfig = os.Getenv(envAlisaDevicesConfig)
+	}
+
+	// Marusya don't have callback API, so notifications disabled
+	if service.frontend, e = alisa.NewFrontend(router, bus, oauthService, access, stateCache, alisa.Config{
+		Prefix:        marusyaEndpointPrefix,
+		DevicesConfig: devicesConfig,
+		UnlinkTopic:   UserUnlinked,
+		ClientIDs:     oauth.ClientIDs(envClientIDs, envMarusyaClientID),
+	}); nil != e {
+		return nil, e
+	}
+
+	return service, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	if nil != service.frontend {
+		return service.frontend.Run(ctx)
+	}
+
+	// Wait until operation complete
+	<-ctx.Done()
+
+	return ctx.Err()
+}
@@ -0,0 +1,226 @@
+package marusya
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/alisa"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+const (
+	// testYandexClientID is OAuth client of the Yandex skill
+	testYandexClientID = "yandex"
+	// testMarusyaClientID is OAuth client of the Marusya skill
+	testMarusyaClientID = "marusya"
+	testRelayID         = "tasmota_AABBCCDDEEFF"
+	devicesPath         = "/v1.0/user/devices"
+	unlinkPath          = "/v1.0/user/unlink"
+)
+
+// testAccess give all devices to the user ivan
+type testAccess apitest.Devices
+
+func (access testAccess) UserDevices(userID string) api.DeviceManager {
+	if "ivan" == userID {
+		return apitest.Devices(access)
+	}
+
+	return apitest.Devices{}
+}
+
+// testService is Marusya front-end mounted next to the Alisa one, it accept JWT access tokens signed by the test key
+type testService struct {
+	router *gin.Engine
+	bus    eventbus.Bus
+	key    *ecdsa.PrivateKey
+	keyID  string
+}
+
+// newTestService return Marusya and Alisa front-ends on the same router, user ivan see the relay
+func newTestService(t *testing.T, enabled string) *testService {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+	gin.SetMode(gin.TestMode)
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != e {
+		t.Fatal(e)
+	}
+	data, e := x509.MarshalECPrivateKey(key)
+	if nil != e {
+		t.Fatal(e)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if e = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: data}), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	t.Setenv("OAUTH_STORAGE", ":memory:")
+	t.Setenv("OAUTH_JWT_KEYS", path)
+	t.Setenv("YANDEX_CLIENT_ID", testYandexClientID)
+	t.Setenv("YANDEX_CLIENT_SECRET", "secret")
+	t.Setenv(envMarusyaClientID, testMarusyaClientID)
+	t.Setenv("MARUSYA_CLIENT_SECRET", "secret")
+	t.Setenv("MARUSYA_CALLBACK_URL", "https://marusya.example/callback")
+	t.Setenv(envMarusyaEnabled, enabled)
+
+	bus := eventbus.New()
+	limiter, e := ratelimit.NewService(bus)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	router := gin.New()
+	oauthService, e := oauth.NewService(router, router, limiter)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	stateCache := apitest.NewStates()
+	_ = stateCache.UpdateState(testRelayID, func(state *api.State) {})
+	access := testAccess{testRelayID: &apitest.Device{
+		ID:         testRelayID,
+		Channels:   []api.Channel{{Index: 1, Type: api.ChannelRelay}},
+		StateCache: stateCache,
+	}}
+
+	if _, e = alisa.NewService(router, bus, oauthService, access, stateCache); nil != e {
+		t.Fatal(e)
+	}
+	if _, e = NewService(router, bus, oauthService, access, stateCache); nil != e {
+		t.Fatal(e)
+	}
+
+	result := &testService{router: router, bus: bus, key: key}
+
+	// Key ID is published by JWKS endpoint
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/oauth/jwks", nil))
+	if e = json.Unmarshal(recorder.Body.Bytes(), &jwks); nil != e || 1 != len(jwks.Keys) {
+		t.Fatalf("JWKS %s: %v", recorder.Body, e)
+	}
+	result.keyID = jwks.Keys[0].KeyID
+
+	return result
+}
+
+// token return JWT access token of the user ivan issued to the client
+func (service *testService) token(t *testing.T, clientID string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"sub":       "ivan",
+		"client_id": clientID,
+		"scope":     oauth.ScopeDevicesRead + " " + oauth.ScopeDevicesControl,
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = service.keyID
+
+	access, e := token.SignedString(service.key)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return access
+}
+
+// serve send request with the access token and return status of the response
+func (service *testService) serve(method string, path string, access string) int {
+	request := httptest.NewRequest(method, path, nil)
+	request.Header.Set("Authorization", "Bearer "+access)
+	request.Header.Set("X-Request-Id", "request")
+
+	recorder := httptest.NewRecorder()
+	service.router.ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func TestClients(t *testing.T) {
+	service := newTestService(t, "true")
+
+	yandex := service.token(t, testYandexClientID)
+	marusya := service.token(t, testMarusyaClientID)
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		access string
+		want   int
+	}{
+		{"Marusya token on Marusya front-end", marusyaEndpointPrefix + devicesPath, marusya, http.StatusOK},
+		{"Alisa token on Marusya front-end", marusyaEndpointPrefix + devicesPath, yandex, http.StatusForbidden},
+		{"Alisa token on Alisa front-end", "/alisa" + devicesPath, yandex, http.StatusOK},
+		{"Marusya token on Alisa front-end", "/alisa" + devicesPath, marusya, http.StatusForbidden},
+	} {
+		if got := service.serve(http.MethodGet, test.path, test.access); test.want != got {
+			t.Errorf("%s: status %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	service := newTestService(t, "true")
+
+	var lock sync.Mutex
+	unlinked := make(map[string][]string)
+	for _, topic := range []string{UserUnlinked, alisa.UserUnlinked} {
+		topic := topic
+		if e := service.bus.Subscribe(topic, func(userID string) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			unlinked[topic] = append(unlinked[topic], userID)
+		}); nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	if got := service.serve(http.MethodPost, marusyaEndpointPrefix+unlinkPath,
+		service.token(t, testMarusyaClientID)); http.StatusOK != got {
+		t.Fatalf("unlink: status %d", got)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if 1 != len(unlinked) || 1 != len(unlinked[UserUnlinked]) || "ivan" != unlinked[UserUnlinked][0] {
+		t.Errorf("unlinked users %v", unlinked)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	service := newTestService(t, "false")
+
+	if got := service.serve(http.MethodGet, marusyaEndpointPrefix+devicesPath,
+		service.token(t, testMarusyaClientID)); http.StatusNotFound != got {
+		t.Errorf("disabled front-end: status %d", got)
+	}
+}
@@ -20,6 +20,8 @@ const (
 	RxCommandsMQTT = "mqtt:commands"
 	// RxStatusesMQTT is events topic where service put received MQTT messages from status topic
 	RxStatusesMQTT = "mqtt:statuses"
+	// TxMQTT is events topic where services put MQTT messages to publish
+	TxMQTT = "mqtt:tx"
 )
 
 const (
@@ -29,11 +31,16 @@ const (
 	envMQTTPassword          = "MQTT_PASSWORD"
 	clientID                 = "alisa_service"
 	waitDisconnectCompleteMS = 1000
+	publishQoS               = 1
 	topicDiscovery           = "tasmota/discovery/#"
 	topicTelemetry           = "tele/#"
 	topicCommands            = "cmnd/#"
 	topicStatuses            = "stat/#"
-	topicPartsDelimiter      = "/"
+)
+
+const (
+	// TopicPartsDelimiter is delimiter of the MQTT topic name parts
+	TopicPartsDelimiter = "/"
 )
 
 // EventMQTT is MQTT event content
@@ -130,6 +137,13 @@ func (service *Service) Run(ctx context.Context) error {
 		_ = service.bus.Unsubscribe(RxStatusesMQTT, service.traceMessageStatus)
 	}()
 
+	if e := service.bus.Subscribe(TxMQTT, service.txMessage); nil != e {
+		return e
+	}
+	defer func() {
+		_ = service.bus.Unsubscribe(TxMQTT, service.txMessage)
+	}()
+
 	token := service.client.Connect()
 
 	contextDone := ctx.Done()
@@ -210,6 +224,16 @@ func (service *Service) traceMessageStatus(event EventMQTT) {
 	log.Log.Debug("Rx MQTT status", event.Topic, string(event.Payload[:]))
 }
 
+// txMessage publish message to the MQTT broker
+func (service *Service) txMessage(event EventMQTT) {
+	topic := strings.Join(event.Topic, TopicPartsDelimiter)
+
+	log.Log.Debug("Tx MQTT", topic, string(event.Payload[:]))
+
+	// Don't wait publishing, caller will wait device response if needed
+	service.client.Publish(topic, publishQoS, false, event.Payload)
+}
+
 // onMessage called when received message from MQTT
 func (service *Service) onMessage(_ mqttclient.Client, msg mqttclient.Message) {
 	log.Log.Debug("Publish message", msg)
@@ -218,7 +242,7 @@ func (service *Service) onMessage(_ mqttclient.Client, msg mqttclient.Message) {
 // NewEventMQTT return internal MQTT event representation
 func NewEventMQTT(topic string, payload []byte) EventMQTT {
 	return EventMQTT{
-		Topic:   strings.Split(topic, topicPartsDelimiter),
+		Topic:   strings.Split(topic, TopicPartsDelimiter),
 		Payload: payload,
 	}
 }
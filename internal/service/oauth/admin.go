@@ -0,0 +1,255 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+const (
+	// envAdminToken is bearer token of the admin API, the API is disabled when it isn't set
+	envAdminToken           = "ADMIN_TOKEN"
+	adminMinimalTokenLength = 16
+	adminEndpointPrefix     = "/admin"
+	adminEndpointGrants     = adminEndpointPrefix + "/grants"
+	adminEndpointGrant      = adminEndpointGrants + "/:id"
+	adminEndpointSessions   = adminEndpointPrefix + "/sessions"
+	adminEndpointSession    = adminEndpointSessions + "/:id"
+	// adminSessionRefLength is number of hex digits of the session digest shown as session ID
+	adminSessionRefLength = 16
+)
+
+// adminSession is login session shown by the admin API. Session ID is the cookie value, so only its digest
+// is shown.
+type adminSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// adminRevoked is result of the bulk revocation
+type adminRevoked struct {
+	Grants   int `json:"grants"`
+	Sessions int `json:"sessions"`
+}
+
+// adminToken return admin API token, empty token is returned when API is disabled
+func adminToken() (string, error) {
+	token := os.Getenv(envAdminToken)
+	if len(token) > 0 && len(token) < adminMinimalTokenLength {
+		return "", fmt.Errorf("%s must be at least %d characters", envAdminToken, adminMinimalTokenLength)
+	}
+
+	return token, nil
+}
+
+// registerAdmin add admin API endpoints, all of them require the admin token
+func (service *Service) registerAdmin(router gin.IRoutes, token string) {
+	digest := sha256.Sum256([]byte(token))
+	authorized := func(ginCtx *gin.Context) {
+		// Digests have the same length, so comparison time don't depend on the token length
+		access, _ := bearerToken(ginCtx.Request)
+		provided := sha256.Sum256([]byte(access))
+		if 1 != subtle.ConstantTimeCompare(digest[:], provided[:]) {
+			// Admin listener isn't rate limited, its clients share the local address with the administrator
+			log.Log.Warnw("Invalid admin token", "path", ginCtx.Request.URL.Path)
+			ginCtx.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			ginCtx.AbortWithStatusJSON(http.StatusUnauthorized, tokenError{Error: "invalid_token"})
+			return
+		}
+
+		ginCtx.Header("Cache-Control", "no-store")
+		ginCtx.Next()
+	}
+
+	router.GET(adminEndpointGrants, authorized, service.onListGrants)
+	router.DELETE(adminEndpointGrants, authorized, service.onRevokeGrants)
+	router.DELETE(adminEndpointGrant, authorized, service.onRevokeGrant)
+	router.GET(adminEndpointSessions, authorized, service.onListSessions)
+	router.DELETE(adminEndpointSession, authorized, service.onRevokeSession)
+}
+
+// adminFailed respond with the internal error
+func adminFailed(ginCtx *gin.Context, message string, e error) {
+	log.Log.Errorw(message, "error", e)
+	ginCtx.JSON(http.StatusServiceUnavailable, tokenError{Error: "temporarily_unavailable"})
+}
+
+// onListGrants return active grants, they're filtered by user_id and client_id query parameters
+func (service *Service) onListGrants(ginCtx *gin.Context) {
+	grants, e := service.tokenStore.Grants(grantFilter{
+		UserID:   ginCtx.Query("user_id"),
+		ClientID: ginCtx.Query("client_id"),
+	})
+	if nil != e {
+		adminFailed(ginCtx, "Grants aren't listed", e)
+		return
+	}
+
+	ginCtx.JSON(http.StatusOK, gin.H{"grants": grants})
+}
+
+// onRevokeGrant revoke single grant
+func (service *Service) onRevokeGrant(ginCtx *gin.Context) {
+	id := ginCtx.Param("id")
+
+	revoked, e := service.tokenStore.RevokeGrant(id)
+	if nil != e {
+		adminFailed(ginCtx, "Grant isn't revoked", e)
+		return
+	}
+
+	if nil == revoked {
+		ginCtx.JSON(http.StatusNotFound, tokenError{Error: "not_found"})
+		return
+	}
+
+	// Front-end drop the user state, as if user unlinked accounts
+	service.publishUnlinked([]grant{*revoked})
+
+	log.Log.Infow("Grant revoked by administrator", "grant", id)
+	ginCtx.Status(http.StatusNoContent)
+}
+
+// onRevokeGrants revoke all grants of the user or of the client, login sessions of the user are closed too
+func (service *Service) onRevokeGrants(ginCtx *gin.Context) {
+	filter := grantFilter{
+		UserID:   ginCtx.Query("user_id"),
+		ClientID: ginCtx.Query("client_id"),
+	}
+	if 0 == len(filter.UserID) && 0 == len(filter.ClientID) {
+		ginCtx.JSON(http.StatusBadRequest, tokenError{
+			Error:       "invalid_request",
+			Description: "user_id or client_id is required",
+		})
+		return
+	}
+
+	revoked, e := service.tokenStore.RevokeGrants(filter)
+	if nil != e {
+		adminFailed(ginCtx, "Grants aren't revoked", e)
+		return
+	}
+	service.publishUnlinked(revoked)
+
+	result := adminRevoked{Grants: len(revoked)}
+
+	// Logged-in user could authorize the client again without password
+	if len(filter.UserID) > 0 && 0 == len(filter.ClientID) {
+		if result.Sessions, e = service.revokeSessions(func(session *adminSession) bool {
+			return filter.UserID == session.UserID
+		}); nil != e {
+			adminFailed(ginCtx, "Sessions aren't revoked", e)
+			return
+		}
+	}
+
+	log.Log.Infow("Grants revoked by administrator",
+		"user_id", filter.UserID,
+		"client_id", filter.ClientID,
+		"grants", result.Grants,
+		"sessions", result.Sessions)
+	ginCtx.JSON(http.StatusOK, result)
+}
+
+// sessionRef return public ID of the login session
+func sessionRef(id string) string {
+	digest := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(digest[:])[:adminSessionRefLength]
+}
+
+// eachSession call f for each login session of the logged-in user
+func eachSession(tx *buntdb.Tx, f func(id string, session *adminSession)) error {
+	now := time.Now()
+
+	return tx.AscendGreaterOrEqual("", sessionKeyPrefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, sessionKeyPrefix) {
+			return false
+		}
+
+		var stored loginSession
+		if e := json.Unmarshal([]byte(value), &stored); nil != e || 0 == len(stored.UserID) {
+			return true
+		}
+
+		id := strings.TrimPrefix(key, sessionKeyPrefix)
+		session := adminSession{ID: sessionRef(id), UserID: stored.UserID}
+		if ttl, e := tx.TTL(key); nil == e && ttl > 0 {
+			session.ExpiresAt = now.Add(ttl)
+		}
+		f(id, &session)
+
+		return true
+	})
+}
+
+// onListSessions return login sessions of the logged-in users, they're filtered by user_id query parameter
+func (service *Service) onListSessions(ginCtx *gin.Context) {
+	userID := ginCtx.Query("user_id")
+
+	sessions := make([]adminSession, 0)
+	if e := service.db.View(func(tx *buntdb.Tx) error {
+		return eachSession(tx, func(_ string, session *adminSession) {
+			if 0 == len(userID) || userID == session.UserID {
+				sessions = append(sessions, *session)
+			}
+		})
+	}); nil != e {
+		adminFailed(ginCtx, "Sessions aren't listed", e)
+		return
+	}
+
+	ginCtx.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// onRevokeSession close single login session
+func (service *Service) onRevokeSession(ginCtx *gin.Context) {
+	ref := ginCtx.Param("id")
+
+	revoked, e := service.revokeSessions(func(session *adminSession) bool {
+		return ref == session.ID
+	})
+	if nil != e {
+		adminFailed(ginCtx, "Session isn't revoked", e)
+		return
+	}
+
+	if 0 == revoked {
+		ginCtx.JSON(http.StatusNotFound, tokenError{Error: "not_found"})
+		return
+	}
+
+	log.Log.Infow("Session revoked by administrator", "session", ref)
+	ginCtx.Status(http.StatusNoContent)
+}
+
+// revokeSessions remove login sessions selected by the function, it return number of removed sessions
+func (service *Service) revokeSessions(selected func(session *adminSession) bool) (int, error) {
+	var revoked int
+
+	e := service.db.Update(func(tx *buntdb.Tx) error {
+		var keys []string
+		if e := eachSession(tx, func(id string, session *adminSession) {
+			if selected(session) {
+				keys = append(keys, sessionKeyPrefix+id)
+			}
+		}); nil != e {
+			return e
+		}
+		revoked = len(keys)
+
+		return deleteKeys(tx, keys...)
+	})
+
+	return revoked, e
+}
@@ -0,0 +1,222 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+// testAdminToken is admin API token of the test service
+const testAdminToken = "admin-token-0123456789"
+
+// adminRequest send admin API request with the token, request without token is sent when it's empty
+type adminRequest func(method string, target string, token string) *httptest.ResponseRecorder
+
+// newTestAdmin return test service with admin API
+func newTestAdmin(t *testing.T) (*Service, *gin.Engine, adminRequest) {
+	t.Helper()
+
+	t.Setenv(envAdminToken, testAdminToken)
+	service, router := newTestService(t)
+
+	return service, router, func(method string, target string, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		request.RemoteAddr = "127.0.0.1:40000"
+		if len(token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+}
+
+// listGrants return owners of the grants listed by the admin API
+func listGrants(t *testing.T, admin adminRequest, query string) []string {
+	t.Helper()
+
+	response := admin(http.MethodGet, adminEndpointGrants+query, testAdminToken)
+	if http.StatusOK != response.Code {
+		t.Fatalf("grants%s: status %d", query, response.Code)
+	}
+
+	var result struct {
+		Grants []grant `json:"grants"`
+	}
+	if e := json.Unmarshal(response.Body.Bytes(), &result); nil != e {
+		t.Fatal(e)
+	}
+
+	return grantOwners(result.Grants)
+}
+
+func TestAdminAuth(t *testing.T) {
+	t.Setenv("RATE_LIMIT_MAX_FAILURES", "2")
+	service, _, admin := newTestAdmin(t)
+
+	for _, token := range []string{"", "guessed", testAdminToken + "x", testAdminToken[:len(testAdminToken)-1]} {
+		response := admin(http.MethodGet, adminEndpointGrants, token)
+		if http.StatusUnauthorized != response.Code || 0 == len(response.Header().Get("WWW-Authenticate")) {
+			t.Errorf("token %q: status %d", token, response.Code)
+		}
+	}
+
+	// Failures aren't counted for the address, it's shared by all local clients
+	if _, ok := service.limiter.AllowIP("127.0.0.1"); !ok {
+		t.Error("local address is locked")
+	}
+
+	response := admin(http.MethodGet, adminEndpointGrants, testAdminToken)
+	if http.StatusOK != response.Code || "no-store" != response.Header().Get("Cache-Control") {
+		t.Errorf("admin token: status %d, Cache-Control %q", response.Code, response.Header().Get("Cache-Control"))
+	}
+}
+
+func TestAdminShortToken(t *testing.T) {
+	t.Setenv(envAdminToken, "short")
+	if _, e := adminToken(); nil == e {
+		t.Fatal("short admin token is accepted")
+	}
+}
+
+func TestAdminGrants(t *testing.T) {
+	service, router, admin := newTestAdmin(t)
+
+	issue(t, service.tokenStore, "ivan", "yandex", "a1")
+	issue(t, service.tokenStore, "ivan", "google", "a2")
+	issue(t, service.tokenStore, "maria", "yandex", "a3")
+	issue(t, service.tokenStore, "petr", "sber", "a4")
+
+	for query, want := range map[string][]string{
+		"":                                {"ivan@google", "ivan@yandex", "maria@yandex", "petr@sber"},
+		"?user_id=ivan":                   {"ivan@google", "ivan@yandex"},
+		"?client_id=yandex":               {"ivan@yandex", "maria@yandex"},
+		"?user_id=ivan&client_id=yandex":  {"ivan@yandex"},
+		"?user_id=olga":                   {},
+		"?user_id=maria&client_id=google": {},
+	} {
+		if got := listGrants(t, admin, query); !reflect.DeepEqual(want, got) {
+			t.Errorf("grants%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	// Revocation of everything isn't allowed
+	if response := admin(http.MethodDelete, adminEndpointGrants, testAdminToken); http.StatusBadRequest != response.Code {
+		t.Errorf("revoke without filter: status %d", response.Code)
+	}
+
+	// Login sessions are closed when all grants of the user are revoked
+	authorizeResponse(t, router, authorizeRequest(nil))
+	if 1 != countKeys(t, service.db, sessionKeyPrefix) {
+		t.Fatal("login session isn't stored")
+	}
+
+	var revoked adminRevoked
+	response := admin(http.MethodDelete, adminEndpointGrants+"?user_id="+testUserID, testAdminToken)
+	if e := json.Unmarshal(response.Body.Bytes(), &revoked); nil != e || http.StatusOK != response.Code ||
+		(adminRevoked{Grants: 2, Sessions: 1}) != revoked {
+		t.Fatalf("revoke user: status %d, %s", response.Code, response.Body)
+	}
+	if found, _ := service.tokenStore.hasAccess("a1"); found {
+		t.Error("token of the revoked user is valid")
+	}
+	if got, want := listGrants(t, admin, ""), []string{"maria@yandex", "petr@sber"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("got %v after user revocation, want %v", got, want)
+	}
+
+	response = admin(http.MethodDelete, adminEndpointGrants+"?client_id=yandex", testAdminToken)
+	if e := json.Unmarshal(response.Body.Bytes(), &revoked); nil != e || http.StatusOK != response.Code ||
+		(adminRevoked{Grants: 1}) != revoked {
+		t.Fatalf("revoke client: status %d, %s", response.Code, response.Body)
+	}
+	if got, want := listGrants(t, admin, ""), []string{"petr@sber"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("got %v after client revocation, want %v", got, want)
+	}
+
+	// Single grant is revoked by its ID
+	grants, e := service.tokenStore.Grants(grantFilter{})
+	if nil != e || 1 != len(grants) {
+		t.Fatalf("got %+v: %v", grants, e)
+	}
+	target := adminEndpointGrants + "/" + grants[0].ID
+	if response = admin(http.MethodDelete, target, testAdminToken); http.StatusNoContent != response.Code {
+		t.Errorf("revoke grant: status %d", response.Code)
+	}
+	if response = admin(http.MethodDelete, target, testAdminToken); http.StatusNotFound != response.Code {
+		t.Errorf("revoke revoked grant: status %d", response.Code)
+	}
+}
+
+func TestAdminPublishUnlinks(t *testing.T) {
+	service, _, admin := newTestAdmin(t)
+
+	// Front-end without configured clients accept tokens of all clients
+	bus := eventbus.New()
+	service.PublishUnlinks(bus, "alisa:unlinked", []string{"yandex"})
+	service.PublishUnlinks(bus, "sber:unlinked", []string{"sber"})
+	service.PublishUnlinks(bus, "any:unlinked", nil)
+
+	unlinked := make(map[string][]string)
+	for _, topic := range []string{"alisa:unlinked", "sber:unlinked", "any:unlinked"} {
+		topic := topic
+		if e := bus.Subscribe(topic, func(userID string) {
+			unlinked[topic] = append(unlinked[topic], userID)
+			sort.Strings(unlinked[topic])
+		}); nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	issue(t, service.tokenStore, "ivan", "yandex", "a1")
+	issue(t, service.tokenStore, "ivan", "google", "a2")
+	issue(t, service.tokenStore, "maria", "yandex", "a3")
+	issue(t, service.tokenStore, "petr", "sber", "a4")
+
+	grants, e := service.tokenStore.Grants(grantFilter{UserID: "petr"})
+	if nil != e || 1 != len(grants) {
+		t.Fatalf("grants %+v: %v", grants, e)
+	}
+
+	for _, step := range []struct {
+		target string
+		want   map[string][]string
+	}{
+		{
+			target: adminEndpointGrants + "/" + grants[0].ID,
+			want:   map[string][]string{"sber:unlinked": {"petr"}, "any:unlinked": {"petr"}},
+		},
+		{
+			// User is published once, though two grants of the user are revoked
+			target: adminEndpointGrants + "?user_id=ivan",
+			want:   map[string][]string{"alisa:unlinked": {"ivan"}, "any:unlinked": {"ivan"}},
+		},
+		{
+			target: adminEndpointGrants + "?client_id=yandex",
+			want:   map[string][]string{"alisa:unlinked": {"maria"}, "any:unlinked": {"maria"}},
+		},
+		{
+			// Nothing is published when nothing is revoked
+			target: adminEndpointGrants + "?client_id=yandex",
+			want:   map[string][]string{},
+		},
+	} {
+		for topic := range unlinked {
+			delete(unlinked, topic)
+		}
+
+		if response := admin(http.MethodDelete, step.target, testAdminToken); response.Code >= 300 {
+			t.Fatalf("%s: status %d", step.target, response.Code)
+		}
+		if !reflect.DeepEqual(step.want, unlinked) {
+			t.Errorf("%s: published %v, want %v", step.target, unlinked, step.want)
+		}
+	}
+}
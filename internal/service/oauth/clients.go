@@ -0,0 +1,458 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// envClients is path to the JSON file with registered clients
+	envClients = "OAUTH_CLIENTS"
+	// clientKeyPrefix is key prefix of the client records
+	clientKeyPrefix = "client:"
+	// redirectURISeparator separate redirect URIs in the client domain, space is never part of the URI
+	redirectURISeparator = " "
+)
+
+const (
+	// Client authentication methods of the token endpoint (RFC 7591), secret in the request body
+	// (client_secret_post) isn't supported
+	authMethodBasic = "client_secret_basic"
+	authMethodNone  = "none"
+)
+
+const (
+	// ScopeDevicesRead allow to enumerate devices and query their states
+	ScopeDevicesRead = "devices:read"
+	// ScopeDevicesControl allow to change device states
+	ScopeDevicesControl = "devices:control"
+)
+
+var (
+	// knownScopes is scopes which may be granted to the clients
+	knownScopes = map[string]bool{
+		ScopeDevicesRead:    true,
+		ScopeDevicesControl: true,
+	}
+	// devicesScopes is scopes of the clients configured by the environment variables
+	devicesScopes = []string{ScopeDevicesRead, ScopeDevicesControl}
+	// errClientNotFound returned when client isn't registered
+	errClientNotFound = errors.New("client not found")
+	// ErrInsufficientScope returned when access token don't grant scope required by the endpoint
+	ErrInsufficientScope = errors.New("insufficient_scope")
+	// ErrTooManyRequests returned when client of the token exceed the rate limit, or token is locked after
+	// repeated failures. Retry-After header is set in such case.
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// client is registered OAuth client
+type client struct {
+	ID string `json:"id"`
+	// Name is shown to the user on the consent page
+	Name         string   `json:"name,omitempty"`
+	Secret       string   `json:"secret"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// Scopes is scopes allowed to the client, they're granted when authorization request has no scope
+	Scopes []string `json:"scopes"`
+	// TokenEndpointAuthMethod is how client authenticate on the token endpoint, client_secret_basic by default
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+	// RequirePKCE reject authorization requests without code challenge, public clients always require it
+	RequirePKCE bool `json:"require_pkce,omitempty"`
+}
+
+// GetID is implementation of oauth2.ClientInfo interface
+func (c *client) GetID() string {
+	return c.ID
+}
+
+// GetSecret is implementation of oauth2.ClientInfo interface
+func (c *client) GetSecret() string {
+	return c.Secret
+}
+
+// GetDomain is implementation of oauth2.ClientInfo interface, it return all redirect URIs of the client
+func (c *client) GetDomain() string {
+	return strings.Join(c.RedirectURIs, redirectURISeparator)
+}
+
+// GetUserID is implementation of oauth2.ClientInfo interface
+func (c *client) GetUserID() string {
+	return ""
+}
+
+// VerifyPassword is implementation of oauth2.ClientPasswordVerifier interface
+func (c *client) VerifyPassword(secret string) bool {
+	return 1 == subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret))
+}
+
+// authMethod return authentication method of the client on the token endpoint
+func (c *client) authMethod() string {
+	if 0 == len(c.TokenEndpointAuthMethod) {
+		return authMethodBasic
+	}
+
+	return c.TokenEndpointAuthMethod
+}
+
+// requirePKCE return true when authorization request must have code challenge
+func (c *client) requirePKCE() bool {
+	return c.RequirePKCE || authMethodNone == c.authMethod()
+}
+
+// displayName return name of the client shown to the user
+func (c *client) displayName() string {
+	if len(c.Name) > 0 {
+		return c.Name
+	}
+
+	return c.ID
+}
+
+// allowed return true when all scopes are allowed to the client
+func (c *client) allowed(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		found := false
+		for _, s := range c.Scopes {
+			found = found || s == requested
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validate check client configuration
+func (c *client) validate() error {
+	if 0 == len(c.ID) {
+		return errors.New("client without id")
+	}
+
+	if 0 == len(c.RedirectURIs) {
+		return fmt.Errorf("client %s has no redirect URIs", c.ID)
+	}
+
+	for _, uri := range c.RedirectURIs {
+		if 0 == len(uri) || strings.Contains(uri, redirectURISeparator) {
+			return fmt.Errorf("client %s has invalid redirect URI %q", c.ID, uri)
+		}
+	}
+
+	for _, scope := range c.Scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("client %s has unknown scope %s", c.ID, scope)
+		}
+	}
+
+	switch c.authMethod() {
+	case authMethodBasic:
+		if 0 == len(c.Secret) {
+			return fmt.Errorf("client %s has no secret", c.ID)
+		}
+	case authMethodNone:
+		// Public client can't keep secret, so it's identified by the code verifier
+		if len(c.Secret) > 0 {
+			return fmt.Errorf("public client %s has secret", c.ID)
+		}
+	default:
+		return fmt.Errorf("client %s has unknown token endpoint auth method %s", c.ID, c.TokenEndpointAuthMethod)
+	}
+
+	return nil
+}
+
+// validateRedirectURI is redirect URI validation handler of the token manager, domain is all redirect URIs
+// of the client. Redirect URI must be equal to one of them, so open redirects on the client host aren't usable.
+func validateRedirectURI(domain string, redirectURI string) error {
+	for _, uri := range strings.Split(domain, redirectURISeparator) {
+		if uri == redirectURI {
+			return nil
+		}
+	}
+
+	return oauthErrors.ErrInvalidRedirectURI
+}
+
+// loadClients return clients from the configuration file, if path isn't empty
+func loadClients(path string) ([]*client, error) {
+	if 0 == len(path) {
+		return nil, nil
+	}
+
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return nil, e
+	}
+
+	var config struct {
+		Clients []*client `json:"clients"`
+	}
+	if e = json.Unmarshal(data, &config); nil != e {
+		return nil, fmt.Errorf("clients config %s: %w", path, e)
+	}
+
+	for _, c := range config.Clients {
+		if e = c.validate(); nil != e {
+			return nil, fmt.Errorf("clients config %s: %w", path, e)
+		}
+	}
+
+	return config.Clients, nil
+}
+
+// ClientIDs return clients allowed to use the front-end: comma separated IDs of the list variable, or client
+// configured by the assistant variable when list isn't set
+func ClientIDs(envList string, envClientID string) []string {
+	value, found := os.LookupEnv(envList)
+	if !found {
+		value = os.Getenv(envClientID)
+	}
+
+	var clientIDs []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			clientIDs = append(clientIDs, id)
+		}
+	}
+
+	return clientIDs
+}
+
+// envClient return client configured by the environment variables, nil if client ID isn't set
+func envClient(name string, envClientID string, envClientSecret string, envCallback string,
+	defaultCallback string) (*client, error) {
+	clientID, found := os.LookupEnv(envClientID)
+	if !found {
+		return nil, nil
+	}
+
+	callback, found := os.LookupEnv(envCallback)
+	if !found {
+		if 0 == len(defaultCallback) {
+			return nil, fmt.Errorf("%s is required for client %s", envCallback, clientID)
+		}
+
+		callback = defaultCallback
+	}
+
+	c := &client{
+		ID:           clientID,
+		Name:         name,
+		Secret:       os.Getenv(envClientSecret),
+		RedirectURIs: []string{callback},
+		Scopes:       devicesScopes,
+	}
+
+	return c, c.validate()
+}
+
+// clientStore is durable client store
+type clientStore struct {
+	db *buntdb.DB
+}
+
+// newClientStore return client store in the database
+func newClientStore(db *buntdb.DB) *clientStore {
+	return &clientStore{
+		db: db,
+	}
+}
+
+// GetByID is implementation of oauth2.ClientStore interface
+func (store *clientStore) GetByID(_ context.Context, id string) (oauth2.ClientInfo, error) {
+	c, e := store.get(id)
+	if errors.Is(e, errClientNotFound) {
+		return nil, oauthErrors.ErrInvalidClient
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	return c, nil
+}
+
+// get return registered client
+func (store *clientStore) get(id string) (*client, error) {
+	var result client
+
+	e := store.db.View(func(tx *buntdb.Tx) error {
+		data, e := tx.Get(clientKeyPrefix + id)
+		if nil != e {
+			return e
+		}
+
+		return json.Unmarshal([]byte(data), &result)
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return nil, errClientNotFound
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	return &result, nil
+}
+
+// Replace store clients, clients which aren't in the list are removed
+func (store *clientStore) Replace(clients []*client) error {
+	return store.db.Update(func(tx *buntdb.Tx) error {
+		var stale []string
+		e := tx.AscendGreaterOrEqual("", clientKeyPrefix, func(key, _ string) bool {
+			if !strings.HasPrefix(key, clientKeyPrefix) {
+				return false
+			}
+
+			stale = append(stale, key)
+
+			return true
+		})
+		if nil != e {
+			return e
+		}
+
+		if e = deleteKeys(tx, stale...); nil != e {
+			return e
+		}
+
+		for _, c := range clients {
+			data, e := json.Marshal(c)
+			if nil != e {
+				return e
+			}
+
+			if _, _, e = tx.Set(clientKeyPrefix+c.ID, string(data), nil); nil != e {
+				return e
+			}
+		}
+
+		return nil
+	})
+}
+
+// clientCredentials return credentials of the client authenticated by the request. Secret is accepted only from the
+// Basic header, public client is identified by client_id of the form and has no secret.
+func (store *clientStore) clientCredentials(r *http.Request) (string, string, error) {
+	formClientID, formSecret := r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// Credentials are form encoded before they're put to the header (RFC 6749 section 2.3.1)
+		var e error
+		if clientID, e = url.QueryUnescape(clientID); nil != e {
+			return "", "", oauthErrors.ErrInvalidClient
+		}
+		if clientSecret, e = url.QueryUnescape(clientSecret); nil != e {
+			return "", "", oauthErrors.ErrInvalidClient
+		}
+
+		// Client must use only one authentication method
+		if len(formSecret) > 0 || (len(formClientID) > 0 && formClientID != clientID) {
+			return "", "", oauthErrors.ErrInvalidRequest
+		}
+	} else {
+		if len(formSecret) > 0 {
+			return "", "", oauthErrors.ErrInvalidClient
+		}
+
+		clientID = formClientID
+	}
+
+	if 0 == len(clientID) {
+		return "", "", oauthErrors.ErrInvalidClient
+	}
+
+	c, e := store.get(clientID)
+	if errors.Is(e, errClientNotFound) {
+		return "", "", oauthErrors.ErrInvalidClient
+	}
+	if nil != e {
+		return "", "", e
+	}
+
+	method := authMethodNone
+	if basic {
+		method = authMethodBasic
+	}
+
+	if method != c.authMethod() || !c.VerifyPassword(clientSecret) {
+		return "", "", oauthErrors.ErrInvalidClient
+	}
+
+	return clientID, clientSecret, nil
+}
+
+// tokenClient is client info handler of the OAuth server. Server verify the client and owner of the grant only
+// when code is exchanged, so refreshed token must be issued to the authenticated client too.
+func (service *Service) tokenClient(r *http.Request) (string, string, error) {
+	clientID, clientSecret, e := service.clientStore.clientCredentials(r)
+	if nil != e || oauth2.Refreshing != oauth2.GrantType(r.FormValue("grant_type")) {
+		return clientID, clientSecret, e
+	}
+
+	// Unknown token is rejected by the server, it detect reuse of the rotated token too
+	token, e := service.tokenStore.get(refreshKeyPrefix + r.FormValue("refresh_token"))
+	if nil != e {
+		return "", "", e
+	}
+	if nil != token && clientID != token.GetClientID() {
+		return "", "", oauthErrors.ErrInvalidGrant
+	}
+
+	return clientID, clientSecret, nil
+}
+
+// scopesHandler return client scope handler of the OAuth server, it check requested scope is allowed to the client
+func (store *clientStore) scopesHandler() server.ClientScopeHandler {
+	return func(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+		c, e := store.get(tgr.ClientID)
+		if nil != e {
+			return false, e
+		}
+
+		return c.allowed(tgr.Scope), nil
+	}
+}
+
+// authorizeScope is authorize scope handler of the OAuth server, all scopes allowed to the client are granted
+// when authorization request has no scope
+func (service *Service) authorizeScope(_ http.ResponseWriter, r *http.Request) (string, error) {
+	if scope := strings.TrimSpace(r.FormValue("scope")); len(scope) > 0 {
+		return scope, nil
+	}
+
+	c, e := service.clientStore.get(r.FormValue("client_id"))
+	if nil != e {
+		return "", e
+	}
+
+	return strings.Join(c.Scopes, " "), nil
+}
+
+// refreshingScope is refreshing scope handler of the OAuth server, refreshed token may only narrow the scope
+func (service *Service) refreshingScope(tgr *oauth2.TokenGenerateRequest, oldScope string) (bool, error) {
+	if len(oldScope) > 0 {
+		granted := &client{Scopes: strings.Fields(oldScope)}
+		return granted.allowed(tgr.Scope), nil
+	}
+
+	c, e := service.clientStore.get(tgr.ClientID)
+	if nil != e {
+		return false, e
+	}
+
+	return c.allowed(tgr.Scope), nil
+}
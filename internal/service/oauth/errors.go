@@ -0,0 +1,42 @@
+package oauth
+
+import (
+	"net/http"
+
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+// internalError is internal error handler of the OAuth server, errors of the code exchange which aren't known
+// by the server are reported as invalid grant, other errors are logged and reported as server error
+func internalError(e error) *oauthErrors.Response {
+	switch e {
+	case oauthErrors.ErrMissingCodeVerifier, oauthErrors.ErrInvalidRedirectURI:
+		return &oauthErrors.Response{
+			Error:       oauthErrors.ErrInvalidGrant,
+			Description: oauthErrors.Descriptions[oauthErrors.ErrInvalidGrant],
+		}
+	}
+
+	log.Log.Errorw("OAuth request failed", "error", e)
+
+	return nil
+}
+
+// responseError is response error handler of the OAuth server, it set status code required by RFC 6749
+// section 5.2 instead of the server defaults
+func responseError(re *oauthErrors.Response) {
+	switch re.Error {
+	case oauthErrors.ErrInvalidClient:
+		re.StatusCode = http.StatusUnauthorized
+		re.SetHeader("WWW-Authenticate", `Basic realm="oauth"`)
+	case oauthErrors.ErrServerError:
+		re.StatusCode = http.StatusInternalServerError
+	case oauthErrors.ErrTemporarilyUnavailable:
+		re.StatusCode = http.StatusServiceUnavailable
+	default:
+		re.StatusCode = http.StatusBadRequest
+	}
+
+	log.Log.Infow("OAuth request rejected", "error", re.Error, "description", re.Description)
+}
@@ -0,0 +1,202 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// grant is account linking of the user with the client, it's the token family with active access or refresh token
+type grant struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	// IssuedAt is time when user authorized the client
+	IssuedAt time.Time `json:"issued_at"`
+	// LastRefreshAt is time of the last refresh, it's absent when tokens of the grant aren't refreshed yet.
+	// Requests with access token aren't tracked, but assistants refresh tokens when they expire, so it show whether
+	// the grant is still used.
+	LastRefreshAt *time.Time `json:"last_refresh_at,omitempty"`
+	// ExpiresAt is time when the last refresh token expire, it's absent when tokens never expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// grantFilter select grants of the user and of the client, empty field match all values
+type grantFilter struct {
+	UserID   string
+	ClientID string
+}
+
+// match return true when token match the filter
+func (filter grantFilter) match(token *storedToken) bool {
+	return (0 == len(filter.UserID) || filter.UserID == token.UserID) &&
+		(0 == len(filter.ClientID) || filter.ClientID == token.ClientID)
+}
+
+// active return true when access or refresh token is still valid
+func active(tx *buntdb.Tx, tokenID string, token *storedToken) bool {
+	for _, key := range []string{accessKeyPrefix + token.GetAccess(), refreshKeyPrefix + token.GetRefresh()} {
+		if value, e := tx.Get(key); nil == e && value == tokenID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eachToken call f for each stored token until it return false
+func eachToken(tx *buntdb.Tx, f func(tokenID string, token *storedToken) bool) error {
+	var failure error
+
+	e := tx.AscendGreaterOrEqual("", tokenKeyPrefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, tokenKeyPrefix) {
+			return false
+		}
+
+		var token storedToken
+		if failure = json.Unmarshal([]byte(value), &token); nil != failure {
+			return false
+		}
+
+		return f(strings.TrimPrefix(key, tokenKeyPrefix), &token)
+	})
+	if nil != e {
+		return e
+	}
+
+	return failure
+}
+
+// Grants return active grants matching the filter, ordered by issue time
+func (store *tokenStore) Grants(filter grantFilter) ([]grant, error) {
+	grants := make(map[string]*grant)
+
+	e := store.db.View(func(tx *buntdb.Tx) error {
+		return eachToken(tx, func(tokenID string, token *storedToken) bool {
+			if !filter.match(token) || !active(tx, tokenID, token) {
+				return true
+			}
+
+			g, found := grants[token.Family]
+			if !found {
+				g = &grant{ID: token.Family, UserID: token.UserID, ClientID: token.ClientID}
+				if seen := lastSeen(tx, token.Family); !seen.IsZero() {
+					g.LastRefreshAt = &seen
+				}
+				grants[token.Family] = g
+			}
+
+			// Tokens stored before issue time was tracked have no it, so the oldest token is used instead
+			issued := token.Issued
+			if issued.IsZero() {
+				issued = token.GetAccessCreateAt()
+			}
+			if g.IssuedAt.IsZero() || issued.Before(g.IssuedAt) {
+				g.IssuedAt = issued
+			}
+
+			// Latest token define current scope and expiration of the grant
+			if expiresIn := token.GetRefreshExpiresIn(); expiresIn > 0 {
+				expires := token.GetRefreshCreateAt().Add(expiresIn)
+				if nil == g.ExpiresAt || expires.After(*g.ExpiresAt) {
+					g.ExpiresAt = &expires
+					g.Scope = token.GetScope()
+				}
+			} else {
+				g.Scope = token.GetScope()
+			}
+
+			return true
+		})
+	})
+	if nil != e {
+		return nil, e
+	}
+
+	result := make([]grant, 0, len(grants))
+	for _, g := range grants {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].IssuedAt.Before(result[j].IssuedAt)
+	})
+
+	return result, nil
+}
+
+// RevokeGrant remove all tokens of the grant, nil is returned when grant isn't found
+func (store *tokenStore) RevokeGrant(id string) (*grant, error) {
+	var result *grant
+
+	e := store.db.Update(func(tx *buntdb.Tx) error {
+		prefix := familyKeyPrefix + id + ":"
+
+		var token *storedToken
+		e := tx.AscendGreaterOrEqual("", prefix, func(key, _ string) bool {
+			if strings.HasPrefix(key, prefix) {
+				token, _ = loadToken(tx, strings.TrimPrefix(key, prefix))
+			}
+
+			return false
+		})
+		if nil != e || nil == token {
+			return e
+		}
+
+		if _, e = revokeFamily(tx, id); nil == e {
+			result = &grant{ID: id, UserID: token.UserID, ClientID: token.ClientID}
+		}
+
+		return e
+	})
+
+	return result, e
+}
+
+// RevokeGrants remove all tokens matching the filter, including authorization codes which aren't exchanged yet.
+// It return revoked active grants.
+func (store *tokenStore) RevokeGrants(filter grantFilter) ([]grant, error) {
+	if 0 == len(filter.UserID) && 0 == len(filter.ClientID) {
+		return nil, errors.New("user or client is required")
+	}
+
+	var revoked []grant
+
+	e := store.db.Update(func(tx *buntdb.Tx) error {
+		// Family is active when at least one its token is active
+		families := make(map[string]*grant)
+		isActive := make(map[string]bool)
+		if e := eachToken(tx, func(tokenID string, token *storedToken) bool {
+			if filter.match(token) {
+				families[token.Family] = &grant{ID: token.Family, UserID: token.UserID, ClientID: token.ClientID}
+				isActive[token.Family] = isActive[token.Family] || active(tx, tokenID, token)
+			}
+
+			return true
+		}); nil != e {
+			return e
+		}
+
+		for family, g := range families {
+			if _, e := revokeFamily(tx, family); nil != e {
+				return e
+			}
+
+			if isActive[family] {
+				revoked = append(revoked, *g)
+			}
+		}
+
+		return nil
+	})
+	if nil != e {
+		return nil, e
+	}
+
+	return revoked, nil
+}
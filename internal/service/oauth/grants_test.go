@@ -0,0 +1,197 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/tidwall/buntdb"
+)
+
+// newTestStore return token store in memory
+func newTestStore(t *testing.T) *tokenStore {
+	t.Helper()
+
+	db, e := buntdb.Open(":memory:")
+	if nil != e {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return newTokenStore(db)
+}
+
+// issue store access token of the user issued to the client
+func issue(t *testing.T, store *tokenStore, userID string, clientID string, access string) {
+	t.Helper()
+
+	token := models.NewToken()
+	token.SetUserID(userID)
+	token.SetClientID(clientID)
+	token.SetAccess(access)
+	token.SetAccessCreateAt(time.Now())
+	token.SetAccessExpiresIn(time.Hour)
+
+	if e := store.Create(context.Background(), token); nil != e {
+		t.Fatal(e)
+	}
+}
+
+// grantOwners return sorted user and client of each grant
+func grantOwners(grants []grant) []string {
+	owners := make([]string, 0, len(grants))
+	for _, g := range grants {
+		owners = append(owners, g.UserID+"@"+g.ClientID)
+	}
+	sort.Strings(owners)
+
+	return owners
+}
+
+func TestGrants(t *testing.T) {
+	store := newTestStore(t)
+
+	issue(t, store, "ivan", "yandex", "a1")
+	issue(t, store, "ivan", "google", "a2")
+	issue(t, store, "maria", "yandex", "a3")
+	issue(t, store, "petr", "sber", "a4")
+
+	for _, test := range []struct {
+		filter grantFilter
+		want   []string
+	}{
+		{grantFilter{}, []string{"ivan@google", "ivan@yandex", "maria@yandex", "petr@sber"}},
+		{grantFilter{UserID: "ivan"}, []string{"ivan@google", "ivan@yandex"}},
+		{grantFilter{ClientID: "yandex"}, []string{"ivan@yandex", "maria@yandex"}},
+		{grantFilter{UserID: "ivan", ClientID: "yandex"}, []string{"ivan@yandex"}},
+		{grantFilter{UserID: "olga"}, []string{}},
+	} {
+		grants, e := store.Grants(test.filter)
+		if nil != e {
+			t.Fatal(e)
+		}
+
+		if got := grantOwners(grants); !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%+v: got %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	// Filter without user and client would revoke everything, so it's rejected
+	if _, e := store.RevokeGrants(grantFilter{}); nil == e {
+		t.Fatal("empty filter is accepted")
+	}
+
+	revoked, e := store.RevokeGrants(grantFilter{ClientID: "yandex"})
+	got, want := grantOwners(revoked), []string{"ivan@yandex", "maria@yandex"}
+	if nil != e || !reflect.DeepEqual(want, got) {
+		t.Fatalf("revoked grants %v of the client, want %v: %v", got, want, e)
+	}
+
+	grants, e := store.Grants(grantFilter{})
+	if nil != e {
+		t.Fatal(e)
+	}
+	if got, want = grantOwners(grants), []string{"ivan@google", "petr@sber"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("got %v after client revocation, want %v", got, want)
+	}
+
+	if g, e := store.RevokeGrant(grants[0].ID); nil != e || nil == g || "ivan" != g.UserID || "google" != g.ClientID {
+		t.Fatalf("grant isn't revoked %+v: %v", g, e)
+	}
+	if g, e := store.RevokeGrant(grants[0].ID); nil != e || nil != g {
+		t.Fatalf("revoked grant is found again: %v", e)
+	}
+
+	if grants, e = store.Grants(grantFilter{}); nil != e || 1 != len(grants) {
+		t.Fatalf("got %+v after grant revocation: %v", grants, e)
+	}
+}
+
+func TestLinkedUsers(t *testing.T) {
+	service := &Service{tokenStore: newTestStore(t)}
+
+	issue(t, service.tokenStore, "ivan", "yandex", "a1")
+	issue(t, service.tokenStore, "ivan", "yandex", "a2")
+	issue(t, service.tokenStore, "maria", "yandex", "a3")
+	issue(t, service.tokenStore, "petr", "google", "a4")
+	issue(t, service.tokenStore, "olga", "yandex", "a5")
+
+	if e := service.tokenStore.RevokeUser(context.Background(), "olga", ""); nil != e {
+		t.Fatal(e)
+	}
+
+	for _, test := range []struct {
+		clientIDs []string
+		want      []string
+	}{
+		{[]string{"yandex"}, []string{"ivan", "maria"}},
+		{[]string{"yandex", "google"}, []string{"ivan", "maria", "petr"}},
+		{[]string{"sber"}, []string{}},
+		{nil, []string{"ivan", "maria", "petr"}},
+	} {
+		got, e := service.LinkedUsers(test.clientIDs)
+		if nil != e {
+			t.Fatal(e)
+		}
+
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%v: got %v, want %v", test.clientIDs, got, test.want)
+		}
+	}
+}
+
+func TestGrantLastRefresh(t *testing.T) {
+	service, router := newTestService(t)
+	router.GET("/resource", func(ginCtx *gin.Context) {
+		if _, e := service.ValidationBearerToken(ginCtx); nil != e {
+			ginCtx.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+
+	issue(t, service.tokenStore, testUserID, testClientID, "a1")
+
+	// Requests don't write the database
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		request.Header.Set("Authorization", "Bearer a1")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if http.StatusOK != recorder.Code {
+			t.Fatalf("status %d", recorder.Code)
+		}
+	}
+
+	grants, e := service.tokenStore.Grants(grantFilter{})
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 1 != len(grants) || nil != grants[0].LastRefreshAt {
+		t.Fatalf("grant isn't refreshed yet, got %+v", grants)
+	}
+
+	// Refreshed token is stored with the family of the loaded one
+	token, e := service.tokenStore.GetByAccess(context.Background(), "a1")
+	if nil != e {
+		t.Fatal(e)
+	}
+	token.SetAccess("a2")
+	token.SetAccessCreateAt(time.Now())
+	if e = service.tokenStore.Create(context.Background(), token); nil != e {
+		t.Fatal(e)
+	}
+
+	if grants, e = service.tokenStore.Grants(grantFilter{}); nil != e {
+		t.Fatal(e)
+	}
+	if 1 != len(grants) || nil == grants[0].LastRefreshAt {
+		t.Fatalf("refresh isn't remembered, got %+v", grants)
+	}
+}
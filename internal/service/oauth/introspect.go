@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+// introspection is response of the introspection endpoint (RFC 7662)
+type introspection struct {
+	Active    bool   `json:"active"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+}
+
+// onIntrospect implement token introspection (RFC 7662). Token store is used, so revoked tokens are inactive.
+func (service *Service) onIntrospect(ginCtx *gin.Context) {
+	ginCtx.Header("Cache-Control", "no-store")
+
+	if !service.clientAllowed(ginCtx) {
+		return
+	}
+
+	clientID, ok := service.authenticateClient(ginCtx.Request)
+	if !ok {
+		service.clientFailed(ginCtx, "invalid client")
+		if _, _, basic := ginCtx.Request.BasicAuth(); basic {
+			ginCtx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		ginCtx.JSON(http.StatusUnauthorized, tokenError{Error: "invalid_client"})
+		return
+	}
+
+	value := ginCtx.PostForm("token")
+	if 0 == len(value) {
+		ginCtx.JSON(http.StatusBadRequest, tokenError{
+			Error:       "invalid_request",
+			Description: "token is required",
+		})
+		return
+	}
+
+	token, tokenType, e := service.tokenStore.lookup(value, ginCtx.PostForm("token_type_hint"))
+	if nil != e {
+		log.Log.Errorw("Token introspection failed", "client_id", clientID, "error", e)
+		ginCtx.JSON(http.StatusServiceUnavailable, tokenError{Error: "temporarily_unavailable"})
+		return
+	}
+
+	if nil == token {
+		ginCtx.JSON(http.StatusOK, introspection{})
+		return
+	}
+
+	result := introspection{
+		Active:    true,
+		ClientID:  token.GetClientID(),
+		Subject:   token.GetUserID(),
+		Scope:     token.GetScope(),
+		TokenType: "Bearer",
+		Audience:  token.GetClientID(),
+	}
+	if nil != service.signer {
+		result.Issuer = service.signer.issuer
+	}
+
+	createAt, expiresIn := token.GetAccessCreateAt(), token.GetAccessExpiresIn()
+	if tokenTypeRefresh == tokenType {
+		createAt, expiresIn = token.GetRefreshCreateAt(), token.GetRefreshExpiresIn()
+		result.TokenType = tokenTypeRefresh
+	}
+
+	result.IssuedAt = createAt.Unix()
+	if expiresIn > 0 {
+		result.ExpiresAt = createAt.Add(expiresIn).Unix()
+	}
+
+	ginCtx.JSON(http.StatusOK, result)
+}
+
+// onJWKS return public keys of the JWT access tokens
+func (service *Service) onJWKS(ginCtx *gin.Context) {
+	keys := []jwk{}
+	if nil != service.signer {
+		keys = service.signer.JWKS()
+	}
+
+	ginCtx.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,298 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/generates"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// envJWTKeys is comma separated paths of the PEM files with RSA or P-256 EC private keys. First key sign
+	// new tokens, other keys are published and accepted until tokens signed by them expire.
+	envJWTKeys = "OAUTH_JWT_KEYS"
+	// envIssuer is issuer of the JWT access tokens, e.g. "https://iot.domain.com"
+	envIssuer = "OAUTH_ISSUER"
+	// envJWTCheckStore enable lookup of the JWT access tokens in the token store, so tokens replaced by refresh are
+	// rejected before they expire. By default tokens are validated by signature, claims and revocation records.
+	envJWTCheckStore = "OAUTH_JWT_CHECK_STORE"
+	// minRSAKeySize is minimal size of the RSA signing key in bits
+	minRSAKeySize = 2048
+)
+
+// signingKey is private key used to sign JWT access tokens
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// jwk is public key in the JWK form (RFC 7517)
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// accessClaims is claims of the JWT access token
+type accessClaims struct {
+	jwt.StandardClaims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// signer issue and validate JWT access tokens
+type signer struct {
+	issuer string
+	keys   []signingKey
+	// refresh generate opaque refresh tokens
+	refresh oauth2.AccessGenerate
+	// checkStore is true when valid token must be in the token store too
+	checkStore bool
+	// db is the token store database with the revocation records
+	db *buntdb.DB
+}
+
+// loadSigner return signer with the keys from the configured files, nil is returned when JWT isn't configured
+func loadSigner() (*signer, error) {
+	paths, found := os.LookupEnv(envJWTKeys)
+	if !found || 0 == len(strings.TrimSpace(paths)) {
+		return nil, nil
+	}
+
+	result := &signer{
+		issuer:  os.Getenv(envIssuer),
+		refresh: generates.NewAccessGenerate(),
+	}
+
+	if value, found := os.LookupEnv(envJWTCheckStore); found {
+		var e error
+		if result.checkStore, e = strconv.ParseBool(value); nil != e {
+			return nil, fmt.Errorf("%s: %w", envJWTCheckStore, e)
+		}
+	}
+
+	for _, path := range strings.Split(paths, ",") {
+		key, e := loadSigningKey(strings.TrimSpace(path))
+		if nil != e {
+			return nil, fmt.Errorf("%s: %w", envJWTKeys, e)
+		}
+
+		result.keys = append(result.keys, key)
+	}
+
+	return result, nil
+}
+
+// loadSigningKey return signing key from the PEM file
+func loadSigningKey(path string) (signingKey, error) {
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return signingKey{}, e
+	}
+
+	block, _ := pem.Decode(data)
+	if nil == block {
+		return signingKey{}, fmt.Errorf("%s isn't PEM file", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, e = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, e = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, e = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if nil != e {
+		return signingKey{}, fmt.Errorf("%s: %w", path, e)
+	}
+
+	result := signingKey{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeySize {
+			return signingKey{}, fmt.Errorf("%s: RSA key must have at least %d bits", path, minRSAKeySize)
+		}
+		result.method, result.key = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		if elliptic.P256() != k.Curve {
+			return signingKey{}, fmt.Errorf("%s: EC key must use P-256 curve", path)
+		}
+		result.method, result.key = jwt.SigningMethodES256, k
+	default:
+		return signingKey{}, fmt.Errorf("%s: RSA or EC private key expected", path)
+	}
+
+	// Key ID is JWK thumbprint (RFC 7638), so it's stable and don't require configuration
+	public := result.jwk()
+	var thumbprint []byte
+	if "RSA" == public.KeyType {
+		thumbprint, e = json.Marshal(map[string]string{"e": public.E, "kty": public.KeyType, "n": public.N})
+	} else {
+		thumbprint, e = json.Marshal(map[string]string{"crv": public.Curve, "kty": public.KeyType, "x": public.X, "y": public.Y})
+	}
+	if nil != e {
+		return signingKey{}, e
+	}
+	hash := sha256.Sum256(thumbprint)
+	result.id = base64.RawURLEncoding.EncodeToString(hash[:])
+
+	return result, nil
+}
+
+// jwk return public key of the signing key
+func (key signingKey) jwk() jwk {
+	result := jwk{
+		KeyID:     key.id,
+		Use:       "sig",
+		Algorithm: key.method.Alg(),
+	}
+
+	switch k := key.key.(type) {
+	case *rsa.PrivateKey:
+		result.KeyType = "RSA"
+		result.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		result.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		result.KeyType = "EC"
+		result.Curve = k.Curve.Params().Name
+		result.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		result.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	}
+
+	return result
+}
+
+// JWKS return public keys of all signing keys
+func (s *signer) JWKS() []jwk {
+	result := make([]jwk, 0, len(s.keys))
+	for _, key := range s.keys {
+		result = append(result, key.jwk())
+	}
+
+	return result
+}
+
+// Token is implementation of oauth2.AccessGenerate interface, access token is signed by the first key
+func (s *signer) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	_, refresh, e := s.refresh.Token(ctx, data, isGenRefresh)
+	if nil != e {
+		return "", "", e
+	}
+
+	info := data.TokenInfo
+	claims := &accessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Issuer:    s.issuer,
+			Subject:   info.GetUserID(),
+			Audience:  info.GetClientID(),
+			IssuedAt:  info.GetAccessCreateAt().Unix(),
+			ExpiresAt: info.GetAccessCreateAt().Add(info.GetAccessExpiresIn()).Unix(),
+		},
+		ClientID: info.GetClientID(),
+		Scope:    info.GetScope(),
+	}
+
+	key := s.keys[0]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+
+	access, e := token.SignedString(key.key)
+	if nil != e {
+		return "", "", e
+	}
+
+	return access, refresh, nil
+}
+
+// isJWT return true when token looks like JWT
+func isJWT(token string) bool {
+	return 2 == strings.Count(token, ".")
+}
+
+// Validate check signature and claims of the access token. Token store isn't used, only tokens issued to the user by
+// the client before revocation are rejected.
+func (s *signer) Validate(access string) (oauth2.TokenInfo, error) {
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}}
+
+	var claims accessClaims
+	_, e := parser.ParseWithClaims(access, &claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		for _, key := range s.keys {
+			if key.id == keyID && key.method.Alg() == token.Method.Alg() {
+				return key.key.Public(), nil
+			}
+		}
+
+		return nil, errors.New("unknown signing key")
+	})
+	var validationError *jwt.ValidationError
+	if errors.As(e, &validationError) && jwt.ValidationErrorExpired == validationError.Errors {
+		// Signature is valid, so token was issued by the service
+		return nil, oauthErrors.ErrExpiredAccessToken
+	}
+	if nil != e {
+		return nil, oauthErrors.ErrInvalidAccessToken
+	}
+
+	// Tokens without expiration aren't issued, so they're never accepted
+	if 0 == claims.ExpiresAt || claims.Issuer != s.issuer || 0 == len(claims.Subject) {
+		return nil, oauthErrors.ErrInvalidAccessToken
+	}
+
+	if nil != s.db {
+		var revoked time.Time
+		if e = s.db.View(func(tx *buntdb.Tx) error {
+			revoked = revokedAt(tx, claims.Subject, claims.ClientID)
+			return nil
+		}); nil != e {
+			return nil, e
+		}
+
+		// Issue time has second precision, so token issued in the second of revocation is rejected too
+		if !revoked.IsZero() && claims.IssuedAt <= revoked.Unix() {
+			return nil, oauthErrors.ErrInvalidAccessToken
+		}
+	}
+
+	token := models.NewToken()
+	token.SetClientID(claims.ClientID)
+	token.SetUserID(claims.Subject)
+	token.SetScope(claims.Scope)
+	token.SetAccess(access)
+	token.SetAccessCreateAt(time.Unix(claims.IssuedAt, 0))
+	token.SetAccessExpiresIn(time.Unix(claims.ExpiresAt, 0).Sub(time.Unix(claims.IssuedAt, 0)))
+
+	return token, nil
+}
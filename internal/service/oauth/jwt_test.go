@@ -0,0 +1,201 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/tidwall/buntdb"
+)
+
+// writeSigningKey write new P-256 private key to the PEM file and return its path
+func writeSigningKey(t *testing.T) string {
+	t.Helper()
+
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	data, e := x509.MarshalECPrivateKey(key)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if e = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: data}), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	return path
+}
+
+// issueJWT store token of the user issued at the time with JWT access token and return the access token
+func issueJWT(t *testing.T, service *Service, userID string, createAt time.Time) string {
+	t.Helper()
+
+	token := models.NewToken()
+	token.SetUserID(userID)
+	token.SetClientID(testClientID)
+	token.SetAccessCreateAt(createAt)
+	token.SetAccessExpiresIn(time.Hour)
+
+	access, refresh, e := service.signer.Token(context.Background(), &oauth2.GenerateBasic{
+		Client:    &client{ID: testClientID},
+		UserID:    userID,
+		CreateAt:  time.Now(),
+		TokenInfo: token,
+	}, true)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	token.SetAccess(access)
+	token.SetRefresh(refresh)
+	token.SetRefreshCreateAt(time.Now())
+	token.SetRefreshExpiresIn(time.Hour)
+	if e = service.tokenStore.Create(context.Background(), token); nil != e {
+		t.Fatal(e)
+	}
+
+	return access
+}
+
+func TestRevokedJWT(t *testing.T) {
+	for _, checkStore := range []bool{false, true} {
+		t.Run(map[bool]string{false: "offline", true: "store"}[checkStore], func(t *testing.T) {
+			t.Setenv(envJWTKeys, writeSigningKey(t))
+			t.Setenv(envJWTCheckStore, strconv.FormatBool(checkStore))
+			service, router := newTestService(t)
+			router.GET("/resource", func(ginCtx *gin.Context) {
+				if _, e := service.ValidationBearerToken(ginCtx); nil != e {
+					ginCtx.AbortWithStatus(http.StatusUnauthorized)
+				}
+			})
+
+			status := func(access string) int {
+				request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+				request.Header.Set("Authorization", "Bearer "+access)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, request)
+
+				return recorder.Code
+			}
+
+			now := time.Now()
+			unlinked := issueJWT(t, service, testUserID, now)
+			refreshed := issueJWT(t, service, "maria", now)
+			revoked := issueJWT(t, service, "petr", now)
+			valid := issueJWT(t, service, "olga", now)
+			for _, access := range []string{unlinked, refreshed, revoked, valid} {
+				if !isJWT(access) || http.StatusOK != status(access) {
+					t.Fatal("issued JWT isn't accepted")
+				}
+			}
+
+			if e := service.RevokeUser(context.Background(), testUserID, testClientID); nil != e {
+				t.Fatal(e)
+			}
+			if e := service.tokenStore.RemoveByAccess(context.Background(), refreshed); nil != e {
+				t.Fatal(e)
+			}
+			_, e := service.tokenStore.RevokeToken(context.Background(), revoked, tokenTypeAccess, testClientID)
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			// Revoked tokens are rejected, token replaced by refresh is accepted until it expire unless store check
+			// is enabled
+			for name, access := range map[string]string{"unlinked": unlinked, "revoked": revoked} {
+				if code := status(access); http.StatusUnauthorized != code {
+					t.Errorf("%s token: status %d", name, code)
+				}
+			}
+			want := http.StatusOK
+			if checkStore {
+				want = http.StatusUnauthorized
+			}
+			if code := status(refreshed); want != code {
+				t.Errorf("refreshed token: status %d, want %d", code, want)
+			}
+			if code := status(valid); http.StatusOK != code {
+				t.Errorf("valid token: status %d", code)
+			}
+
+			// Token issued when the user linked the account again is accepted
+			if e = service.db.Update(func(tx *buntdb.Tx) error {
+				_, _, e := tx.Set(revokedKey(testUserID, testClientID), now.Add(-time.Second).Format(time.RFC3339Nano), nil)
+				return e
+			}); nil != e {
+				t.Fatal(e)
+			}
+			linked := issueJWT(t, service, testUserID, now)
+			if code := status(linked); http.StatusOK != code {
+				t.Errorf("token issued after revocation: status %d", code)
+			}
+
+			// Signature is always checked
+			forged := valid[:strings.LastIndex(valid, ".")] + revoked[strings.LastIndex(revoked, "."):]
+			if code := status(forged); http.StatusUnauthorized != code {
+				t.Errorf("forged token: status %d", code)
+			}
+		})
+	}
+}
+
+func TestUnlinkRejectJWT(t *testing.T) {
+	t.Setenv(envJWTKeys, writeSigningKey(t))
+	service, router := newTestService(t)
+	router.GET("/resource", func(ginCtx *gin.Context) {
+		if _, e := service.ValidationBearerToken(ginCtx); nil != e {
+			ginCtx.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+
+	status := func(access string) int {
+		request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		request.Header.Set("Authorization", "Bearer "+access)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	first := exchange(t, router, authorize(t, router, nil))
+	response := refresh(router, first.RefreshToken, nil)
+	second := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("refresh: status %d, %+v", response.StatusCode, second)
+	}
+
+	// Token replaced by refresh isn't in the store, but it's still valid JWT
+	for _, access := range []string{first.AccessToken, second.AccessToken} {
+		if !isJWT(access) || http.StatusOK != status(access) {
+			t.Fatal("issued JWT isn't accepted")
+		}
+	}
+
+	if e := service.RevokeUser(context.Background(), testUserID, testClientID); nil != e {
+		t.Fatal(e)
+	}
+
+	for name, access := range map[string]string{"refreshed": first.AccessToken, "current": second.AccessToken} {
+		if code := status(access); http.StatusUnauthorized != code {
+			t.Errorf("%s token of the unlinked user: status %d", name, code)
+		}
+	}
+}
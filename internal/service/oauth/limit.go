@@ -0,0 +1,67 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+)
+
+// requestClientID return client ID of the request, it isn't authenticated
+func requestClientID(r *http.Request) string {
+	if clientID, _, basic := r.BasicAuth(); basic {
+		if unescaped, e := url.QueryUnescape(clientID); nil == e {
+			return unescaped
+		}
+
+		return clientID
+	}
+
+	return r.PostFormValue("client_id")
+}
+
+// clientAllowed check rate limit of the request client, request is rejected when limit is exceeded. Client isn't
+// authenticated yet, so requests are limited by the client and address pair.
+func (service *Service) clientAllowed(ginCtx *gin.Context) bool {
+	clientID := requestClientID(ginCtx.Request)
+	if 0 == len(clientID) {
+		return true
+	}
+
+	wait, ok := service.limiter.AllowClientIP(clientID, ginCtx.ClientIP())
+	if !ok {
+		ratelimit.SetRetryAfter(ginCtx.Writer, wait)
+		ginCtx.Header("Cache-Control", "no-store")
+		ginCtx.JSON(http.StatusTooManyRequests, tokenError{
+			Error:       "temporarily_unavailable",
+			Description: "too many requests",
+		})
+	}
+
+	return ok
+}
+
+// clientFailed count failed authentication of the request client. Client ID isn't authenticated, so failure is
+// counted for the address only, otherwise anyone could lock the client by its ID.
+func (service *Service) clientFailed(ginCtx *gin.Context, reason string) {
+	service.limiter.FailIP(ginCtx.ClientIP(), reason)
+}
+
+// allowClient take token from the bucket of the client, Retry-After header is set when limit is exceeded
+func (service *Service) allowClient(ginCtx *gin.Context, clientID string) bool {
+	wait, ok := service.limiter.AllowClient(clientID)
+	if !ok {
+		ratelimit.SetRetryAfter(ginCtx.Writer, wait)
+	}
+
+	return ok
+}
+
+// tokenDigest return digest which identify bearer token in the rate limiter, token itself isn't kept in memory
+func tokenDigest(access string) string {
+	digest := sha256.Sum256([]byte(access))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newResourceRouter return test service with the resource of the test client
+func newResourceRouter(t *testing.T) (*Service, func(access string) *httptest.ResponseRecorder) {
+	t.Helper()
+
+	service, router := newTestService(t)
+	router.Use(service.limiter.Middleware())
+	router.GET("/resource", func(ginCtx *gin.Context) {
+		_, e := service.ValidationClientToken(ginCtx, []string{testClientID})
+		switch {
+		case ErrTooManyRequests == e:
+			ginCtx.AbortWithStatus(http.StatusTooManyRequests)
+		case nil != e:
+			ginCtx.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+
+	return service, func(access string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		request.RemoteAddr = "192.0.2.1:443"
+		request.Header.Set("Authorization", "Bearer "+access)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+}
+
+func TestInvalidTokensDontLockAddress(t *testing.T) {
+	t.Setenv("RATE_LIMIT_MAX_FAILURES", "3")
+	service, get := newResourceRouter(t)
+	issue(t, service.tokenStore, testUserID, testClientID, "valid")
+
+	// Cloud send requests of many users from the same address, some tokens are revoked
+	for i, access := range []string{"revoked1", "revoked2", "revoked3", "revoked4"} {
+		if code := get(access).Code; http.StatusUnauthorized != code {
+			t.Fatalf("invalid token %d: status %d", i, code)
+		}
+	}
+	if code := get("valid").Code; http.StatusOK != code {
+		t.Fatalf("valid token after failures of other tokens: status %d", code)
+	}
+
+	// Repeated token is locked
+	for i := 0; i < 3; i++ {
+		get("revoked1")
+	}
+	response := get("revoked1")
+	if http.StatusTooManyRequests != response.Code || 0 == len(response.Header().Get("Retry-After")) {
+		t.Fatalf("locked token: status %d, Retry-After %q", response.Code, response.Header().Get("Retry-After"))
+	}
+	if code := get("valid").Code; http.StatusOK != code {
+		t.Fatalf("valid token after lockout of other token: status %d", code)
+	}
+}
+
+func TestInvalidTokensLockBearerAddress(t *testing.T) {
+	t.Setenv("RATE_LIMIT_MAX_FAILURES", "3")
+	t.Setenv("RATE_LIMIT_BEARER_MAX_FAILURES", "5")
+	service, get := newResourceRouter(t)
+	issue(t, service.tokenStore, testUserID, testClientID, "valid")
+
+	// Scanner send random tokens, each of them fail once
+	for i, access := range []string{"random1", "random2", "random3", "random4", "random5"} {
+		if code := get(access).Code; http.StatusUnauthorized != code {
+			t.Fatalf("invalid token %d: status %d", i, code)
+		}
+	}
+
+	response := get("valid")
+	if http.StatusTooManyRequests != response.Code || 0 == len(response.Header().Get("Retry-After")) {
+		t.Fatalf("locked address: status %d, Retry-After %q", response.Code, response.Header().Get("Retry-After"))
+	}
+}
+
+// postToken send token request with credentials of the test client from the address
+func postToken(router http.Handler, ip string, secret string, form url.Values) int {
+	request := httptest.NewRequest(http.MethodPost, oauthEndpointToken, strings.NewReader(form.Encode()))
+	request.RemoteAddr = ip + ":443"
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(testClientID, secret)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func TestWrongSecretDontLockClient(t *testing.T) {
+	t.Setenv("RATE_LIMIT_MAX_FAILURES", "3")
+	service, router := newTestService(t)
+	token := exchange(t, router, authorize(t, router, nil))
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token.RefreshToken}}
+
+	// Attacker guess secret of the client, only its address is locked
+	for i := 0; i < 3; i++ {
+		if code := postToken(router, "198.51.100.1", "guessed", form); http.StatusUnauthorized != code {
+			t.Fatalf("wrong secret %d: status %d", i, code)
+		}
+	}
+	if _, ok := service.limiter.AllowIP("198.51.100.1"); ok {
+		t.Fatal("address isn't locked")
+	}
+
+	if _, ok := service.limiter.AllowClient(testClientID); !ok {
+		t.Fatal("client is locked")
+	}
+	if code := postToken(router, "192.0.2.1", "secret", form); http.StatusOK != code {
+		t.Fatalf("client after failures of other address: status %d", code)
+	}
+}
+
+func TestScannersDontDrainClient(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CLIENT_RATE", "0.001")
+	t.Setenv("RATE_LIMIT_CLIENT_BURST", "2")
+	_, router := newTestService(t)
+	token := exchange(t, router, authorize(t, router, nil))
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"guessed"}}
+
+	// Scanners send ID of the client from several addresses, each of them is limited
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		for i := 0; i < 2; i++ {
+			if code := postToken(router, ip, "guessed", form); http.StatusUnauthorized != code {
+				t.Fatalf("%s request %d: status %d", ip, i, code)
+			}
+		}
+		if code := postToken(router, ip, "guessed", form); http.StatusTooManyRequests != code {
+			t.Fatalf("%s over limit: status %d", ip, code)
+		}
+	}
+
+	form = url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token.RefreshToken}}
+	if code := postToken(router, "192.0.2.1", "secret", form); http.StatusOK != code {
+		t.Fatalf("client after scanners: status %d", code)
+	}
+}
+
+func TestInvalidGrantsDontLockAddress(t *testing.T) {
+	t.Setenv("RATE_LIMIT_MAX_FAILURES", "3")
+	service, router := newTestService(t)
+	token := exchange(t, router, authorize(t, router, nil))
+
+	// Cloud refresh revoked tokens of many users from the same address
+	for i := 0; i < 5; i++ {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"revoked"}}
+		if code := postToken(router, "192.0.2.1", "secret", form); http.StatusBadRequest != code {
+			t.Fatalf("revoked token %d: status %d", i, code)
+		}
+	}
+	if _, ok := service.limiter.AllowIP("192.0.2.1"); !ok {
+		t.Fatal("address is locked")
+	}
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token.RefreshToken}}
+	if code := postToken(router, "192.0.2.1", "secret", form); http.StatusOK != code {
+		t.Fatalf("refresh after invalid grants: status %d", code)
+	}
+}
+
+func TestBearerRequestsLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_IP_RATE", "0.001")
+	t.Setenv("RATE_LIMIT_IP_BURST", "1")
+	t.Setenv("RATE_LIMIT_CLIENT_RATE", "0.001")
+	t.Setenv("RATE_LIMIT_CLIENT_BURST", "3")
+	service, get := newResourceRouter(t)
+	issue(t, service.tokenStore, testUserID, testClientID, "valid")
+
+	// Requests with token aren't limited by the address limit, but limited by the client one
+	for i := 0; i < 3; i++ {
+		if code := get("valid").Code; http.StatusOK != code {
+			t.Fatalf("request %d: status %d", i, code)
+		}
+	}
+	if code := get("valid").Code; http.StatusTooManyRequests != code {
+		t.Fatalf("client over limit: status %d", code)
+	}
+}
@@ -0,0 +1,356 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+)
+
+const (
+	// sessionCookie is name of the login session cookie
+	sessionCookie = "alisa_session"
+	// sessionKeyPrefix is key prefix of the login session records
+	sessionKeyPrefix = "session:"
+	// sessionLifetime is time while user stay logged in
+	sessionLifetime = time.Minute * 30
+	// sessionIDLength is number of random bytes in the session ID and CSRF token
+	sessionIDLength = 32
+	// Actions of the authorization page forms
+	actionLogin  = "login"
+	actionLogout = "logout"
+	actionAllow  = "allow"
+	actionDeny   = "deny"
+)
+
+// clientIPKey is request context key of the client IP address
+type clientIPKey struct{}
+
+// clientIP return client IP address of the authorization request
+func clientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(clientIPKey{}).(string)
+	return ip
+}
+
+// loginSession is state of the authorization page session
+type loginSession struct {
+	ID string `json:"-"`
+	// UserID is ID of the logged-in user, empty until user logged in
+	UserID string `json:"user_id,omitempty"`
+	// CSRF is token which must be posted with every form
+	CSRF string `json:"csrf"`
+}
+
+// authorizePage is data of the authorization page template
+type authorizePage struct {
+	Action     string
+	CSRF       string
+	ClientName string
+	UserName   string
+	Scopes     []string
+	Login      string
+	Error      string
+}
+
+// authorizeTemplate is login and consent page
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Вход</title>
+<style>
+body{font-family:sans-serif;max-width:22em;margin:3em auto;padding:0 1em}
+input,button{display:block;width:100%;box-sizing:border-box;margin:.5em 0;padding:.5em;font-size:1em}
+.error{color:#c00}
+</style>
+</head>
+<body>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .UserName}}
+<p>Приложение <b>{{.ClientName}}</b> запрашивает доступ к устройствам пользователя <b>{{.UserName}}</b>:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<button type="submit" name="action" value="allow">Разрешить</button>
+<button type="submit" name="action" value="deny">Запретить</button>
+<button type="submit" name="action" value="logout">Войти под другим пользователем</button>
+</form>
+{{else if .Action}}
+<p>Вход для связки аккаунтов с приложением <b>{{.ClientName}}</b></p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<input type="hidden" name="action" value="login">
+<input name="username" value="{{.Login}}" placeholder="Пользователь" autocomplete="username" required autofocus>
+<input name="password" type="password" placeholder="Пароль" autocomplete="current-password" required>
+<button type="submit">Войти</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// scopeDescription return description of the scope shown on the consent page
+func scopeDescription(scope string) string {
+	switch scope {
+	case ScopeDevicesRead:
+		return "просмотр устройств и их состояния"
+	case ScopeDevicesControl:
+		return "управление устройствами"
+	default:
+		return scope
+	}
+}
+
+// randomToken return random URL-safe token
+func randomToken() (string, error) {
+	data := make([]byte, sessionIDLength)
+	if _, e := rand.Read(data); nil != e {
+		return "", e
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// newLoginSession return new anonymous session. Anonymous session isn't stored, so its ID kept in the cookie is
+// the CSRF token too, form can't be posted by other site which don't know the cookie.
+func newLoginSession() (*loginSession, error) {
+	id, e := randomToken()
+	if nil != e {
+		return nil, e
+	}
+
+	return &loginSession{ID: id, CSRF: id}, nil
+}
+
+// newUserSession return new session of the logged-in user
+func newUserSession(userID string) (*loginSession, error) {
+	id, e := randomToken()
+	if nil != e {
+		return nil, e
+	}
+
+	csrf, e := randomToken()
+	if nil != e {
+		return nil, e
+	}
+
+	return &loginSession{ID: id, UserID: userID, CSRF: csrf}, nil
+}
+
+// loadSession return session of the request cookie. Session which isn't stored is anonymous session of the cookie,
+// new anonymous session returned when request has no cookie.
+func (service *Service) loadSession(r *http.Request) (*loginSession, error) {
+	cookie, e := r.Cookie(sessionCookie)
+	if nil != e || 0 == len(cookie.Value) {
+		return newLoginSession()
+	}
+
+	var session loginSession
+	e = service.db.View(func(tx *buntdb.Tx) error {
+		data, e := tx.Get(sessionKeyPrefix + cookie.Value)
+		if nil != e {
+			return e
+		}
+
+		return json.Unmarshal([]byte(data), &session)
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return &loginSession{ID: cookie.Value, CSRF: cookie.Value}, nil
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	session.ID = cookie.Value
+
+	return &session, nil
+}
+
+// saveSession set session cookie, only session of the logged-in user is stored. Previous session is removed when
+// session ID changed.
+func (service *Service) saveSession(w http.ResponseWriter, r *http.Request, session *loginSession, previousID string) error {
+	data, e := json.Marshal(session)
+	if nil != e {
+		return e
+	}
+
+	if e = service.db.Update(func(tx *buntdb.Tx) error {
+		if len(previousID) > 0 && previousID != session.ID {
+			if _, e := tx.Delete(sessionKeyPrefix + previousID); nil != e && !errors.Is(e, buntdb.ErrNotFound) {
+				return e
+			}
+		}
+
+		// Anonymous page views don't grow the database
+		if 0 == len(session.UserID) {
+			return nil
+		}
+
+		_, _, e := tx.Set(sessionKeyPrefix+session.ID, string(data),
+			&buntdb.SetOptions{Expires: true, TTL: sessionLifetime})
+		return e
+	}); nil != e {
+		return e
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session.ID,
+		Path:     oauthEndpointPrefix,
+		MaxAge:   int(sessionLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   nil != r.TLS || strings.EqualFold("https", r.Header.Get("X-Forwarded-Proto")),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// renderPage send authorization page
+func renderPage(w http.ResponseWriter, status int, page authorizePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Page must not be framed, otherwise consent may be clickjacked
+	w.Header().Set("X-Frame-Options", "DENY")
+	// Form action isn't restricted, browsers apply it to the redirect to the client after the form is posted
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if e := authorizeTemplate.Execute(w, page); nil != e {
+		log.Log.Warnw("Authorization page isn't rendered", "error", e)
+	}
+}
+
+// authorizeUser is user authorization handler of the OAuth server. It show login page and consent page, user ID
+// is returned only when logged-in user allowed access to the client.
+func (service *Service) authorizeUser(w http.ResponseWriter, r *http.Request) (string, error) {
+	clientID := r.FormValue("client_id")
+	c, e := service.clientStore.get(clientID)
+	if nil != e {
+		renderPage(w, http.StatusBadRequest, authorizePage{Error: "Неизвестное приложение"})
+		return "", nil
+	}
+
+	// Redirect URI is checked before user interaction, so errors are never redirected to the foreign site
+	if redirectURI := r.FormValue("redirect_uri"); len(redirectURI) > 0 {
+		if e = validateRedirectURI(c.GetDomain(), redirectURI); nil != e {
+			renderPage(w, http.StatusBadRequest, authorizePage{Error: "Недопустимый адрес возврата"})
+			return "", nil
+		}
+	} else if 1 != len(c.RedirectURIs) {
+		// Redirect URI can't be chosen when client has several ones
+		renderPage(w, http.StatusBadRequest, authorizePage{Error: "Не указан адрес возврата"})
+		return "", nil
+	}
+
+	scope := strings.TrimSpace(r.FormValue("scope"))
+	if !c.allowed(scope) {
+		return "", oauthErrors.ErrInvalidScope
+	}
+	if 0 == len(scope) {
+		scope = strings.Join(c.Scopes, " ")
+	}
+
+	// Plain code challenge don't protect the code when authorization request is intercepted
+	if challenge := r.FormValue("code_challenge"); 0 == len(challenge) {
+		if c.requirePKCE() {
+			return "", oauthErrors.ErrCodeChallengeRquired
+		}
+	} else if oauth2.CodeChallengeS256 != oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method")) {
+		return "", oauthErrors.ErrUnsupportedCodeChallengeMethod
+	}
+
+	session, e := service.loadSession(r)
+	if nil != e {
+		return "", e
+	}
+	previousID := session.ID
+
+	page := authorizePage{
+		Action:     r.URL.RequestURI(),
+		ClientName: c.displayName(),
+	}
+	for _, s := range strings.Fields(scope) {
+		page.Scopes = append(page.Scopes, scopeDescription(s))
+	}
+
+	status := http.StatusOK
+	if http.MethodPost == r.Method {
+		csrf := r.PostFormValue("csrf_token")
+		if 1 != subtle.ConstantTimeCompare([]byte(csrf), []byte(session.CSRF)) {
+			renderPage(w, http.StatusForbidden, authorizePage{Error: "Сессия устарела, обновите страницу"})
+			return "", nil
+		}
+
+		switch r.PostFormValue("action") {
+		case actionLogin:
+			page.Login = strings.TrimSpace(r.PostFormValue("username"))
+			// Locked user isn't authenticated at all, so password can't be guessed during lockout
+			if wait, locked := service.limiter.UserLocked(page.Login); locked {
+				ratelimit.SetRetryAfter(w, wait)
+				status = http.StatusTooManyRequests
+				page.Error = "Слишком много неудачных попыток входа, повторите позже"
+				break
+			}
+
+			u, ok := service.users.authenticate(page.Login, r.PostFormValue("password"))
+			if !ok {
+				log.Log.Warnw("OAuth login failed", "user_id", page.Login, "client_id", clientID)
+				service.limiter.FailUser(page.Login, "invalid password")
+				service.limiter.FailIP(clientIP(r), "invalid password")
+				page.Error = "Неверное имя пользователя или пароль"
+				break
+			}
+			service.limiter.ResetUser(page.Login)
+
+			// New session ID after login prevent session fixation
+			if session, e = newUserSession(u.ID); nil != e {
+				return "", e
+			}
+		case actionLogout:
+			if session, e = newLoginSession(); nil != e {
+				return "", e
+			}
+		case actionAllow:
+			if len(session.UserID) > 0 {
+				log.Log.Infow("OAuth access allowed", "user_id", session.UserID, "client_id", clientID)
+				return session.UserID, nil
+			}
+		case actionDeny:
+			if len(session.UserID) > 0 {
+				return "", oauthErrors.ErrAccessDenied
+			}
+		}
+	}
+
+	if e = service.saveSession(w, r, session, previousID); nil != e {
+		return "", e
+	}
+
+	page.CSRF = session.CSRF
+	if len(session.UserID) > 0 {
+		page.UserName = session.UserID
+		if u, found := service.users[session.UserID]; found && len(u.Name) > 0 {
+			page.UserName = u.Name
+		}
+	}
+
+	if http.StatusOK == status && len(page.Error) > 0 {
+		status = http.StatusUnauthorized
+	}
+	renderPage(w, status, page)
+
+	return "", nil
+}
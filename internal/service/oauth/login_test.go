@@ -0,0 +1,108 @@
+package oauth
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// csrfPattern find CSRF token of the authorization page form
+var csrfPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// authorizeTarget is authorization request of the test client
+var authorizeTarget = oauthEndpointAuthorize + "?" + url.Values{
+	"response_type": {"code"},
+	"client_id":     {testClientID},
+	"redirect_uri":  {testRedirectURI},
+	"state":         {"state"},
+}.Encode()
+
+// sessionOf return session cookie and CSRF token of the authorization page
+func sessionOf(t *testing.T, response *http.Response) (*http.Cookie, string) {
+	t.Helper()
+
+	var session *http.Cookie
+	for _, cookie := range response.Cookies() {
+		if sessionCookie == cookie.Name {
+			session = cookie
+		}
+	}
+	if nil == session {
+		t.Fatal("session cookie isn't set")
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	match := csrfPattern.FindSubmatch(body)
+	if nil == match {
+		t.Fatalf("CSRF token isn't found in the page: %s", body)
+	}
+
+	return session, string(match[1])
+}
+
+func TestAnonymousSessionNotStored(t *testing.T) {
+	service, router := newTestService(t)
+
+	response := serve(router, http.MethodGet, authorizeTarget, nil)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("status %d", response.StatusCode)
+	}
+	if policy := response.Header.Get("Content-Security-Policy"); strings.Contains(policy, "form-action") {
+		t.Fatalf("form action restricted by %q, redirect to the client is blocked", policy)
+	}
+	cookie, csrf := sessionOf(t, response)
+
+	// Page views with and without cookie don't store anything
+	for i := 0; i < 3; i++ {
+		serve(router, http.MethodGet, authorizeTarget, nil)
+		response = serve(router, http.MethodGet, authorizeTarget, nil, cookie)
+		if _, again := sessionOf(t, response); csrf != again {
+			t.Fatal("CSRF token of the anonymous session changed")
+		}
+	}
+	if count := countKeys(t, service.db, sessionKeyPrefix); 0 != count {
+		t.Fatalf("%d anonymous sessions stored", count)
+	}
+
+	// Form of the other session is rejected
+	response = serve(router, http.MethodPost, authorizeTarget, url.Values{
+		"csrf_token": {"forged"},
+		"action":     {actionLogin},
+		"username":   {testUserID},
+		"password":   {"secret"},
+	}, cookie)
+	if http.StatusForbidden != response.StatusCode {
+		t.Fatalf("forged form: status %d", response.StatusCode)
+	}
+
+	response = serve(router, http.MethodPost, authorizeTarget, url.Values{
+		"csrf_token": {csrf},
+		"action":     {actionLogin},
+		"username":   {testUserID},
+		"password":   {"secret"},
+	}, cookie)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("login: status %d", response.StatusCode)
+	}
+	userCookie, userCSRF := sessionOf(t, response)
+	if userCookie.Value == cookie.Value || userCSRF == csrf {
+		t.Fatal("session isn't renewed after login")
+	}
+	if count := countKeys(t, service.db, sessionKeyPrefix); 1 != count {
+		t.Fatalf("%d sessions stored after login, want 1", count)
+	}
+
+	response = serve(router, http.MethodPost, authorizeTarget, url.Values{
+		"csrf_token": {userCSRF},
+		"action":     {actionAllow},
+	}, userCookie)
+	if http.StatusFound != response.StatusCode {
+		t.Fatalf("allow: status %d", response.StatusCode)
+	}
+	if location := response.Header.Get("Location"); !strings.HasPrefix(location, testRedirectURI+"?code=") {
+		t.Fatalf("allow: redirected to %s", location)
+	}
+}
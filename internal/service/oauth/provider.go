@@ -0,0 +1,454 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/env"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+const (
+	// envProviderUserInfoURL is userinfo URL of the external OAuth provider, e.g. "https://login.yandex.ru/info"
+	envProviderUserInfoURL = "OAUTH_PROVIDER_USERINFO_URL"
+	// envProviderIntrospectionURL is token introspection URL (RFC 7662) of the external OAuth provider
+	envProviderIntrospectionURL = "OAUTH_PROVIDER_INTROSPECTION_URL"
+	// envProviderClientID is client ID registered at the external provider, tokens of other clients are rejected.
+	// Provider issue tokens to any application, so it's required.
+	envProviderClientID = "OAUTH_PROVIDER_CLIENT_ID"
+	// envProviderClientSecret is client secret used to authenticate introspection requests
+	envProviderClientSecret = "OAUTH_PROVIDER_CLIENT_SECRET"
+	// envProviderAuthScheme is authorization scheme of the userinfo request, Yandex use "OAuth"
+	envProviderAuthScheme = "OAUTH_PROVIDER_AUTH_SCHEME"
+	// envProviderUsers is path to the JSON file which map external user IDs onto local user IDs. Provider
+	// authenticate any its user, so it's required and users which aren't mapped are rejected.
+	envProviderUsers = "OAUTH_PROVIDER_USERS"
+	// envProviderCacheTTL is time while validated token isn't checked by the provider again, e.g. "5m"
+	envProviderCacheTTL     = "OAUTH_PROVIDER_CACHE_TTL"
+	defaultProviderCacheTTL = time.Minute * 5
+	defaultAuthScheme       = "Bearer"
+	providerTimeout         = time.Second * 5
+	// cleanupInterval is period of the expired cache entries removal
+	cleanupInterval = time.Minute
+	// providerTokenKeyPrefix is key prefix of the validated provider tokens by token digest
+	providerTokenKeyPrefix = "provider:"
+)
+
+var (
+	// errProviderUnavailable returned when external provider can't validate token
+	errProviderUnavailable = errors.New("oauth provider unavailable")
+)
+
+// providerResponse is fields of the userinfo and introspection responses used by the service
+type providerResponse struct {
+	// Active is introspection result, it's absent in the userinfo response
+	Active *bool `json:"active"`
+	// Subject is user ID of the OpenID Connect userinfo and introspection responses
+	Subject string `json:"sub"`
+	// ID is user ID of the Yandex userinfo response
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	ClientID string `json:"client_id"`
+	Expires  int64  `json:"exp"`
+}
+
+// cachedToken is validated token
+type cachedToken struct {
+	info    oauth2.TokenInfo
+	expires time.Time
+}
+
+// providerToken is stored provider token, it's kept while token is valid. Token of the unlinked user is kept as
+// revoked, because provider still accept it.
+type providerToken struct {
+	UserID   string `json:"user_id"`
+	ClientID string `json:"client_id"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+// provider validate tokens issued by the external OAuth provider
+type provider struct {
+	userInfoURL      string
+	introspectionURL string
+	clientID         string
+	clientSecret     string
+	authScheme       string
+	cacheTTL         time.Duration
+	// users map external user IDs onto local ones
+	users  map[string]string
+	client *http.Client
+	// db keep validated tokens, so tokens of the unlinked users are rejected after restart
+	db *buntdb.DB
+
+	mutex sync.Mutex
+	// tokens is validated tokens by token hash
+	tokens map[[sha256.Size]byte]cachedToken
+}
+
+// loadProvider return external provider configured by the environment variables, nil when it isn't configured
+func loadProvider() (*provider, error) {
+	result := &provider{
+		userInfoURL:      os.Getenv(envProviderUserInfoURL),
+		introspectionURL: os.Getenv(envProviderIntrospectionURL),
+		clientID:         os.Getenv(envProviderClientID),
+		clientSecret:     os.Getenv(envProviderClientSecret),
+		authScheme:       defaultAuthScheme,
+		client:           &http.Client{Timeout: providerTimeout},
+		tokens:           make(map[[sha256.Size]byte]cachedToken),
+	}
+
+	if 0 == len(result.userInfoURL) && 0 == len(result.introspectionURL) {
+		return nil, nil
+	}
+
+	if len(result.userInfoURL) > 0 && len(result.introspectionURL) > 0 {
+		return nil, fmt.Errorf("%s and %s can't be used together", envProviderUserInfoURL, envProviderIntrospectionURL)
+	}
+
+	if 0 == len(result.clientID) {
+		return nil, fmt.Errorf("%s is required with external OAuth provider", envProviderClientID)
+	}
+
+	if value, found := os.LookupEnv(envProviderAuthScheme); found {
+		result.authScheme = value
+	}
+
+	var e error
+	if result.cacheTTL, e = env.Duration(envProviderCacheTTL, defaultProviderCacheTTL); nil != e {
+		return nil, e
+	}
+
+	if result.users, e = loadProviderUsers(os.Getenv(envProviderUsers)); nil != e {
+		return nil, e
+	}
+
+	return result, nil
+}
+
+// loadProviderUsers return map of the external user IDs onto local user IDs
+func loadProviderUsers(path string) (map[string]string, error) {
+	if 0 == len(path) {
+		return nil, fmt.Errorf("%s is required with external OAuth provider", envProviderUsers)
+	}
+
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return nil, e
+	}
+
+	var config struct {
+		Users map[string]string `json:"users"`
+	}
+	if e = json.Unmarshal(data, &config); nil != e {
+		return nil, fmt.Errorf("provider users config %s: %w", path, e)
+	}
+
+	for external, local := range config.Users {
+		if 0 == len(external) || 0 == len(local) {
+			return nil, fmt.Errorf("provider users config %s: empty user ID", path)
+		}
+	}
+
+	return config.Users, nil
+}
+
+// run remove expired cache entries until context done
+func (p *provider) run(ctx context.Context) error {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			p.cleanup(now)
+		}
+	}
+}
+
+// cleanup remove expired cache entries
+func (p *provider) cleanup(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for hash, cached := range p.tokens {
+		if now.After(cached.expires) {
+			delete(p.tokens, hash)
+		}
+	}
+}
+
+// Validate return token info of the access token, provider is asked only when token isn't cached. Revoked token is
+// rejected without the provider.
+func (p *provider) Validate(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	hash := sha256.Sum256([]byte(access))
+	key := providerTokenKeyPrefix + hex.EncodeToString(hash[:])
+	now := time.Now()
+
+	if revoked, e := p.isRevoked(key); nil != e {
+		return nil, e
+	} else if revoked {
+		return nil, oauthErrors.ErrInvalidAccessToken
+	}
+
+	p.mutex.Lock()
+	cached, found := p.tokens[hash]
+	p.mutex.Unlock()
+
+	if found && now.Before(cached.expires) {
+		return cached.info, nil
+	}
+
+	response, e := p.request(ctx, access)
+	if nil == e {
+		var info oauth2.TokenInfo
+		var expires time.Time
+		if info, expires, e = p.tokenInfo(access, response, now); nil == e {
+			return p.accept(hash, key, info, expires)
+		}
+	}
+
+	// Token rejected by the provider isn't usable anymore, so it isn't kept
+	if errors.Is(e, oauthErrors.ErrInvalidAccessToken) {
+		_ = p.db.Update(func(tx *buntdb.Tx) error {
+			_, e := tx.Delete(key)
+			return e
+		})
+	}
+
+	return nil, e
+}
+
+// accept store and cache token validated by the provider
+func (p *provider) accept(hash [sha256.Size]byte, key string, info oauth2.TokenInfo,
+	expires time.Time) (oauth2.TokenInfo, error) {
+
+	if e := p.remember(key, info); nil != e {
+		return nil, e
+	}
+
+	p.mutex.Lock()
+	p.tokens[hash] = cachedToken{info: info, expires: expires}
+	p.mutex.Unlock()
+
+	return info, nil
+}
+
+// isRevoked return true when stored token is revoked
+func (p *provider) isRevoked(key string) (bool, error) {
+	var token providerToken
+
+	e := p.db.View(func(tx *buntdb.Tx) error {
+		value, e := tx.Get(key)
+		if nil != e {
+			return e
+		}
+
+		return json.Unmarshal([]byte(value), &token)
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return false, nil
+	}
+
+	return token.Revoked, e
+}
+
+// remember store validated token until it expire, or forever when its expiration isn't known. Token revoked
+// while provider was asked is rejected.
+func (p *provider) remember(key string, info oauth2.TokenInfo) error {
+	data, e := json.Marshal(&providerToken{UserID: info.GetUserID(), ClientID: info.GetClientID()})
+	if nil != e {
+		return e
+	}
+
+	var options *buntdb.SetOptions
+	if expiresIn := info.GetAccessExpiresIn(); expiresIn > 0 {
+		options = &buntdb.SetOptions{Expires: true, TTL: expiresIn}
+	}
+
+	return p.db.Update(func(tx *buntdb.Tx) error {
+		if value, e := tx.Get(key); nil == e {
+			var stored providerToken
+			if e = json.Unmarshal([]byte(value), &stored); nil == e && stored.Revoked {
+				return oauthErrors.ErrInvalidAccessToken
+			}
+		}
+
+		_, _, e := tx.Set(key, string(data), options)
+		return e
+	})
+}
+
+// request ask provider about the access token
+func (p *provider) request(ctx context.Context, access string) (*providerResponse, error) {
+	var request *http.Request
+	var e error
+	if len(p.introspectionURL) > 0 {
+		form := url.Values{"token": {access}, "token_type_hint": {tokenTypeAccess}}
+		if request, e = http.NewRequestWithContext(ctx, http.MethodPost, p.introspectionURL,
+			strings.NewReader(form.Encode())); nil != e {
+			return nil, e
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	} else {
+		if request, e = http.NewRequestWithContext(ctx, http.MethodGet, p.userInfoURL, nil); nil != e {
+			return nil, e
+		}
+		request.Header.Set("Authorization", p.authScheme+" "+access)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, e := p.client.Do(request)
+	if nil != e {
+		log.Log.Warnw("OAuth provider request failed", "error", e)
+		return nil, errProviderUnavailable
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	switch {
+	case http.StatusUnauthorized == response.StatusCode || http.StatusForbidden == response.StatusCode:
+		if len(p.introspectionURL) > 0 {
+			// Introspection request is authenticated by the service credentials, so they're wrong
+			log.Log.Errorw("OAuth provider rejected introspection request", "status", response.StatusCode)
+			return nil, errProviderUnavailable
+		}
+
+		return nil, oauthErrors.ErrInvalidAccessToken
+	case http.StatusOK != response.StatusCode:
+		log.Log.Warnw("OAuth provider request failed", "status", response.StatusCode)
+		return nil, errProviderUnavailable
+	}
+
+	var result providerResponse
+	if e = json.NewDecoder(response.Body).Decode(&result); nil != e {
+		log.Log.Warnw("OAuth provider response isn't parsed", "error", e)
+		return nil, errProviderUnavailable
+	}
+
+	return &result, nil
+}
+
+// tokenInfo return token info and cache expiration of the validated token
+func (p *provider) tokenInfo(access string, response *providerResponse, now time.Time) (oauth2.TokenInfo, time.Time, error) {
+	if nil != response.Active && !*response.Active {
+		return nil, time.Time{}, oauthErrors.ErrInvalidAccessToken
+	}
+
+	// Token issued to other client of the same provider must not grant access to the devices, token of the unknown
+	// client may be issued to any of them
+	if p.clientID != response.ClientID {
+		log.Log.Warnw("Token of other OAuth client rejected", "client_id", response.ClientID)
+		return nil, time.Time{}, oauthErrors.ErrInvalidAccessToken
+	}
+
+	externalID := response.Subject
+	if 0 == len(externalID) {
+		externalID = response.ID
+	}
+	if 0 == len(externalID) {
+		externalID = response.Username
+	}
+	if 0 == len(externalID) {
+		log.Log.Warn("OAuth provider response has no user ID")
+		return nil, time.Time{}, oauthErrors.ErrInvalidAccessToken
+	}
+
+	userID, found := p.users[externalID]
+	if !found {
+		log.Log.Warnw("External user isn't mapped onto local user", "external_user_id", externalID)
+		return nil, time.Time{}, oauthErrors.ErrInvalidAccessToken
+	}
+
+	expires := now.Add(p.cacheTTL)
+	if response.Expires > 0 {
+		tokenExpires := time.Unix(response.Expires, 0)
+		if !now.Before(tokenExpires) {
+			return nil, time.Time{}, oauthErrors.ErrInvalidAccessToken
+		}
+		if tokenExpires.Before(expires) {
+			expires = tokenExpires
+		}
+	}
+
+	// Provider scopes don't describe devices access, so user allowed by the provider get all devices scopes
+	token := models.NewToken()
+	token.SetClientID(response.ClientID)
+	token.SetUserID(userID)
+	token.SetScope(strings.Join(devicesScopes, " "))
+	token.SetAccess(access)
+	token.SetAccessCreateAt(now)
+	if response.Expires > 0 {
+		token.SetAccessExpiresIn(time.Unix(response.Expires, 0).Sub(now))
+	}
+
+	return token, expires, nil
+}
+
+// Forget reject tokens of the user issued to the client, or to all clients when client ID is empty. Provider still
+// accept these tokens, so all stored tokens of the user are kept as revoked until they expire.
+func (p *provider) Forget(userID string, clientID string) error {
+	p.mutex.Lock()
+	for hash, cached := range p.tokens {
+		if userID == cached.info.GetUserID() && (0 == len(clientID) || clientID == cached.info.GetClientID()) {
+			delete(p.tokens, hash)
+		}
+	}
+	p.mutex.Unlock()
+
+	return p.db.Update(func(tx *buntdb.Tx) error {
+		revoked := make(map[string]string)
+
+		if e := tx.AscendGreaterOrEqual("", providerTokenKeyPrefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, providerTokenKeyPrefix) {
+				return false
+			}
+
+			var token providerToken
+			if e := json.Unmarshal([]byte(value), &token); nil != e || token.Revoked || userID != token.UserID ||
+				(len(clientID) > 0 && clientID != token.ClientID) {
+				return true
+			}
+
+			token.Revoked = true
+			if data, e := json.Marshal(&token); nil == e {
+				revoked[key] = string(data)
+			}
+
+			return true
+		}); nil != e {
+			return e
+		}
+
+		for key, value := range revoked {
+			// Revoked token is kept as long as it's valid at the provider
+			var options *buntdb.SetOptions
+			if ttl, e := tx.TTL(key); nil == e && ttl > 0 {
+				options = &buntdb.SetOptions{Expires: true, TTL: ttl}
+			}
+
+			if _, _, e := tx.Set(key, value, options); nil != e {
+				return e
+			}
+		}
+
+		return nil
+	})
+}
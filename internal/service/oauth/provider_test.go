@@ -0,0 +1,259 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// testProviderClientID is client registered at the fake provider
+	testProviderClientID = "alisa"
+	// testProviderClientSecret is secret of the client registered at the fake provider
+	testProviderClientSecret = "provider-secret"
+)
+
+// setProviderUsers configure users of the provider, ivan and maria are mapped onto local users with the same IDs
+func setProviderUsers(t *testing.T) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "provider-users.json")
+	if e := os.WriteFile(path, []byte(`{"users":{"ivan":"ivan","maria":"maria"}}`), 0600); nil != e {
+		t.Fatal(e)
+	}
+	t.Setenv(envProviderUsers, path)
+}
+
+// newTestProvider return provider which introspect tokens by the fake endpoint, and number of its requests.
+// Endpoint answer by the responses map, token which isn't there is inactive.
+func newTestProvider(t *testing.T, responses map[string]providerResponse) (*provider, *int32) {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		clientID, clientSecret, found := r.BasicAuth()
+		if !found || testProviderClientID != clientID || testProviderClientSecret != clientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if http.MethodPost != r.Method || tokenTypeAccess != r.PostFormValue("token_type_hint") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, found := responses[r.PostFormValue("token")]
+		if !found {
+			inactive := false
+			response = providerResponse{Active: &inactive}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv(envProviderIntrospectionURL, server.URL)
+	t.Setenv(envProviderClientID, testProviderClientID)
+	t.Setenv(envProviderClientSecret, testProviderClientSecret)
+	setProviderUsers(t)
+
+	result, e := loadProvider()
+	if nil != e {
+		t.Fatal(e)
+	}
+	result.db = openTestDB(t, ":memory:")
+
+	return result, &requests
+}
+
+// openTestDB return database of the path, it's closed by the test cleanup
+func openTestDB(t *testing.T, path string) *buntdb.DB {
+	t.Helper()
+
+	db, e := buntdb.Open(path)
+	if nil != e {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func TestProviderIntrospection(t *testing.T) {
+	active := true
+	expires := time.Now().Add(time.Hour).Unix()
+	p, requests := newTestProvider(t, map[string]providerResponse{
+		"valid": {Active: &active, Subject: "ivan", ClientID: testProviderClientID, Expires: expires},
+		"other": {Active: &active, Subject: "ivan", ClientID: "other", Expires: expires},
+		"expired": {Active: &active, Subject: "ivan", ClientID: testProviderClientID,
+			Expires: time.Now().Add(-time.Minute).Unix()},
+		"anonym": {Active: &active, ClientID: testProviderClientID},
+	})
+
+	info, e := p.Validate(context.Background(), "valid")
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "ivan" != info.GetUserID() || testProviderClientID != info.GetClientID() {
+		t.Fatalf("unexpected token info %+v", info)
+	}
+
+	// Validated token is cached
+	if _, e = p.Validate(context.Background(), "valid"); nil != e {
+		t.Fatal(e)
+	}
+	if got := atomic.LoadInt32(requests); 1 != got {
+		t.Fatalf("got %d introspection requests, want 1", got)
+	}
+
+	for _, access := range []string{"inactive", "other", "expired", "anonym"} {
+		if _, e = p.Validate(context.Background(), access); !errors.Is(e, oauthErrors.ErrInvalidAccessToken) {
+			t.Errorf("%s: got %v, want %v", access, e, oauthErrors.ErrInvalidAccessToken)
+		}
+	}
+
+	// Wrong service credentials don't mean invalid token
+	p.clientSecret = "wrong"
+	if _, e = p.Validate(context.Background(), "other"); !errors.Is(e, errProviderUnavailable) {
+		t.Errorf("wrong credentials: got %v, want %v", e, errProviderUnavailable)
+	}
+}
+
+func TestProviderUserInfo(t *testing.T) {
+	log.Log = zap.NewNop().Sugar()
+
+	// Yandex userinfo response has numeric ID, login and client of the token
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses := map[string]providerResponse{
+			"OAuth valid":    {ID: "ivan", Username: "ivan.petrov", ClientID: testProviderClientID},
+			"OAuth other":    {ID: "ivan", ClientID: "other"},
+			"OAuth noclient": {ID: "ivan"},
+			"OAuth unmapped": {ID: "olga", ClientID: testProviderClientID},
+		}
+
+		response, found := responses[r.Header.Get("Authorization")]
+		if !found {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv(envProviderUserInfoURL, server.URL)
+	t.Setenv(envProviderAuthScheme, "OAuth")
+
+	// Client and users are required, otherwise token of any application and any user would be accepted
+	if _, e := loadProvider(); nil == e {
+		t.Fatal("provider without client is accepted")
+	}
+	t.Setenv(envProviderClientID, testProviderClientID)
+	if _, e := loadProvider(); nil == e {
+		t.Fatal("provider without users is accepted")
+	}
+	setProviderUsers(t)
+
+	p, e := loadProvider()
+	if nil != e {
+		t.Fatal(e)
+	}
+	p.db = openTestDB(t, ":memory:")
+
+	info, e := p.Validate(context.Background(), "valid")
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "ivan" != info.GetUserID() || testProviderClientID != info.GetClientID() {
+		t.Fatalf("unexpected token info %+v", info)
+	}
+
+	for _, access := range []string{"other", "noclient", "unmapped", "unknown"} {
+		if _, e = p.Validate(context.Background(), access); !errors.Is(e, oauthErrors.ErrInvalidAccessToken) {
+			t.Errorf("%s: got %v, want %v", access, e, oauthErrors.ErrInvalidAccessToken)
+		}
+	}
+}
+
+func TestProviderForget(t *testing.T) {
+	active := true
+	p, requests := newTestProvider(t, map[string]providerResponse{
+		"expiring": {Active: &active, Subject: "ivan", ClientID: testProviderClientID,
+			Expires: time.Now().Add(time.Hour).Unix()},
+		"endless": {Active: &active, Subject: "ivan", ClientID: testProviderClientID},
+		"seen":    {Active: &active, Subject: "ivan", ClientID: testProviderClientID},
+		"maria":   {Active: &active, Subject: "maria", ClientID: testProviderClientID},
+	})
+	path := filepath.Join(t.TempDir(), "oauth.db")
+	p.db = openTestDB(t, path)
+
+	for _, access := range []string{"expiring", "endless", "seen", "maria"} {
+		if _, e := p.Validate(context.Background(), access); nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	// Token which isn't used recently isn't cached, but it's revoked too
+	p.mutex.Lock()
+	delete(p.tokens, sha256.Sum256([]byte("seen")))
+	p.mutex.Unlock()
+
+	if e := p.Forget("ivan", ""); nil != e {
+		t.Fatal(e)
+	}
+
+	// Provider still accept tokens of the unlinked user, but they aren't asked anymore
+	validate := func(p *provider) {
+		t.Helper()
+
+		for _, access := range []string{"expiring", "endless", "seen"} {
+			if _, e := p.Validate(context.Background(), access); !errors.Is(e, oauthErrors.ErrInvalidAccessToken) {
+				t.Errorf("%s: got %v, want %v", access, e, oauthErrors.ErrInvalidAccessToken)
+			}
+		}
+		if _, e := p.Validate(context.Background(), "maria"); nil != e {
+			t.Errorf("maria: %v", e)
+		}
+	}
+	validate(p)
+	if got := atomic.LoadInt32(requests); 4 != got {
+		t.Fatalf("got %d introspection requests, want 4", got)
+	}
+
+	// Revocations survive restart and don't expire with the cache
+	if e := p.db.Close(); nil != e {
+		t.Fatal(e)
+	}
+	restarted, e := loadProvider()
+	if nil != e {
+		t.Fatal(e)
+	}
+	restarted.db = openTestDB(t, path)
+	restarted.cleanup(time.Now().Add(time.Hour * 48))
+
+	validate(restarted)
+	if got := atomic.LoadInt32(requests); 5 != got {
+		t.Fatalf("got %d introspection requests after restart, want 5", got)
+	}
+}
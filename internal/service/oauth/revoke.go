@@ -0,0 +1,66 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+// tokenError is error response of the token endpoints
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// authenticateClient return ID of the client authenticated by the method registered for the client
+func (service *Service) authenticateClient(r *http.Request) (string, bool) {
+	clientID, _, e := service.clientStore.clientCredentials(r)
+	if nil != e {
+		return "", false
+	}
+
+	return clientID, true
+}
+
+// onRevoke implement token revocation (RFC 7009). Revoked token invalidate all tokens of the same grant.
+func (service *Service) onRevoke(ginCtx *gin.Context) {
+	ginCtx.Header("Cache-Control", "no-store")
+
+	if !service.clientAllowed(ginCtx) {
+		return
+	}
+
+	clientID, ok := service.authenticateClient(ginCtx.Request)
+	if !ok {
+		service.clientFailed(ginCtx, "invalid client")
+		if _, _, basic := ginCtx.Request.BasicAuth(); basic {
+			ginCtx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		ginCtx.JSON(http.StatusUnauthorized, tokenError{Error: "invalid_client"})
+		return
+	}
+
+	token := ginCtx.PostForm("token")
+	if 0 == len(token) {
+		ginCtx.JSON(http.StatusBadRequest, tokenError{
+			Error:       "invalid_request",
+			Description: "token is required",
+		})
+		return
+	}
+
+	revoked, e := service.tokenStore.RevokeToken(ginCtx.Request.Context(), token, ginCtx.PostForm("token_type_hint"), clientID)
+	if nil != e {
+		log.Log.Errorw("Token revocation failed", "client_id", clientID, "error", e)
+		ginCtx.JSON(http.StatusServiceUnavailable, tokenError{Error: "temporarily_unavailable"})
+		return
+	}
+
+	if revoked {
+		log.Log.Infow("Token revoked", "client_id", clientID)
+	}
+
+	// Unknown and already revoked tokens aren't reported, as required by RFC 7009
+	ginCtx.Status(http.StatusOK)
+}
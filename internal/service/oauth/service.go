@@ -2,117 +2,544 @@ package oauth
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/manage"
-	"github.com/go-oauth2/oauth2/v4/models"
 	oauthserver "github.com/go-oauth2/oauth2/v4/server"
-	"github.com/go-oauth2/oauth2/v4/store"
 	"github.com/pior/runnable"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/env"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/pkg/eventbus"
 )
 
 const (
 	envYandexClientID      = "YANDEX_CLIENT_ID"
 	envYandexClientSecret  = "YANDEX_CLIENT_SECRET"
 	envCallbackURL         = "CALLBACK_URL"
-	yandexCallbackValue    = "https://social.yandex.net/broker/redirect"
-	oauthEndpointPrefix    = "/oauth"
-	oauthEndpointAuthorize = oauthEndpointPrefix + "/authorize"
-	oauthEndpointToken     = oauthEndpointPrefix + "/token"
+	envMarusyaClientID     = "MARUSYA_CLIENT_ID"
+	envMarusyaClientSecret = "MARUSYA_CLIENT_SECRET"
+	envMarusyaCallbackURL  = "MARUSYA_CALLBACK_URL"
+	envSberClientID        = "SBER_CLIENT_ID"
+	envSberClientSecret    = "SBER_CLIENT_SECRET"
+	envSberCallbackURL     = "SBER_CALLBACK_URL"
+	envGoogleClientID      = "GOOGLE_CLIENT_ID"
+	envGoogleClientSecret  = "GOOGLE_CLIENT_SECRET"
+	envGoogleCallbackURL   = "GOOGLE_CALLBACK_URL"
+	// envStorage is path of the file with tokens and clients, ":memory:" keep them in memory only
+	envStorage     = "OAUTH_STORAGE"
+	defaultStorage = "oauth.db"
+	// envAccessTokenLifetime is access token lifetime, e.g. "2h"
+	envAccessTokenLifetime = "OAUTH_ACCESS_TOKEN_LIFETIME"
+	// envRefreshTokenLifetime is refresh token lifetime, it's counted from the last refresh, e.g. "720h"
+	envRefreshTokenLifetime     = "OAUTH_REFRESH_TOKEN_LIFETIME"
+	defaultAccessTokenLifetime  = time.Hour * 2
+	defaultRefreshTokenLifetime = time.Hour * 24 * 30
+	yandexCallbackValue         = "https://social.yandex.net/broker/redirect"
+	oauthEndpointPrefix         = "/oauth"
+	oauthEndpointAuthorize      = oauthEndpointPrefix + "/authorize"
+	oauthEndpointToken          = oauthEndpointPrefix + "/token"
+	oauthEndpointRevoke         = oauthEndpointPrefix + "/revoke"
+	oauthEndpointIntrospect     = oauthEndpointPrefix + "/introspect"
+	oauthEndpointJWKS           = oauthEndpointPrefix + "/jwks"
 )
 
+// allowedGrantTypes is grant types of the token endpoint
+var allowedGrantTypes = []oauth2.GrantType{oauth2.AuthorizationCode, oauth2.Refreshing}
+
 // Service is Alisa service implementation
 type Service struct {
 	runnable.Runnable
 	oauthServer *oauthserver.Server
+	db          *buntdb.DB
+	tokenStore  *tokenStore
+	clientStore *clientStore
+	users       users
+	// signer issue JWT access tokens, nil when opaque tokens are issued
+	signer *signer
+	// provider validate tokens of the external OAuth provider, nil when tokens are issued by the service
+	provider *provider
+	limiter  *ratelimit.Service
+	// unlinkTopics is events topics of the front-ends, they're registered before service started
+	unlinkTopics []unlinkTopic
 }
 
-// NewService return new service implementation
-func NewService(router gin.IRoutes) (service *Service, e error) {
-	service = &Service{}
+// unlinkTopic is events topic where user ID is put when administrator revoke grant of the user with one of the clients
+type unlinkTopic struct {
+	bus       eventbus.Bus
+	topic     string
+	clientIDs []string
+}
 
-	manager := manage.NewDefaultManager()
-	// token memory store
-	manager.MustTokenStorage(store.NewMemoryTokenStore())
+// NewService return new service implementation. Admin API is added to the admin router, limiter count failed
+// authentications of the public endpoints.
+func NewService(router gin.IRoutes, adminRouter gin.IRoutes, limiter *ratelimit.Service) (service *Service, e error) {
+	service = &Service{
+		limiter: limiter,
+	}
 
-	clientID := ""
-	if value, found := os.LookupEnv(envYandexClientID); found {
-		clientID = value
+	if service.provider, e = loadProvider(); nil != e {
+		return nil, e
 	}
 
-	clientSecret := ""
-	if value, found := os.LookupEnv(envYandexClientSecret); found {
-		clientSecret = value
+	token, e := adminToken()
+	if nil != e {
+		return nil, e
 	}
 
-	callback := yandexCallbackValue
-	if value, found := os.LookupEnv(envCallbackURL); found {
-		callback = value
+	if nil != service.provider {
+		// Accounts are linked by the external provider, so the service don't issue tokens
+		if len(token) > 0 {
+			log.Log.Warnf("Admin API isn't available with external OAuth provider, %s is ignored", envAdminToken)
+		}
+
+		// Tokens of the unlinked users are still valid at the provider, so they're rejected by the stored state
+		if service.db, e = openStorage(); nil != e {
+			return nil, e
+		}
+		service.provider.db = service.db
+
+		return service, nil
 	}
 
-	// client memory store
-	clientStore := store.NewClientStore()
-	_ = clientStore.Set(clientID, &models.Client{
-		ID:     "000000",
-		Secret: clientSecret,
-		Domain: callback,
-	})
-	manager.MapClientStorage(clientStore)
+	if service.users, e = loadUsers(os.Getenv(envUsers)); nil != e {
+		return nil, e
+	}
 
-	service.oauthServer = oauthserver.NewDefaultServer(manager)
-	service.oauthServer.SetAllowGetAccessRequest(true)
-	service.oauthServer.SetClientInfoHandler(oauthserver.ClientFormHandler)
+	accessTokenLifetime, e := env.Duration(envAccessTokenLifetime, defaultAccessTokenLifetime)
+	if nil != e {
+		return nil, e
+	}
 
-	service.oauthServer.UserAuthorizationHandler = func(w http.ResponseWriter, r *http.Request) (string, error) {
-		if clientID := strings.TrimSpace(r.FormValue("client_id")); len(clientID) > 0 {
-			return clientID, nil
-		}
+	refreshTokenLifetime, e := env.Duration(envRefreshTokenLifetime, defaultRefreshTokenLifetime)
+	if nil != e {
+		return nil, e
+	}
 
-		return "", errors.ErrAccessDenied
+	if service.signer, e = loadSigner(); nil != e {
+		return nil, e
 	}
 
-	service.oauthServer.SetInternalErrorHandler(func(err error) (re *errors.Response) {
-		log.Println("Internal Error:", err.Error())
-		return
-	})
+	clients, e := configuredClients()
+	if nil != e {
+		return nil, e
+	}
+
+	// Tokens and clients survive restart, expired tokens are removed by the database in background
+	if service.db, e = openStorage(); nil != e {
+		return nil, e
+	}
 
-	service.oauthServer.SetResponseErrorHandler(func(re *errors.Response) {
-		log.Println("Response Error:", re.Error.Error())
+	manager := manage.NewDefaultManager()
+	manager.SetAuthorizeCodeTokenCfg(&manage.Config{
+		AccessTokenExp:    accessTokenLifetime,
+		RefreshTokenExp:   refreshTokenLifetime,
+		IsGenerateRefresh: true,
+	})
+	// Refresh token is rotated on each refresh, reuse of the old one is detected by the token store
+	manager.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		AccessTokenExp:     accessTokenLifetime,
+		RefreshTokenExp:    refreshTokenLifetime,
+		IsGenerateRefresh:  true,
+		IsResetRefreshTime: true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
 	})
+	if nil != service.signer {
+		service.signer.db = service.db
+		manager.MapAccessGenerate(service.signer)
+	}
+	service.tokenStore = newTokenStore(service.db)
+	manager.MapTokenStorage(service.tokenStore)
+
+	// Configuration is authoritative, so changed secrets and removed clients are applied on restart
+	service.clientStore = newClientStore(service.db)
+	if e = service.clientStore.Replace(clients); nil != e {
+		_ = service.db.Close()
+		return nil, e
+	}
+	manager.MapClientStorage(service.clientStore)
+	manager.SetValidateURIHandler(validateRedirectURI)
+
+	// Only authorization code flow is used by the assistants, other grants aren't exposed
+	service.oauthServer = oauthserver.NewServer(&oauthserver.Config{
+		TokenType:            "Bearer",
+		AllowedResponseTypes: []oauth2.ResponseType{oauth2.Code},
+		AllowedGrantTypes:    allowedGrantTypes,
+		// Plain method is rejected by the authorization handler, server require it for requests without challenge
+		AllowedCodeChallengeMethods: []oauth2.CodeChallengeMethod{oauth2.CodeChallengePlain, oauth2.CodeChallengeS256},
+	}, manager)
+	service.oauthServer.SetClientInfoHandler(service.tokenClient)
+
+	service.oauthServer.UserAuthorizationHandler = service.authorizeUser
+	service.oauthServer.SetAuthorizeScopeHandler(service.authorizeScope)
+	service.oauthServer.SetClientScopeHandler(service.clientStore.scopesHandler())
+	service.oauthServer.SetRefreshingScopeHandler(service.refreshingScope)
+
+	service.oauthServer.SetInternalErrorHandler(internalError)
+	service.oauthServer.SetResponseErrorHandler(responseError)
 
 	router.GET(oauthEndpointAuthorize, service.onAuthorize)
 	router.POST(oauthEndpointAuthorize, service.onAuthorize)
-	router.GET(oauthEndpointToken, service.onToken)
 	router.POST(oauthEndpointToken, service.onToken)
+	router.POST(oauthEndpointRevoke, service.onRevoke)
+	router.POST(oauthEndpointIntrospect, service.onIntrospect)
+	router.GET(oauthEndpointJWKS, service.onJWKS)
+
+	if len(token) > 0 {
+		service.registerAdmin(adminRouter, token)
+	}
 
 	return service, nil
 }
 
+// configuredClients return clients from the configuration file and clients configured by the environment variables
+func configuredClients() ([]*client, error) {
+	clients, e := loadClients(os.Getenv(envClients))
+	if nil != e {
+		return nil, e
+	}
+
+	// Assistants clients configured by the environment variables are allowed to use all devices scopes
+	for _, c := range []struct {
+		name            string
+		envClientID     string
+		envClientSecret string
+		envCallback     string
+		defaultCallback string
+	}{
+		{"Алиса", envYandexClientID, envYandexClientSecret, envCallbackURL, yandexCallbackValue},
+		{"Маруся", envMarusyaClientID, envMarusyaClientSecret, envMarusyaCallbackURL, ""},
+		{"Салют", envSberClientID, envSberClientSecret, envSberCallbackURL, ""},
+		{"Google Home", envGoogleClientID, envGoogleClientSecret, envGoogleCallbackURL, ""},
+	} {
+		configured, e := envClient(c.name, c.envClientID, c.envClientSecret, c.envCallback, c.defaultCallback)
+		if nil != e {
+			return nil, e
+		}
+
+		if nil != configured {
+			clients = append(clients, configured)
+		}
+	}
+
+	registered := make(map[string]bool)
+	for _, c := range clients {
+		if registered[c.ID] {
+			return nil, fmt.Errorf("client %s is registered twice", c.ID)
+		}
+		registered[c.ID] = true
+	}
+
+	return clients, nil
+}
+
+// openStorage open database of the service
+func openStorage() (*buntdb.DB, error) {
+	path := defaultStorage
+	if value, found := os.LookupEnv(envStorage); found {
+		path = value
+	}
+
+	return buntdb.Open(path)
+}
+
 // Run is implementation of runnable.Runnable interface
 func (service *Service) Run(ctx context.Context) error {
-	// Wait until operation complete
-	<-ctx.Done()
+	if nil != service.provider {
+		// Cache is cleaned until operation complete
+		_ = service.provider.run(ctx)
+	} else {
+		// Wait until operation complete
+		<-ctx.Done()
+	}
+
+	// Service is stopped after HTTP server, so requests don't use the database anymore
+	if e := service.db.Close(); nil != e {
+		return e
+	}
 
 	return ctx.Err()
 }
 
 // onAuthorize implement user authorization
 func (service *Service) onAuthorize(ginCtx *gin.Context) {
-	_ = service.oauthServer.HandleAuthorizeRequest(ginCtx.Writer, ginCtx.Request)
+	// Authorization handler receive only request, so address resolved by the router is passed in the context
+	r := ginCtx.Request.WithContext(context.WithValue(ginCtx.Request.Context(), clientIPKey{}, ginCtx.ClientIP()))
+
+	e := service.oauthServer.HandleAuthorizeRequest(ginCtx.Writer, r)
+	if nil == e || ginCtx.Writer.Written() {
+		return
+	}
+
+	// Error isn't redirected when request is invalid, redirect URI may be untrusted in such case
+	status := http.StatusBadRequest
+	if _, known := errors.Descriptions[e]; !known {
+		log.Log.Errorw("OAuth authorization failed", "error", e)
+		status = http.StatusInternalServerError
+	}
+	renderPage(ginCtx.Writer, status, authorizePage{Error: "Некорректный запрос авторизации"})
 }
 
 // onToken implement token issuing
 func (service *Service) onToken(ginCtx *gin.Context) {
+	if !service.clientAllowed(ginCtx) {
+		return
+	}
+
+	// Unsupported grants are rejected before the server, which don't report them as RFC 6749 require
+	grantType := oauth2.GrantType(ginCtx.PostForm("grant_type"))
+	supported := false
+	for _, allowed := range allowedGrantTypes {
+		supported = supported || allowed == grantType
+	}
+
+	if !supported {
+		ginCtx.Header("Cache-Control", "no-store")
+		ginCtx.JSON(http.StatusBadRequest, tokenError{
+			Error:       errors.ErrUnsupportedGrantType.Error(),
+			Description: errors.Descriptions[errors.ErrUnsupportedGrantType],
+		})
+		return
+	}
+
 	_ = service.oauthServer.HandleTokenRequest(ginCtx.Writer, ginCtx.Request)
+
+	// Only wrong credentials are counted. Invalid grants are usual for the assistant clouds, e.g. when refresh token
+	// is revoked, so they would lock the cloud address.
+	if http.StatusUnauthorized == ginCtx.Writer.Status() {
+		service.clientFailed(ginCtx, "invalid client")
+	}
 }
 
-// ValidationBearerToken do validate token on Resource Service
+// ValidationBearerToken do validate token on Resource Service. Invalid tokens are counted as failures of the token
+// and of the bearer requests from the address, the latter has higher threshold because assistant clouds send
+// requests of all users from few addresses. Expired tokens are used by the assistants before refresh, so they
+// aren't counted.
 func (service *Service) ValidationBearerToken(ginCtx *gin.Context) (oauth2.TokenInfo, error) {
+	access, found := bearerToken(ginCtx.Request)
+	if !found && nil != service.oauthServer {
+		// Server accept token in the request parameter too
+		access, found = service.oauthServer.BearerAuth(ginCtx.Request)
+	}
+	if !found {
+		return nil, errors.ErrInvalidAccessToken
+	}
+
+	digest := tokenDigest(access)
+	if wait, locked := service.limiter.TokenLocked(digest); locked {
+		ratelimit.SetRetryAfter(ginCtx.Writer, wait)
+		return nil, ErrTooManyRequests
+	}
+
+	tokenInfo, e := service.validateBearer(ginCtx)
+	if errors.ErrInvalidAccessToken == e {
+		service.limiter.FailToken(digest, "invalid access token")
+		service.limiter.FailBearerIP(ginCtx.ClientIP(), "invalid access token")
+	}
+
+	return tokenInfo, e
+}
+
+// ValidationClientToken validate token like ValidationBearerToken and reject tokens issued to other clients, so
+// token of one assistant can't be used at the front-end of another. Tokens of all clients are accepted when
+// clients list is empty. Requests of the client are rate limited.
+func (service *Service) ValidationClientToken(ginCtx *gin.Context, clientIDs []string) (oauth2.TokenInfo, error) {
+	tokenInfo, e := service.ValidationBearerToken(ginCtx)
+	if nil != e {
+		return nil, e
+	}
+
+	for _, clientID := range clientIDs {
+		if clientID != tokenInfo.GetClientID() {
+			continue
+		}
+
+		if !service.allowClient(ginCtx, clientID) {
+			return nil, ErrTooManyRequests
+		}
+
+		return tokenInfo, nil
+	}
+
+	if 0 == len(clientIDs) {
+		if !service.allowClient(ginCtx, tokenInfo.GetClientID()) {
+			return nil, ErrTooManyRequests
+		}
+
+		return tokenInfo, nil
+	}
+
+	log.Log.Warnw("Token of other client rejected",
+		"path", ginCtx.Request.URL.Path,
+		"client_id", tokenInfo.GetClientID())
+
+	return nil, errors.ErrInvalidAccessToken
+}
+
+// validateBearer validate token of the request. JWT access tokens are validated by signature and claims without
+// the store, unless store check is enabled, so revoked and refreshed tokens are accepted until they expire. Opaque
+// tokens issued before JWT enabled are checked in the store. Tokens of the external provider are validated by the
+// provider.
+func (service *Service) validateBearer(ginCtx *gin.Context) (oauth2.TokenInfo, error) {
+	if nil != service.provider {
+		access, found := bearerToken(ginCtx.Request)
+		if !found {
+			return nil, errors.ErrInvalidAccessToken
+		}
+
+		return service.provider.Validate(ginCtx.Request.Context(), access)
+	}
+
+	if nil != service.signer {
+		if access, found := service.oauthServer.BearerAuth(ginCtx.Request); found && isJWT(access) {
+			tokenInfo, e := service.signer.Validate(access)
+			if nil != e {
+				return nil, e
+			}
+
+			if !service.signer.checkStore {
+				return tokenInfo, nil
+			}
+
+			// Signature don't show revocation, so token is accepted only while it's in the store
+			if stored, e := service.tokenStore.hasAccess(access); nil != e {
+				return nil, e
+			} else if !stored {
+				return nil, errors.ErrInvalidAccessToken
+			}
+
+			return tokenInfo, nil
+		}
+	}
+
 	return service.oauthServer.ValidationBearerToken(ginCtx.Request)
 }
+
+// bearerToken return access token of the request authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(prefix, auth[:len(prefix)]) {
+		return "", false
+	}
+
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// HasScope return true when token grant the scope. Tokens without scope grant all scopes allowed to the client.
+func (service *Service) HasScope(tokenInfo oauth2.TokenInfo, scope string) bool {
+	granted := tokenInfo.GetScope()
+	if 0 == len(granted) {
+		c, e := service.clientStore.get(tokenInfo.GetClientID())
+		if nil != e {
+			return false
+		}
+
+		granted = strings.Join(c.Scopes, " ")
+	}
+
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LinkedUsers return users with active grants of the clients, or of all clients when clients list is empty. Users
+// of the external provider aren't stored, so none of them is returned.
+func (service *Service) LinkedUsers(clientIDs []string) ([]string, error) {
+	if nil != service.provider {
+		return nil, nil
+	}
+
+	filters := []grantFilter{{}}
+	if len(clientIDs) > 0 {
+		filters = make([]grantFilter, 0, len(clientIDs))
+		for _, clientID := range clientIDs {
+			filters = append(filters, grantFilter{ClientID: clientID})
+		}
+	}
+
+	linked := make(map[string]bool)
+	for _, filter := range filters {
+		grants, e := service.tokenStore.Grants(filter)
+		if nil != e {
+			return nil, e
+		}
+
+		for _, g := range grants {
+			linked[g.UserID] = true
+		}
+	}
+
+	userIDs := make([]string, 0, len(linked))
+	for userID := range linked {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	return userIDs, nil
+}
+
+// PublishUnlinks register events topic of the front-end, user ID is put there when administrator revoke grant of
+// the user with one of the clients, or with any client when clients list is empty. It's the topic where front-end
+// put user ID when user unlinked accounts, so subscribers handle both cases the same way.
+func (service *Service) PublishUnlinks(bus eventbus.Bus, topic string, clientIDs []string) {
+	service.unlinkTopics = append(service.unlinkTopics, unlinkTopic{
+		bus:       bus,
+		topic:     topic,
+		clientIDs: clientIDs,
+	})
+}
+
+// publishUnlinked put users of the revoked grants to the topics of the front-ends which serve their clients
+func (service *Service) publishUnlinked(grants []grant) {
+	for _, t := range service.unlinkTopics {
+		published := make(map[string]bool)
+		for _, g := range grants {
+			if published[g.UserID] || !t.serve(g.ClientID) {
+				continue
+			}
+
+			published[g.UserID] = true
+			t.bus.Publish(t.topic, g.UserID)
+		}
+	}
+}
+
+// serve return true when front-end of the topic accept tokens of the client
+func (t unlinkTopic) serve(clientID string) bool {
+	if 0 == len(t.clientIDs) {
+		return true
+	}
+
+	for _, id := range t.clientIDs {
+		if id == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RevokeUser revoke all tokens issued to the user by the client, or by all clients when client ID is empty
+func (service *Service) RevokeUser(ctx context.Context, userID string, clientID string) error {
+	if nil != service.provider {
+		return service.provider.Forget(userID, clientID)
+	}
+
+	return service.tokenStore.RevokeUser(ctx, userID, clientID)
+}
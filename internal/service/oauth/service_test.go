@@ -0,0 +1,641 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+const (
+	// testClientID is client configured by the test service
+	testClientID = "yandex"
+	// testRedirectURI is redirect URI of the test client
+	testRedirectURI = "https://social.yandex.net/broker/redirect"
+	// testUserID is user of the test service, password is "secret"
+	testUserID = "ivan"
+	// testPasswordHash is bcrypt hash of the password "secret"
+	testPasswordHash = "$2a$04$F2T955jcxFd2apGTe9Napuf.SkLN7JpzFxT8raHhmOrYoVlgDd.la"
+	// testPublicClientID is public client configured by the tests which need it
+	testPublicClientID = "mobile"
+	// testVerifier is PKCE code verifier of the test authorization requests
+	testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// tokenResponse is response of the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	Error        string `json:"error"`
+}
+
+// newTestService return service with database in memory and router which serve its endpoints
+func newTestService(t *testing.T) (*Service, *gin.Engine) {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+	gin.SetMode(gin.TestMode)
+
+	usersPath := filepath.Join(t.TempDir(), "users.json")
+	if e := os.WriteFile(usersPath,
+		[]byte(`{"users":[{"id":"`+testUserID+`","password":"`+testPasswordHash+`"}]}`), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	t.Setenv(envStorage, ":memory:")
+	t.Setenv(envUsers, usersPath)
+	t.Setenv(envYandexClientID, testClientID)
+	t.Setenv(envYandexClientSecret, "secret")
+
+	limiter, e := ratelimit.NewService(eventbus.New())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	router := gin.New()
+	service, e := NewService(router, router, limiter)
+	if nil != e {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		_ = service.db.Close()
+	})
+
+	return service, router
+}
+
+// setPublicClient configure public client which authenticate on the token endpoint only by the code verifier
+func setPublicClient(t *testing.T) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "clients.json")
+	if e := os.WriteFile(path, []byte(`{"clients":[{"id":"`+testPublicClientID+`","redirect_uris":["`+
+		testRedirectURI+`"],"scopes":["`+ScopeDevicesRead+`"],"token_endpoint_auth_method":"none"}]}`), 0600); nil != e {
+		t.Fatal(e)
+	}
+	t.Setenv(envClients, path)
+}
+
+// serve send request to the router and return the response
+func serve(router http.Handler, method string, target string, form url.Values, cookies ...*http.Cookie) *http.Response {
+	var request *http.Request
+	if nil == form {
+		request = httptest.NewRequest(method, target, nil)
+	} else {
+		request = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder.Result()
+}
+
+// authorizeRequest return authorization request of the test client with S256 code challenge of the test
+// verifier, parameters replace the default ones and empty parameters are removed
+func authorizeRequest(params url.Values) string {
+	challenge := sha256.Sum256([]byte(testVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"state"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for name, values := range params {
+		if 0 == len(values) {
+			query.Del(name)
+		} else {
+			query[name] = values
+		}
+	}
+
+	return oauthEndpointAuthorize + "?" + query.Encode()
+}
+
+// authorizeResponse log in the test user and allow access to the client, it return response of the first step
+// which isn't successful, or response of the consent
+func authorizeResponse(t *testing.T, router http.Handler, target string) *http.Response {
+	t.Helper()
+
+	response := serve(router, http.MethodGet, target, nil)
+	if http.StatusOK != response.StatusCode {
+		return response
+	}
+	cookie, csrf := sessionOf(t, response)
+
+	response = serve(router, http.MethodPost, target, url.Values{
+		"csrf_token": {csrf},
+		"action":     {actionLogin},
+		"username":   {testUserID},
+		"password":   {"secret"},
+	}, cookie)
+	if http.StatusOK != response.StatusCode {
+		return response
+	}
+	cookie, csrf = sessionOf(t, response)
+
+	return serve(router, http.MethodPost, target, url.Values{
+		"csrf_token": {csrf},
+		"action":     {actionAllow},
+	}, cookie)
+}
+
+// authorize return authorization code issued to the test client for the test user
+func authorize(t *testing.T, router http.Handler, params url.Values) string {
+	t.Helper()
+
+	response := authorizeResponse(t, router, authorizeRequest(params))
+	if http.StatusFound != response.StatusCode {
+		t.Fatalf("authorization: status %d", response.StatusCode)
+	}
+
+	location, e := url.Parse(response.Header.Get("Location"))
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	code := location.Query().Get("code")
+	if 0 == len(code) {
+		t.Fatalf("authorization: redirected to %s", location)
+	}
+
+	return code
+}
+
+// requestToken send request to the token endpoint, authenticated by the test client credentials with Basic scheme
+func requestToken(router http.Handler, form url.Values) *http.Response {
+	request := httptest.NewRequest(http.MethodPost, oauthEndpointToken, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(testClientID, "secret")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder.Result()
+}
+
+// decodeToken return decoded response of the token endpoint
+func decodeToken(t *testing.T, response *http.Response) tokenResponse {
+	t.Helper()
+
+	var result tokenResponse
+	if e := json.NewDecoder(response.Body).Decode(&result); nil != e {
+		t.Fatalf("token response isn't decoded: %v", e)
+	}
+
+	return result
+}
+
+// exchange return tokens issued for the authorization code
+func exchange(t *testing.T, router http.Handler, code string) tokenResponse {
+	t.Helper()
+
+	response := requestToken(router, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	})
+	token := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode || 0 == len(token.AccessToken) || 0 == len(token.RefreshToken) {
+		t.Fatalf("code exchange: status %d, %+v", response.StatusCode, token)
+	}
+
+	return token
+}
+
+// refresh send refresh request of the token
+func refresh(router http.Handler, refreshToken string, params url.Values) *http.Response {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	for name, values := range params {
+		form[name] = values
+	}
+
+	return requestToken(router, form)
+}
+
+// countKeys return number of the database records with the prefix
+func countKeys(t *testing.T, db *buntdb.DB, prefix string) int {
+	t.Helper()
+
+	var count int
+	if e := db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(prefix+"*", func(_, _ string) bool {
+			count++
+			return true
+		})
+	}); nil != e {
+		t.Fatal(e)
+	}
+
+	return count
+}
+
+func TestClientTokens(t *testing.T) {
+	service, router := newTestService(t)
+	issue(t, service.tokenStore, testUserID, testClientID, "yandex-token")
+	issue(t, service.tokenStore, testUserID, "google", "google-token")
+
+	for path, clientIDs := range map[string][]string{"/yandex": {testClientID}, "/any": nil} {
+		clientIDs := clientIDs
+		router.GET(path, func(ginCtx *gin.Context) {
+			if _, e := service.ValidationClientToken(ginCtx, clientIDs); nil != e {
+				ginCtx.AbortWithStatus(http.StatusUnauthorized)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		path   string
+		access string
+		want   int
+	}{
+		{"/yandex", "yandex-token", http.StatusOK},
+		{"/yandex", "google-token", http.StatusUnauthorized},
+		{"/any", "yandex-token", http.StatusOK},
+		{"/any", "google-token", http.StatusOK},
+		{"/any", "unknown-token", http.StatusUnauthorized},
+	} {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		request.Header.Set("Authorization", "Bearer "+test.access)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if test.want != recorder.Code {
+			t.Errorf("%s with %s: status %d, want %d", test.path, test.access, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	service, router := newTestService(t)
+	first := exchange(t, router, authorize(t, router, nil))
+
+	response := refresh(router, first.RefreshToken, nil)
+	second := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("refresh: status %d, %+v", response.StatusCode, second)
+	}
+
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatalf("tokens aren't rotated: %+v", second)
+	}
+	if second.Scope != first.Scope {
+		t.Errorf("refreshed scope %q, want %q", second.Scope, first.Scope)
+	}
+	if found, _ := service.tokenStore.hasAccess(first.AccessToken); found {
+		t.Error("access token isn't replaced by refresh")
+	}
+	if found, _ := service.tokenStore.hasAccess(second.AccessToken); !found {
+		t.Error("refreshed access token isn't stored")
+	}
+
+	// Rotated token can be used once, so refresh with the latest token succeed
+	response = refresh(router, second.RefreshToken, nil)
+	third := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("second refresh: status %d, %+v", response.StatusCode, third)
+	}
+
+	grants, e := service.tokenStore.Grants(grantFilter{})
+	if nil != e || 1 != len(grants) {
+		t.Fatalf("refreshed tokens aren't single grant %+v: %v", grants, e)
+	}
+}
+
+func TestRefreshReuseRevokeFamily(t *testing.T) {
+	service, router := newTestService(t)
+	first := exchange(t, router, authorize(t, router, nil))
+	other := exchange(t, router, authorize(t, router, nil))
+
+	response := refresh(router, first.RefreshToken, nil)
+	second := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode {
+		t.Fatalf("refresh: status %d, %+v", response.StatusCode, second)
+	}
+
+	// Rotated token is reused, it may be stolen, so whole family is revoked
+	response = refresh(router, first.RefreshToken, nil)
+	result := decodeToken(t, response)
+	if http.StatusBadRequest != response.StatusCode || "invalid_grant" != result.Error {
+		t.Fatalf("reused refresh token: status %d, %+v", response.StatusCode, result)
+	}
+
+	if found, _ := service.tokenStore.hasAccess(second.AccessToken); found {
+		t.Error("access token of the family isn't revoked")
+	}
+	response = refresh(router, second.RefreshToken, nil)
+	if result = decodeToken(t, response); http.StatusBadRequest != response.StatusCode || "invalid_grant" != result.Error {
+		t.Errorf("refresh token of the revoked family: status %d, %+v", response.StatusCode, result)
+	}
+
+	// Other grant of the same user and client isn't affected
+	if found, _ := service.tokenStore.hasAccess(other.AccessToken); !found {
+		t.Error("access token of other family is revoked")
+	}
+	if response = refresh(router, other.RefreshToken, nil); http.StatusOK != response.StatusCode {
+		t.Errorf("refresh token of other family: status %d", response.StatusCode)
+	}
+}
+
+func TestRefreshScope(t *testing.T) {
+	_, router := newTestService(t)
+	first := exchange(t, router, authorize(t, router, url.Values{"scope": {ScopeDevicesRead}}))
+	if ScopeDevicesRead != first.Scope {
+		t.Fatalf("granted scope %q, want %q", first.Scope, ScopeDevicesRead)
+	}
+
+	// Refresh can't widen the scope granted by the user
+	response := refresh(router, first.RefreshToken, url.Values{"scope": {ScopeDevicesRead + " " + ScopeDevicesControl}})
+	result := decodeToken(t, response)
+	if http.StatusBadRequest != response.StatusCode || "invalid_scope" != result.Error {
+		t.Fatalf("widened scope: status %d, %+v", response.StatusCode, result)
+	}
+
+	// Refresh without scope keep the granted scope
+	response = refresh(router, first.RefreshToken, nil)
+	second := decodeToken(t, response)
+	if http.StatusOK != response.StatusCode || ScopeDevicesRead != second.Scope {
+		t.Fatalf("refresh: status %d, %+v", response.StatusCode, second)
+	}
+}
+
+func TestAuthorizeRejected(t *testing.T) {
+	setPublicClient(t)
+
+	_, router := newTestService(t)
+
+	for _, test := range []struct {
+		name   string
+		params url.Values
+		// error is error redirected to the client, page with the status is shown instead when it's empty
+		error  string
+		status int
+	}{
+		{"unknown client", url.Values{"client_id": {"unknown"}}, "", http.StatusBadRequest},
+		{"redirect URI prefix", url.Values{"redirect_uri": {testRedirectURI + "/../evil"}}, "", http.StatusBadRequest},
+		{"redirect URI extended", url.Values{"redirect_uri": {testRedirectURI + "?next=evil"}}, "", http.StatusBadRequest},
+		{"redirect URI truncated", url.Values{"redirect_uri": {strings.TrimSuffix(testRedirectURI, "/redirect")}}, "",
+			http.StatusBadRequest},
+		{"plain code challenge", url.Values{"code_challenge": {testVerifier}, "code_challenge_method": {"plain"}},
+			"invalid_request", http.StatusFound},
+		{"default code challenge method", url.Values{"code_challenge_method": {""}}, "invalid_request",
+			http.StatusFound},
+		{"public client without code challenge", url.Values{"client_id": {testPublicClientID},
+			"code_challenge": {""}, "code_challenge_method": {""}}, "invalid_request", http.StatusFound},
+		{"unknown scope", url.Values{"scope": {"admin"}}, "invalid_scope", http.StatusFound},
+	} {
+		response := serve(router, http.MethodGet, authorizeRequest(test.params), nil)
+		if test.status != response.StatusCode {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+			continue
+		}
+
+		if 0 == len(test.error) {
+			if location := response.Header.Get("Location"); len(location) > 0 {
+				t.Errorf("%s: redirected to %s", test.name, location)
+			}
+			continue
+		}
+
+		location, e := url.Parse(response.Header.Get("Location"))
+		if nil != e || !strings.HasPrefix(location.String(), testRedirectURI+"?") ||
+			test.error != location.Query().Get("error") || "state" != location.Query().Get("state") {
+			t.Errorf("%s: redirected to %s", test.name, location)
+		}
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	setPublicClient(t)
+
+	_, router := newTestService(t)
+
+	// post send token request with form credentials, or without credentials when client ID isn't set
+	post := func(form url.Values) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, oauthEndpointToken, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Result()
+	}
+
+	code := func(clientID string) url.Values {
+		return url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {authorize(t, router, url.Values{"client_id": {clientID}})},
+			"redirect_uri": {testRedirectURI},
+		}
+	}
+
+	// Public client can't exchange the code without verifier, and code isn't usable after that
+	form := code(testPublicClientID)
+	form.Set("client_id", testPublicClientID)
+	if response := post(form); http.StatusBadRequest != response.StatusCode {
+		t.Errorf("public client without verifier: status %d", response.StatusCode)
+	}
+	form.Set("code_verifier", testVerifier)
+	if response := post(form); http.StatusOK == response.StatusCode {
+		t.Error("code is exchanged after failed attempt")
+	}
+
+	// Refresh token is usable only by its client authenticated by the secret
+	token := exchange(t, router, authorize(t, router, nil))
+	request := httptest.NewRequest(http.MethodPost, oauthEndpointToken, strings.NewReader(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(testClientID, "guessed")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if http.StatusUnauthorized != recorder.Code {
+		t.Errorf("refresh with wrong secret: status %d", recorder.Code)
+	}
+
+	response := post(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+		"client_id":     {testPublicClientID},
+	})
+	result := decodeToken(t, response)
+	if http.StatusBadRequest != response.StatusCode || "invalid_grant" != result.Error {
+		t.Errorf("refresh by other client: status %d, %+v", response.StatusCode, result)
+	}
+	if response = refresh(router, token.RefreshToken, nil); http.StatusOK != response.StatusCode {
+		t.Errorf("refresh by own client after rejected attempts: status %d", response.StatusCode)
+	}
+
+	// Confidential client must use only one authentication method
+	form = code(testClientID)
+	form.Set("code_verifier", testVerifier)
+	form.Set("client_id", testClientID)
+	form.Set("client_secret", "secret")
+	response = requestToken(router, form)
+	result = decodeToken(t, response)
+	if http.StatusBadRequest != response.StatusCode || "invalid_request" != result.Error {
+		t.Errorf("credentials in form and header: status %d, %+v", response.StatusCode, result)
+	}
+
+	// Secret isn't accepted from the form
+	form = code(testClientID)
+	form.Set("code_verifier", testVerifier)
+	form.Set("client_id", testClientID)
+	form.Set("client_secret", "secret")
+	response = post(form)
+	result = decodeToken(t, response)
+	if http.StatusUnauthorized != response.StatusCode || "invalid_client" != result.Error {
+		t.Errorf("secret in form: status %d, %+v", response.StatusCode, result)
+	}
+
+	// Token isn't issued by GET request, its parameters may be logged
+	if response = serve(router, http.MethodGet, oauthEndpointToken+"?"+form.Encode(), nil); http.StatusOK ==
+		response.StatusCode {
+		t.Error("token is issued by GET request")
+	}
+
+	// Error is JSON object which isn't cached (RFC 6749 section 5.2)
+	response = requestToken(router, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"guessed"},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	})
+	if http.StatusBadRequest != response.StatusCode {
+		t.Fatalf("guessed code: status %d", response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("error content type %s", contentType)
+	}
+	if cacheControl := response.Header.Get("Cache-Control"); "no-store" != cacheControl {
+		t.Errorf("error cache control %q", cacheControl)
+	}
+
+	var body map[string]interface{}
+	if e := json.NewDecoder(response.Body).Decode(&body); nil != e || "invalid_grant" != body["error"] {
+		t.Errorf("error body %+v: %v", body, e)
+	}
+	if _, found := body["access_token"]; found {
+		t.Errorf("error has token %+v", body)
+	}
+}
+
+func TestClientAuthMethod(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		secret string
+		valid  bool
+	}{
+		{"", "secret", true},
+		{authMethodBasic, "secret", true},
+		{authMethodBasic, "", false},
+		{authMethodNone, "", true},
+		{authMethodNone, "secret", false},
+		{"client_secret_post", "secret", false},
+	} {
+		c := client{
+			ID:                      testClientID,
+			Secret:                  test.secret,
+			RedirectURIs:            []string{testRedirectURI},
+			TokenEndpointAuthMethod: test.method,
+		}
+		if e := c.validate(); test.valid != (nil == e) {
+			t.Errorf("method %q, secret %q: %v", test.method, test.secret, e)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	service, router := newTestService(t)
+
+	revoke := func(token string, hint string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, oauthEndpointRevoke, strings.NewReader(url.Values{
+			"token":           {token},
+			"token_type_hint": {hint},
+		}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(testClientID, "secret")
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Result()
+	}
+
+	byAccess := exchange(t, router, authorize(t, router, nil))
+	byRefresh := exchange(t, router, authorize(t, router, nil))
+	kept := exchange(t, router, authorize(t, router, nil))
+
+	for _, test := range []struct {
+		name  string
+		token string
+		hint  string
+	}{
+		{"access token", byAccess.AccessToken, ""},
+		{"refresh token", byRefresh.RefreshToken, tokenTypeRefresh},
+		{"revoked token", byAccess.AccessToken, tokenTypeAccess},
+		{"unknown token", "unknown", ""},
+		{"wrong hint", "unknown", tokenTypeRefresh},
+	} {
+		if response := revoke(test.token, test.hint); http.StatusOK != response.StatusCode {
+			t.Errorf("%s: status %d", test.name, response.StatusCode)
+		}
+	}
+
+	// Revoked token invalidate all tokens of the grant
+	for name, access := range map[string]string{
+		"revoked":         byAccess.AccessToken,
+		"refresh revoked": byRefresh.AccessToken,
+	} {
+		if found, _ := service.tokenStore.hasAccess(access); found {
+			t.Errorf("%s access token is valid", name)
+		}
+	}
+	for name, refreshToken := range map[string]string{
+		"access revoked": byAccess.RefreshToken,
+		"revoked":        byRefresh.RefreshToken,
+	} {
+		if response := refresh(router, refreshToken, nil); http.StatusBadRequest != response.StatusCode {
+			t.Errorf("%s refresh token: status %d", name, response.StatusCode)
+		}
+	}
+	if found, _ := service.tokenStore.hasAccess(kept.AccessToken); !found {
+		t.Error("token of other grant is revoked")
+	}
+
+	// Client is authenticated even when token is unknown
+	request := httptest.NewRequest(http.MethodPost, oauthEndpointRevoke, strings.NewReader("token=unknown"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(testClientID, "wrong")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if http.StatusUnauthorized != recorder.Code {
+		t.Errorf("invalid client: status %d", recorder.Code)
+	}
+}
@@ -0,0 +1,578 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/google/uuid"
+	"github.com/tidwall/buntdb"
+	"github.com/vedga/alisa/internal/pkg/log"
+)
+
+const (
+	// Key prefixes of the token store records
+	tokenKeyPrefix   = "token:"
+	codeKeyPrefix    = "code:"
+	accessKeyPrefix  = "access:"
+	refreshKeyPrefix = "refresh:"
+	userKeyPrefix    = "user:"
+	familyKeyPrefix  = "family:"
+	// usedKeyPrefix is key prefix of the rotated refresh tokens, their reuse revoke the token family
+	usedKeyPrefix = "used:"
+	// seenKeyPrefix is key prefix of the last refresh time of the token family
+	seenKeyPrefix = "seen:"
+	// revokedKeyPrefix is key prefix of the last revocation time of the JWT access tokens issued to the user by
+	// the client, signature don't show revocation so tokens issued before this time are rejected by the signer
+	revokedKeyPrefix = "revoked:"
+	// Token type hints of the revocation and introspection requests
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+// storedToken is token with ID of the token family. Tokens issued by refreshing belong to the family of
+// the refreshed token and keep its issue time.
+type storedToken struct {
+	models.Token
+	Family string    `json:"Family,omitempty"`
+	Issued time.Time `json:"Issued"`
+}
+
+// tokenStore is durable token store, it keep index of the tokens issued to each user.
+// Records are removed by the database when all credentials of the token expired.
+type tokenStore struct {
+	db *buntdb.DB
+}
+
+// newTokenStore return token store in the database
+func newTokenStore(db *buntdb.DB) *tokenStore {
+	return &tokenStore{
+		db: db,
+	}
+}
+
+// userKey return key of the user index record
+func userKey(userID string, tokenID string) string {
+	return userKeyPrefix + userID + ":" + tokenID
+}
+
+// familyKey return key of the family index record
+func familyKey(family string, tokenID string) string {
+	return familyKeyPrefix + family + ":" + tokenID
+}
+
+// expiration return options of the record which live until credential expired
+func expiration(createAt time.Time, expiresIn time.Duration, now time.Time) *buntdb.SetOptions {
+	if expiresIn <= 0 {
+		return nil
+	}
+
+	ttl := createAt.Add(expiresIn).Sub(now)
+	if ttl <= 0 {
+		// Already expired credential is removed by the next cleanup
+		ttl = time.Nanosecond
+	}
+
+	return &buntdb.SetOptions{Expires: true, TTL: ttl}
+}
+
+// longest return options of the record which live until all credentials expired
+func longest(options ...*buntdb.SetOptions) *buntdb.SetOptions {
+	var result *buntdb.SetOptions
+
+	for _, option := range options {
+		if nil == option {
+			// Record without expiration never expire
+			return nil
+		}
+
+		if nil == result || option.TTL > result.TTL {
+			result = option
+		}
+	}
+
+	return result
+}
+
+// Create is implementation of oauth2.TokenStore interface
+func (store *tokenStore) Create(_ context.Context, info oauth2.TokenInfo) error {
+	data, e := json.Marshal(info)
+	if nil != e {
+		return e
+	}
+
+	// Refreshed token is the token loaded from the store, so it already has family
+	var token storedToken
+	if e = json.Unmarshal(data, &token); nil != e {
+		return e
+	}
+
+	now := time.Now()
+	tokenID := uuid.NewString()
+	refreshed := len(token.Family) > 0
+	if !refreshed {
+		token.Family = tokenID
+	}
+	if token.Issued.IsZero() {
+		token.Issued = now
+	}
+
+	if data, e = json.Marshal(&token); nil != e {
+		return e
+	}
+
+	type credential struct {
+		key     string
+		options *buntdb.SetOptions
+	}
+
+	var credentials []credential
+	if code := info.GetCode(); len(code) > 0 {
+		credentials = append(credentials, credential{
+			key:     codeKeyPrefix + code,
+			options: expiration(info.GetCodeCreateAt(), info.GetCodeExpiresIn(), now),
+		})
+	}
+	if access := info.GetAccess(); len(access) > 0 {
+		credentials = append(credentials, credential{
+			key:     accessKeyPrefix + access,
+			options: expiration(info.GetAccessCreateAt(), info.GetAccessExpiresIn(), now),
+		})
+	}
+	if refresh := info.GetRefresh(); len(refresh) > 0 {
+		credentials = append(credentials, credential{
+			key:     refreshKeyPrefix + refresh,
+			options: expiration(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn(), now),
+		})
+	}
+
+	if 0 == len(credentials) {
+		return errors.New("token without credentials")
+	}
+
+	options := make([]*buntdb.SetOptions, 0, len(credentials))
+	for _, c := range credentials {
+		options = append(options, c.options)
+	}
+	recordOptions := longest(options...)
+
+	return store.db.Update(func(tx *buntdb.Tx) error {
+		if _, _, e := tx.Set(tokenKeyPrefix+tokenID, string(data), recordOptions); nil != e {
+			return e
+		}
+
+		if _, _, e := tx.Set(userKey(token.UserID, tokenID), token.ClientID, recordOptions); nil != e {
+			return e
+		}
+
+		if _, _, e := tx.Set(familyKey(token.Family, tokenID), "", recordOptions); nil != e {
+			return e
+		}
+
+		for _, c := range credentials {
+			if _, _, e := tx.Set(c.key, tokenID, c.options); nil != e {
+				return e
+			}
+		}
+
+		// Refresh is use of the grant by the client
+		if refreshed {
+			return setSeen(tx, token.Family, now, recordOptions)
+		}
+
+		return nil
+	})
+}
+
+// setSeen store last refresh time of the token family, it live as long as the refreshed token
+func setSeen(tx *buntdb.Tx, family string, now time.Time, options *buntdb.SetOptions) error {
+	_, _, e := tx.Set(seenKeyPrefix+family, now.Format(time.RFC3339Nano), options)
+	return e
+}
+
+// lastSeen return last refresh time of the token family, zero time is returned when it's unknown
+func lastSeen(tx *buntdb.Tx, family string) time.Time {
+	value, e := tx.Get(seenKeyPrefix + family)
+	if nil != e {
+		return time.Time{}
+	}
+
+	result, e := time.Parse(time.RFC3339Nano, value)
+	if nil != e {
+		return time.Time{}
+	}
+
+	return result
+}
+
+// hasAccess return true when access token isn't expired, revoked or replaced by refresh
+func (store *tokenStore) hasAccess(access string) (bool, error) {
+	e := store.db.View(func(tx *buntdb.Tx) error {
+		_, e := tx.Get(accessKeyPrefix + access)
+		return e
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return false, nil
+	}
+
+	return nil == e, e
+}
+
+// remove delete credential record, token itself is removed when it expired or user revoked
+func (store *tokenStore) remove(key string) error {
+	e := store.db.Update(func(tx *buntdb.Tx) error {
+		_, e := tx.Delete(key)
+		return e
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return nil
+	}
+
+	return e
+}
+
+// RemoveByCode is implementation of oauth2.TokenStore interface
+func (store *tokenStore) RemoveByCode(_ context.Context, code string) error {
+	return store.remove(codeKeyPrefix + code)
+}
+
+// RemoveByAccess is implementation of oauth2.TokenStore interface
+func (store *tokenStore) RemoveByAccess(_ context.Context, access string) error {
+	return store.remove(accessKeyPrefix + access)
+}
+
+// RemoveByRefresh is implementation of oauth2.TokenStore interface. It's called when refresh token rotated,
+// so the token is remembered as used until it expire.
+func (store *tokenStore) RemoveByRefresh(_ context.Context, refresh string) error {
+	e := store.db.Update(func(tx *buntdb.Tx) error {
+		key := refreshKeyPrefix + refresh
+
+		ttl, e := tx.TTL(key)
+		if nil != e {
+			return e
+		}
+
+		tokenID, e := tx.Delete(key)
+		if nil != e {
+			return e
+		}
+
+		token, e := loadToken(tx, tokenID)
+		if nil != e {
+			return e
+		}
+
+		var options *buntdb.SetOptions
+		if ttl > 0 {
+			options = &buntdb.SetOptions{Expires: true, TTL: ttl}
+		}
+
+		_, _, e = tx.Set(usedKeyPrefix+refresh, token.Family, options)
+		return e
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return nil
+	}
+
+	return e
+}
+
+// loadToken return token record
+func loadToken(tx *buntdb.Tx, tokenID string) (*storedToken, error) {
+	data, e := tx.Get(tokenKeyPrefix + tokenID)
+	if nil != e {
+		return nil, e
+	}
+
+	var token storedToken
+	if e = json.Unmarshal([]byte(data), &token); nil != e {
+		return nil, e
+	}
+
+	return &token, nil
+}
+
+// find return token by the credential key, buntdb.ErrNotFound is returned when credential isn't found
+func find(tx *buntdb.Tx, key string) (*storedToken, error) {
+	tokenID, e := tx.Get(key)
+	if nil != e {
+		return nil, e
+	}
+
+	return loadToken(tx, tokenID)
+}
+
+// get return token by the credential key, nil is returned when credential isn't found
+func (store *tokenStore) get(key string) (oauth2.TokenInfo, error) {
+	var result *storedToken
+
+	e := store.db.View(func(tx *buntdb.Tx) (e error) {
+		result, e = find(tx, key)
+		return e
+	})
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return nil, nil
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	return result, nil
+}
+
+// GetByCode is implementation of oauth2.TokenStore interface
+func (store *tokenStore) GetByCode(_ context.Context, code string) (oauth2.TokenInfo, error) {
+	return store.get(codeKeyPrefix + code)
+}
+
+// GetByAccess is implementation of oauth2.TokenStore interface
+func (store *tokenStore) GetByAccess(_ context.Context, access string) (oauth2.TokenInfo, error) {
+	return store.get(accessKeyPrefix + access)
+}
+
+// GetByRefresh is implementation of oauth2.TokenStore interface. Reuse of the rotated refresh token means it's
+// leaked, so all tokens of its family are revoked.
+func (store *tokenStore) GetByRefresh(_ context.Context, refresh string) (oauth2.TokenInfo, error) {
+	token, e := store.get(refreshKeyPrefix + refresh)
+	if nil != e || nil != token {
+		return token, e
+	}
+
+	e = store.db.Update(func(tx *buntdb.Tx) error {
+		family, e := tx.Get(usedKeyPrefix + refresh)
+		if nil != e {
+			return e
+		}
+
+		revoked, e := revokeFamily(tx, family)
+		if nil != e {
+			return e
+		}
+
+		log.Log.Warnw("Rotated refresh token reused, token family revoked", "family", family, "tokens", revoked)
+
+		return nil
+	})
+	if nil != e && !errors.Is(e, buntdb.ErrNotFound) {
+		return nil, e
+	}
+
+	return nil, nil
+}
+
+// lookup return active access or refresh token, nil is returned when token isn't found
+func (store *tokenStore) lookup(value string, hint string) (oauth2.TokenInfo, string, error) {
+	types := []string{tokenTypeAccess, tokenTypeRefresh}
+	if tokenTypeRefresh == hint {
+		types[0], types[1] = types[1], types[0]
+	}
+
+	for _, tokenType := range types {
+		prefix := accessKeyPrefix
+		if tokenTypeRefresh == tokenType {
+			prefix = refreshKeyPrefix
+		}
+
+		token, e := store.get(prefix + value)
+		if nil != e {
+			return nil, "", e
+		}
+
+		if nil != token {
+			return token, tokenType, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// RevokeToken revoke access or refresh token issued to the client with all tokens of its family.
+// It return false when token isn't found or it's issued to the other client.
+func (store *tokenStore) RevokeToken(_ context.Context, value string, hint string, clientID string) (bool, error) {
+	keys := []string{accessKeyPrefix + value, refreshKeyPrefix + value}
+	if tokenTypeRefresh == hint {
+		keys[0], keys[1] = keys[1], keys[0]
+	}
+
+	revoked := false
+	e := store.db.Update(func(tx *buntdb.Tx) error {
+		for _, key := range keys {
+			token, e := find(tx, key)
+			if errors.Is(e, buntdb.ErrNotFound) {
+				continue
+			}
+			if nil != e {
+				return e
+			}
+
+			if token.ClientID != clientID {
+				return nil
+			}
+
+			_, e = revokeFamily(tx, token.Family)
+			revoked = nil == e
+
+			return e
+		}
+
+		return nil
+	})
+
+	return revoked, e
+}
+
+// RevokeUser remove all authorization codes, access and refresh tokens issued to the user by the client.
+// Tokens issued by all clients are removed when client ID is empty.
+func (store *tokenStore) RevokeUser(_ context.Context, userID string, clientID string) error {
+	return store.db.Update(func(tx *buntdb.Tx) error {
+		prefix := userKeyPrefix + userID + ":"
+
+		var revoked []string
+		e := tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+
+			// Token ID never contain separator, so key of the other user with the same prefix is skipped
+			if tokenID := strings.TrimPrefix(key, prefix); !strings.Contains(tokenID, ":") {
+				if 0 == len(clientID) || value == clientID {
+					revoked = append(revoked, tokenID)
+				}
+			}
+
+			return true
+		})
+		if nil != e {
+			return e
+		}
+
+		for _, tokenID := range revoked {
+			if e = revoke(tx, tokenID); nil != e {
+				return e
+			}
+
+			if e = deleteKeys(tx, userKey(userID, tokenID)); nil != e {
+				return e
+			}
+		}
+
+		return nil
+	})
+}
+
+// revokeFamily remove all tokens of the family, it return number of removed tokens
+func revokeFamily(tx *buntdb.Tx, family string) (int, error) {
+	prefix := familyKeyPrefix + family + ":"
+
+	var tokenIDs []string
+	e := tx.AscendGreaterOrEqual("", prefix, func(key, _ string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+
+		tokenIDs = append(tokenIDs, strings.TrimPrefix(key, prefix))
+
+		return true
+	})
+	if nil != e {
+		return 0, e
+	}
+
+	for _, tokenID := range tokenIDs {
+		if e = revoke(tx, tokenID); nil != e {
+			return 0, e
+		}
+	}
+
+	if e = deleteKeys(tx, seenKeyPrefix+family); nil != e {
+		return 0, e
+	}
+
+	return len(tokenIDs), nil
+}
+
+// revoke remove token with all its credentials and index records
+func revoke(tx *buntdb.Tx, tokenID string) error {
+	token, e := loadToken(tx, tokenID)
+	if errors.Is(e, buntdb.ErrNotFound) {
+		return nil
+	}
+	if nil != e {
+		return e
+	}
+
+	if e = revokeAccess(tx, token, time.Now()); nil != e {
+		return e
+	}
+
+	keys := []string{tokenKeyPrefix + tokenID, userKey(token.UserID, tokenID)}
+	if len(token.Family) > 0 {
+		keys = append(keys, familyKey(token.Family, tokenID))
+	}
+	if code := token.GetCode(); len(code) > 0 {
+		keys = append(keys, codeKeyPrefix+code)
+	}
+	if access := token.GetAccess(); len(access) > 0 {
+		keys = append(keys, accessKeyPrefix+access)
+	}
+	if refresh := token.GetRefresh(); len(refresh) > 0 {
+		keys = append(keys, refreshKeyPrefix+refresh)
+	}
+
+	return deleteKeys(tx, keys...)
+}
+
+// revokedKey return key of the JWT revocation record
+func revokedKey(userID string, clientID string) string {
+	return revokedKeyPrefix + userID + ":" + clientID
+}
+
+// revokeAccess remember revocation time of the JWT access token, the record live until the revoked token expire.
+// Token ID isn't signed into the JWT, so all tokens issued to the user by the client before revocation are rejected.
+func revokeAccess(tx *buntdb.Tx, token *storedToken, now time.Time) error {
+	expiresAt := token.GetAccessCreateAt().Add(token.GetAccessExpiresIn())
+	if !isJWT(token.GetAccess()) || !expiresAt.After(now) {
+		return nil
+	}
+
+	key := revokedKey(token.UserID, token.ClientID)
+	ttl := expiresAt.Sub(now)
+	// Record of the other token may live longer
+	if current, e := tx.TTL(key); nil == e && current > ttl {
+		ttl = current
+	}
+
+	_, _, e := tx.Set(key, now.Format(time.RFC3339Nano), &buntdb.SetOptions{Expires: true, TTL: ttl})
+	return e
+}
+
+// revokedAt return last revocation time of the JWT access tokens issued to the user by the client, zero time is
+// returned when they aren't revoked
+func revokedAt(tx *buntdb.Tx, userID string, clientID string) time.Time {
+	value, e := tx.Get(revokedKey(userID, clientID))
+	if nil != e {
+		return time.Time{}
+	}
+
+	result, e := time.Parse(time.RFC3339Nano, value)
+	if nil != e {
+		return time.Time{}
+	}
+
+	return result
+}
+
+// deleteKeys remove records, missing records are ignored
+func deleteKeys(tx *buntdb.Tx, keys ...string) error {
+	for _, key := range keys {
+		if _, e := tx.Delete(key); nil != e && !errors.Is(e, buntdb.ErrNotFound) {
+			return e
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package oauth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/tidwall/buntdb"
+)
+
+func TestTokensSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oauth.db")
+
+	db, e := buntdb.Open(path)
+	if nil != e {
+		t.Fatal(e)
+	}
+	store := newTokenStore(db)
+
+	token := models.NewToken()
+	token.SetUserID(testUserID)
+	token.SetClientID(testClientID)
+	token.SetAccess("access")
+	token.SetAccessCreateAt(time.Now())
+	token.SetAccessExpiresIn(time.Hour)
+	token.SetRefresh("refresh")
+	token.SetRefreshCreateAt(time.Now())
+	token.SetRefreshExpiresIn(time.Hour * 24)
+	if e = store.Create(context.Background(), token); nil != e {
+		t.Fatal(e)
+	}
+	issue(t, store, "maria", testClientID, "revoked")
+	if e = store.RemoveByAccess(context.Background(), "revoked"); nil != e {
+		t.Fatal(e)
+	}
+
+	if e = db.Close(); nil != e {
+		t.Fatal(e)
+	}
+
+	if db, e = buntdb.Open(path); nil != e {
+		t.Fatal(e)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	store = newTokenStore(db)
+
+	info, e := store.GetByAccess(context.Background(), "access")
+	if nil != e || nil == info || testUserID != info.GetUserID() || testClientID != info.GetClientID() {
+		t.Fatalf("access token isn't restored %+v: %v", info, e)
+	}
+
+	info, e = store.GetByRefresh(context.Background(), "refresh")
+	if nil != e || nil == info || "access" != info.GetAccess() {
+		t.Fatalf("refresh token isn't restored %+v: %v", info, e)
+	}
+
+	// Expiration of the credentials is restored too
+	if e = db.View(func(tx *buntdb.Tx) error {
+		ttl, e := tx.TTL(accessKeyPrefix + "access")
+		if nil == e && (ttl <= 0 || ttl > time.Hour) {
+			t.Errorf("access token TTL %v", ttl)
+		}
+		return e
+	}); nil != e {
+		t.Fatal(e)
+	}
+
+	if found, _ := store.hasAccess("revoked"); found {
+		t.Error("revoked token is restored")
+	}
+
+	grants, e := store.Grants(grantFilter{})
+	if nil != e || 1 != len(grants) || testUserID != grants[0].UserID {
+		t.Errorf("got grants %+v: %v", grants, e)
+	}
+}
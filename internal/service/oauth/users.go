@@ -0,0 +1,83 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// envUsers is path to the JSON file with users allowed to link accounts
+	envUsers = "OAUTH_USERS"
+)
+
+// user is account which can log in on the authorization page
+type user struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Password is bcrypt hash of the password
+	Password string `json:"password"`
+}
+
+// users is user accounts by ID
+type users map[string]user
+
+var (
+	// dummyPassword is compared when user isn't found, so response time don't reveal existing accounts
+	dummyPassword, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+)
+
+// loadUsers return users from the configuration file, if path isn't empty
+func loadUsers(path string) (users, error) {
+	result := make(users)
+	if 0 == len(path) {
+		return result, nil
+	}
+
+	data, e := os.ReadFile(path)
+	if nil != e {
+		return nil, e
+	}
+
+	var config struct {
+		Users []user `json:"users"`
+	}
+	if e = json.Unmarshal(data, &config); nil != e {
+		return nil, fmt.Errorf("users config %s: %w", path, e)
+	}
+
+	for _, u := range config.Users {
+		if 0 == len(u.ID) {
+			return nil, fmt.Errorf("users config %s: user without id", path)
+		}
+
+		if _, e = bcrypt.Cost([]byte(u.Password)); nil != e {
+			return nil, fmt.Errorf("users config %s: user %s password isn't bcrypt hash: %w", path, u.ID, e)
+		}
+
+		if _, found := result[u.ID]; found {
+			return nil, fmt.Errorf("users config %s: duplicate user %s", path, u.ID)
+		}
+
+		result[u.ID] = u
+	}
+
+	return result, nil
+}
+
+// authenticate return user with the login and password, false if credentials are wrong
+func (all users) authenticate(login string, password string) (user, bool) {
+	u, found := all[login]
+	if !found {
+		_ = bcrypt.CompareHashAndPassword(dummyPassword, []byte(password))
+		return user{}, false
+	}
+
+	if e := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); nil != e {
+		return user{}, false
+	}
+
+	return u, true
+}
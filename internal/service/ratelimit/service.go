@@ -0,0 +1,436 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/pkg/env"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// Locked is events topic where service put Lockout when key is locked after repeated failures
+	Locked = "ratelimit:locked"
+)
+
+const (
+	// envIPRate is number of requests per second allowed to the single IP address
+	envIPRate = "RATE_LIMIT_IP_RATE"
+	// envIPBurst is number of requests the single IP address may send at once
+	envIPBurst = "RATE_LIMIT_IP_BURST"
+	// envClientRate is number of requests per second allowed to the single OAuth client
+	envClientRate = "RATE_LIMIT_CLIENT_RATE"
+	// envClientBurst is number of requests the single OAuth client may send at once
+	envClientBurst = "RATE_LIMIT_CLIENT_BURST"
+	// envBearerRate is number of requests per second with bearer token allowed to the single IP address.
+	// Assistant clouds send requests of all their users from few addresses, so it's higher than IP rate.
+	envBearerRate = "RATE_LIMIT_BEARER_RATE"
+	// envBearerBurst is number of requests with bearer token the single IP address may send at once
+	envBearerBurst = "RATE_LIMIT_BEARER_BURST"
+	// envMaxFailures is number of failures in the window which lock the key, 0 disable lockouts
+	envMaxFailures = "RATE_LIMIT_MAX_FAILURES"
+	// envBearerMaxFailures is number of invalid bearer tokens in the window which lock requests with bearer token
+	// from the IP address, 0 disable such lockouts. Cloud addresses are shared by many users, so it's higher than
+	// number of failures which lock the key.
+	envBearerMaxFailures = "RATE_LIMIT_BEARER_MAX_FAILURES"
+	// envFailureWindow is time while failures are counted, e.g. "10m"
+	envFailureWindow = "RATE_LIMIT_FAILURE_WINDOW"
+	// envLockout is time while locked key is rejected, e.g. "15m"
+	envLockout               = "RATE_LIMIT_LOCKOUT"
+	defaultIPRate            = 10
+	defaultIPBurst           = 50
+	defaultClientRate        = 50
+	defaultClientBurst       = 200
+	defaultBearerRate        = 100
+	defaultBearerBurst       = 500
+	defaultMaxFailures       = 10
+	defaultBearerMaxFailures = 100
+	defaultFailureWindow     = time.Minute * 10
+	defaultLockout           = time.Minute * 15
+	cleanupInterval          = time.Minute
+)
+
+// Key prefixes separate limits of the different subjects
+const (
+	keyIP     = "ip:"
+	keyBearer = "bearer:"
+	keyClient = "client:"
+	keyUser   = "user:"
+	keyToken  = "token:"
+)
+
+// Lockout is content of the Locked event
+type Lockout struct {
+	// Key is locked subject, e.g. "ip:192.0.2.1" or "user:ivan"
+	Key      string
+	Reason   string
+	Failures int
+	Until    time.Time
+}
+
+// bucket is token bucket of the single key
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// failures is failures counter of the single key
+type failures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// limit is rate and burst of the bucket
+type limit struct {
+	rate  float64
+	burst float64
+}
+
+// Service is rate limiting service implementation
+type Service struct {
+	runnable.Runnable
+	bus               eventbus.Bus
+	ipLimit           limit
+	clientLimit       limit
+	bearerLimit       limit
+	maxFailures       int
+	bearerMaxFailures int
+	failureWindow     time.Duration
+	lockout           time.Duration
+
+	mutex    sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failures
+}
+
+// NewService return new service implementation
+func NewService(bus eventbus.Bus) (service *Service, e error) {
+	service = &Service{
+		bus:      bus,
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]*failures),
+	}
+
+	if service.ipLimit, e = limitEnv(envIPRate, defaultIPRate, envIPBurst, defaultIPBurst); nil != e {
+		return nil, e
+	}
+
+	if service.clientLimit, e = limitEnv(envClientRate, defaultClientRate, envClientBurst, defaultClientBurst); nil != e {
+		return nil, e
+	}
+
+	if service.bearerLimit, e = limitEnv(envBearerRate, defaultBearerRate, envBearerBurst, defaultBearerBurst); nil != e {
+		return nil, e
+	}
+
+	if service.maxFailures, e = countEnv(envMaxFailures, defaultMaxFailures); nil != e {
+		return nil, e
+	}
+
+	if service.bearerMaxFailures, e = countEnv(envBearerMaxFailures, defaultBearerMaxFailures); nil != e {
+		return nil, e
+	}
+
+	if service.failureWindow, e = env.Duration(envFailureWindow, defaultFailureWindow); nil != e {
+		return nil, e
+	}
+
+	if service.lockout, e = env.Duration(envLockout, defaultLockout); nil != e {
+		return nil, e
+	}
+
+	return service, nil
+}
+
+// limitEnv return limit from the environment variables, or default values when they aren't set
+func limitEnv(envRate string, defaultRate float64, envBurst string, defaultBurst float64) (limit, error) {
+	result := limit{rate: defaultRate, burst: defaultBurst}
+
+	if value, found := os.LookupEnv(envRate); found {
+		var e error
+		if result.rate, e = strconv.ParseFloat(value, 64); nil != e || result.rate <= 0 {
+			return limit{}, fmt.Errorf("%s must be positive number", envRate)
+		}
+	}
+
+	if value, found := os.LookupEnv(envBurst); found {
+		var e error
+		if result.burst, e = strconv.ParseFloat(value, 64); nil != e || result.burst < 1 {
+			return limit{}, fmt.Errorf("%s must be at least 1", envBurst)
+		}
+	}
+
+	return result, nil
+}
+
+// countEnv return non-negative number from the environment variable, or default value when it isn't set
+func countEnv(name string, defaultValue int) (int, error) {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return defaultValue, nil
+	}
+
+	count, e := strconv.Atoi(value)
+	if nil != e || count < 0 {
+		return 0, fmt.Errorf("%s must be non-negative number", name)
+	}
+
+	return count, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			service.cleanup(now)
+		}
+	}
+}
+
+// cleanup remove full buckets and outdated failure counters
+func (service *Service) cleanup(now time.Time) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	for key, b := range service.buckets {
+		if service.refill(key, b, now) >= service.limitOf(key).burst {
+			delete(service.buckets, key)
+		}
+	}
+
+	for key, f := range service.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.windowStart) > service.failureWindow {
+			delete(service.failures, key)
+		}
+	}
+}
+
+// limitOf return limit of the key
+func (service *Service) limitOf(key string) limit {
+	switch {
+	case strings.HasPrefix(key, keyClient):
+		return service.clientLimit
+	case strings.HasPrefix(key, keyBearer):
+		return service.bearerLimit
+	default:
+		return service.ipLimit
+	}
+}
+
+// maxFailuresOf return number of failures which lock the key
+func (service *Service) maxFailuresOf(key string) int {
+	if strings.HasPrefix(key, keyBearer) {
+		return service.bearerMaxFailures
+	}
+
+	return service.maxFailures
+}
+
+// refill add tokens accumulated since the last update and return number of tokens in the bucket
+func (service *Service) refill(key string, b *bucket, now time.Time) float64 {
+	l := service.limitOf(key)
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	return b.tokens
+}
+
+// allow take token from the bucket of the key, time to wait is returned when key is limited or locked
+func (service *Service) allow(key string) (time.Duration, bool) {
+	now := time.Now()
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if f, found := service.failures[key]; found && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now), false
+	}
+
+	b, found := service.buckets[key]
+	if !found {
+		b = &bucket{tokens: service.limitOf(key).burst, updated: now}
+		service.buckets[key] = b
+	}
+
+	if service.refill(key, b, now) < 1 {
+		return time.Duration((1 - b.tokens) / service.limitOf(key).rate * float64(time.Second)), false
+	}
+	b.tokens--
+
+	return 0, true
+}
+
+// locked return time to wait when key is locked
+func (service *Service) locked(key string) (time.Duration, bool) {
+	now := time.Now()
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if f, found := service.failures[key]; found && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now), true
+	}
+
+	return 0, false
+}
+
+// fail count failure of the key and lock it when too many failures happen in the window
+func (service *Service) fail(key string, reason string) {
+	maxFailures := service.maxFailuresOf(key)
+	if 0 == maxFailures {
+		return
+	}
+
+	now := time.Now()
+
+	service.mutex.Lock()
+	f, found := service.failures[key]
+	if !found {
+		f = &failures{windowStart: now}
+		service.failures[key] = f
+	} else if now.Sub(f.windowStart) > service.failureWindow {
+		f.count, f.windowStart = 0, now
+	}
+	f.count++
+
+	var lockout *Lockout
+	if f.count >= maxFailures && !now.Before(f.lockedUntil) {
+		f.lockedUntil = now.Add(service.lockout)
+		lockout = &Lockout{Key: key, Reason: reason, Failures: f.count, Until: f.lockedUntil}
+		// Failures after lockout are counted again, so persistent attacker is locked again
+		f.count, f.windowStart = 0, now
+	}
+	service.mutex.Unlock()
+
+	if nil != lockout {
+		log.Log.Warnw("Access locked after repeated failures",
+			"key", lockout.Key,
+			"reason", lockout.Reason,
+			"failures", lockout.Failures,
+			"until", lockout.Until)
+		service.bus.Publish(Locked, *lockout)
+	}
+}
+
+// reset forget failures of the key which isn't locked
+func (service *Service) reset(key string) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if f, found := service.failures[key]; found && !time.Now().Before(f.lockedUntil) {
+		delete(service.failures, key)
+	}
+}
+
+// Middleware return handler which reject requests of the locked IP addresses and of the addresses which exceed
+// the rate limit. Requests with bearer token have separate higher limit and lockout, their tokens and clients are
+// limited when token is validated.
+func (service *Service) Middleware() gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		allow := service.AllowIP
+		if hasBearer(ginCtx.Request) {
+			allow = service.AllowBearerIP
+		}
+
+		if wait, ok := allow(ginCtx.ClientIP()); !ok {
+			Reject(ginCtx, wait)
+			return
+		}
+
+		ginCtx.Next()
+	}
+}
+
+// hasBearer return true when request is authorized by bearer token
+func hasBearer(r *http.Request) bool {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	return len(auth) > len(prefix) && strings.EqualFold(prefix, auth[:len(prefix)])
+}
+
+// Reject abort request with Too Many Requests status and Retry-After header
+func Reject(ginCtx *gin.Context, wait time.Duration) {
+	SetRetryAfter(ginCtx.Writer, wait)
+	ginCtx.AbortWithStatus(http.StatusTooManyRequests)
+}
+
+// SetRetryAfter set Retry-After header in seconds, it's rounded up so client don't retry too early
+func SetRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// AllowIP take token from the bucket of the IP address, time to wait is returned when it's limited or locked
+func (service *Service) AllowIP(ip string) (time.Duration, bool) {
+	return service.allow(keyIP + ip)
+}
+
+// AllowBearerIP take token from the bucket of the requests with bearer token from the IP address, time to wait is
+// returned when it's limited or locked
+func (service *Service) AllowBearerIP(ip string) (time.Duration, bool) {
+	return service.allow(keyBearer + ip)
+}
+
+// AllowClient take token from the bucket of the OAuth client authenticated by the access token, time to wait is
+// returned when it's limited. Client is never locked.
+func (service *Service) AllowClient(clientID string) (time.Duration, bool) {
+	return service.allow(keyClient + clientID)
+}
+
+// AllowClientIP take token from the bucket of the OAuth client requests from the IP address, it's used before client
+// is authenticated. Bucket of the client itself would be drained by anyone who send its ID.
+func (service *Service) AllowClientIP(clientID string, ip string) (time.Duration, bool) {
+	return service.allow(keyClient + clientID + "@" + ip)
+}
+
+// UserLocked return time to wait when login of the user is locked
+func (service *Service) UserLocked(login string) (time.Duration, bool) {
+	return service.locked(keyUser + login)
+}
+
+// TokenLocked return time to wait when bearer token is locked, token is identified by its digest
+func (service *Service) TokenLocked(digest string) (time.Duration, bool) {
+	return service.locked(keyToken + digest)
+}
+
+// FailToken count failed validation of the bearer token, token is identified by its digest
+func (service *Service) FailToken(digest string, reason string) {
+	service.fail(keyToken+digest, reason)
+}
+
+// FailIP count failure of the request from the IP address, e.g. invalid password
+func (service *Service) FailIP(ip string, reason string) {
+	service.fail(keyIP+ip, reason)
+}
+
+// FailBearerIP count invalid bearer token sent from the IP address
+func (service *Service) FailBearerIP(ip string, reason string) {
+	service.fail(keyBearer+ip, reason)
+}
+
+// FailUser count failed login of the user
+func (service *Service) FailUser(login string, reason string) {
+	service.fail(keyUser+login, reason)
+}
+
+// ResetUser forget failures of the logged-in user
+func (service *Service) ResetUser(login string) {
+	service.reset(keyUser + login)
+}
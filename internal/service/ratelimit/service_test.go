@@ -0,0 +1,298 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+// newTestService return service with the environment and lockouts published to the returned list
+func newTestService(t *testing.T, environment map[string]string) (*Service, func() []Lockout) {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+	for name, value := range environment {
+		t.Setenv(name, value)
+	}
+
+	var lock sync.Mutex
+	var lockouts []Lockout
+
+	bus := eventbus.New()
+	if e := bus.Subscribe(Locked, func(lockout Lockout) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		lockouts = append(lockouts, lockout)
+	}); nil != e {
+		t.Fatal(e)
+	}
+
+	service, e := NewService(bus)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	return service, func() []Lockout {
+		lock.Lock()
+		defer lock.Unlock()
+
+		result := lockouts
+		lockouts = nil
+
+		return result
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for name, value := range map[string]string{
+		envIPRate:            "0",
+		envClientRate:        "fast",
+		envBearerBurst:       "0.5",
+		envMaxFailures:       "-1",
+		envFailureWindow:     "-1m",
+		envLockout:           "forever",
+		envBearerMaxFailures: "many",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, e := NewService(eventbus.New()); nil == e {
+				t.Errorf("%s=%s is accepted", name, value)
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{
+		envIPRate:      "20",
+		envIPBurst:     "3",
+		envBearerRate:  "20",
+		envBearerBurst: "5",
+	})
+
+	// Burst is allowed at once
+	for i := 0; i < 3; i++ {
+		if _, ok := service.AllowIP("192.0.2.1"); !ok {
+			t.Fatalf("request %d of the burst is limited", i+1)
+		}
+	}
+
+	wait, ok := service.AllowIP("192.0.2.1")
+	if ok || wait <= 0 || wait > time.Second/20 {
+		t.Fatalf("request after burst: allowed %v, wait %v", ok, wait)
+	}
+
+	// Other address and requests with bearer token have own buckets
+	if _, ok = service.AllowIP("192.0.2.2"); !ok {
+		t.Error("other address is limited")
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok = service.AllowBearerIP("192.0.2.1"); !ok {
+			t.Fatalf("request %d with bearer token is limited", i+1)
+		}
+	}
+	if _, ok = service.AllowBearerIP("192.0.2.1"); ok {
+		t.Error("bearer burst isn't limited")
+	}
+
+	// Bucket is refilled with the rate, but not above the burst
+	time.Sleep(time.Second / 10)
+	for i := 0; i < 2; i++ {
+		if _, ok = service.AllowIP("192.0.2.1"); !ok {
+			t.Fatalf("request %d isn't allowed after refill", i+1)
+		}
+	}
+
+	time.Sleep(time.Second / 2)
+	for i := 0; i < 3; i++ {
+		if _, ok = service.AllowIP("192.0.2.1"); !ok {
+			t.Fatalf("request %d isn't allowed after full refill", i+1)
+		}
+	}
+	if _, ok = service.AllowIP("192.0.2.1"); ok {
+		t.Error("bucket is refilled above the burst")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	service, published := newTestService(t, map[string]string{
+		envMaxFailures:   "3",
+		envFailureWindow: "1h",
+		envLockout:       "200ms",
+	})
+
+	for i := 0; i < 2; i++ {
+		service.FailUser("ivan", "invalid password")
+	}
+	if _, locked := service.UserLocked("ivan"); locked {
+		t.Fatal("user is locked before max failures")
+	}
+	if lockouts := published(); 0 != len(lockouts) {
+		t.Fatalf("lockout is published before max failures %+v", lockouts)
+	}
+
+	before := time.Now()
+	service.FailUser("ivan", "invalid password")
+	wait, locked := service.UserLocked("ivan")
+	if !locked || wait <= 0 || wait > time.Millisecond*200 {
+		t.Fatalf("user after max failures: locked %v, wait %v", locked, wait)
+	}
+
+	lockouts := published()
+	if 1 != len(lockouts) {
+		t.Fatalf("published lockouts %+v", lockouts)
+	}
+	if lockout := lockouts[0]; keyUser+"ivan" != lockout.Key || "invalid password" != lockout.Reason ||
+		3 != lockout.Failures || lockout.Until.Before(before.Add(time.Millisecond*200)) ||
+		lockout.Until.After(time.Now().Add(time.Millisecond*200)) {
+		t.Errorf("published lockout %+v", lockout)
+	}
+
+	// Failures of the locked user don't publish lockout again, other user isn't locked
+	service.FailUser("ivan", "invalid password")
+	if lockouts = published(); 0 != len(lockouts) {
+		t.Errorf("lockout is published again %+v", lockouts)
+	}
+	if _, locked = service.UserLocked("maria"); locked {
+		t.Error("other user is locked")
+	}
+
+	// Locked address is rejected by the bucket too
+	for i := 0; i < 3; i++ {
+		service.FailIP("192.0.2.1", "invalid client")
+	}
+	if _, ok := service.AllowIP("192.0.2.1"); ok {
+		t.Error("locked address is allowed")
+	}
+
+	// Lockout expire
+	time.Sleep(time.Millisecond * 250)
+	if _, locked = service.UserLocked("ivan"); locked {
+		t.Error("user is locked after lockout")
+	}
+	if _, ok := service.AllowIP("192.0.2.1"); !ok {
+		t.Error("address is locked after lockout")
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	service, published := newTestService(t, map[string]string{
+		envMaxFailures:       "3",
+		envBearerMaxFailures: "0",
+		envFailureWindow:     "100ms",
+		envLockout:           "1h",
+	})
+
+	// Failures out of the window aren't counted
+	service.FailUser("ivan", "invalid password")
+	service.FailUser("ivan", "invalid password")
+	time.Sleep(time.Millisecond * 150)
+	service.FailUser("ivan", "invalid password")
+	if _, locked := service.UserLocked("ivan"); locked {
+		t.Fatal("user is locked by failures out of the window")
+	}
+
+	// Successful login forget failures
+	service.FailUser("ivan", "invalid password")
+	service.ResetUser("ivan")
+	service.FailUser("ivan", "invalid password")
+	if _, locked := service.UserLocked("ivan"); locked {
+		t.Fatal("user is locked by failures before login")
+	}
+
+	// Zero max failures disable lockouts
+	for i := 0; i < 10; i++ {
+		service.FailBearerIP("192.0.2.1", "invalid token")
+	}
+	if _, ok := service.AllowBearerIP("192.0.2.1"); !ok {
+		t.Error("bearer requests are locked with disabled lockouts")
+	}
+
+	if lockouts := published(); 0 != len(lockouts) {
+		t.Errorf("published lockouts %+v", lockouts)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	service, _ := newTestService(t, map[string]string{
+		envMaxFailures:   "1",
+		envFailureWindow: "1m",
+		envLockout:       "1m",
+	})
+
+	service.AllowIP("192.0.2.1")
+	service.FailUser("ivan", "invalid password")
+	service.FailUser("maria", "invalid password")
+
+	// Locked key is kept until lockout expire
+	service.cleanup(time.Now().Add(time.Second * 30))
+	service.mutex.Lock()
+	buckets, failures := len(service.buckets), len(service.failures)
+	service.mutex.Unlock()
+	if 0 != buckets || 2 != failures {
+		t.Fatalf("after refill: %d buckets, %d failure counters", buckets, failures)
+	}
+
+	service.cleanup(time.Now().Add(time.Minute * 2))
+	service.mutex.Lock()
+	failures = len(service.failures)
+	service.mutex.Unlock()
+	if 0 != failures {
+		t.Fatalf("after lockout: %d failure counters", failures)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, _ := newTestService(t, map[string]string{
+		envIPRate:      "1",
+		envIPBurst:     "1",
+		envBearerRate:  "1",
+		envBearerBurst: "2",
+	})
+
+	router := gin.New()
+	router.Use(service.Middleware())
+	router.GET("/", func(ginCtx *gin.Context) {
+		ginCtx.Status(http.StatusOK)
+	})
+
+	request := func(bearer bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:40000"
+		if bearer {
+			r.Header.Set("Authorization", "Bearer token")
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	if code := request(false).Code; http.StatusOK != code {
+		t.Fatalf("first request: status %d", code)
+	}
+	if response := request(false); http.StatusTooManyRequests != response.Code ||
+		"1" != response.Header().Get("Retry-After") {
+		t.Fatalf("limited request: status %d, Retry-After %q", response.Code, response.Header().Get("Retry-After"))
+	}
+
+	// Requests with bearer token use own bucket of the address
+	for i := 0; i < 2; i++ {
+		if code := request(true).Code; http.StatusOK != code {
+			t.Fatalf("request %d with bearer token: status %d", i+1, code)
+		}
+	}
+	if code := request(true).Code; http.StatusTooManyRequests != code {
+		t.Fatalf("limited request with bearer token: status %d", code)
+	}
+}
@@ -0,0 +1,213 @@
+package sber
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// channelSeparator separate device ID and channel index in the Sber device ID
+	channelSeparator = ":"
+	// Tasmota-compatible white light temperature range
+	lightTemperatureMin = 2000
+	lightTemperatureMax = 6500
+)
+
+// endpointID return Sber device ID for the device channel. Channel 0 is device sensors.
+func endpointID(deviceID string, channel int) string {
+	if 0 == channel {
+		return deviceID
+	}
+
+	return deviceID + channelSeparator + strconv.Itoa(channel)
+}
+
+// findEndpoint return device and channel for the Sber device ID. Channel is nil for the sensors endpoint.
+func findEndpoint(deviceManager api.DeviceManager, id string) (string, api.Device, *api.Channel, error) {
+	deviceID, channelIndex := id, 0
+	if index := strings.LastIndex(id, channelSeparator); index >= 0 {
+		if channel, e := strconv.Atoi(id[index+1:]); nil == e {
+			deviceID, channelIndex = id[:index], channel
+		}
+	}
+
+	device, e := deviceManager.GetDevice(deviceID)
+	if nil != e {
+		return deviceID, nil, nil, e
+	}
+
+	if 0 == channelIndex {
+		if 0 == len(climateSensors(device.GetSensors())) {
+			return deviceID, nil, nil, api.ErrDeviceNotFound
+		}
+
+		return deviceID, device, nil, nil
+	}
+
+	for _, channel := range device.GetChannels() {
+		if channel.Index == channelIndex {
+			return deviceID, device, &channel, nil
+		}
+	}
+
+	return deviceID, nil, nil, api.ErrDeviceNotFound
+}
+
+// newDevices return all Sber devices provided by the device manager
+func newDevices(deviceManager api.DeviceManager) ([]Device, error) {
+	devices, e := deviceManager.EnumDevices()
+	if nil != e {
+		return nil, e
+	}
+
+	// Keep devices order stable between requests
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	result := make([]Device, 0, len(devices))
+	for _, deviceID := range deviceIDs {
+		result = append(result, newDeviceEndpoints(deviceID, devices[deviceID])...)
+	}
+
+	return result, nil
+}
+
+// newDeviceEndpoints return Sber devices for each device channel and for the device sensors
+func newDeviceEndpoints(deviceID string, device api.Device) (result []Device) {
+	name := strings.TrimSpace(device.GetName())
+	if 0 == len(name) {
+		name = deviceID
+	}
+
+	channels := device.GetChannels()
+	for _, channel := range channels {
+		endpoint := Device{
+			ID:              endpointID(deviceID, channel.Index),
+			Name:            strings.TrimSpace(channel.Name),
+			Model:           channelModel(device, channel),
+			HardwareVersion: device.GetType(),
+			SoftwareVersion: device.GetFirmwareVersion(),
+		}
+
+		if 0 == len(endpoint.Name) {
+			endpoint.Name = name
+			if len(channels) > 1 {
+				// Names must be different for each channel
+				endpoint.Name += " " + strconv.Itoa(channel.Index)
+			}
+		}
+		endpoint.DefaultName = endpoint.Name
+
+		result = append(result, endpoint)
+	}
+
+	if sensors := climateSensors(device.GetSensors()); len(sensors) > 0 {
+		result = append(result, Device{
+			ID:          endpointID(deviceID, 0),
+			Name:        name,
+			DefaultName: name,
+			Model: Model{
+				ID:           device.GetType() + "/" + categorySensorTemp,
+				Manufacturer: manufacturer(device),
+				Model:        device.GetType(),
+				Category:     categorySensorTemp,
+				Features:     append([]string{featureOnline}, sensors...),
+			},
+			HardwareVersion: device.GetType(),
+			SoftwareVersion: device.GetFirmwareVersion(),
+		})
+	}
+
+	return result
+}
+
+// manufacturer return device manufacturer name
+func manufacturer(device api.Device) string {
+	if route := device.GetRoute(); len(route.Integration) > 0 {
+		return route.Integration
+	}
+
+	return "alisa"
+}
+
+// channelModel return Sber model for the device channel
+func channelModel(device api.Device, channel api.Channel) Model {
+	model := Model{
+		Manufacturer: manufacturer(device),
+		Model:        device.GetType(),
+		Category:     categoryRelay,
+		Features:     []string{featureOnline, featureOnOff},
+	}
+
+	if api.ChannelLight == channel.Type && api.LightNone != channel.Light {
+		model.Category = categoryLight
+		model.Features = append(model.Features, featureBrightness)
+		model.AllowedValues = map[string]AllowedValue{
+			featureBrightness: {
+				Type: valueTypeInteger,
+				IntegerValues: &IntegerValues{
+					Min:  brightnessMin,
+					Max:  brightnessMax,
+					Step: 1,
+				},
+			},
+		}
+
+		color, temperature := lightAbilities(channel.Light)
+		if color {
+			model.Features = append(model.Features, featureColour)
+		}
+		if temperature {
+			model.Features = append(model.Features, featureColourTemp)
+			model.AllowedValues[featureColourTemp] = AllowedValue{
+				Type: valueTypeInteger,
+				IntegerValues: &IntegerValues{
+					Min:  colourTempMin,
+					Max:  colourTempMax,
+					Step: 1,
+				},
+			}
+		}
+		if color || temperature {
+			model.Features = append(model.Features, featureLightMode)
+		}
+	}
+
+	model.ID = device.GetType() + "/" + model.Category + "/" + strings.Join(model.Features, ",")
+
+	return model
+}
+
+// lightAbilities return is light support color and white temperature
+func lightAbilities(light api.LightType) (color bool, temperature bool) {
+	switch light {
+	case api.LightRGB, api.LightRGBW:
+		return true, false
+	case api.LightRGBCW:
+		return true, true
+	case api.LightCW:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// climateSensors return Sber features for the device sensors supported by the sensor_temp category
+func climateSensors(sensors []api.Sensor) (features []string) {
+	for _, sensor := range sensors {
+		switch sensor.Kind {
+		case api.SensorTemperature:
+			features = append(features, featureTemperature)
+		case api.SensorHumidity:
+			features = append(features, featureHumidity)
+		}
+	}
+
+	return features
+}
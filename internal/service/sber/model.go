@@ -0,0 +1,209 @@
+package sber
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Device categories
+const (
+	categoryLight      = "light"
+	categoryRelay      = "relay"
+	categorySensorTemp = "sensor_temp"
+)
+
+// Device features, feature is also a key of the state
+const (
+	featureOnline         = "online"
+	featureOnOff          = "on_off"
+	featureBrightness     = "light_brightness"
+	featureColour         = "light_colour"
+	featureColourTemp     = "light_colour_temp"
+	featureLightMode      = "light_mode"
+	featureTemperature    = "temperature"
+	featureHumidity       = "humidity"
+	lightModeWhite        = "white"
+	lightModeColour       = "colour"
+	valueTypeBool         = "BOOL"
+	valueTypeInteger      = "INTEGER"
+	valueTypeEnum         = "ENUM"
+	valueTypeColour       = "COLOUR"
+	brightnessMin         = 50
+	brightnessMax         = 1000
+	colourTempMin         = 0
+	colourTempMax         = 1000
+	colourSaturationMax   = 1000
+	colourValueMin        = 100
+	colourValueMax        = 1000
+	colourHueMax          = 360
+	temperatureMultiplier = 10
+)
+
+// Integer is integer value, Sber transfer it as string
+type Integer int64
+
+// MarshalJSON is implementation of json.Marshaler interface
+func (i Integer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
+
+// UnmarshalJSON is implementation of json.Unmarshaler interface, both string and number are accepted
+func (i *Integer) UnmarshalJSON(data []byte) error {
+	var text string
+	if e := json.Unmarshal(data, &text); nil != e {
+		var number int64
+		if e = json.Unmarshal(data, &number); nil != e {
+			return e
+		}
+		*i = Integer(number)
+		return nil
+	}
+
+	number, e := strconv.ParseInt(text, 10, 64)
+	if nil != e {
+		return e
+	}
+	*i = Integer(number)
+
+	return nil
+}
+
+// IntegerValues is allowed range of the integer feature
+type IntegerValues struct {
+	Min  Integer `json:"min"`
+	Max  Integer `json:"max"`
+	Step Integer `json:"step"`
+}
+
+// EnumValues is allowed values of the enum feature
+type EnumValues struct {
+	Values []string `json:"values"`
+}
+
+// AllowedValue is allowed values of the feature
+type AllowedValue struct {
+	Type          string         `json:"type"`
+	IntegerValues *IntegerValues `json:"integer_values,omitempty"`
+	EnumValues    *EnumValues    `json:"enum_values,omitempty"`
+}
+
+// Model is device model description
+type Model struct {
+	ID            string                  `json:"id"`
+	Manufacturer  string                  `json:"manufacturer"`
+	Model         string                  `json:"model"`
+	Category      string                  `json:"category"`
+	Features      []string                `json:"features"`
+	AllowedValues map[string]AllowedValue `json:"allowed_values,omitempty"`
+}
+
+// Device is Sber device description
+type Device struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	DefaultName     string `json:"default_name,omitempty"`
+	Room            string `json:"room,omitempty"`
+	Model           Model  `json:"model"`
+	HardwareVersion string `json:"hw_version,omitempty"`
+	SoftwareVersion string `json:"sw_version,omitempty"`
+}
+
+// Colour is colour value, hue 0..360, saturation 0..1000 and value 100..1000
+type Colour struct {
+	H int `json:"h"`
+	S int `json:"s"`
+	V int `json:"v"`
+}
+
+// Value is typed state value
+type Value struct {
+	Type         string   `json:"type"`
+	BoolValue    *bool    `json:"bool_value,omitempty"`
+	IntegerValue *Integer `json:"integer_value,omitempty"`
+	EnumValue    string   `json:"enum_value,omitempty"`
+	ColourValue  *Colour  `json:"colour_value,omitempty"`
+}
+
+// State is value of the device feature
+type State struct {
+	Key   string `json:"key"`
+	Value Value  `json:"value"`
+}
+
+// newBoolState return state with boolean value
+func newBoolState(key string, value bool) State {
+	return State{
+		Key: key,
+		Value: Value{
+			Type:      valueTypeBool,
+			BoolValue: &value,
+		},
+	}
+}
+
+// newIntegerState return state with integer value
+func newIntegerState(key string, value int) State {
+	integer := Integer(value)
+
+	return State{
+		Key: key,
+		Value: Value{
+			Type:         valueTypeInteger,
+			IntegerValue: &integer,
+		},
+	}
+}
+
+// newEnumState return state with enum value
+func newEnumState(key string, value string) State {
+	return State{
+		Key: key,
+		Value: Value{
+			Type:      valueTypeEnum,
+			EnumValue: value,
+		},
+	}
+}
+
+// newColourState return state with colour value
+func newColourState(key string, value Colour) State {
+	return State{
+		Key: key,
+		Value: Value{
+			Type:        valueTypeColour,
+			ColourValue: &value,
+		},
+	}
+}
+
+// DeviceStates is states of the device
+type DeviceStates struct {
+	States []State `json:"states"`
+}
+
+// devicesResponse is response for the devices request
+type devicesResponse struct {
+	Devices []Device `json:"devices"`
+}
+
+// statesRequest is request of the device states
+type statesRequest struct {
+	Devices []string `json:"devices"`
+}
+
+// statesPayload is states of the devices by ID, it's used in responses, commands and state reports
+type statesPayload struct {
+	Devices map[string]DeviceStates `json:"devices"`
+}
+
+// stateReport is state report sent to the Sber cloud
+type stateReport struct {
+	UserID  string                  `json:"user_id"`
+	Devices map[string]DeviceStates `json:"devices"`
+}
+
+// errorResponse is response for the request which can't be processed
+type errorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
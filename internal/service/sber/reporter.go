@@ -0,0 +1,227 @@
+package sber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/states"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	cloudEndpointState = "/v1/state"
+	// reportInterval is minimal interval between state reports, changes are batched during this interval
+	reportInterval = time.Second
+	// reportRetries is number of attempts to send report
+	reportRetries = 3
+	// reportRetryDelay is delay before first retry, doubled with each attempt
+	reportRetryDelay = time.Millisecond * 500
+	// reportRequestTimeout is timeout of the single report request
+	reportRequestTimeout = time.Second * 5
+)
+
+// errTransient is error which may disappear on retry
+var errTransient = errors.New("transient error")
+
+// reporter push device states to the Sber cloud
+type reporter struct {
+	client     *http.Client
+	cloudURL   string
+	token      string
+	access     api.AccessControl
+	stateCache api.StateCache
+	lock       sync.Mutex
+	// users is linked users
+	users map[string]struct{}
+	// changed is IDs of the devices with changed state
+	changed map[string]struct{}
+}
+
+// newReporter return state reporter
+func newReporter(cloudURL string, token string, access api.AccessControl, stateCache api.StateCache) *reporter {
+	return &reporter{
+		client: &http.Client{
+			Timeout: reportRequestTimeout,
+		},
+		cloudURL:   strings.TrimSuffix(cloudURL, "/"),
+		token:      token,
+		access:     access,
+		stateCache: stateCache,
+		users:      make(map[string]struct{}),
+		changed:    make(map[string]struct{}),
+	}
+}
+
+// Subscribe start reporting states for the user
+func (r *reporter) Subscribe(userID string) {
+	if 0 == len(userID) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.users[userID] = struct{}{}
+}
+
+// Unsubscribe stop reporting states for the user
+func (r *reporter) Unsubscribe(userID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.users, userID)
+}
+
+// MarkChanged schedule report of the device state, even if it isn't changed
+func (r *reporter) MarkChanged(deviceID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.changed[deviceID] = struct{}{}
+}
+
+// run send batched reports until context done, users are unsubscribed by events of the unlink topic
+func (r *reporter) run(ctx context.Context, bus eventbus.Bus, unlinkTopic string) error {
+	if e := bus.Subscribe(unlinkTopic, r.Unsubscribe); nil != e {
+		return e
+	}
+	defer func() {
+		_ = bus.Unsubscribe(unlinkTopic, r.Unsubscribe)
+	}()
+
+	if e := bus.Subscribe(states.StateChanged, r.MarkChanged); nil != e {
+		return e
+	}
+	defer func() {
+		_ = bus.Unsubscribe(states.StateChanged, r.MarkChanged)
+	}()
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flush send reports about changes collected since previous call
+func (r *reporter) flush(ctx context.Context) {
+	r.lock.Lock()
+	changed := r.changed
+	r.changed = make(map[string]struct{})
+	userIDs := make([]string, 0, len(r.users))
+	for userID := range r.users {
+		userIDs = append(userIDs, userID)
+	}
+	r.lock.Unlock()
+
+	if 0 == len(changed) {
+		return
+	}
+
+	for _, userID := range userIDs {
+		report := stateReport{
+			UserID:  userID,
+			Devices: r.changedStates(r.access.UserDevices(userID), changed),
+		}
+
+		if 0 == len(report.Devices) {
+			continue
+		}
+
+		if e := r.send(ctx, &report); nil != e {
+			log.Log.Errorw("Unable to report Sber device states", "user_id", userID, "error", e)
+		}
+	}
+}
+
+// changedStates return states of all Sber devices of the changed devices
+func (r *reporter) changedStates(deviceManager api.DeviceManager, changed map[string]struct{}) map[string]DeviceStates {
+	result := make(map[string]DeviceStates)
+
+	for deviceID := range changed {
+		device, e := deviceManager.GetDevice(deviceID)
+		if nil != e {
+			continue
+		}
+
+		for _, endpoint := range newDeviceEndpoints(deviceID, device) {
+			result[endpoint.ID] = newDeviceStates(deviceManager, r.stateCache, endpoint.ID)
+		}
+	}
+
+	return result
+}
+
+// send report with retries on transient errors
+func (r *reporter) send(ctx context.Context, report *stateReport) (e error) {
+	body, e := json.Marshal(report)
+	if nil != e {
+		return e
+	}
+
+	delay := reportRetryDelay
+
+	for attempt := 0; attempt < reportRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+				delay *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if e = r.post(ctx, body); nil == e || !errors.Is(e, errTransient) {
+			return e
+		}
+
+		log.Log.Debugw("Sber state report failed, retry", "error", e)
+	}
+
+	return e
+}
+
+// post send single report request
+func (r *reporter) post(ctx context.Context, body []byte) error {
+	request, e := http.NewRequestWithContext(ctx, http.MethodPost, r.cloudURL+cloudEndpointState, bytes.NewReader(body))
+	if nil != e {
+		return e
+	}
+	request.Header.Set("Authorization", "Bearer "+r.token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, e := r.client.Do(request)
+	if nil != e {
+		return fmt.Errorf("%w: %v", errTransient, e)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	switch {
+	case response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices:
+		return nil
+	case http.StatusTooManyRequests == response.StatusCode || response.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: status %d", errTransient, response.StatusCode)
+	}
+
+	return fmt.Errorf("status %d", response.StatusCode)
+}
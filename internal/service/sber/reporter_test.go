@@ -0,0 +1,155 @@
+package sber
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// reportedIDs return sorted IDs of the reported devices
+func reportedIDs(report stateReport) []string {
+	ids := make([]string, 0, len(report.Devices))
+	for id := range report.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestReporterBatching(t *testing.T) {
+	cloud := newTestCloud(t, 0)
+	service := newTestService(t, cloud)
+	service.setState(t, testRelayID, map[int]api.ChannelState{1: {On: true}}, map[api.SensorKind]float64{
+		api.SensorTemperature: 20,
+	})
+
+	// Nothing changed, so nothing is reported
+	service.reporter.Subscribe("ivan")
+	service.reporter.Subscribe("maria")
+	service.reporter.Subscribe("")
+	service.reporter.flush(context.Background())
+	if requests, _ := cloud.received(); 0 != requests {
+		t.Fatalf("got %d requests without changes", requests)
+	}
+
+	// Changes since previous flush are sent by single request for each user
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.MarkChanged(testLightID)
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.MarkChanged("tasmota_000000000000")
+	service.reporter.flush(context.Background())
+
+	requests, reports := cloud.received()
+	if 2 != requests || 2 != len(reports) {
+		t.Fatalf("got %d requests, reports %+v", requests, reports)
+	}
+
+	for i, test := range []struct {
+		userID string
+		want   []string
+	}{
+		{"ivan", []string{testRelayID, testRelayID + ":1", testRelayID + ":2", testLightID + ":1"}},
+		{"maria", []string{testRelayID, testRelayID + ":1", testRelayID + ":2"}},
+	} {
+		if test.userID != reports[i].UserID {
+			t.Fatalf("got report of %q, want %q", reports[i].UserID, test.userID)
+		}
+		if got := reportedIDs(reports[i]); !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%s: got devices %v, want %v", test.userID, got, test.want)
+		}
+	}
+
+	if got, want := statesJSON(t, reports[1].Devices[testRelayID+":1"]),
+		`[{"key":"online","value":{"type":"BOOL","bool_value":true}},`+
+			`{"key":"on_off","value":{"type":"BOOL","bool_value":true}}]`; want != got {
+		t.Errorf("reported state:\n got %s\nwant %s", got, want)
+	}
+	if got, want := statesJSON(t, reports[0].Devices[testLightID+":1"]),
+		`[{"key":"online","value":{"type":"BOOL","bool_value":false}}]`; want != got {
+		t.Errorf("unreachable device state:\n got %s\nwant %s", got, want)
+	}
+
+	// Reported changes aren't sent again
+	service.reporter.flush(context.Background())
+	if requests, _ = cloud.received(); 2 != requests {
+		t.Fatalf("got %d requests after second flush", requests)
+	}
+
+	// Change of the device which user can't see isn't reported to the user
+	service.reporter.MarkChanged(testLightID)
+	service.reporter.flush(context.Background())
+	if requests, reports = cloud.received(); 3 != requests || "ivan" != reports[1].UserID ||
+		!reflect.DeepEqual([]string{testLightID + ":1"}, reportedIDs(reports[1])) {
+		t.Fatalf("got %d requests, reports %+v", requests, reports)
+	}
+}
+
+func TestReporterRetry(t *testing.T) {
+	cloud := newTestCloud(t, 1)
+	service := newTestService(t, cloud)
+
+	service.reporter.Subscribe("maria")
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.flush(context.Background())
+
+	requests, reports := cloud.received()
+	if 2 != requests || 1 != len(reports) || "maria" != reports[0].UserID {
+		t.Fatalf("got %d requests, reports %+v", requests, reports)
+	}
+
+	// Report is dropped when cloud is unavailable after all retries
+	cloud.lock.Lock()
+	cloud.failures = reportRetries
+	cloud.lock.Unlock()
+
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.flush(context.Background())
+
+	if requests, reports = cloud.received(); 2+reportRetries != requests || 1 != len(reports) {
+		t.Fatalf("got %d requests, reports %+v", requests, reports)
+	}
+
+	// Cancelled context stop retries
+	cloud.lock.Lock()
+	cloud.failures = reportRetries
+	cloud.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.flush(ctx)
+
+	if requests, _ = cloud.received(); 2+reportRetries != requests {
+		t.Fatalf("got %d requests with cancelled context", requests)
+	}
+}
+
+func TestReporterPermanentError(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		want   int
+	}{
+		{http.StatusTooManyRequests, 2},
+		{http.StatusBadGateway, 2},
+		{http.StatusBadRequest, 1},
+		{http.StatusUnauthorized, 1},
+		{http.StatusForbidden, 1},
+	} {
+		cloud := newTestCloud(t, 1)
+		cloud.status = test.status
+		service := newTestService(t, cloud)
+
+		service.reporter.Subscribe("maria")
+		service.reporter.MarkChanged(testRelayID)
+		service.reporter.flush(context.Background())
+
+		if requests, _ := cloud.received(); test.want != requests {
+			t.Errorf("status %d: got %d requests, want %d", test.status, requests, test.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package sber
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// UserUnlinked is events topic where service put user ID when user unlinked accounts
+	UserUnlinked = "sber:unlinked"
+)
+
+const (
+	// envSberEnabled enable Sber front-end, e.g. "true"
+	envSberEnabled = "SBER_ENABLED"
+	// envClientIDs is comma separated OAuth clients allowed to use Sber front-end, Sber client by default
+	envClientIDs = "SBER_CLIENT_IDS"
+	// envSberClientID is OAuth client of the Sber smart home
+	envSberClientID = "SBER_CLIENT_ID"
+	// envSberCloudURL is base URL of the Sber cloud, which receive state reports
+	envSberCloudURL = "SBER_CLOUD_URL"
+	// envSberPartnerToken is token to access the Sber cloud, state reports are disabled without it
+	envSberPartnerToken    = "SBER_PARTNER_TOKEN"
+	defaultCloudURL        = "https://partners.iot.sberdevices.ru"
+	sberEndpointPrefix     = "/sber/v1/"
+	sberEndpointDevices    = "devices"
+	sberEndpointStates     = "states"
+	sberEndpointCommands   = "commands"
+	sberEndpointUnlink     = "unlink"
+	contextUserID          = "X-User-ID"
+	contextClientID        = "X-Client-ID"
+	contextTokenInfo       = "X-Token-Info"
+	errorCodeForbidden     = http.StatusForbidden
+	commandTimeout         = time.Second * 5
+	errorCodeUnauthorized  = http.StatusUnauthorized
+	errorCodeInvalidValue  = http.StatusBadRequest
+	errorCodeInternalError = http.StatusInternalServerError
+)
+
+// Service is Sber smart home front-end implementation
+type Service struct {
+	runnable.Runnable
+	bus          eventbus.Bus
+	oauthService *oauth.Service
+	access       api.AccessControl
+	stateCache   api.StateCache
+	clientIDs    []string
+	reporter     *reporter
+}
+
+// NewService return new service implementation. Routes are registered only when front-end enabled.
+func NewService(router gin.IRouter,
+	bus eventbus.Bus,
+	oauthService *oauth.Service,
+	access api.AccessControl,
+	stateCache api.StateCache) (service *Service, e error) {
+	service = &Service{
+		bus:          bus,
+		oauthService: oauthService,
+		access:       access,
+		stateCache:   stateCache,
+		clientIDs:    oauth.ClientIDs(envClientIDs, envSberClientID),
+	}
+
+	enabled := false
+	if value, found := os.LookupEnv(envSberEnabled); found {
+		if enabled, e = strconv.ParseBool(value); nil != e {
+			return nil, e
+		}
+	}
+
+	if !enabled {
+		return service, nil
+	}
+
+	if 0 == len(service.clientIDs) {
+		log.Log.Warn("Sber OAuth client isn't configured, tokens of all clients are accepted")
+	}
+
+	oauthService.PublishUnlinks(bus, UserUnlinked, service.clientIDs)
+
+	cloudURL := defaultCloudURL
+	if value, found := os.LookupEnv(envSberCloudURL); found {
+		cloudURL = value
+	}
+
+	if token, found := os.LookupEnv(envSberPartnerToken); found {
+		service.reporter = newReporter(cloudURL, token, access, stateCache)
+	} else {
+		log.Log.Warn("Sber state reports disabled, partner token isn't configured")
+	}
+
+	// All routes required authorized access
+	authorized := router.Group(sberEndpointPrefix, service.authorize)
+
+	authorized.GET(sberEndpointDevices, service.requireScope(oauth.ScopeDevicesRead), service.onDevices)
+	authorized.POST(sberEndpointStates, service.requireScope(oauth.ScopeDevicesRead), service.onStates)
+	authorized.POST(sberEndpointCommands, service.requireScope(oauth.ScopeDevicesControl), service.onCommands)
+	authorized.POST(sberEndpointUnlink, service.requireScope(oauth.ScopeDevicesRead), service.onUnlink)
+
+	return service, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	if nil != service.reporter {
+		// Subscriptions aren't stored, so users linked before restart are restored from their grants
+		userIDs, e := service.oauthService.LinkedUsers(service.clientIDs)
+		if nil != e {
+			log.Log.Warnw("Linked users aren't subscribed to state reports", "error", e)
+		}
+
+		for _, userID := range userIDs {
+			service.reporter.Subscribe(userID)
+		}
+
+		return service.reporter.run(ctx, service.bus, UserUnlinked)
+	}
+
+	// Wait until operation complete
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// abortWithError log the error and abort request with the error response
+func abortWithError(ginCtx *gin.Context, status int, e error) {
+	log.Log.Warnw("Sber request failed",
+		"path", ginCtx.Request.URL.Path,
+		"user_id", ginCtx.GetString(contextUserID),
+		"error", e)
+
+	ginCtx.AbortWithStatusJSON(status, &errorResponse{
+		Code:    status,
+		Message: e.Error(),
+	})
+}
+
+// authorize is bearer token checker
+func (service *Service) authorize(ginCtx *gin.Context) {
+	tokenInfo, e := service.oauthService.ValidationClientToken(ginCtx, service.clientIDs)
+	if oauth.ErrTooManyRequests == e {
+		abortWithError(ginCtx, http.StatusTooManyRequests, e)
+		return
+	}
+	if nil != e {
+		abortWithError(ginCtx, errorCodeUnauthorized, e)
+		return
+	}
+
+	ginCtx.Set(contextUserID, tokenInfo.GetUserID())
+	ginCtx.Set(contextClientID, tokenInfo.GetClientID())
+	ginCtx.Set(contextTokenInfo, tokenInfo)
+
+	if nil != service.reporter {
+		// User linked accounts, so should receive state reports
+		service.reporter.Subscribe(tokenInfo.GetUserID())
+	}
+
+	// Call next handler
+	ginCtx.Next()
+}
+
+// requireScope return handler which reject requests when access token don't grant the scope
+func (service *Service) requireScope(scope string) gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		tokenInfo, _ := ginCtx.Value(contextTokenInfo).(oauth2.TokenInfo)
+		if nil == tokenInfo || !service.oauthService.HasScope(tokenInfo, scope) {
+			abortWithError(ginCtx, errorCodeForbidden, oauth.ErrInsufficientScope)
+			return
+		}
+
+		ginCtx.Next()
+	}
+}
+
+// userDevices return devices allowed to the authorized user
+func (service *Service) userDevices(ginCtx *gin.Context) api.DeviceManager {
+	return service.access.UserDevices(ginCtx.GetString(contextUserID))
+}
+
+// onDevices called by Sber to enumerate devices
+func (service *Service) onDevices(ginCtx *gin.Context) {
+	devices, e := newDevices(service.userDevices(ginCtx))
+	if nil != e {
+		abortWithError(ginCtx, errorCodeInternalError, e)
+		return
+	}
+
+	ginCtx.JSON(http.StatusOK, &devicesResponse{
+		Devices: devices,
+	})
+}
+
+// onStates called by Sber to query device states
+func (service *Service) onStates(ginCtx *gin.Context) {
+	var request statesRequest
+	if e := ginCtx.ShouldBindJSON(&request); nil != e {
+		abortWithError(ginCtx, errorCodeInvalidValue, e)
+		return
+	}
+
+	deviceManager := service.userDevices(ginCtx)
+
+	response := statesPayload{
+		Devices: make(map[string]DeviceStates, len(request.Devices)),
+	}
+	for _, id := range request.Devices {
+		response.Devices[id] = newDeviceStates(deviceManager, service.stateCache, id)
+	}
+
+	ginCtx.JSON(http.StatusOK, &response)
+}
+
+// onCommands called by Sber to change device states. New states are reported after execution.
+func (service *Service) onCommands(ginCtx *gin.Context) {
+	var request statesPayload
+	if e := ginCtx.ShouldBindJSON(&request); nil != e {
+		abortWithError(ginCtx, errorCodeInvalidValue, e)
+		return
+	}
+
+	deviceManager := service.userDevices(ginCtx)
+
+	ctx, cancel := context.WithTimeout(ginCtx.Request.Context(), commandTimeout)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed error
+	)
+	for id, states := range request.Devices {
+		wg.Add(1)
+		go func(id string, states []State) {
+			defer wg.Done()
+
+			if e := service.execute(ctx, deviceManager, id, states); nil != e {
+				log.Log.Warnw("Sber command failed", "device_id", id, "error", e)
+
+				lock.Lock()
+				failed = e
+				lock.Unlock()
+			}
+		}(id, states.States)
+	}
+	wg.Wait()
+
+	if errors.Is(failed, api.ErrInvalidAction) || errors.Is(failed, api.ErrInvalidValue) {
+		abortWithError(ginCtx, errorCodeInvalidValue, failed)
+		return
+	}
+
+	// Unreachable devices will be reported as offline
+	response := statesPayload{
+		Devices: make(map[string]DeviceStates, len(request.Devices)),
+	}
+	for id := range request.Devices {
+		response.Devices[id] = newDeviceStates(deviceManager, service.stateCache, id)
+	}
+
+	ginCtx.JSON(http.StatusOK, &response)
+}
+
+// execute perform commands on the device
+func (service *Service) execute(ctx context.Context,
+	deviceManager api.DeviceManager,
+	id string,
+	states []State) error {
+	deviceID, device, channel, e := findEndpoint(deviceManager, id)
+	if nil == e && nil == channel {
+		e = api.ErrInvalidAction
+	}
+	if nil != e {
+		return e
+	}
+
+	commands, e := newCommands(*channel, states)
+	if nil != e {
+		return e
+	}
+
+	for _, command := range commands {
+		if e = device.Execute(ctx, command); nil != e {
+			return e
+		}
+	}
+
+	if nil != service.reporter {
+		// Sber expect state report after command, even if state isn't changed
+		service.reporter.MarkChanged(deviceID)
+	}
+
+	return nil
+}
+
+// onUnlink called by Sber when accounts unlinked
+func (service *Service) onUnlink(ginCtx *gin.Context) {
+	userID := ginCtx.GetString(contextUserID)
+
+	if e := service.oauthService.RevokeUser(ginCtx.Request.Context(), userID,
+		ginCtx.GetString(contextClientID)); nil != e {
+		abortWithError(ginCtx, errorCodeInternalError, e)
+		return
+	}
+
+	// Reporter and other services may hold per-user data which should be removed
+	service.bus.Publish(UserUnlinked, userID)
+
+	ginCtx.Status(http.StatusOK)
+}
@@ -0,0 +1,532 @@
+package sber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vedga/alisa/internal/pkg/apitest"
+	"github.com/vedga/alisa/internal/pkg/log"
+	"github.com/vedga/alisa/internal/service/oauth"
+	"github.com/vedga/alisa/internal/service/ratelimit"
+	"github.com/vedga/alisa/internal/service/states"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+	"go.uber.org/zap"
+)
+
+const (
+	// testClientID is OAuth client of the Sber smart home
+	testClientID = "sber"
+	// testLightID is color light of the user ivan
+	testLightID = "tasmota_AABBCCDDEEFF"
+	// testRelayID is two channels relay with climate sensors, shared by ivan and maria
+	testRelayID = "tasmota_112233445566"
+)
+
+// testTokens is access tokens of the fake OAuth provider by user ID
+var testTokens = map[string]string{
+	"ivan":  "ivan-token",
+	"maria": "maria-token",
+}
+
+// testAccess give each user own devices, unknown user has no devices
+type testAccess map[string]api.DeviceManager
+
+func (access testAccess) UserDevices(userID string) api.DeviceManager {
+	if deviceManager, found := access[userID]; found {
+		return deviceManager
+	}
+
+	return apitest.Devices{}
+}
+
+// testCloud is fake Sber cloud which record state reports, first failures requests are rejected with status
+type testCloud struct {
+	*httptest.Server
+	lock     sync.Mutex
+	failures int
+	status   int
+	requests int
+	reports  []stateReport
+}
+
+// newTestCloud return started fake Sber cloud
+func newTestCloud(t *testing.T, failures int) *testCloud {
+	t.Helper()
+
+	cloud := &testCloud{failures: failures, status: http.StatusServiceUnavailable}
+	cloud.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cloud.lock.Lock()
+		defer cloud.lock.Unlock()
+
+		cloud.requests++
+
+		if http.MethodPost != r.Method || cloudEndpointState != r.URL.Path ||
+			"Bearer partner-token" != r.Header.Get("Authorization") {
+			t.Errorf("unexpected report request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if cloud.failures > 0 {
+			cloud.failures--
+			w.WriteHeader(cloud.status)
+			return
+		}
+
+		var report stateReport
+		if e := json.NewDecoder(r.Body).Decode(&report); nil != e {
+			t.Errorf("report isn't parsed: %v", e)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cloud.reports = append(cloud.reports, report)
+	}))
+	t.Cleanup(cloud.Close)
+
+	return cloud
+}
+
+// received return number of requests and reports received by the cloud, reports are sorted by user ID and time
+func (cloud *testCloud) received() (int, []stateReport) {
+	cloud.lock.Lock()
+	defer cloud.lock.Unlock()
+
+	reports := append([]stateReport(nil), cloud.reports...)
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].UserID < reports[j].UserID
+	})
+
+	return cloud.requests, reports
+}
+
+// testService is Sber front-end with the test devices, which validate tokens by the fake OAuth provider
+type testService struct {
+	*Service
+	router     *gin.Engine
+	stateCache *apitest.States
+	light      *apitest.Device
+	relay      *apitest.Device
+	unlinked   chan string
+}
+
+// newTestService return enabled Sber front-end which report states to the cloud
+func newTestService(t *testing.T, cloud *testCloud) *testService {
+	t.Helper()
+
+	log.Log = zap.NewNop().Sugar()
+	gin.SetMode(gin.TestMode)
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for userID, access := range testTokens {
+			if "Bearer "+access == r.Header.Get("Authorization") {
+				_ = json.NewEncoder(w).Encode(map[string]string{"id": userID, "client_id": testClientID})
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(provider.Close)
+
+	// Provider users are local users with the same IDs
+	usersPath := filepath.Join(t.TempDir(), "provider-users.json")
+	if e := os.WriteFile(usersPath, []byte(`{"users":{"ivan":"ivan","maria":"maria"}}`), 0600); nil != e {
+		t.Fatal(e)
+	}
+
+	t.Setenv("OAUTH_PROVIDER_USERINFO_URL", provider.URL)
+	t.Setenv("OAUTH_PROVIDER_CLIENT_ID", testClientID)
+	t.Setenv("OAUTH_PROVIDER_USERS", usersPath)
+	t.Setenv("OAUTH_STORAGE", ":memory:")
+	t.Setenv(envSberEnabled, "true")
+	t.Setenv(envSberClientID, testClientID)
+	t.Setenv(envSberCloudURL, cloud.URL)
+	t.Setenv(envSberPartnerToken, "partner-token")
+
+	bus := eventbus.New()
+	limiter, e := ratelimit.NewService(bus)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	router := gin.New()
+	oauthService, e := oauth.NewService(router, router, limiter)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	stateCache := apitest.NewStates()
+
+	result := &testService{
+		router:     router,
+		stateCache: stateCache,
+		light: &apitest.Device{
+			ID:         testLightID,
+			Name:       "Лампа",
+			Channels:   []api.Channel{{Index: 1, Type: api.ChannelLight, Light: api.LightRGBCW}},
+			StateCache: stateCache,
+		},
+		relay: &apitest.Device{
+			ID:   testRelayID,
+			Name: "Реле",
+			Channels: []api.Channel{
+				{Index: 1, Type: api.ChannelRelay},
+				{Index: 2, Type: api.ChannelRelay},
+			},
+			Sensors:    []api.Sensor{{Kind: api.SensorTemperature}, {Kind: api.SensorHumidity}},
+			StateCache: stateCache,
+		},
+		unlinked: make(chan string, 1),
+	}
+
+	access := testAccess{
+		"ivan":  apitest.Devices{testLightID: result.light, testRelayID: result.relay},
+		"maria": apitest.Devices{testRelayID: result.relay},
+	}
+
+	if result.Service, e = NewService(router, bus, oauthService, access, stateCache); nil != e {
+		t.Fatal(e)
+	}
+
+	if e = bus.Subscribe(UserUnlinked, func(userID string) {
+		result.unlinked <- userID
+	}); nil != e {
+		t.Fatal(e)
+	}
+
+	return result
+}
+
+// serve send request of the user to the front-end and decode response into the result
+func (service *testService) serve(t *testing.T, method string, endpoint string, access string, body string,
+	result interface{}) int {
+	t.Helper()
+
+	request := httptest.NewRequest(method, sberEndpointPrefix+endpoint, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if len(access) > 0 {
+		request.Header.Set("Authorization", "Bearer "+access)
+	}
+
+	recorder := httptest.NewRecorder()
+	service.router.ServeHTTP(recorder, request)
+
+	if nil != result && http.StatusOK == recorder.Code {
+		if e := json.Unmarshal(recorder.Body.Bytes(), result); nil != e {
+			t.Fatalf("%s response isn't parsed: %v", endpoint, e)
+		}
+	}
+
+	return recorder.Code
+}
+
+// setState store the state of the device channels and sensors
+func (service *testService) setState(t *testing.T, deviceID string, channels map[int]api.ChannelState,
+	sensors map[api.SensorKind]float64) {
+	t.Helper()
+
+	if e := service.stateCache.UpdateState(deviceID, func(state *api.State) {
+		for index, channel := range channels {
+			state.Channels[index] = channel
+		}
+		for kind, value := range sensors {
+			state.Sensors[kind] = value
+		}
+	}); nil != e {
+		t.Fatal(e)
+	}
+}
+
+// statesJSON return compact JSON of the device states, which is easy to compare
+func statesJSON(t *testing.T, states DeviceStates) string {
+	t.Helper()
+
+	data, e := json.Marshal(states.States)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var compact bytes.Buffer
+	if e = json.Compact(&compact, data); nil != e {
+		t.Fatal(e)
+	}
+
+	return compact.String()
+}
+
+func TestAuthorization(t *testing.T) {
+	service := newTestService(t, newTestCloud(t, 0))
+
+	for _, test := range []struct {
+		name   string
+		access string
+		want   int
+	}{
+		{"without token", "", http.StatusUnauthorized},
+		{"unknown token", "unknown-token", http.StatusUnauthorized},
+		{"valid token", testTokens["ivan"], http.StatusOK},
+	} {
+		if got := service.serve(t, http.MethodGet, sberEndpointDevices, test.access, "", nil); test.want != got {
+			t.Errorf("%s: got status %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	service := newTestService(t, newTestCloud(t, 0))
+
+	for _, test := range []struct {
+		userID string
+		want   []string
+	}{
+		{"ivan", []string{testRelayID + ":1", testRelayID + ":2", testRelayID, testLightID + ":1"}},
+		{"maria", []string{testRelayID + ":1", testRelayID + ":2", testRelayID}},
+	} {
+		var response devicesResponse
+		if code := service.serve(t, http.MethodGet, sberEndpointDevices, testTokens[test.userID], "",
+			&response); http.StatusOK != code {
+			t.Fatalf("%s: status %d", test.userID, code)
+		}
+
+		got := make([]string, 0, len(response.Devices))
+		for _, device := range response.Devices {
+			got = append(got, device.ID)
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%s: got devices %v, want %v", test.userID, got, test.want)
+		}
+	}
+
+	var response devicesResponse
+	service.serve(t, http.MethodGet, sberEndpointDevices, testTokens["ivan"], "", &response)
+
+	models := make(map[string]Device, len(response.Devices))
+	for _, device := range response.Devices {
+		models[device.ID] = device
+	}
+
+	for _, test := range []struct {
+		id       string
+		name     string
+		category string
+		features []string
+	}{
+		{testLightID + ":1", "Лампа", categoryLight, []string{featureOnline, featureOnOff, featureBrightness,
+			featureColour, featureColourTemp, featureLightMode}},
+		{testRelayID + ":2", "Реле 2", categoryRelay, []string{featureOnline, featureOnOff}},
+		{testRelayID, "Реле", categorySensorTemp, []string{featureOnline, featureTemperature, featureHumidity}},
+	} {
+		device := models[test.id]
+		if test.name != device.Name || test.category != device.Model.Category ||
+			!reflect.DeepEqual(test.features, device.Model.Features) {
+			t.Errorf("%s: got %+v", test.id, device)
+		}
+	}
+}
+
+func TestStates(t *testing.T) {
+	service := newTestService(t, newTestCloud(t, 0))
+
+	service.setState(t, testLightID, map[int]api.ChannelState{
+		1: {On: true, Brightness: 100, ColorTemperature: 6500},
+	}, nil)
+	service.setState(t, testRelayID, map[int]api.ChannelState{
+		1: {On: true},
+	}, map[api.SensorKind]float64{
+		api.SensorTemperature: 21.47,
+		api.SensorHumidity:    40.6,
+	})
+
+	const offline = `[{"key":"online","value":{"type":"BOOL","bool_value":false}}]`
+
+	for _, test := range []struct {
+		userID string
+		want   map[string]string
+	}{
+		{"ivan", map[string]string{
+			testLightID + ":1": `[{"key":"online","value":{"type":"BOOL","bool_value":true}},` +
+				`{"key":"on_off","value":{"type":"BOOL","bool_value":true}},` +
+				`{"key":"light_brightness","value":{"type":"INTEGER","integer_value":"1000"}},` +
+				`{"key":"light_colour_temp","value":{"type":"INTEGER","integer_value":"1000"}},` +
+				`{"key":"light_colour","value":{"type":"COLOUR","colour_value":{"h":0,"s":0,"v":100}}},` +
+				`{"key":"light_mode","value":{"type":"ENUM","enum_value":"white"}}]`,
+			testRelayID + ":2": `[{"key":"online","value":{"type":"BOOL","bool_value":true}},` +
+				`{"key":"on_off","value":{"type":"BOOL","bool_value":false}}]`,
+			testRelayID: `[{"key":"online","value":{"type":"BOOL","bool_value":true}},` +
+				`{"key":"temperature","value":{"type":"INTEGER","integer_value":"215"}},` +
+				`{"key":"humidity","value":{"type":"INTEGER","integer_value":"41"}}]`,
+			"tasmota_000000000000:1": offline,
+		}},
+		// Device of other user is reported as offline
+		{"maria", map[string]string{
+			testLightID + ":1": offline,
+		}},
+	} {
+		ids := make([]string, 0, len(test.want))
+		for id := range test.want {
+			ids = append(ids, id)
+		}
+		body, _ := json.Marshal(&statesRequest{Devices: ids})
+
+		var response statesPayload
+		if code := service.serve(t, http.MethodPost, sberEndpointStates, testTokens[test.userID], string(body),
+			&response); http.StatusOK != code {
+			t.Fatalf("%s: status %d", test.userID, code)
+		}
+
+		for id, want := range test.want {
+			if got := statesJSON(t, response.Devices[id]); want != got {
+				t.Errorf("%s %s:\n got %s\nwant %s", test.userID, id, got, want)
+			}
+		}
+	}
+
+	if code := service.serve(t, http.MethodPost, sberEndpointStates, testTokens["ivan"], "{", nil); http.StatusBadRequest != code {
+		t.Errorf("invalid request: status %d", code)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	cloud := newTestCloud(t, 0)
+	service := newTestService(t, cloud)
+
+	service.setState(t, testLightID, map[int]api.ChannelState{1: {Brightness: 50}}, nil)
+	service.setState(t, testRelayID, map[int]api.ChannelState{1: {}, 2: {}}, nil)
+
+	var response statesPayload
+	if code := service.serve(t, http.MethodPost, sberEndpointCommands, testTokens["ivan"], `{"devices":{
+		"`+testLightID+`:1":{"states":[
+			{"key":"on_off","value":{"type":"BOOL","bool_value":true}},
+			{"key":"light_brightness","value":{"type":"INTEGER","integer_value":"1000"}},
+			{"key":"light_colour","value":{"type":"COLOUR","colour_value":{"h":120,"s":1000,"v":1000}}},
+			{"key":"light_mode","value":{"type":"ENUM","enum_value":"colour"}}]}}}`,
+		&response); http.StatusOK != code {
+		t.Fatalf("status %d", code)
+	}
+
+	want := []api.Command{
+		{Channel: 1, Type: api.CommandOnOff, On: true},
+		{Channel: 1, Type: api.CommandBrightness, Brightness: 100},
+		{Channel: 1, Type: api.CommandColor, Color: api.ColorHSV{Hue: 120, Saturation: 100, Value: 100}},
+	}
+	if got := service.light.Executed(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("got commands %+v, want %+v", got, want)
+	}
+
+	if got, want := statesJSON(t, response.Devices[testLightID+":1"]),
+		`[{"key":"online","value":{"type":"BOOL","bool_value":true}},`+
+			`{"key":"on_off","value":{"type":"BOOL","bool_value":true}},`+
+			`{"key":"light_brightness","value":{"type":"INTEGER","integer_value":"1000"}},`+
+			`{"key":"light_colour_temp","value":{"type":"INTEGER","integer_value":"0"}},`+
+			`{"key":"light_colour","value":{"type":"COLOUR","colour_value":{"h":120,"s":1000,"v":1000}}},`+
+			`{"key":"light_mode","value":{"type":"ENUM","enum_value":"colour"}}]`; want != got {
+		t.Errorf("light state:\n got %s\nwant %s", got, want)
+	}
+
+	for _, test := range []struct {
+		name   string
+		userID string
+		body   string
+		want   int
+	}{
+		{"brightness of relay", "ivan", `{"devices":{"` + testRelayID + `:1":{"states":[
+			{"key":"light_brightness","value":{"type":"INTEGER","integer_value":"500"}}]}}}`, http.StatusBadRequest},
+		{"sensors endpoint", "ivan", `{"devices":{"` + testRelayID + `":{"states":[
+			{"key":"on_off","value":{"type":"BOOL","bool_value":true}}]}}}`, http.StatusBadRequest},
+		{"invalid hue", "ivan", `{"devices":{"` + testLightID + `:1":{"states":[
+			{"key":"light_colour","value":{"type":"COLOUR","colour_value":{"h":400,"s":0,"v":100}}}]}}}`,
+			http.StatusBadRequest},
+		// Device of other user is unreachable, so it's reported as offline
+		{"device of other user", "maria", `{"devices":{"` + testLightID + `:1":{"states":[
+			{"key":"on_off","value":{"type":"BOOL","bool_value":false}}]}}}`, http.StatusOK},
+	} {
+		if got := service.serve(t, http.MethodPost, sberEndpointCommands, testTokens[test.userID], test.body,
+			nil); test.want != got {
+			t.Errorf("%s: got status %d, want %d", test.name, got, test.want)
+		}
+	}
+	if 3 != len(service.light.Executed()) || 0 != len(service.relay.Executed()) {
+		t.Fatalf("rejected commands are executed: light %+v, relay %+v",
+			service.light.Executed(), service.relay.Executed())
+	}
+
+	// Executed command is reported to the users who can see the device
+	service.reporter.flush(context.Background())
+
+	_, reports := cloud.received()
+	if 1 != len(reports) || "ivan" != reports[0].UserID {
+		t.Fatalf("got reports %+v", reports)
+	}
+	if got, want := statesJSON(t, reports[0].Devices[testLightID+":1"]),
+		statesJSON(t, response.Devices[testLightID+":1"]); want != got {
+		t.Errorf("reported state:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	cloud := newTestCloud(t, 0)
+	service := newTestService(t, cloud)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Reporter subscribe to state changes after unlink events
+	for deadline := time.Now().Add(time.Second); !service.bus.HasCallback(states.StateChanged); {
+		if time.Now().After(deadline) {
+			t.Fatal("reporter isn't started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for userID, access := range testTokens {
+		if code := service.serve(t, http.MethodGet, sberEndpointDevices, access, "", nil); http.StatusOK != code {
+			t.Fatalf("%s: status %d", userID, code)
+		}
+	}
+
+	if code := service.serve(t, http.MethodPost, sberEndpointUnlink, testTokens["ivan"], "", nil); http.StatusOK != code {
+		t.Fatalf("unlink: status %d", code)
+	}
+
+	select {
+	case userID := <-service.unlinked:
+		if "ivan" != userID {
+			t.Errorf("got unlinked user %q", userID)
+		}
+	default:
+		t.Error("unlink isn't published")
+	}
+
+	if code := service.serve(t, http.MethodGet, sberEndpointDevices, testTokens["ivan"], "", nil); http.StatusUnauthorized != code {
+		t.Errorf("token of the unlinked user: status %d", code)
+	}
+
+	// Unlinked user don't receive reports anymore
+	service.reporter.MarkChanged(testRelayID)
+	service.reporter.flush(context.Background())
+
+	_, reports := cloud.received()
+	if 1 != len(reports) || "maria" != reports[0].UserID {
+		t.Fatalf("got reports %+v", reports)
+	}
+}
@@ -0,0 +1,160 @@
+package sber
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// scale linearly convert value from one range to another with clamping
+func scale(value int, fromMin int, fromMax int, toMin int, toMax int) int {
+	if value <= fromMin {
+		return toMin
+	}
+	if value >= fromMax {
+		return toMax
+	}
+
+	return toMin + int(math.Round(float64(value-fromMin)*float64(toMax-toMin)/float64(fromMax-fromMin)))
+}
+
+// newDeviceStates return current states of the Sber device. Unreachable device is reported as offline.
+func newDeviceStates(deviceManager api.DeviceManager, stateCache api.StateCache, id string) DeviceStates {
+	offline := DeviceStates{
+		States: []State{newBoolState(featureOnline, false)},
+	}
+
+	deviceID, device, channel, e := findEndpoint(deviceManager, id)
+	if nil != e {
+		return offline
+	}
+
+	state, e := stateCache.GetState(deviceID)
+	if nil != e {
+		return offline
+	}
+
+	if nil == channel {
+		return DeviceStates{
+			States: sensorStates(device.GetSensors(), state),
+		}
+	}
+
+	return DeviceStates{
+		States: channelStates(*channel, state.Channels[channel.Index]),
+	}
+}
+
+// channelStates return states of the device channel
+func channelStates(channel api.Channel, state api.ChannelState) []State {
+	states := []State{
+		newBoolState(featureOnline, true),
+		newBoolState(featureOnOff, state.On),
+	}
+
+	if api.ChannelLight != channel.Type || api.LightNone == channel.Light {
+		return states
+	}
+
+	states = append(states, newIntegerState(featureBrightness, scale(state.Brightness, 1, 100, brightnessMin, brightnessMax)))
+
+	color, temperature := lightAbilities(channel.Light)
+	white := temperature && (!color || state.ColorTemperature > 0)
+
+	if temperature {
+		states = append(states, newIntegerState(featureColourTemp,
+			scale(state.ColorTemperature, lightTemperatureMin, lightTemperatureMax, colourTempMin, colourTempMax)))
+	}
+
+	if color {
+		states = append(states, newColourState(featureColour, Colour{
+			H: state.Color.Hue,
+			S: scale(state.Color.Saturation, 0, 100, 0, colourSaturationMax),
+			V: scale(state.Color.Value, 0, 100, colourValueMin, colourValueMax),
+		}))
+	}
+
+	if color || temperature {
+		mode := lightModeColour
+		if white {
+			mode = lightModeWhite
+		}
+		states = append(states, newEnumState(featureLightMode, mode))
+	}
+
+	return states
+}
+
+// sensorStates return states of the device sensors
+func sensorStates(sensors []api.Sensor, state api.State) []State {
+	states := []State{
+		newBoolState(featureOnline, true),
+	}
+
+	for _, sensor := range sensors {
+		value, found := state.Sensors[sensor.Kind]
+		if !found {
+			// Value not reported yet
+			continue
+		}
+
+		switch sensor.Kind {
+		case api.SensorTemperature:
+			if "F" == sensor.Unit {
+				value = (value - 32) * 5 / 9
+			}
+			states = append(states, newIntegerState(featureTemperature, int(math.Round(value*temperatureMultiplier))))
+		case api.SensorHumidity:
+			states = append(states, newIntegerState(featureHumidity, int(math.Round(value))))
+		}
+	}
+
+	return states
+}
+
+// newCommands return device commands for the requested states
+func newCommands(channel api.Channel, states []State) ([]api.Command, error) {
+	commands := make([]api.Command, 0, len(states))
+	light := api.ChannelLight == channel.Type && api.LightNone != channel.Light
+	color, temperature := lightAbilities(channel.Light)
+
+	for _, state := range states {
+		command := api.Command{
+			Channel: channel.Index,
+		}
+
+		switch {
+		case featureOnOff == state.Key && nil != state.Value.BoolValue:
+			command.Type = api.CommandOnOff
+			command.On = *state.Value.BoolValue
+		case featureBrightness == state.Key && light && nil != state.Value.IntegerValue:
+			command.Type = api.CommandBrightness
+			command.Brightness = scale(int(*state.Value.IntegerValue), brightnessMin, brightnessMax, 1, 100)
+		case featureColourTemp == state.Key && temperature && nil != state.Value.IntegerValue:
+			command.Type = api.CommandColorTemperature
+			command.ColorTemperature = scale(int(*state.Value.IntegerValue),
+				colourTempMin, colourTempMax, lightTemperatureMin, lightTemperatureMax)
+		case featureColour == state.Key && color && nil != state.Value.ColourValue:
+			colour := state.Value.ColourValue
+			if colour.H < 0 || colour.H > colourHueMax {
+				return nil, fmt.Errorf("%w: hue %d", api.ErrInvalidValue, colour.H)
+			}
+			command.Type = api.CommandColor
+			command.Color = api.ColorHSV{
+				Hue:        colour.H,
+				Saturation: scale(colour.S, 0, colourSaturationMax, 0, 100),
+				Value:      scale(colour.V, colourValueMin, colourValueMax, 1, 100),
+			}
+		case featureLightMode == state.Key && (color || temperature):
+			// Mode is changed by the colour or temperature command
+			continue
+		default:
+			return nil, fmt.Errorf("%w: %s isn't supported", api.ErrInvalidAction, state.Key)
+		}
+
+		commands = append(commands, command)
+	}
+
+	return commands, nil
+}
@@ -0,0 +1,113 @@
+package states
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pior/runnable"
+	"github.com/vedga/alisa/internal/pkg/env"
+	"github.com/vedga/alisa/pkg/api"
+	"github.com/vedga/alisa/pkg/eventbus"
+)
+
+const (
+	// StateChanged is events topic where service put device ID when device state changed
+	StateChanged = "states:changed"
+)
+
+const (
+	// envStateExpiration is duration (e.g. "15m") after which device without state updates treated as unreachable
+	envStateExpiration = "STATE_EXPIRATION"
+	// defaultStateExpiration is enough to receive Tasmota telemetry with default TelePeriod (300s) at least twice
+	defaultStateExpiration = time.Minute * 11
+)
+
+// Service is device state cache service implementation
+type Service struct {
+	runnable.Runnable
+	events     *eventbus.Dispatcher
+	expiration time.Duration
+	lock       sync.RWMutex
+	states     map[string]*api.State
+}
+
+// NewService return new service implementation
+func NewService(bus eventbus.Bus) (service *Service, e error) {
+	service = &Service{
+		events: eventbus.NewDispatcher(bus),
+		states: make(map[string]*api.State),
+	}
+
+	if service.expiration, e = env.Duration(envStateExpiration, defaultStateExpiration); nil != e {
+		return nil, e
+	}
+
+	return service, nil
+}
+
+// Run is implementation of runnable.Runnable interface
+func (service *Service) Run(ctx context.Context) error {
+	// Publish events until operation complete
+	return service.events.Run(ctx)
+}
+
+// UpdateState is implementation of api.StateCache interface
+func (service *Service) UpdateState(deviceID string, update func(state *api.State)) error {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	state, found := service.states[deviceID]
+	if !found {
+		state = &api.State{
+			Online:   true,
+			Channels: make(map[int]api.ChannelState),
+			Sensors:  make(map[api.SensorKind]float64),
+		}
+		service.states[deviceID] = state
+	}
+
+	previous := cloneState(state)
+
+	update(state)
+
+	previous.Updated = state.Updated
+	if !found || !reflect.DeepEqual(previous, cloneState(state)) {
+		// Updates usually come from the event bus handlers, while bus is locked, so event is published by the dispatcher
+		service.events.Publish(StateChanged, deviceID)
+	}
+
+	state.Updated = time.Now()
+
+	return nil
+}
+
+// GetState is implementation of api.StateCache interface
+func (service *Service) GetState(deviceID string) (api.State, error) {
+	service.lock.RLock()
+	defer service.lock.RUnlock()
+
+	state, found := service.states[deviceID]
+	if !found || !state.Online || time.Since(state.Updated) > service.expiration {
+		return api.State{}, api.ErrDeviceUnreachable
+	}
+
+	// Return copy to the caller, because stored state may be changed concurrently
+	return cloneState(state), nil
+}
+
+// cloneState return deep copy of the state
+func cloneState(state *api.State) api.State {
+	result := *state
+	result.Channels = make(map[int]api.ChannelState, len(state.Channels))
+	for index, channel := range state.Channels {
+		result.Channels[index] = channel
+	}
+	result.Sensors = make(map[api.SensorKind]float64, len(state.Sensors))
+	for kind, value := range state.Sensors {
+		result.Sensors[kind] = value
+	}
+
+	return result
+}
@@ -0,0 +1,271 @@
+package tasmota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vedga/alisa/internal/service/mqtt"
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// tasmotaCommandPower is Tasmota-specific power command prefix (POWER1, POWER2...)
+	tasmotaCommandPower = "POWER"
+	// tasmotaCommandDimmer is Tasmota-specific light brightness command
+	tasmotaCommandDimmer = "Dimmer"
+	// tasmotaCommandHSBColor is Tasmota-specific light color command
+	tasmotaCommandHSBColor = "HSBColor"
+	// tasmotaCommandCT is Tasmota-specific white light temperature command
+	tasmotaCommandCT = "CT"
+	// tasmotaCommandState is Tasmota-specific command which request device state
+	tasmotaCommandState = "STATE"
+	// tasmotaCommandStatus is Tasmota-specific command which request device status
+	tasmotaCommandStatus = "STATUS"
+	// tasmotaStatusSensorsIndex is "Status" command argument for sensors status
+	tasmotaStatusSensorsIndex = "10"
+	// tasmotaResultCommand is Tasmota-specific field in the result of unknown command
+	tasmotaResultCommand = "Command"
+	// tasmotaPowerOff is Tasmota-specific power state value
+	tasmotaPowerOff = "OFF"
+	// tasmotaDefaultFullTopic is Tasmota default full topic template
+	tasmotaDefaultFullTopic = "%prefix%/%topic%/"
+	// tasmotaDefaultCommandPrefix is Tasmota default command prefix
+	tasmotaDefaultCommandPrefix = "cmnd"
+	// tasmotaMinCT is Tasmota minimal white light temperature in mireds (6500K)
+	tasmotaMinCT = 153
+	// tasmotaMaxCT is Tasmota maximal white light temperature in mireds (2000K)
+	tasmotaMaxCT = 500
+)
+
+// resultMatcher check is command result message contain result of the waiting command
+type resultMatcher func(fields map[string]json.RawMessage) bool
+
+// resultWaiter wait command result from the device
+type resultWaiter struct {
+	match resultMatcher
+	done  chan error
+	// completed is true when result is received, it's guarded by waiters lock
+	completed bool
+}
+
+// commandTopic return MQTT topic for the device command
+func (d *device) commandTopic(command string) []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	fullTopic := d.FullTopic
+	if 0 == len(fullTopic) {
+		fullTopic = tasmotaDefaultFullTopic
+	}
+
+	prefix := tasmotaDefaultCommandPrefix
+	if len(d.Prefixes) > 0 {
+		prefix = d.Prefixes[0]
+	}
+
+	fullTopic = strings.ReplaceAll(fullTopic, "%prefix%", prefix)
+	fullTopic = strings.ReplaceAll(fullTopic, "%topic%", d.TopicID)
+
+	return strings.Split(fullTopic+command, mqtt.TopicPartsDelimiter)
+}
+
+// publishCommand send command to the device
+func (service *Service) publishCommand(d *device, command string, value string) {
+	service.bus.Publish(mqtt.TxMQTT, mqtt.EventMQTT{
+		Topic:   d.commandTopic(command),
+		Payload: []byte(value),
+	})
+}
+
+// requestState request current device state and sensor values
+func (service *Service) requestState(d *device) {
+	service.publishCommand(d, tasmotaCommandState, "")
+	service.publishCommand(d, tasmotaCommandStatus, tasmotaStatusSensorsIndex)
+}
+
+// execute send command to the device and wait result
+func (service *Service) execute(ctx context.Context, d *device, command api.Command) error {
+	name, value, match, e := newCommand(d, command)
+	if nil != e {
+		return e
+	}
+
+	d.lock.RLock()
+	id := deviceID(d.MAC)
+	d.lock.RUnlock()
+
+	waiter := &resultWaiter{
+		match: match,
+		done:  make(chan error, 1),
+	}
+
+	// Waiter is added with the command sent, so unknown command result is passed to the right waiter.
+	// Waiters lock isn't held while publishing, result handler take it while bus is locked.
+	service.sendLock.Lock()
+	service.addWaiter(id, waiter)
+	service.publishCommand(d, name, value)
+	service.sendLock.Unlock()
+	defer service.removeWaiter(id, waiter)
+
+	select {
+	case e = <-waiter.done:
+		return e
+	case <-ctx.Done():
+		// Device don't confirm command in time
+		return api.ErrDeviceUnreachable
+	}
+}
+
+// addWaiter start waiting command result from the device
+func (service *Service) addWaiter(deviceID string, waiter *resultWaiter) {
+	service.waitersLock.Lock()
+	defer service.waitersLock.Unlock()
+
+	service.waiters[deviceID] = append(service.waiters[deviceID], waiter)
+}
+
+// removeWaiter stop waiting command result from the device
+func (service *Service) removeWaiter(deviceID string, waiter *resultWaiter) {
+	service.waitersLock.Lock()
+	defer service.waitersLock.Unlock()
+
+	deviceWaiters := service.waiters[deviceID]
+	for i, w := range deviceWaiters {
+		if w == waiter {
+			deviceWaiters = append(deviceWaiters[:i:i], deviceWaiters[i+1:]...)
+			break
+		}
+	}
+
+	if 0 == len(deviceWaiters) {
+		delete(service.waiters, deviceID)
+	} else {
+		service.waiters[deviceID] = deviceWaiters
+	}
+}
+
+// notifyWaiters pass command result message to waiters
+func (service *Service) notifyWaiters(deviceID string, fields map[string]json.RawMessage) {
+	service.waitersLock.Lock()
+	defer service.waitersLock.Unlock()
+
+	var result string
+	unknown := nil == json.Unmarshal(fields[tasmotaResultCommand], &result) && "Unknown" == result
+
+	for _, waiter := range service.waiters[deviceID] {
+		if waiter.completed {
+			continue
+		}
+
+		if unknown {
+			// Tasmota don't report which command is unknown, but it execute commands in order,
+			// so result belong to the oldest command which isn't completed yet
+			waiter.complete(api.ErrInvalidAction)
+			return
+		}
+
+		if waiter.match(fields) {
+			waiter.complete(nil)
+		}
+	}
+}
+
+// complete finish waiting with result, only first result is used. It's called with waiters lock held.
+func (waiter *resultWaiter) complete(e error) {
+	waiter.completed = true
+
+	select {
+	case waiter.done <- e:
+	default:
+	}
+}
+
+// matchField return matcher for result with specified field
+func matchField(field string) resultMatcher {
+	return func(fields map[string]json.RawMessage) bool {
+		_, found := fields[field]
+		return found
+	}
+}
+
+// newCommand return Tasmota command, value and result matcher for the command
+func newCommand(d *device, command api.Command) (name string, value string, match resultMatcher, e error) {
+	var channel *api.Channel
+	for _, c := range d.GetChannels() {
+		if c.Index == command.Channel {
+			channel = &c
+			break
+		}
+	}
+
+	if nil == channel {
+		return "", "", nil, api.ErrDeviceNotFound
+	}
+
+	isLight := api.ChannelLight == channel.Type
+
+	switch command.Type {
+	case api.CommandOnOff:
+		value = tasmotaPowerOff
+		if command.On {
+			value = tasmotaPowerOn
+		}
+
+		return tasmotaCommandPower + strconv.Itoa(channel.Index), value, func(fields map[string]json.RawMessage) bool {
+			for field := range fields {
+				// Single channel device may report "POWER" instead of "POWER1"
+				if parsePowerChannel(field) == channel.Index {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case api.CommandBrightness:
+		if !isLight || api.LightNone == channel.Light {
+			return "", "", nil, api.ErrInvalidAction
+		}
+
+		if command.Brightness < 0 || command.Brightness > 100 {
+			return "", "", nil, api.ErrInvalidValue
+		}
+
+		return tasmotaCommandDimmer, strconv.Itoa(command.Brightness), matchField(tasmotaStateDimmer), nil
+	case api.CommandColor:
+		if !isLight || channel.Light < api.LightRGB {
+			return "", "", nil, api.ErrInvalidAction
+		}
+
+		color := command.Color
+		if color.Hue < 0 || color.Hue > 360 ||
+			color.Saturation < 0 || color.Saturation > 100 ||
+			color.Value < 0 || color.Value > 100 {
+			return "", "", nil, api.ErrInvalidValue
+		}
+
+		value = fmt.Sprintf("%d,%d,%d", color.Hue, color.Saturation, color.Value)
+
+		return tasmotaCommandHSBColor, value, matchField(tasmotaStateHSBColor), nil
+	case api.CommandColorTemperature:
+		if !isLight || (api.LightCW != channel.Light && api.LightRGBCW != channel.Light) {
+			return "", "", nil, api.ErrInvalidAction
+		}
+
+		if command.ColorTemperature <= 0 {
+			return "", "", nil, api.ErrInvalidValue
+		}
+
+		mireds := 1000000 / command.ColorTemperature
+		if mireds < tasmotaMinCT {
+			mireds = tasmotaMinCT
+		} else if mireds > tasmotaMaxCT {
+			mireds = tasmotaMaxCT
+		}
+
+		return tasmotaCommandCT, strconv.Itoa(mireds), matchField(tasmotaStateCT), nil
+	}
+
+	return "", "", nil, api.ErrInvalidAction
+}
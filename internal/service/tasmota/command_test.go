@@ -0,0 +1,68 @@
+package tasmota
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+// result return result of the waiter, false and nil error are returned when waiter isn't completed
+func result(waiter *resultWaiter) (bool, error) {
+	select {
+	case e := <-waiter.done:
+		return true, e
+	default:
+		return false, nil
+	}
+}
+
+func TestUnknownCommandFailOldestWaiter(t *testing.T) {
+	service := &Service{waiters: make(map[string][]*resultWaiter)}
+
+	power := &resultWaiter{match: matchField("POWER1"), done: make(chan error, 1)}
+	dimmer := &resultWaiter{match: matchField(tasmotaStateDimmer), done: make(chan error, 1)}
+	service.addWaiter("d1", power)
+	service.addWaiter("d1", dimmer)
+
+	service.notifyWaiters("d1", map[string]json.RawMessage{tasmotaResultCommand: json.RawMessage(`"Unknown"`)})
+
+	if done, e := result(power); !done || api.ErrInvalidAction != e {
+		t.Fatalf("oldest waiter: got %v %v, want %v", e, done, api.ErrInvalidAction)
+	}
+	if done, _ := result(dimmer); done {
+		t.Fatal("unknown command result completed newer waiter")
+	}
+
+	service.notifyWaiters("d1", map[string]json.RawMessage{tasmotaStateDimmer: json.RawMessage(`50`)})
+
+	if done, e := result(dimmer); !done || nil != e {
+		t.Fatalf("dimmer waiter: got %v %v, want success", e, done)
+	}
+
+	service.removeWaiter("d1", power)
+	service.removeWaiter("d1", dimmer)
+	if _, found := service.waiters["d1"]; found {
+		t.Fatal("waiters of the device aren't removed")
+	}
+}
+
+func TestUnknownCommandSkipCompletedWaiter(t *testing.T) {
+	service := &Service{waiters: make(map[string][]*resultWaiter)}
+
+	power := &resultWaiter{match: matchField("POWER1"), done: make(chan error, 1)}
+	dimmer := &resultWaiter{match: matchField(tasmotaStateDimmer), done: make(chan error, 1)}
+	service.addWaiter("d1", power)
+	service.addWaiter("d1", dimmer)
+
+	// Confirmed command isn't removed yet, but the unknown result belong to the next one
+	service.notifyWaiters("d1", map[string]json.RawMessage{"POWER1": json.RawMessage(`"ON"`)})
+	service.notifyWaiters("d1", map[string]json.RawMessage{tasmotaResultCommand: json.RawMessage(`"Unknown"`)})
+
+	if done, e := result(power); !done || nil != e {
+		t.Fatalf("power waiter: got %v %v, want success", e, done)
+	}
+	if done, e := result(dimmer); !done || api.ErrInvalidAction != e {
+		t.Fatalf("dimmer waiter: got %v %v, want %v", e, done, api.ErrInvalidAction)
+	}
+}
@@ -0,0 +1,176 @@
+package tasmota
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	devicePrefix = "tasmota_"
+	// integrationName is name of the integration in the device route
+	integrationName = "tasmota"
+)
+
+// Tasmota relay types in the discovery message
+const (
+	relayNone   = 0
+	relaySwitch = 1
+	relayLight  = 2
+)
+
+// deviceID return deviceID by internal hardware ID
+func deviceID(hardwareID string) string {
+	return devicePrefix + hardwareID
+}
+
+// device is object which implement api.Device interface
+type device struct {
+	IP                    string   `json:"ip,omitempty"`
+	DN                    string   `json:"dn,omitempty"`
+	HardwareCompatibility []string `json:"fn,omitempty"`
+	HardwareID            string   `json:"hn,omitempty"`
+	MAC                   string   `json:"mac,omitempty"`
+	Type                  string   `json:"md,omitempty"`
+	SupportedStates       []string `json:"state,omitempty"`
+	FirmwareVersion       string   `json:"sw,omitempty"`
+	TopicID               string   `json:"t,omitempty"`
+	Relays                []int    `json:"rl,omitempty"`
+	LightSubtype          int      `json:"lt_st,omitempty"`
+	FullTopic             string   `json:"ft,omitempty"`
+	Prefixes              []string `json:"tp,omitempty"`
+	sensors               []api.Sensor
+	service               *Service
+	lock                  sync.RWMutex
+}
+
+// GetType is implementation of api.Device interface
+func (d *device) GetType() string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.Type
+}
+
+// GetName is implementation of api.Device interface
+func (d *device) GetName() string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.DN
+}
+
+// GetRoute is implementation of api.Device interface
+func (d *device) GetRoute() api.Route {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return api.Route{
+		Integration: integrationName,
+		Topic:       d.TopicID,
+	}
+}
+
+// GetFirmwareVersion is implementation of api.Device interface
+func (d *device) GetFirmwareVersion() string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.FirmwareVersion
+}
+
+// GetChannels is implementation of api.Device interface
+func (d *device) GetChannels() (channels []api.Channel) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for index, relayType := range d.Relays {
+		channel := api.Channel{
+			Index: index + 1,
+		}
+
+		if index < len(d.HardwareCompatibility) {
+			channel.Name = d.HardwareCompatibility[index]
+		}
+
+		switch relayType {
+		case relaySwitch:
+			channel.Type = api.ChannelRelay
+		case relayLight:
+			channel.Type = api.ChannelLight
+			channel.Light = api.LightType(d.LightSubtype)
+		default:
+			// Not used or unsupported (shutter etc.) output
+			continue
+		}
+
+		channels = append(channels, channel)
+	}
+
+	return channels
+}
+
+// GetSensors is implementation of api.Device interface
+func (d *device) GetSensors() []api.Sensor {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.sensors
+}
+
+// Update is implementation of api.Device interface
+func (d *device) Update(newDevice api.Device) error {
+	source, valid := newDevice.(*device)
+	if !valid {
+		return errors.New("invalid device")
+	}
+
+	source.lock.RLock()
+	defer source.lock.RUnlock()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(source.TopicID) > 0 {
+		// Source contains device configuration
+		d.IP = source.IP
+		d.DN = source.DN
+		d.HardwareCompatibility = source.HardwareCompatibility
+		d.HardwareID = source.HardwareID
+		d.MAC = source.MAC
+		d.Type = source.Type
+		d.SupportedStates = source.SupportedStates
+		d.FirmwareVersion = source.FirmwareVersion
+		d.TopicID = source.TopicID
+		d.Relays = source.Relays
+		d.LightSubtype = source.LightSubtype
+		d.FullTopic = source.FullTopic
+		d.Prefixes = source.Prefixes
+	}
+
+	if nil == d.service {
+		d.service = source.service
+	}
+
+	if nil != source.sensors {
+		// Source contains sensors configuration
+		d.sensors = source.sensors
+	}
+
+	return nil
+}
+
+// Execute is implementation of api.Device interface
+func (d *device) Execute(ctx context.Context, command api.Command) error {
+	d.lock.RLock()
+	service := d.service
+	d.lock.RUnlock()
+
+	if nil == service {
+		return errors.New("device isn't attached to the service")
+	}
+
+	return service.execute(ctx, d, command)
+}
@@ -3,7 +3,9 @@ package tasmota
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/pior/runnable"
 	"github.com/vedga/alisa/internal/pkg/log"
@@ -17,13 +19,25 @@ type Service struct {
 	runnable.Runnable
 	bus           eventbus.Bus
 	deviceManager api.DeviceManager
+	stateCache    api.StateCache
+	// topics is device ID by Tasmota device topic
+	topics sync.Map
+	// waiters is command result waiters by device ID, in the order commands are sent
+	waiters     map[string][]*resultWaiter
+	waitersLock sync.Mutex
+	// sendLock keep order of the waiters same as order of the sent commands
+	sendLock sync.Mutex
 }
 
 // NewService return new service implementation
-func NewService(bus eventbus.Bus, deviceManager api.DeviceManager) (service *Service, e error) {
+func NewService(bus eventbus.Bus,
+	deviceManager api.DeviceManager,
+	stateCache api.StateCache) (service *Service, e error) {
 	return &Service{
 		bus:           bus,
 		deviceManager: deviceManager,
+		stateCache:    stateCache,
+		waiters:       make(map[string][]*resultWaiter),
 	}, nil
 }
 
@@ -115,16 +129,39 @@ func (service *Service) rxMessageDiscovery(event mqtt.EventMQTT) {
 			return
 		}
 
+		payload.service = service
+
 		if e := service.deviceManager.AddDevice(deviceID(payload.MAC), &payload); nil != e {
 			log.Log.Error("Invalid topic name for discovery message", event)
 			return
 		}
+
+		// Now messages from the device topic can be routed to the device
+		service.topics.Store(payload.TopicID, deviceID(payload.MAC))
+
+		// Don't wait periodic telemetry. Event bus is locked while this handler is running,
+		// so publishing must be done outside.
+		go service.requestState(&payload)
 	case tasmotaPayloadSensors:
 		var payload struct {
-			Version int `json:"ver,omitempty"`
+			Sensors map[string]json.RawMessage `json:"sn,omitempty"`
+			Version int                        `json:"ver,omitempty"`
 		}
 		if e := json.Unmarshal(event.Payload, &payload); nil != e {
 			log.Log.Error("Discovery message don't implemented yet", event, e)
+			return
+		}
+
+		// Sensors are stored as part of the device, received config (if any) will be merged
+		sensorsDevice := &device{
+			MAC:     mac,
+			sensors: parseSensors(payload.Sensors),
+			service: service,
+		}
+
+		if e := service.deviceManager.AddDevice(deviceID(mac), sensorsDevice); nil != e {
+			log.Log.Error("Unable to update device sensors", event, e)
+			return
 		}
 	default:
 		log.Log.Warn("Decoding Discovery message don't implemented yet", event)
@@ -132,63 +169,237 @@ func (service *Service) rxMessageDiscovery(event mqtt.EventMQTT) {
 	}
 }
 
-// rxMessageDiscovery called when received telemetry message
+const (
+	// mqttIndexTopic is index in MQTT topic name part with Tasmota device topic
+	mqttIndexTopic = 1
+	// mqttIndexCommand is index in MQTT topic name part with Tasmota command or message type
+	mqttIndexCommand = -1
+	// tasmotaTelemetryState is Tasmota-specific periodic state message
+	tasmotaTelemetryState = "STATE"
+	// tasmotaTelemetrySensor is Tasmota-specific periodic sensors message
+	tasmotaTelemetrySensor = "SENSOR"
+	// tasmotaTelemetryLWT is Tasmota-specific last will and testament message
+	tasmotaTelemetryLWT = "LWT"
+	// tasmotaStatusResult is Tasmota-specific command result message
+	tasmotaStatusResult = "RESULT"
+	// tasmotaStatusState is Tasmota-specific "Status 11" response message
+	tasmotaStatusState = "STATUS11"
+	// tasmotaStatusSensors is Tasmota-specific "Status 10" response message
+	tasmotaStatusSensors = "STATUS10"
+)
+
+// parseTopic return device ID and command (message type) for the message from the device topic
+func (service *Service) parseTopic(event mqtt.EventMQTT) (deviceID string, command string, found bool) {
+	topicParts := len(event.Topic)
+	if topicParts < 3 {
+		return "", "", false
+	}
+
+	// Device topic may contain delimiters
+	topic := strings.Join(event.Topic[mqttIndexTopic:topicParts+mqttIndexCommand], mqtt.TopicPartsDelimiter)
+
+	value, found := service.topics.Load(topic)
+	if !found {
+		return "", "", false
+	}
+
+	return value.(string), event.Topic[topicParts+mqttIndexCommand], true
+}
+
+// updateState apply message from the device to the device state
+func (service *Service) updateState(deviceID string, update func(d *device, state *api.State)) {
+	var d *device
+	if value, e := service.deviceManager.GetDevice(deviceID); nil == e {
+		d, _ = value.(*device)
+	}
+
+	if e := service.stateCache.UpdateState(deviceID, func(state *api.State) {
+		update(d, state)
+	}); nil != e {
+		log.Log.Error("Unable to update device state", deviceID, e)
+	}
+}
+
+// rxMessageTelemetry called when received telemetry message
 func (service *Service) rxMessageTelemetry(event mqtt.EventMQTT) {
+	deviceID, command, found := service.parseTopic(event)
+	if !found {
+		return
+	}
+
+	switch command {
+	case tasmotaTelemetryLWT:
+		service.updateState(deviceID, func(_ *device, state *api.State) {
+			state.Online = tasmotaOnline == string(event.Payload)
+		})
+	case tasmotaTelemetryState:
+		var fields map[string]json.RawMessage
+		if e := json.Unmarshal(event.Payload, &fields); nil != e {
+			log.Log.Error("Invalid state message", event, e)
+			return
+		}
+
+		service.updateState(deviceID, func(d *device, state *api.State) {
+			state.Online = true
+			applyState(d, fields, state)
+		})
+	case tasmotaTelemetrySensor:
+		var fields map[string]json.RawMessage
+		if e := json.Unmarshal(event.Payload, &fields); nil != e {
+			log.Log.Error("Invalid sensor message", event, e)
+			return
+		}
+
+		service.updateState(deviceID, func(_ *device, state *api.State) {
+			state.Online = true
+			applySensors(fields, state)
+		})
+	}
 }
 
-// rxMessageDiscovery called when received command message
+// rxMessageCommand called when received command message
 func (service *Service) rxMessageCommand(event mqtt.EventMQTT) {
 }
 
-// rxMessageDiscovery called when received status message
+// rxMessageStatus called when received status message
 func (service *Service) rxMessageStatus(event mqtt.EventMQTT) {
+	deviceID, command, found := service.parseTopic(event)
+	if !found {
+		return
+	}
+
+	if channel := parsePowerChannel(command); channel > 0 {
+		// stat/<topic>/POWER<n> contain plain value
+		service.updateState(deviceID, func(_ *device, state *api.State) {
+			state.Online = true
+			channelState := state.Channels[channel]
+			channelState.On = tasmotaPowerOn == string(event.Payload)
+			state.Channels[channel] = channelState
+		})
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	switch command {
+	case tasmotaStatusResult:
+		if e := json.Unmarshal(event.Payload, &fields); nil != e {
+			log.Log.Error("Invalid result message", event, e)
+			return
+		}
+	case tasmotaStatusState:
+		var payload struct {
+			Fields map[string]json.RawMessage `json:"StatusSTS"`
+		}
+		if e := json.Unmarshal(event.Payload, &payload); nil != e {
+			log.Log.Error("Invalid status message", event, e)
+			return
+		}
+		fields = payload.Fields
+	case tasmotaStatusSensors:
+		var payload struct {
+			Fields map[string]json.RawMessage `json:"StatusSNS"`
+		}
+		if e := json.Unmarshal(event.Payload, &payload); nil != e {
+			log.Log.Error("Invalid status message", event, e)
+			return
+		}
+
+		service.updateState(deviceID, func(_ *device, state *api.State) {
+			state.Online = true
+			applySensors(payload.Fields, state)
+		})
+		return
+	default:
+		return
+	}
+
+	service.updateState(deviceID, func(d *device, state *api.State) {
+		state.Online = true
+		applyState(d, fields, state)
+	})
+
+	if tasmotaStatusResult == command {
+		service.notifyWaiters(deviceID, fields)
+	}
 }
 
 const (
-	devicePrefix = "tasmota_"
+	// tasmotaSensorEnergy is Tasmota-specific energy meter sensor name
+	tasmotaSensorEnergy = "ENERGY"
+	// tasmotaTemperatureUnit is Tasmota-specific temperature unit field
+	tasmotaTemperatureUnit = "TempUnit"
+	// tasmotaPressureUnit is Tasmota-specific pressure unit field
+	tasmotaPressureUnit = "PressureUnit"
 )
 
-// deviceID return deviceID by internal hardware ID
-func deviceID(hardwareID string) string {
-	return devicePrefix + hardwareID
+// tasmotaSensorKinds is known Tasmota sensor values
+var tasmotaSensorKinds = map[string]api.SensorKind{
+	"Temperature":   api.SensorTemperature,
+	"Humidity":      api.SensorHumidity,
+	"Pressure":      api.SensorPressure,
+	"Illuminance":   api.SensorIlluminance,
+	"CarbonDioxide": api.SensorCO2,
+	"Voltage":       api.SensorVoltage,
+	"Current":       api.SensorCurrent,
+	"Power":         api.SensorPower,
 }
 
-// device is object which implement api.Device interface
-type device struct {
-	IP                    string   `json:"ip,omitempty"`
-	DN                    string   `json:"dn,omitempty"`
-	HardwareCompatibility []string `json:"fn,omitempty"`
-	HardwareID            string   `json:"hn,omitempty"`
-	MAC                   string   `json:"mac,omitempty"`
-	Type                  string   `json:"md,omitempty"`
-	SupportedStates       []string `json:"state,omitempty"`
-	FirmwareVersion       string   `json:"sw,omitempty"`
-	TopicID               string   `json:"t,omitempty"`
-}
+// parseSensors return list of known sensors in the sensors discovery message
+//
+// Example:
+// "sn":{"Time":"2022-12-06T18:51:26","AM2301":{"Temperature":22.1,"Humidity":40.2},"TempUnit":"C"}
+func parseSensors(values map[string]json.RawMessage) []api.Sensor {
+	units := map[api.SensorKind]string{
+		api.SensorHumidity:    "%",
+		api.SensorIlluminance: "lx",
+		api.SensorCO2:         "ppm",
+		api.SensorVoltage:     "V",
+		api.SensorCurrent:     "A",
+		api.SensorPower:       "W",
+	}
 
-// GetType is implementation of api.Device interface
-func (d *device) GetType() string {
-	return d.Type
-}
+	var unit string
+	if e := json.Unmarshal(values[tasmotaTemperatureUnit], &unit); nil == e {
+		units[api.SensorTemperature] = unit
+	}
+	if e := json.Unmarshal(values[tasmotaPressureUnit], &unit); nil == e {
+		units[api.SensorPressure] = unit
+	}
 
-// GetFirmwareVersion is implementation of api.Device interface
-func (d *device) GetFirmwareVersion() string {
-	return d.FirmwareVersion
-}
+	found := make(map[api.SensorKind]bool)
+	sensors := make([]api.Sensor, 0)
+
+	for name, value := range values {
+		var fields map[string]json.RawMessage
+		if e := json.Unmarshal(value, &fields); nil != e {
+			// Not a sensor object
+			continue
+		}
+
+		for field, fieldValue := range fields {
+			kind, known := tasmotaSensorKinds[field]
+			if !known || found[kind] {
+				continue
+			}
+
+			var number float64
+			if e := json.Unmarshal(fieldValue, &number); nil != e {
+				log.Log.Debug("Unsupported sensor value", name, field)
+				continue
+			}
+
+			found[kind] = true
+			sensors = append(sensors, api.Sensor{
+				Kind: kind,
+				Unit: units[kind],
+			})
+		}
+	}
+
+	// Keep sensors order stable
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].Kind < sensors[j].Kind
+	})
 
-// Update is implementation of api.Device interface
-func (d *device) Update(newDevice api.Device) error {
-	if source, valid := newDevice.(*device); valid {
-		d.IP = source.IP
-		d.DN = source.DN
-		d.HardwareCompatibility = source.HardwareCompatibility
-		d.HardwareID = source.HardwareID
-		d.MAC = source.MAC
-		d.Type = source.Type
-		d.SupportedStates = source.SupportedStates
-		d.FirmwareVersion = source.FirmwareVersion
-		d.TopicID = source.TopicID
-	}
-
-	return errors.New("invalid device")
+	return sensors
 }
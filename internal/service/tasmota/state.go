@@ -0,0 +1,148 @@
+package tasmota
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/vedga/alisa/pkg/api"
+)
+
+const (
+	// tasmotaStatePower is Tasmota-specific power state field prefix (POWER, POWER1, POWER2...)
+	tasmotaStatePower = "POWER"
+	// tasmotaStateDimmer is Tasmota-specific light brightness field
+	tasmotaStateDimmer = "Dimmer"
+	// tasmotaStateHSBColor is Tasmota-specific light color field
+	tasmotaStateHSBColor = "HSBColor"
+	// tasmotaStateCT is Tasmota-specific white light temperature field (in mireds)
+	tasmotaStateCT = "CT"
+	// tasmotaStateColor is Tasmota-specific light channels field
+	tasmotaStateColor = "Color"
+	// tasmotaPowerOn is Tasmota-specific power state value
+	tasmotaPowerOn = "ON"
+	// tasmotaOnline is Tasmota-specific LWT message value
+	tasmotaOnline = "Online"
+)
+
+// parsePowerChannel return channel index for the power state field, or 0 if field isn't power state
+func parsePowerChannel(field string) int {
+	if !strings.HasPrefix(field, tasmotaStatePower) {
+		return 0
+	}
+
+	suffix := field[len(tasmotaStatePower):]
+	if 0 == len(suffix) {
+		// Single channel device
+		return 1
+	}
+
+	channel, e := strconv.Atoi(suffix)
+	if nil != e || channel < 1 {
+		return 0
+	}
+
+	return channel
+}
+
+// applyState update state from Tasmota state message (tele STATE, stat RESULT etc.)
+//
+// Example:
+// {"Time":"2022-12-06T18:51:26","Uptime":"0T00:10:09","POWER1":"ON","POWER2":"OFF",
+// "Dimmer":50,"Color":"FF00000000","HSBColor":"0,100,50","CT":153}
+func applyState(d *device, fields map[string]json.RawMessage, state *api.State) {
+	for field, value := range fields {
+		channel := parsePowerChannel(field)
+		if 0 == channel {
+			continue
+		}
+
+		var power string
+		if e := json.Unmarshal(value, &power); nil != e {
+			continue
+		}
+
+		channelState := state.Channels[channel]
+		channelState.On = tasmotaPowerOn == power
+		state.Channels[channel] = channelState
+	}
+
+	if nil == d {
+		return
+	}
+
+	for _, channel := range d.GetChannels() {
+		if api.ChannelLight != channel.Type {
+			continue
+		}
+
+		channelState := state.Channels[channel.Index]
+		applyLightState(channel.Light, fields, &channelState)
+		state.Channels[channel.Index] = channelState
+	}
+}
+
+// applyLightState update light channel state from Tasmota state message
+func applyLightState(light api.LightType, fields map[string]json.RawMessage, state *api.ChannelState) {
+	var dimmer int
+	if e := json.Unmarshal(fields[tasmotaStateDimmer], &dimmer); nil == e {
+		state.Brightness = dimmer
+	}
+
+	var hsb string
+	if e := json.Unmarshal(fields[tasmotaStateHSBColor], &hsb); nil == e {
+		if parts := strings.Split(hsb, ","); 3 == len(parts) {
+			state.Color.Hue, _ = strconv.Atoi(parts[0])
+			state.Color.Saturation, _ = strconv.Atoi(parts[1])
+			state.Color.Value, _ = strconv.Atoi(parts[2])
+		}
+	}
+
+	var mireds int
+	if e := json.Unmarshal(fields[tasmotaStateCT], &mireds); nil != e || mireds <= 0 {
+		return
+	}
+
+	switch light {
+	case api.LightCW:
+		state.ColorTemperature = 1000000 / mireds
+	case api.LightRGBCW:
+		// White mode when RGB channels is off
+		var color string
+		if e := json.Unmarshal(fields[tasmotaStateColor], &color); nil == e && len(color) >= 6 {
+			if "000000" == color[:6] {
+				state.ColorTemperature = 1000000 / mireds
+			} else {
+				state.ColorTemperature = 0
+			}
+		}
+	}
+}
+
+// applySensors update state from Tasmota sensors message (tele SENSOR)
+//
+// Example:
+// {"Time":"2022-12-06T18:51:26","AM2301":{"Temperature":22.1,"Humidity":40.2},"TempUnit":"C"}
+func applySensors(fields map[string]json.RawMessage, state *api.State) {
+	for _, value := range fields {
+		var sensorFields map[string]json.RawMessage
+		if e := json.Unmarshal(value, &sensorFields); nil != e {
+			// Not a sensor object
+			continue
+		}
+
+		for field, fieldValue := range sensorFields {
+			kind, known := tasmotaSensorKinds[field]
+			if !known {
+				continue
+			}
+
+			var number float64
+			if e := json.Unmarshal(fieldValue, &number); nil != e {
+				continue
+			}
+
+			state.Sensors[kind] = number
+		}
+	}
+}
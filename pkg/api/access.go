@@ -0,0 +1,7 @@
+package api
+
+// AccessControl restrict devices visible to the users
+type AccessControl interface {
+	// UserDevices return device manager which expose only devices the user allowed to access
+	UserDevices(userID string) DeviceManager
+}
@@ -0,0 +1,40 @@
+package api
+
+import "errors"
+
+var (
+	// ErrInvalidAction returned when command isn't supported by the device channel
+	ErrInvalidAction = errors.New("invalid action")
+	// ErrInvalidValue returned when command value isn't acceptable by the device
+	ErrInvalidValue = errors.New("invalid value")
+)
+
+// CommandType is type of the device channel command
+type CommandType int
+
+const (
+	// CommandOnOff turn channel on or off
+	CommandOnOff CommandType = iota + 1
+	// CommandBrightness set light brightness
+	CommandBrightness
+	// CommandColor set light color
+	CommandColor
+	// CommandColorTemperature set white light temperature
+	CommandColorTemperature
+)
+
+// Command is request to change the device channel state
+type Command struct {
+	// Channel is channel index
+	Channel int
+	// Type is command type, only corresponding value used
+	Type CommandType
+	// On is value for CommandOnOff
+	On bool
+	// Brightness is value for CommandBrightness, 0..100 percents
+	Brightness int
+	// Color is value for CommandColor
+	Color ColorHSV
+	// ColorTemperature is value for CommandColorTemperature, in Kelvin
+	ColorTemperature int
+}
@@ -1,14 +1,104 @@
 package api
 
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrDeviceNotFound returned when requested device is unknown
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+// ChannelType is type of the independently controlled device output
+type ChannelType int
+
+const (
+	// ChannelRelay is simple on/off output
+	ChannelRelay ChannelType = iota + 1
+	// ChannelLight is light output, optionally dimmable and colored
+	ChannelLight
+)
+
+// LightType describe light output abilities
+type LightType int
+
+const (
+	// LightNone is not a light, or light without any settings except on/off
+	LightNone LightType = iota
+	// LightDimmer is single channel dimmable light
+	LightDimmer
+	// LightCW is cold/warm white light
+	LightCW
+	// LightRGB is color light
+	LightRGB
+	// LightRGBW is color light with separate white channel
+	LightRGBW
+	// LightRGBCW is color light with cold/warm white channels
+	LightRGBCW
+)
+
+// Channel represent independently controlled device output
+type Channel struct {
+	// Index is channel number, starting from 1
+	Index int
+	// Name is channel name reported by the device, may be empty
+	Name string
+	// Type is channel type
+	Type ChannelType
+	// Light is light abilities for ChannelLight channels
+	Light LightType
+}
+
+// SensorKind is quantity measured by the device sensor
+type SensorKind string
+
+// Known sensor kinds
+const (
+	SensorTemperature SensorKind = "temperature"
+	SensorHumidity    SensorKind = "humidity"
+	SensorPressure    SensorKind = "pressure"
+	SensorIlluminance SensorKind = "illuminance"
+	SensorCO2         SensorKind = "co2"
+	SensorVoltage     SensorKind = "voltage"
+	SensorCurrent     SensorKind = "current"
+	SensorPower       SensorKind = "power"
+)
+
+// Sensor represent device sensor
+type Sensor struct {
+	// Kind is measured quantity
+	Kind SensorKind
+	// Unit is measurement unit reported by the device, may be empty
+	Unit string
+}
+
+// Route describe how the integration reach the device, it don't depend on the device ID in the registry
+type Route struct {
+	// Integration is name of the integration which serve the device, e.g. "tasmota"
+	Integration string
+	// Topic is MQTT topic of the device
+	Topic string
+}
+
 // Device represent device object API
 type Device interface {
 	GetType() string
+	GetName() string
 	GetFirmwareVersion() string
+	GetChannels() []Channel
+	GetSensors() []Sensor
+	GetRoute() Route
 	Update(newDevice Device) error
+	// Execute send command to the device and wait until the device confirm it or context done
+	Execute(ctx context.Context, command Command) error
 }
 
 // DeviceManager is interface for device manager
 type DeviceManager interface {
 	AddDevice(deviceID string, device Device) error
+	GetDevice(deviceID string) (Device, error)
+	// FindDevice return device ID and device by the route
+	FindDevice(route Route) (string, Device, error)
 	EnumDevices() (map[string]Device, error)
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDeviceUnreachable returned when device state is unknown, outdated or device is offline
+	ErrDeviceUnreachable = errors.New("device unreachable")
+)
+
+// ColorHSV is color in the HSV model
+type ColorHSV struct {
+	// Hue is 0..360 degrees
+	Hue int
+	// Saturation is 0..100 percents
+	Saturation int
+	// Value is 0..100 percents
+	Value int
+}
+
+// ChannelState represent current state of the device channel
+type ChannelState struct {
+	// On is true when channel output is turned on
+	On bool
+	// Brightness is light brightness 0..100 percents
+	Brightness int
+	// Color is current light color
+	Color ColorHSV
+	// ColorTemperature is white light temperature in Kelvin, 0 when light in the color mode
+	ColorTemperature int
+}
+
+// State represent last known device state
+type State struct {
+	// Online is false when device reported it is offline
+	Online bool
+	// Updated is time of the last state update
+	Updated time.Time
+	// Channels is channel states by channel index
+	Channels map[int]ChannelState
+	// Sensors is last reported sensor values
+	Sensors map[SensorKind]float64
+}
+
+// StateCache is interface for the device states storage
+type StateCache interface {
+	// UpdateState call update function with the stored state of the device, which may modify it
+	UpdateState(deviceID string, update func(state *State)) error
+	// GetState return copy of the device state or ErrDeviceUnreachable
+	GetState(deviceID string) (State, error)
+}
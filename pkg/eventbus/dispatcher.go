@@ -0,0 +1,81 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+)
+
+// event is queued event with the single argument
+type event struct {
+	topic string
+	arg   interface{}
+}
+
+// Dispatcher publish events to the bus from the single goroutine, in the order they're queued. Queueing never
+// block, so it's safe from the bus handlers while bus is locked. Event which is already pending isn't queued
+// again, so queue never grow beyond number of distinct events.
+type Dispatcher struct {
+	bus     Bus
+	mutex   sync.Mutex
+	pending []event
+	queued  map[event]bool
+	signal  chan struct{}
+}
+
+// NewDispatcher return new dispatcher of the bus, events are published when it's running
+func NewDispatcher(bus Bus) *Dispatcher {
+	return &Dispatcher{
+		bus:    bus,
+		queued: make(map[event]bool),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Publish queue event, argument must be comparable
+func (d *Dispatcher) Publish(topic string, arg interface{}) {
+	e := event{topic: topic, arg: arg}
+
+	d.mutex.Lock()
+	if !d.queued[e] {
+		d.queued[e] = true
+		d.pending = append(d.pending, e)
+	}
+	d.mutex.Unlock()
+
+	select {
+	case d.signal <- struct{}{}:
+	default:
+	}
+}
+
+// next return the oldest pending event
+func (d *Dispatcher) next() (event, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if 0 == len(d.pending) {
+		return event{}, false
+	}
+
+	e := d.pending[0]
+	d.pending[0] = event{}
+	d.pending = d.pending[1:]
+	delete(d.queued, e)
+
+	return e, true
+}
+
+// Run publish queued events until context done
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		for e, found := d.next(); found; e, found = d.next() {
+			d.bus.Publish(e.topic, e.arg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.signal:
+		}
+	}
+}
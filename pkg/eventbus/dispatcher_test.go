@@ -0,0 +1,81 @@
+package eventbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOrderAndCoalescing(t *testing.T) {
+	bus := New()
+	received := make(chan string, 10)
+	if e := bus.Subscribe("topic", func(id string) { received <- id }); nil != e {
+		t.Fatal(e)
+	}
+
+	d := NewDispatcher(bus)
+	// Pending "a" isn't queued again
+	for _, id := range []string{"a", "b", "a", "c"} {
+		d.Publish("topic", id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = d.Run(ctx)
+	}()
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case id := <-received:
+			got = append(got, id)
+		case <-time.After(time.Second):
+			t.Fatalf("events not delivered, got %v", got)
+		}
+	}
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Delivered event is queued again
+	d.Publish("topic", "a")
+	select {
+	case id := <-received:
+		if "a" != id {
+			t.Fatalf("got %s, want a", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestDispatcherPublishFromHandler(t *testing.T) {
+	bus := New()
+	d := NewDispatcher(bus)
+	done := make(chan struct{})
+
+	// Handler is called while bus is locked, so queueing must not publish synchronously
+	if e := bus.Subscribe("first", func(string) { d.Publish("second", "x") }); nil != e {
+		t.Fatal(e)
+	}
+	if e := bus.Subscribe("second", func(string) { close(done) }); nil != e {
+		t.Fatal(e)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = d.Run(ctx)
+	}()
+
+	d.Publish("first", "x")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event published from handler not delivered")
+	}
+}